@@ -0,0 +1,121 @@
+package migrator
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// maxHeaderSize bounds the bytes read when parsing the annotation header of
+// a SQL file.
+const maxHeaderSize = 64 << 10
+
+// Well-known annotation keys.
+const (
+	// AnnotationDescription holds a human-readable migration description.
+	AnnotationDescription = "description"
+	// AnnotationTicket holds a change-management reference such as JIRA-123.
+	AnnotationTicket = "ticket"
+)
+
+// ParseAnnotations parses the leading comment block of SQL into annotations.
+// Lines of the form "-- key: value" are annotations; other comment lines and
+// blank lines are skipped. Parsing stops at the first line that is neither a
+// comment nor blank. Keys are lower-cased.
+//
+// Parameters:
+//   - r: The reader to read SQL from.
+//
+// Returns:
+//   - map[string]string: The annotations, nil if there are none.
+//   - error: An error if reading fails.
+func ParseAnnotations(r io.Reader) (map[string]string, error) {
+	var annotations map[string]string
+	scanner := bufio.NewScanner(io.LimitReader(r, maxHeaderSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(comment, ":")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || !isAnnotationKey(key) {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[key] = strings.TrimSpace(value)
+	}
+	return annotations, scanner.Err()
+}
+
+// isAnnotationKey reports whether key is a single word usable as an
+// annotation key.
+func isAnnotationKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r != '_' && r != '-' && !isIdentRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAnnotationsString parses the annotation header of SQL held in memory.
+func parseAnnotationsString(sql string) map[string]string {
+	annotations, _ := ParseAnnotations(strings.NewReader(sql))
+	return annotations
+}
+
+// fileAnnotationsLoader returns a function reading the annotation header of
+// the file at filePath of fsys, nil for the operating system's.
+func fileAnnotationsLoader(
+	fsys fs.FS, filePath string,
+) func() (map[string]string, error) {
+	return func() (map[string]string, error) {
+		f, err := openFile(fsys, filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseAnnotations(f)
+	}
+}
+
+// resolveAnnotations loads the annotations of a migration whose source
+// defers file reads and fills in the fields derived from annotations. It is
+// a no-op for migrations whose annotations are already resolved.
+func (m *Migration) resolveAnnotations() error {
+	if m.loadAnnotations != nil {
+		annotations, err := m.loadAnnotations()
+		if err != nil {
+			return err
+		}
+		m.loadAnnotations = nil
+		m.setAnnotations(annotations)
+	}
+	return nil
+}
+
+// setAnnotations stores annotations and fills in the fields derived from
+// them, keeping values that were set explicitly.
+func (m *Migration) setAnnotations(annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	m.Annotations = annotations
+	if m.Description == "" {
+		m.Description = annotations[AnnotationDescription]
+	}
+	if m.Ticket == "" {
+		m.Ticket = annotations[AnnotationTicket]
+	}
+}
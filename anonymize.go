@@ -0,0 +1,272 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AnonymizeAction is the way an AnonymizeRule replaces column values.
+type AnonymizeAction string
+
+const (
+	// AnonymizeHash replaces values with the hex encoded SHA-256 of the
+	// value and a salt, keeping equal values equal across tables.
+	AnonymizeHash AnonymizeAction = "hash"
+	// AnonymizeMask replaces all but the last characters with asterisks.
+	AnonymizeMask AnonymizeAction = "mask"
+	// AnonymizeFake replaces values with a template in which "{key}" is
+	// replaced with the row's key.
+	AnonymizeFake AnonymizeAction = "fake"
+)
+
+// AnonymizeRule describes how the values of a column are replaced. NULL
+// values are kept.
+type AnonymizeRule struct {
+	Column string
+	Action AnonymizeAction
+	// Salt is appended to values before hashing.
+	Salt string
+	// Keep is the number of trailing characters a mask keeps.
+	Keep int
+	// Template is the fake value, e.g. "user{key}@example.com".
+	Template string
+}
+
+// HashColumn returns a rule replacing the values of column with their
+// salted SHA-256 hash.
+//
+// Parameters:
+//   - column: The column to anonymize.
+//   - salt: The salt appended to values before hashing.
+//
+// Returns:
+//   - AnonymizeRule: The rule.
+func HashColumn(column string, salt string) AnonymizeRule {
+	return AnonymizeRule{Column: column, Action: AnonymizeHash, Salt: salt}
+}
+
+// MaskColumn returns a rule replacing all but the last keep characters of
+// the values of column with asterisks.
+//
+// Parameters:
+//   - column: The column to anonymize.
+//   - keep: The number of trailing characters to keep.
+//
+// Returns:
+//   - AnonymizeRule: The rule.
+func MaskColumn(column string, keep int) AnonymizeRule {
+	return AnonymizeRule{Column: column, Action: AnonymizeMask, Keep: keep}
+}
+
+// FakeColumn returns a rule replacing the values of column with template,
+// in which "{key}" is replaced with the row's key, e.g.
+// "user{key}@example.com".
+//
+// Parameters:
+//   - column: The column to anonymize.
+//   - template: The fake value.
+//
+// Returns:
+//   - AnonymizeRule: The rule.
+func FakeColumn(column string, template string) AnonymizeRule {
+	return AnonymizeRule{Column: column, Action: AnonymizeFake, Template: template}
+}
+
+// AnonymizeStep replaces personal data in a table with hashed, masked or
+// fake values, for refreshing staging databases from production snapshots.
+// As running it against production would destroy data, it can be gated on
+// an environment variable (see WithEnvGuard): when the variable does not
+// hold one of the allowed values, the step is skipped with a log message.
+// Its down direction does nothing, as the original values are gone.
+type AnonymizeStep struct {
+	Dialect Dialect
+	Table   string
+	// Key is the column substituted for "{key}" in fake values, usually
+	// the primary key.
+	Key   string
+	Rules []AnonymizeRule
+	// Where restricts the anonymized rows, e.g. "email NOT LIKE
+	// '%@example.com'". Empty anonymizes all rows.
+	Where string
+	// EnvVar is the environment variable gating the step. Empty runs the
+	// step unconditionally.
+	EnvVar string
+	// Environments are the values of EnvVar the step runs in.
+	Environments []string
+}
+
+// NewAnonymizeStep returns a new AnonymizeStep.
+//
+// Parameters:
+//   - dialect: DialectPostgres or DialectMySQL.
+//   - table: The table to anonymize.
+//   - key: The column substituted for "{key}" in fake values.
+//   - rules: The rules of the anonymized columns.
+//
+// Returns:
+//   - *AnonymizeStep: A new AnonymizeStep.
+func NewAnonymizeStep(
+	dialect Dialect, table string, key string, rules ...AnonymizeRule,
+) *AnonymizeStep {
+	return &AnonymizeStep{Dialect: dialect, Table: table, Key: key, Rules: rules}
+}
+
+// WithWhere returns a new AnonymizeStep anonymizing only the rows matching
+// where.
+//
+// Parameters:
+//   - where: The condition of the anonymized rows.
+//
+// Returns:
+//   - *AnonymizeStep: A new AnonymizeStep.
+func (s *AnonymizeStep) WithWhere(where string) *AnonymizeStep {
+	new := *s
+	new.Where = where
+	return &new
+}
+
+// WithEnvGuard returns a new AnonymizeStep that only runs when the
+// environment variable name holds one of environments, e.g.
+// WithEnvGuard("APP_ENV", "staging", "dev").
+//
+// Parameters:
+//   - name: The name of the environment variable.
+//   - environments: The values the step runs in.
+//
+// Returns:
+//   - *AnonymizeStep: A new AnonymizeStep.
+func (s *AnonymizeStep) WithEnvGuard(
+	name string, environments ...string,
+) *AnonymizeStep {
+	new := *s
+	new.EnvVar = name
+	new.Environments = environments
+	return &new
+}
+
+// SQL returns the UPDATE statement anonymizing the table.
+//
+// Returns:
+//   - string: The statement.
+//   - error: An error if the dialect, an action or a rule is invalid.
+func (s AnonymizeStep) SQL() (string, error) {
+	if s.Dialect != DialectPostgres && s.Dialect != DialectMySQL {
+		return "", fmt.Errorf("anonymize %s: unsupported dialect %q", s.Table, s.Dialect)
+	}
+	if len(s.Rules) == 0 {
+		return "", fmt.Errorf("anonymize %s: no rules", s.Table)
+	}
+	sets := make([]string, 0, len(s.Rules))
+	for _, rule := range s.Rules {
+		expr, err := s.expression(rule)
+		if err != nil {
+			return "", fmt.Errorf("anonymize %s.%s: %w", s.Table, rule.Column, err)
+		}
+		sets = append(sets, fmt.Sprintf(
+			"%s = CASE WHEN %s IS NULL THEN NULL ELSE %s END",
+			rule.Column, rule.Column, expr,
+		))
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s", s.Table, strings.Join(sets, ", "))
+	if s.Where != "" {
+		query += " WHERE " + s.Where
+	}
+	return query, nil
+}
+
+// expression returns the SQL expression of the anonymized column value.
+func (s AnonymizeStep) expression(rule AnonymizeRule) (string, error) {
+	col := rule.Column
+	mysql := s.Dialect == DialectMySQL
+	switch rule.Action {
+	case AnonymizeHash:
+		if mysql {
+			return fmt.Sprintf("SHA2(CONCAT(%s, %s), 256)", col, sqlString(rule.Salt)), nil
+		}
+		return fmt.Sprintf(
+			"encode(sha256(convert_to(%s::text || %s, 'UTF8')), 'hex')",
+			col, sqlString(rule.Salt),
+		), nil
+	case AnonymizeMask:
+		keep := max(rule.Keep, 0)
+		if mysql {
+			return fmt.Sprintf(
+				"CONCAT(REPEAT('*', GREATEST(CHAR_LENGTH(%s) - %d, 0)), RIGHT(%s, %d))",
+				col, keep, col, keep,
+			), nil
+		}
+		return fmt.Sprintf(
+			"repeat('*', greatest(char_length(%s) - %d, 0)) || right(%s, %d)",
+			col, keep, col, keep,
+		), nil
+	case AnonymizeFake:
+		if !strings.Contains(rule.Template, "{key}") {
+			return sqlString(rule.Template), nil
+		}
+		if s.Key == "" {
+			return "", fmt.Errorf("fake value uses {key} but the step has no key")
+		}
+		key := fmt.Sprintf("CAST(%s AS TEXT)", s.Key)
+		if mysql {
+			key = fmt.Sprintf("CAST(%s AS CHAR)", s.Key)
+		}
+		return fmt.Sprintf(
+			"REPLACE(%s, '{key}', %s)", sqlString(rule.Template), key,
+		), nil
+	default:
+		return "", fmt.Errorf("unknown anonymize action %q", rule.Action)
+	}
+}
+
+// sqlString returns s as a SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// ExecuteUp anonymizes the table unless the environment guard prevents it.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the rules are invalid or the update fails.
+func (s AnonymizeStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	if s.EnvVar != "" {
+		env := os.Getenv(s.EnvVar)
+		if !slices.Contains(s.Environments, env) {
+			contextLogger(ctx).Printf(
+				"Skipping anonymization of %s: %s=%q is not one of %q",
+				s.Table, s.EnvVar, env, s.Environments,
+			)
+			return nil
+		}
+	}
+	query, err := s.SQL()
+	if err != nil {
+		return err
+	}
+	res, err := exec.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("anonymize %s: %w", s.Table, err)
+	}
+	if rows, err := res.RowsAffected(); err == nil {
+		contextLogger(ctx).Printf("Anonymized %d rows of %s", rows, s.Table)
+	}
+	return nil
+}
+
+// ExecuteDown does nothing.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: Always nil.
+func (s AnonymizeStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// placeholderKey is the context key of the bind variable format of the
+// dialect a migration runs against.
+type placeholderKey struct{}
+
+// withPlaceholder returns ctx carrying the bind variable format ph.
+func withPlaceholder(ctx context.Context, ph func(int) string) context.Context {
+	return context.WithValue(ctx, placeholderKey{}, ph)
+}
+
+// placeholderFromContext returns the bind variable format of ctx, "?" when
+// ctx carries none.
+func placeholderFromContext(ctx context.Context) func(int) string {
+	if ph, ok := ctx.Value(placeholderKey{}).(func(int) string); ok {
+		return ph
+	}
+	return questionPlaceholder
+}
+
+// NewSQLMigrationStepArgs returns a new SQLMigrationStep that binds args to
+// the bind variables of sql instead of embedding the values in the SQL.
+// Positional args use the driver's bind variables ("?" or "$1"). Named args
+// created with sql.Named are referenced as ":name" and rewritten to the
+// bind variables of the dialect of the history manager:
+//
+//	NewSQLMigrationStepArgs(
+//		"DELETE FROM events WHERE tenant_id = :tenant AND created < :cutoff",
+//		sql.Named("tenant", tenantID), sql.Named("cutoff", cutoff),
+//	)
+//
+// Parameters:
+//   - sql: The SQL statement to execute.
+//   - args: The values to bind.
+//
+// Returns:
+//   - *SQLMigrationStep: A new SQLMigrationStep.
+func NewSQLMigrationStepArgs(sql string, args ...any) *SQLMigrationStep {
+	return &SQLMigrationStep{
+		SQL:  sql,
+		Args: args,
+	}
+}
+
+// WithArgs returns a new SQLMigrationStep with the given args.
+//
+// Parameters:
+//   - args: The values to bind.
+//
+// Returns:
+//   - *SQLMigrationStep: A new SQLMigrationStep.
+func (s *SQLMigrationStep) WithArgs(args ...any) *SQLMigrationStep {
+	new := *s
+	new.Args = args
+	return &new
+}
+
+// bindArgs returns query and args ready to execute. If args holds named
+// args, or ctx carries the bind variables of the migration, every ":name"
+// parameter outside of string literals, quoted identifiers, dollar-quoted
+// bodies and comments is replaced with a positional bind variable and the
+// matching values are returned in order. Named args take precedence over
+// bind variables. Parameters without a value are an error when the step
+// has named args and are left untouched otherwise, so that SQL written
+// without bind variables in mind runs unchanged.
+func bindArgs(
+	ctx context.Context, query string, args []any,
+) (string, []any, error) {
+	named := maps.Clone(bindVarsFromContext(ctx))
+	count := 0
+	for _, arg := range args {
+		if n, ok := arg.(sql.NamedArg); ok {
+			if named == nil {
+				named = map[string]any{}
+			}
+			named[n.Name] = n.Value
+			count++
+		}
+	}
+	switch {
+	case count > 0 && count != len(args):
+		return "", nil, fmt.Errorf("mixed positional and named args")
+	case len(args) > count || len(named) == 0:
+		return query, args, nil
+	}
+	return bindNamed(query, named, placeholderFromContext(ctx), count > 0)
+}
+
+// bindNamed replaces the ":name" parameters of query with bind variables
+// formatted by ph. Unknown parameters are an error if strict is set.
+func bindNamed(
+	query string, named map[string]any, ph func(int) string, strict bool,
+) (string, []any, error) {
+	var out strings.Builder
+	var values []any
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String(), values, nil
+			}
+			out.WriteString(query[i : i+end+2])
+			i += end + 2
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			out.WriteString(query[i : i+end+4])
+			i += end + 4
+		case c == '$' && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String(), values, nil
+			}
+			out.WriteString(query[i : i+end+2*len(tag)])
+			i += end + 2*len(tag)
+		case strings.HasPrefix(query[i:], "::"):
+			out.WriteString("::")
+			i += 2
+		case c == ':' && i+1 < len(query) && isParamStart(query[i+1]):
+			j := i + 1
+			for j < len(query) && isIdentRune(rune(query[j])) {
+				j++
+			}
+			name := query[i+1 : j]
+			value, ok := named[name]
+			if !ok {
+				if strict {
+					return "", nil, fmt.Errorf("no value for parameter :%s", name)
+				}
+				out.WriteString(query[i:j])
+				i = j
+				continue
+			}
+			values = append(values, value)
+			out.WriteString(ph(len(values)))
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String(), values, nil
+}
+
+// dollarTag returns the "$tag$" opening a dollar-quoted body at the start
+// of s, or "" if s does not start with one.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		switch c := s[j]; {
+		case c == '$':
+			return s[:j+1]
+		case j == 1 && c >= '0' && c <= '9':
+			return ""
+		case !isIdentRune(rune(c)):
+			return ""
+		}
+	}
+	return ""
+}
+
+// isParamStart reports whether c can start a parameter name.
+func isParamStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,98 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPendingMigrations is returned when migrations are pending while the
+// Migrator only asserts that the database is up to date.
+var ErrPendingMigrations = errors.New("pending migrations")
+
+// PendingMigrationsError lists the versions of pending migrations. It wraps
+// ErrPendingMigrations.
+type PendingMigrationsError struct {
+	// Versions holds the pending versions in the order they would be applied.
+	Versions []string
+}
+
+// Error returns the error message.
+func (e *PendingMigrationsError) Error() string {
+	return fmt.Sprintf(
+		"%v: %d not applied (%s)",
+		ErrPendingMigrations, len(e.Versions), strings.Join(e.Versions, ", "),
+	)
+}
+
+// Unwrap returns ErrPendingMigrations.
+func (e *PendingMigrationsError) Unwrap() error {
+	return ErrPendingMigrations
+}
+
+// WithAssertOnly returns a new Migrator that never applies migrations. In
+// assert-only mode MigrateUp behaves like AssertUpToDate, so services whose
+// migrations are run by a separate job refuse to start on an outdated schema
+// instead of changing it themselves.
+//
+// Parameters:
+//   - assertOnly: Whether to only assert that no migrations are pending.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAssertOnly(assertOnly bool) *Migrator {
+	new := *m
+	new.AssertOnly = assertOnly
+	return &new
+}
+
+// AssertUpToDate checks that every loaded migration has been applied. It
+// does not modify the database.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//
+// Returns:
+//   - error: A *PendingMigrationsError if migrations are pending, or an
+//     error if the migrations or the history cannot be loaded.
+func (m *Migrator) AssertUpToDate(ctx context.Context) error {
+	return m.assertUpToDate(ctx, "")
+}
+
+// assertUpToDate checks that no migration up to target is pending.
+func (m *Migrator) assertUpToDate(ctx context.Context, target string) error {
+	all, applied, _, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, mig := range m.pendingMigrations(all, applied, target) {
+		pending = append(pending, mig.Version)
+	}
+	if len(pending) > 0 {
+		err := &PendingMigrationsError{Versions: pending}
+		m.logf("Schema is not up to date: %v", err)
+		return err
+	}
+	m.logf("Schema is up to date")
+	return nil
+}
+
+// pendingMigrations returns the migrations of all that MigrateUp would apply
+// up to target.
+func (m *Migrator) pendingMigrations(
+	all []Migration, applied appliedSet, target string,
+) []Migration {
+	var pending []Migration
+	for _, mig := range all {
+		if applied.has(mig) || m.beforeRange(mig) || m.excluded(mig, nil) {
+			continue
+		}
+		if m.isTargetReached(target, mig, "up") {
+			break
+		}
+		pending = append(pending, mig)
+	}
+	return pending
+}
@@ -0,0 +1,149 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// AuditMode selects what is stored in the audit table for every executed
+// migration.
+type AuditMode int
+
+const (
+	// AuditOff disables the audit table.
+	AuditOff AuditMode = iota
+	// AuditChecksum stores a SHA-256 checksum of the executed SQL.
+	AuditChecksum
+	// AuditSQL stores the checksum and the full text of the executed SQL.
+	AuditSQL
+)
+
+// auditTableSuffix is appended to the history table name to derive the
+// default audit table name.
+const auditTableSuffix = "_audit"
+
+// auditExecutor records the statements executed through it. Since it sees
+// the final queries passed to the database, the recorded SQL reflects what
+// was actually executed, after any templating done by the steps.
+type auditExecutor struct {
+	exec  Executor
+	stmts []string
+}
+
+// ExecContext records the query and executes it on the wrapped executor.
+func (a *auditExecutor) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	a.stmts = append(a.stmts, maskSecrets(ctx, query))
+	return a.exec.ExecContext(ctx, query, args...)
+}
+
+// WithAudit returns a new Migrator that writes a row to the audit table for
+// every executed migration, in the same transaction as the migration itself
+// when the run is transactional.
+//
+// Parameters:
+//   - mode: What to store for the executed SQL.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAudit(mode AuditMode) *Migrator {
+	new := *m
+	new.Audit = mode
+	return &new
+}
+
+// WithAuditTable returns a new Migrator with the given audit table name.
+// When unset, the history table name suffixed with "_audit" is used.
+//
+// Parameters:
+//   - auditTable: The name of the audit table.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAuditTable(auditTable string) *Migrator {
+	new := *m
+	new.AuditTable = auditTable
+	return &new
+}
+
+// auditTable returns the name of the audit table.
+func (m *Migrator) auditTable() string {
+	if m.AuditTable != "" {
+		return m.quotedTable(m.AuditTable)
+	}
+	return m.derivedTable(auditTableSuffix)
+}
+
+// ensureAuditTable creates the audit table if auditing is enabled.
+func (m *Migrator) ensureAuditTable(ctx context.Context) error {
+	if m.Audit == AuditOff {
+		return nil
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		migration_name VARCHAR(255) NOT NULL DEFAULT '',
+		version VARCHAR(255) NOT NULL,
+		direction VARCHAR(4) NOT NULL,
+		executed_at TIMESTAMP NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		sql_text TEXT
+	)`, m.auditTable())
+	if _, err := m.DB.ExecContext(ctx, query); err != nil {
+		m.logf("Error ensuring audit table %s: %v", m.auditTable(), err)
+		return err
+	}
+	return nil
+}
+
+// auditExec returns the executor to run the steps of a migration with. When
+// auditing is enabled it records the executed statements.
+func (m *Migrator) auditExec(run *migrationRun) (Executor, *auditExecutor) {
+	if m.Audit == AuditOff {
+		return run.exec, nil
+	}
+	audit := &auditExecutor{exec: run.exec}
+	return withQueryer(audit, run.exec, nil), audit
+}
+
+// recordAudit writes the audit row of a migration executed in direction.
+func (m *Migrator) recordAudit(
+	ctx context.Context,
+	run *migrationRun,
+	audit *auditExecutor,
+	mig Migration,
+	direction string,
+) error {
+	if audit == nil {
+		return nil
+	}
+	text := strings.Join(audit.stmts, ";\n")
+	sum := sha256.Sum256([]byte(text))
+	var sqlText any
+	if m.Audit == AuditSQL {
+		sqlText = text
+	}
+	query := fmt.Sprintf(
+		`INSERT INTO %s (migration_name, version, direction, executed_at, `+
+			`checksum, sql_text) VALUES (%s)`,
+		m.auditTable(),
+		placeholderList(placeholderFunc(m.HistoryManager), 1, 6),
+	)
+	if _, err := run.history.ExecContext(
+		ctx,
+		query,
+		mig.MigrationName,
+		mig.Version,
+		direction,
+		nowUTC(m.NowFunc),
+		hex.EncodeToString(sum[:]),
+		sqlText,
+	); err != nil {
+		m.logf("Error recording audit for %s: %v", mig.Version, err)
+		return err
+	}
+	return nil
+}
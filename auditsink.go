@@ -0,0 +1,182 @@
+package migrator
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventRecord is the JSON form of an event written by JSONAuditSink and
+// JSONLogSink. Field names are stable so that downstream pipelines can
+// rely on them.
+type eventRecord struct {
+	Time          time.Time     `json:"time"`
+	Level         string        `json:"level,omitempty"`
+	Msg           string        `json:"msg,omitempty"`
+	Event         EventType     `json:"event"`
+	Direction     string        `json:"direction,omitempty"`
+	Host          string        `json:"host,omitempty"`
+	User          string        `json:"user,omitempty"`
+	Version       string        `json:"version,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	MigrationName string        `json:"migration_name,omitempty"`
+	Ticket        string        `json:"ticket,omitempty"`
+	Checksum      string        `json:"checksum,omitempty"`
+	Step          int           `json:"step,omitempty"`
+	DurationMS    *int64        `json:"duration_ms,omitempty"`
+	Progress      *StepProgress `json:"progress,omitempty"`
+	Lock          *LockHolder   `json:"lock,omitempty"`
+	RowsAffected  *int64        `json:"rows_affected,omitempty"`
+	Migrations    *int          `json:"migrations,omitempty"`
+	WarningCode   string        `json:"warning_code,omitempty"`
+	Warning       string        `json:"warning,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// newEventRecord returns the JSON form of event.
+func newEventRecord(event Event) eventRecord {
+	record := eventRecord{
+		Time:      event.Time,
+		Event:     event.Type,
+		Direction: event.Direction,
+		Step:      event.Step,
+	}
+	if mig := event.Migration; mig != nil {
+		record.Version = mig.Version
+		record.Name = mig.Name
+		record.MigrationName = mig.MigrationName
+		record.Ticket = mig.Ticket
+		record.Checksum = mig.Checksum
+		switch event.Type {
+		case EventMigrationApplied, EventMigrationRolledBack:
+			duration := mig.Duration.Milliseconds()
+			rows := mig.TotalRowsAffected()
+			record.DurationMS, record.RowsAffected = &duration, &rows
+		}
+	}
+	if event.Step > 0 {
+		duration := event.Duration.Milliseconds()
+		record.DurationMS = &duration
+	}
+	record.Progress = event.Progress
+	record.Lock = event.Lock
+	if event.Type == EventLockWaiting {
+		duration := event.Duration.Milliseconds()
+		record.DurationMS = &duration
+	}
+	if event.Warning != nil {
+		record.WarningCode = string(event.Warning.Code)
+		record.Warning = event.Warning.Message
+	}
+	if event.Result != nil {
+		duration := event.Result.Duration.Milliseconds()
+		count := len(event.Result.Migrations)
+		record.DurationMS, record.Migrations = &duration, &count
+	}
+	if event.Err != nil {
+		record.Error = event.Err.Error()
+	}
+	return record
+}
+
+// JSONAuditSink is an EventSink that writes every event as a line of JSON
+// (NDJSON), suitable for shipping to SIEM and compliance pipelines. Each
+// line holds the event type, time, host and OS user, plus the migration,
+// checksum, duration, warning or error the event carries.
+type JSONAuditSink struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	closer   io.Closer
+	identity applierIdentity
+	err      error
+}
+
+// NewJSONAuditSink returns a new JSONAuditSink writing to w.
+//
+// Parameters:
+//   - w: The writer to write events to.
+//
+// Returns:
+//   - *JSONAuditSink: A new JSONAuditSink.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{
+		enc:      json.NewEncoder(w),
+		identity: currentIdentity(""),
+	}
+}
+
+// OpenJSONAuditSink returns a new JSONAuditSink appending to the file at
+// path, which is created if it does not exist. Close the sink to close the
+// file.
+//
+// Parameters:
+//   - path: The path of the file.
+//
+// Returns:
+//   - *JSONAuditSink: A new JSONAuditSink.
+//   - error: An error if the file cannot be opened.
+func OpenJSONAuditSink(path string) (*JSONAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewJSONAuditSink(f)
+	sink.closer = f
+	return sink, nil
+}
+
+// HandleEvent writes event as a line of JSON. Write errors are kept and
+// reported by Err, since they must not interrupt the migrations.
+//
+// Parameters:
+//   - event: The event to write.
+func (s *JSONAuditSink) HandleEvent(event Event) {
+	record := newEventRecord(event)
+	record.Host, record.User = s.identity.host, s.identity.user
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(record); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Err returns the first error that occurred writing an event.
+//
+// Returns:
+//   - error: The first write error, or nil.
+func (s *JSONAuditSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close closes the file opened by OpenJSONAuditSink. It does nothing for
+// sinks created with NewJSONAuditSink.
+//
+// Returns:
+//   - error: An error if closing the file fails.
+func (s *JSONAuditSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// MultiEventSink returns an EventSink delivering every event to each of
+// sinks in order, e.g. to stream events to an audit file and a metrics
+// sink at once.
+//
+// Parameters:
+//   - sinks: The sinks to deliver events to.
+//
+// Returns:
+//   - EventSink: The combined sink.
+func MultiEventSink(sinks ...EventSink) EventSink {
+	return EventSinkFunc(func(event Event) {
+		for _, sink := range sinks {
+			sink.HandleEvent(event)
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// backfillTableSuffix is appended to the history table name to derive the
+// name of the table holding backfill cursors.
+const backfillTableSuffix = "_backfills"
+
+// DefaultBackfillTable holds the backfill cursors of steps executed
+// outside a Migrator, e.g. by scheduled jobs.
+const DefaultBackfillTable = "migrator_backfills"
+
+// backfillTableKey is the context key of the backfill cursor table.
+type backfillTableKey struct{}
+
+// backfillTable returns the backfill cursor table of the Migrator executing
+// the step, or DefaultBackfillTable.
+func backfillTable(ctx context.Context) string {
+	if table, ok := ctx.Value(backfillTableKey{}).(string); ok {
+		return table
+	}
+	return DefaultBackfillTable
+}
+
+// BackfillStep updates a large table in batches of consecutive keys and
+// persists the last processed key, so that a backfill running for hours
+// resumes where it stopped after a restart instead of starting over. SQL
+// processes one batch and references its first and last key as ":from" and
+// ":to", e.g. "UPDATE users SET email_lower = lower(email) WHERE id BETWEEN
+// :from AND :to". After every batch the step reports its progress, the
+// number of processed rows out of the rows of the table, as an
+// EventStepProgress event.
+//
+// Cursors are kept in a table named after the history table suffixed with
+// "_backfills", and are written through the step's executor: in
+// transactional runs they are only persisted when the run commits, so
+// long backfills belong in non-transactional runs. A completed backfill is
+// skipped when executed again; its down direction deletes the cursor so
+// that it can run again.
+type BackfillStep struct {
+	// Name identifies the cursor of the backfill.
+	Name  string
+	Table string
+	// Key is a unique, ordered column of Table, usually the primary key.
+	Key       string
+	SQL       string
+	BatchSize int
+	Pacer     *ReplicaPacer
+}
+
+// NewBackfillStep returns a new BackfillStep processing 1000 keys per
+// batch.
+//
+// Parameters:
+//   - name: The name of the backfill cursor.
+//   - table: The table to backfill.
+//   - key: The unique, ordered column the batches are built on.
+//   - sql: The SQL of one batch, referencing ":from" and ":to".
+//
+// Returns:
+//   - *BackfillStep: A new BackfillStep.
+func NewBackfillStep(
+	name string, table string, key string, sql string,
+) *BackfillStep {
+	return &BackfillStep{
+		Name:      name,
+		Table:     table,
+		Key:       key,
+		SQL:       sql,
+		BatchSize: 1000,
+	}
+}
+
+// WithBatchSize returns a new BackfillStep processing size keys per batch.
+//
+// Parameters:
+//   - size: The number of keys per batch.
+//
+// Returns:
+//   - *BackfillStep: A new BackfillStep.
+func (b *BackfillStep) WithBatchSize(size int) *BackfillStep {
+	new := *b
+	new.BatchSize = size
+	return &new
+}
+
+// WithPacer returns a new BackfillStep waiting for replicas to catch up
+// between batches.
+//
+// Parameters:
+//   - pacer: The pacer to wait for between batches.
+//
+// Returns:
+//   - *BackfillStep: A new BackfillStep.
+func (b *BackfillStep) WithPacer(pacer *ReplicaPacer) *BackfillStep {
+	new := *b
+	new.Pacer = pacer
+	return &new
+}
+
+// backfillCursor is the persisted state of a backfill.
+type backfillCursor struct {
+	lastKey   sql.NullString
+	done      int64
+	completed bool
+}
+
+// ExecuteUp processes the remaining batches.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection. It must implement Queryer.
+//
+// Returns:
+//   - error: An error if a batch, persisting the cursor or pacing fails.
+func (b BackfillStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	q, ok := exec.(Queryer)
+	if !ok {
+		return fmt.Errorf(
+			"backfill %s: executor %T cannot query: %w",
+			b.Name, exec, errors.ErrUnsupported,
+		)
+	}
+	table := backfillTable(ctx)
+	if err := ensureBackfillTable(ctx, exec, table); err != nil {
+		return err
+	}
+	ph := placeholderFromContext(ctx)
+	cursor, err := b.loadCursor(ctx, q, table, ph)
+	if err != nil {
+		return err
+	}
+	if cursor.completed {
+		contextLogger(ctx).Printf("Backfill %s already completed", b.Name)
+		return nil
+	}
+	remaining, err := b.count(ctx, q, cursor.lastKey, ph)
+	if err != nil {
+		return err
+	}
+	total := cursor.done + remaining
+	if cursor.lastKey.Valid {
+		contextLogger(ctx).Printf(
+			"Resuming backfill %s after key %s (%d of %d rows done)",
+			b.Name, cursor.lastKey.String, cursor.done, total,
+		)
+	}
+	for batch := 1; ; batch++ {
+		if batch > 1 {
+			if err := WaitIfPaused(ctx); err != nil {
+				return fmt.Errorf("backfill %s: after batch %d: %w", b.Name, batch-1, err)
+			}
+		}
+		from, to, n, err := b.nextBatch(ctx, q, cursor.lastKey, ph)
+		if err != nil {
+			return fmt.Errorf("backfill %s: batch %d: %w", b.Name, batch, err)
+		}
+		if n == 0 {
+			cursor.completed = true
+			if err := b.saveCursor(ctx, exec, table, ph, cursor); err != nil {
+				return err
+			}
+			contextLogger(ctx).Printf(
+				"Backfill %s completed: %d rows", b.Name, cursor.done,
+			)
+			return nil
+		}
+		query, args, err := bindArgs(
+			ctx, b.SQL, []any{sql.Named("from", from), sql.Named("to", to)},
+		)
+		if err != nil {
+			return fmt.Errorf("backfill %s: %w", b.Name, err)
+		}
+		if _, err := exec.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("backfill %s: batch %d: %w", b.Name, batch, err)
+		}
+		cursor.lastKey = sql.NullString{String: to, Valid: true}
+		cursor.done += n
+		if err := b.saveCursor(ctx, exec, table, ph, cursor); err != nil {
+			return err
+		}
+		ReportProgress(ctx, StepProgress{
+			Phase: "backfilling " + b.Table,
+			Done:  cursor.done,
+			Total: max(total, cursor.done),
+		})
+		if b.Pacer != nil {
+			if err := b.Pacer.Wait(ctx, exec); err != nil {
+				return fmt.Errorf("backfill %s: after batch %d: %w", b.Name, batch, err)
+			}
+		}
+	}
+}
+
+// ExecuteDown deletes the cursor of the backfill.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if deleting the cursor fails.
+func (b BackfillStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	table := backfillTable(ctx)
+	if err := ensureBackfillTable(ctx, exec, table); err != nil {
+		return err
+	}
+	_, err := exec.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE name = %s", table, placeholderFromContext(ctx)(1),
+	), b.Name)
+	return err
+}
+
+// ensureBackfillTable creates the backfill cursor table.
+func ensureBackfillTable(ctx context.Context, exec Executor, table string) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		last_key VARCHAR(255),
+		done BIGINT NOT NULL,
+		completed BOOLEAN NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`, table)
+	if _, err := exec.ExecContext(ctx, query); err != nil {
+		contextLogger(ctx).Printf(
+			"Error ensuring backfill table %s: %v", table, err,
+		)
+		return err
+	}
+	return nil
+}
+
+// loadCursor returns the persisted cursor, or an empty cursor if the
+// backfill has not started.
+func (b BackfillStep) loadCursor(
+	ctx context.Context, q Queryer, table string, ph func(int) string,
+) (backfillCursor, error) {
+	var cursor backfillCursor
+	err := q.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT last_key, done, completed FROM %s WHERE name = %s", table, ph(1),
+	), b.Name).Scan(&cursor.lastKey, &cursor.done, &cursor.completed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return backfillCursor{}, fmt.Errorf("read cursor of backfill %s: %w", b.Name, err)
+	}
+	return cursor, nil
+}
+
+// saveCursor persists cursor.
+func (b BackfillStep) saveCursor(
+	ctx context.Context,
+	exec Executor,
+	table string,
+	ph func(int) string,
+	cursor backfillCursor,
+) error {
+	if _, err := exec.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE name = %s", table, ph(1),
+	), b.Name); err != nil {
+		return fmt.Errorf("save cursor of backfill %s: %w", b.Name, err)
+	}
+	if _, err := exec.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (name, last_key, done, completed, updated_at) VALUES (%s)",
+		table, placeholderList(ph, 1, 5),
+	), b.Name, cursor.lastKey, cursor.done, cursor.completed, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("save cursor of backfill %s: %w", b.Name, err)
+	}
+	return nil
+}
+
+// after returns the condition and arguments selecting the keys after
+// lastKey.
+func (b BackfillStep) after(
+	lastKey sql.NullString, ph func(int) string,
+) (string, []any) {
+	if !lastKey.Valid {
+		return "", nil
+	}
+	return fmt.Sprintf(" WHERE %s > %s", b.Key, ph(1)), []any{lastKey.String}
+}
+
+// count returns the number of rows after lastKey.
+func (b BackfillStep) count(
+	ctx context.Context, q Queryer, lastKey sql.NullString, ph func(int) string,
+) (int64, error) {
+	where, args := b.after(lastKey, ph)
+	var n int64
+	err := q.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s%s", b.Table, where,
+	), args...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count rows of backfill %s: %w", b.Name, err)
+	}
+	return n, nil
+}
+
+// nextBatch returns the first and last key and the number of rows of the
+// batch following lastKey.
+func (b BackfillStep) nextBatch(
+	ctx context.Context, q Queryer, lastKey sql.NullString, ph func(int) string,
+) (string, string, int64, error) {
+	where, args := b.after(lastKey, ph)
+	var from, to sql.NullString
+	var n int64
+	err := q.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT MIN(%s), MAX(%s), COUNT(*) FROM "+
+			"(SELECT %s FROM %s%s ORDER BY %s LIMIT %d) batch",
+		b.Key, b.Key, b.Key, b.Table, where, b.Key, max(b.BatchSize, 1),
+	), args...).Scan(&from, &to, &n)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return from.String, to.String, n, nil
+}
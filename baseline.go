@@ -0,0 +1,79 @@
+package migrator
+
+import (
+	"context"
+)
+
+// Baseline records the pending migrations up to and including version as
+// applied without executing them, for adopting the migrator on a database
+// whose schema already holds their changes. Later runs apply only the
+// migrations after version. The records are written in one transaction
+// regardless of the Transactional setting, under the run lock if enabled.
+// It fails with a *RunInProgressError while another run of the process
+// uses the same database and history table.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - version: The version of the last migration the schema holds.
+//
+// Returns:
+//   - error: An error if version is invalid or the history cannot be
+//     written.
+func (m *Migrator) Baseline(ctx context.Context, version string) error {
+	m.logf("Starting Baseline at version %s", version)
+
+	if !m.validVersion(version) {
+		return m.versionFormatError("baseline version", version)
+	}
+	endRun, err := m.beginRun("up")
+	if err != nil {
+		return err
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return err
+	}
+	if err := m.ensureHistoryTable(ctx); err != nil {
+		return err
+	}
+	if err := m.checkHistoryKey(ctx); err != nil {
+		return err
+	}
+	release, err := m.acquireLock(ctx, "up")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	all, applied, _, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	pending := m.pendingMigrations(all, applied, version)
+	if len(pending) == 0 {
+		m.logf("No pending migrations up to version %s to baseline", version)
+		return nil
+	}
+
+	tx := m.WithTransactional(true).WithTxPerMigration(false)
+	_, err = tx.runMigrationsIfTransactional(
+		ctx,
+		func(run *migrationRun) (int, error) {
+			entries := make([]HistoryEntry, len(pending))
+			for i, mig := range pending {
+				entries[i] = run.newEntry(mig)
+			}
+			return len(entries), m.recordEntries(ctx, run, entries)
+		},
+	)
+	m.InvalidateCache()
+	if err != nil {
+		return err
+	}
+
+	m.logf(
+		"Baseline complete. Recorded %d migrations as applied up to %s",
+		len(pending), version,
+	)
+	return nil
+}
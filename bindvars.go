@@ -0,0 +1,57 @@
+package migrator
+
+import (
+	"context"
+	"maps"
+)
+
+// bindVarsKey is the context key of the bind variables of a migration.
+type bindVarsKey struct{}
+
+// withBindVars returns ctx carrying the bind variables vars.
+func withBindVars(ctx context.Context, vars map[string]any) context.Context {
+	if len(vars) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bindVarsKey{}, vars)
+}
+
+// bindVarsFromContext returns the bind variables of ctx, or nil.
+func bindVarsFromContext(ctx context.Context) map[string]any {
+	vars, _ := ctx.Value(bindVarsKey{}).(map[string]any)
+	return vars
+}
+
+// WithBindVars returns a new Migrator that binds named values to the SQL
+// steps of migrations, keyed by migration version. A ":name" parameter in
+// the SQL of a migration, including SQL files, is replaced with a bind
+// variable holding the value, so that environment-specific constants such
+// as tenant IDs or retention periods stay out of the SQL itself. Values
+// set here override those in Migration.BindVars.
+//
+// Parameters:
+//   - vars: The named values of each migration version.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithBindVars(vars map[string]map[string]any) *Migrator {
+	new := *m
+	new.BindVars = vars
+	return &new
+}
+
+// bindVars returns the bind variables of mig: Migration.BindVars
+// overridden by the Migrator's BindVars of its version.
+func (m *Migrator) bindVars(mig Migration) map[string]any {
+	configured := m.BindVars[mig.Version]
+	if len(configured) == 0 {
+		return mig.BindVars
+	}
+	if len(mig.BindVars) == 0 {
+		return configured
+	}
+	vars := make(map[string]any, len(mig.BindVars)+len(configured))
+	maps.Copy(vars, mig.BindVars)
+	maps.Copy(vars, configured)
+	return vars
+}
@@ -0,0 +1,262 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SourceChecker is implemented by migration sources that can validate their
+// input strictly, reporting problems that LoadMigrations tolerates, such as
+// files that do not match the filename format.
+type SourceChecker interface {
+	// Check validates the source without a database connection.
+	Check() error
+}
+
+// sqlStep is implemented by steps whose SQL can be read without executing
+// them.
+type sqlStep interface {
+	// stepSQL returns the SQL executed by the step.
+	stepSQL() (string, error)
+}
+
+// stepSQL returns the SQL of the step.
+func (s SQLMigrationStep) stepSQL() (string, error) {
+	return s.SQL, nil
+}
+
+// stepSQL returns the SQL of one batch of the step.
+func (b BatchMigrationStep) stepSQL() (string, error) {
+	return b.SQL, nil
+}
+
+// stepSQL returns the ALTER TABLE statement the tool applies.
+func (s OnlineSchemaChangeStep) stepSQL() (string, error) {
+	return "ALTER TABLE " + s.Table + " " + s.Alter, nil
+}
+
+// stepSQL returns the statement building the index.
+func (s ConcurrentIndexStep) stepSQL() (string, error) {
+	return s.createSQL(), nil
+}
+
+// stepSQL reads and returns the SQL stored in the file.
+func (f FileSQLMigrationStep) stepSQL() (string, error) {
+	content, err := f.readFile(f.Path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Check validates all sources without a database connection, for use as a
+// fast CI gate. It checks filenames strictly for sources implementing
+// SourceChecker, requires numeric, ULID or UUID versions that are unique
+// per migration name, splits the SQL of every step into statements and
+// requires every migration to define down steps. All problems found are
+// returned joined.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - error: The problems found, or nil if the sources are valid.
+func (m *Migrator) Check(ctx context.Context) error {
+	m.logf("Starting Check")
+
+	var errs []error
+	for _, src := range m.allSources() {
+		if checker, ok := src.(SourceChecker); ok {
+			if err := checker.Check(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	all, err := m.LoadAllMigrations()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	seen := make(map[string]map[string]bool)
+	for _, mig := range all {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		errs = append(errs, m.checkMigration(mig, seen)...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		m.logf("Check found %d problems", len(errs))
+		return err
+	}
+	m.logf("Check complete. %d migrations are valid", len(all))
+	return nil
+}
+
+// checkMigration validates a single migration. seen tracks the versions
+// already used per migration name.
+func (m *Migrator) checkMigration(
+	mig Migration, seen map[string]map[string]bool,
+) []error {
+	var errs []error
+	if !m.validVersion(mig.Version) {
+		errs = append(errs, fmt.Errorf(
+			"migration %s (%s): %w", mig.Version, mig.Name,
+			m.versionFormatError("version", mig.Version),
+		))
+	}
+	if seen[mig.MigrationName] == nil {
+		seen[mig.MigrationName] = make(map[string]bool)
+	}
+	if seen[mig.MigrationName][mig.Version] {
+		errs = append(errs, fmt.Errorf(
+			"migration %s (%s): duplicate version", mig.Version, mig.Name,
+		))
+	}
+	seen[mig.MigrationName][mig.Version] = true
+
+	if len(mig.DownSteps) == 0 {
+		errs = append(errs, fmt.Errorf(
+			"migration %s (%s): no down steps defined", mig.Version, mig.Name,
+		))
+	}
+	errs = append(errs, checkSteps(mig, mig.UpSteps, "up")...)
+	errs = append(errs, checkSteps(mig, mig.DownSteps, "down")...)
+	return errs
+}
+
+// checkSteps splits the SQL of every step into statements. A down step
+// without statements is reported since the migration cannot be reverted.
+func checkSteps(mig Migration, steps []MigrationStep, direction string) []error {
+	var errs []error
+	for idx, step := range steps {
+		s, ok := step.(sqlStep)
+		if !ok {
+			continue
+		}
+		sql, err := s.stepSQL()
+		if err == nil {
+			var stmts []string
+			stmts, err = SplitStatements(sql)
+			if err == nil && len(stmts) == 0 && direction == "down" {
+				err = errors.New("no statements")
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"migration %s (%s): %s step %d: %w",
+				mig.Version, mig.Name, direction, idx+1, err,
+			))
+		}
+	}
+	return errs
+}
+
+// Check validates the directory strictly. Unlike LoadMigrations, which skips
+// them, files with an allowed extension that do not match the filename
+// format are reported. Every version must have exactly one up file, at most
+// one down file and a single name.
+//
+// Returns:
+//   - error: The problems found, or nil if the directory is valid.
+func (d *DirMigrationSource) Check() error {
+	files, err := readDir(d.fsys, d.Dir)
+	if err != nil {
+		return err
+	}
+	parser := d.FilenameParser
+	if parser == nil {
+		parser = defaultParseFilename
+	}
+	allowed := d.AllowedExts
+	if allowed == nil {
+		allowed = []string{".sql", ".sqlite"}
+	}
+
+	var errs []error
+	byVersion := make(map[string][]dirEntry)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() ||
+			!slices.Contains(allowed, strings.ToLower(path.Ext(name))) {
+			continue
+		}
+		version, migName, direction, ok := parser(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf(
+				"file %s does not match the filename format",
+				filepath.Join(d.Dir, name),
+			))
+			continue
+		}
+		if direction != "up" && direction != "down" {
+			errs = append(errs, fmt.Errorf(
+				"file %s has invalid direction %q",
+				filepath.Join(d.Dir, name), direction,
+			))
+			continue
+		}
+		byVersion[version] = append(byVersion[version], dirEntry{
+			version:   version,
+			name:      migName,
+			direction: direction,
+			filename:  name,
+		})
+	}
+
+	for _, version := range slices.Sorted(maps.Keys(byVersion)) {
+		entries := byVersion[version]
+		ups, downs := 0, 0
+		for _, entry := range entries {
+			if entry.name != entries[0].name {
+				errs = append(errs, fmt.Errorf(
+					"version %s has conflicting names %q and %q",
+					version, entries[0].name, entry.name,
+				))
+			}
+			if entry.direction == "up" {
+				ups++
+			} else {
+				downs++
+			}
+		}
+		if ups != 1 {
+			errs = append(errs, fmt.Errorf(
+				"version %s has %d up files, want 1", version, ups,
+			))
+		}
+		if downs > 1 {
+			errs = append(errs, fmt.Errorf(
+				"version %s has %d down files, want at most 1", version, downs,
+			))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check validates the filename of the migration file when a filename parser
+// is set.
+//
+// Returns:
+//   - error: An error if the file is missing or its name cannot be parsed.
+func (f *FileMigrationSource) Check() error {
+	if _, err := os.Stat(f.FilePath); err != nil {
+		return err
+	}
+	if f.FilenameParser == nil {
+		return nil
+	}
+	if _, _, _, ok := f.FilenameParser(path.Base(f.FilePath)); !ok {
+		return fmt.Errorf(
+			"file %s does not match the filename format", f.FilePath,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// stepTableSuffix is appended to the history table name to derive the name
+// of the step table.
+const stepTableSuffix = "_steps"
+
+// StepEntry is the record of a completed up step of a migration.
+type StepEntry struct {
+	MigrationName string
+	Version       string
+	// Step is the 1-based index of the step in the migration.
+	Step        int
+	CompletedAt time.Time
+	// Duration is the time it took to execute the step.
+	Duration time.Duration
+}
+
+// WithStepCheckpoints returns a new Migrator that records the completion of
+// every up step of non-transactional runs in a step table (the history
+// table name suffixed with "_steps"). When a migration fails halfway, the
+// next run resumes at the failed step instead of repeating the completed
+// ones. The checkpoints of a migration are removed once it is recorded as
+// applied, unless step history is enabled. Transactional runs roll back
+// completed steps on failure and ignore this setting.
+//
+// Parameters:
+//   - enabled: Whether to checkpoint steps.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithStepCheckpoints(enabled bool) *Migrator {
+	new := *m
+	new.StepCheckpoints = enabled
+	return &new
+}
+
+// WithStepHistory returns a new Migrator that keeps a record of every
+// completed up step, with its completion time and duration, in the step
+// table. The records of a migration are removed when it is rolled back.
+// StepEntries returns them, e.g. to find the slow step of a long
+// migration.
+//
+// Parameters:
+//   - enabled: Whether to record step history.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithStepHistory(enabled bool) *Migrator {
+	new := *m
+	new.StepHistory = enabled
+	return &new
+}
+
+// checkpointsEnabled reports whether steps are checkpointed.
+func (m *Migrator) checkpointsEnabled() bool {
+	return m.StepCheckpoints && !m.migrationsTransactional()
+}
+
+// stepsRecorded reports whether step completions are written to the step
+// table.
+func (m *Migrator) stepsRecorded() bool {
+	return m.StepHistory || m.checkpointsEnabled()
+}
+
+// stepTable returns the name of the step table.
+func (m *Migrator) stepTable() string {
+	return m.derivedTable(stepTableSuffix)
+}
+
+// ensureStepTable creates the step table if steps are recorded.
+func (m *Migrator) ensureStepTable(ctx context.Context) error {
+	if !m.stepsRecorded() {
+		return nil
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		migration_name VARCHAR(255) NOT NULL DEFAULT '',
+		version VARCHAR(255) NOT NULL,
+		step INTEGER NOT NULL,
+		completed_at TIMESTAMP NOT NULL,
+		duration_ms BIGINT,
+		PRIMARY KEY (migration_name, version, step)
+	)`, m.stepTable())
+	if _, err := m.DB.ExecContext(ctx, query); err != nil {
+		m.logf("Error ensuring step table %s: %v", m.stepTable(), err)
+		return err
+	}
+	return nil
+}
+
+// StepEntries returns the recorded up steps of the Migrator's migrations,
+// ordered by migration name, version and step.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//
+// Returns:
+//   - []StepEntry: The recorded steps.
+//   - error: An error if the step table cannot be read.
+func (m *Migrator) StepEntries(ctx context.Context) ([]StepEntry, error) {
+	query := fmt.Sprintf(
+		`SELECT migration_name, version, step, completed_at, duration_ms `+
+			`FROM %s ORDER BY migration_name, version, step`,
+		m.stepTable(),
+	)
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []StepEntry
+	for rows.Next() {
+		var (
+			entry       StepEntry
+			completedAt any
+			durationMS  sql.NullInt64
+		)
+		if err := rows.Scan(
+			&entry.MigrationName,
+			&entry.Version,
+			&entry.Step,
+			&completedAt,
+			&durationMS,
+		); err != nil {
+			return nil, err
+		}
+		if entry.CompletedAt, err = parseHistoryTime(completedAt); err != nil {
+			return nil, err
+		}
+		entry.Duration = time.Duration(durationMS.Int64) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// stepCheckpoint records the steps of a migration and holds those completed
+// by earlier runs.
+type stepCheckpoint struct {
+	m    *Migrator
+	exec Executor
+	mig  Migration
+	done map[int]bool
+	// resume is set when steps completed by an earlier run were loaded.
+	resume bool
+}
+
+// loadCheckpoint returns the checkpoint of mig writing through exec, or nil
+// if steps are not recorded. Completed steps are only loaded when
+// checkpoints are enabled.
+func (m *Migrator) loadCheckpoint(
+	ctx context.Context, exec Executor, mig Migration,
+) (*stepCheckpoint, error) {
+	if !m.stepsRecorded() {
+		return nil, nil
+	}
+	cp := &stepCheckpoint{m: m, exec: exec, mig: mig, done: make(map[int]bool)}
+	if !m.checkpointsEnabled() {
+		return cp, nil
+	}
+	bind := placeholderFunc(m.HistoryManager)
+	query := fmt.Sprintf(
+		`SELECT step FROM %s WHERE migration_name = %s AND version = %s`,
+		m.stepTable(), bind(1), bind(2),
+	)
+	rows, err := m.DB.QueryContext(ctx, query, mig.MigrationName, mig.Version)
+	if err != nil {
+		m.logf("Error loading step checkpoints of %s: %v", mig.Version, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var step int
+		if err := rows.Scan(&step); err != nil {
+			return nil, err
+		}
+		cp.done[step] = true
+	}
+	if len(cp.done) > 0 {
+		cp.resume = true
+		m.logf(
+			"Resuming migration %s after %d completed steps",
+			mig.Version, len(cp.done),
+		)
+	}
+	return cp, rows.Err()
+}
+
+// completed reports whether the step with the 1-based index step was
+// completed by an earlier run.
+func (c *stepCheckpoint) completed(step int) bool {
+	return c != nil && c.done[step]
+}
+
+// resumed reports whether the migration is resumed after an earlier run
+// completed some of its steps.
+func (c *stepCheckpoint) resumed() bool {
+	return c != nil && c.resume
+}
+
+// record stores the completion of the step with the 1-based index step.
+func (c *stepCheckpoint) record(
+	ctx context.Context, step int, duration time.Duration,
+) error {
+	if c == nil {
+		return nil
+	}
+	bind := placeholderFunc(c.m.HistoryManager)
+	query := fmt.Sprintf(
+		`INSERT INTO %s (migration_name, version, step, completed_at, `+
+			`duration_ms) VALUES (%s)`,
+		c.m.stepTable(), placeholderList(bind, 1, 5),
+	)
+	if _, err := c.exec.ExecContext(
+		ctx, query, c.mig.MigrationName, c.mig.Version, step,
+		nowUTC(c.m.NowFunc), duration.Milliseconds(),
+	); err != nil {
+		c.m.logf("Error recording step %d of %s: %v", step, c.mig.Version, err)
+		return err
+	}
+	c.done[step] = true
+	return nil
+}
+
+// clear removes the checkpoints of the migration once it is recorded as
+// applied. They are kept as step history when enabled.
+func (c *stepCheckpoint) clear(ctx context.Context) error {
+	if c == nil || c.m.StepHistory {
+		return nil
+	}
+	return c.m.removeSteps(ctx, c.exec, c.mig)
+}
+
+// removeSteps deletes the step records of mig through exec.
+func (m *Migrator) removeSteps(
+	ctx context.Context, exec Executor, mig Migration,
+) error {
+	bind := placeholderFunc(m.HistoryManager)
+	query := fmt.Sprintf(
+		`DELETE FROM %s WHERE migration_name = %s AND version = %s`,
+		m.stepTable(), bind(1), bind(2),
+	)
+	if _, err := exec.ExecContext(
+		ctx, query, mig.MigrationName, mig.Version,
+	); err != nil {
+		m.logf("Error removing step records of %s: %v", mig.Version, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io/fs"
+	"runtime"
+	"sync"
+)
+
+// checksumFile is a file that contributes to a migration checksum.
+type checksumFile struct {
+	direction string
+	path      string
+	// fsys is the file system holding path, nil for the operating system's.
+	fsys fs.FS
+}
+
+// checksumFiles returns the hex encoded SHA-256 checksum of the given files.
+// Each file contributes its direction and content so that moving SQL between
+// the up and down files changes the checksum.
+func checksumFiles(files []checksumFile) (string, error) {
+	h := sha256.New()
+	for _, f := range files {
+		content, err := readFile(f.fsys, f.path)
+		if err != nil {
+			return "", err
+		}
+		writeChecksumPart(h, f.direction, content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksumPart writes the direction and content of a file or step to
+// the checksum h.
+func writeChecksumPart(h hash.Hash, direction string, content []byte) {
+	h.Write([]byte(direction))
+	h.Write([]byte{0})
+	h.Write(content)
+	h.Write([]byte{0})
+}
+
+// computeChecksums computes the checksum of every job using at most workers
+// concurrent workers. The returned checksums have the same order as jobs. If
+// workers is not positive, GOMAXPROCS workers are used.
+func computeChecksums(
+	jobs [][]checksumFile, workers int,
+) ([]string, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(jobs))
+
+	indexes := make(chan int)
+	sums := make([]string, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sums[i], errs[i] = checksumFiles(jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aatuh/migrator"
+)
+
+// config holds the settings of the command.
+type config struct {
+	// Driver is the name of the database/sql driver: pgx or mysql.
+	Driver string `json:"driver"`
+	// DSN is the data source name passed to the driver.
+	DSN string `json:"dsn"`
+	// Dir is the directory holding the migration files.
+	Dir string `json:"dir"`
+	// Table is the name of the history table.
+	Table string `json:"table"`
+	// MigrationName is the history namespace of the migrations.
+	MigrationName string `json:"migration_name"`
+	// Transactional runs all migrations of a run in one transaction.
+	Transactional bool `json:"transactional"`
+	// LockTimeout is how long to wait for the run lock, e.g. "30s". Empty
+	// or zero runs without the lock.
+	LockTimeout string `json:"lock_timeout"`
+	// Quiet discards the log output of the migrator.
+	Quiet bool `json:"quiet"`
+	// VersionFormat is the version format of created migrations:
+	// sequential, timestamp, ulid or uuid. Empty continues the format of
+	// the existing migrations.
+	VersionFormat string `json:"version_format"`
+}
+
+// defaultConfig returns the settings used when neither a config file nor a
+// flag sets them.
+func defaultConfig() config {
+	return config{
+		DSN:           os.Getenv("MIGRATOR_DSN"),
+		Dir:           "migrations",
+		Table:         "schema_migrations",
+		MigrationName: "app",
+	}
+}
+
+// lockTimeout returns the parsed LockTimeout.
+func (c config) lockTimeout() (time.Duration, error) {
+	if c.LockTimeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.LockTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("lock timeout: %w", err)
+	}
+	return timeout, nil
+}
+
+// versionGenerator returns the generator of the versions of created
+// migrations, nil to detect it from the existing ones.
+func (c config) versionGenerator() (migrator.VersionGenerator, error) {
+	switch c.VersionFormat {
+	case "":
+		return nil, nil
+	case "sequential":
+		return migrator.SequentialVersion(migrator.DefaultSequentialWidth), nil
+	case "timestamp":
+		return migrator.TimestampVersion(nil), nil
+	case "ulid":
+		return migrator.ULIDVersion(nil, nil), nil
+	case "uuid":
+		return migrator.UUIDVersion(nil, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown version format %q", c.VersionFormat)
+	}
+}
+
+// bindFlags defines the flags of the settings of c on fs. The flags write
+// to c.
+func bindFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.Driver, "driver", c.Driver,
+		"database/sql driver: pgx or mysql")
+	fs.StringVar(&c.DSN, "dsn", c.DSN,
+		"data source name, defaults to $MIGRATOR_DSN")
+	fs.StringVar(&c.Dir, "dir", c.Dir, "directory of the migration files")
+	fs.StringVar(&c.Table, "table", c.Table, "name of the history table")
+	fs.StringVar(&c.MigrationName, "migration-name", c.MigrationName,
+		"history namespace of the migrations")
+	fs.BoolVar(&c.Transactional, "transactional", c.Transactional,
+		"run all migrations of a run in one transaction")
+	fs.StringVar(&c.LockTimeout, "lock-timeout", c.LockTimeout,
+		"wait this long for the run lock, e.g. 30s; empty runs without it")
+	fs.BoolVar(&c.Quiet, "quiet", c.Quiet, "discard the migrator's log output")
+	fs.StringVar(&c.VersionFormat, "version-format", c.VersionFormat,
+		"version format of created migrations: sequential, timestamp, ulid "+
+			"or uuid; empty continues the existing format")
+}
+
+// loadConfig returns the settings of the JSON config file at path, if any,
+// overridden by the flags set on fs, which were bound to flags.
+func loadConfig(path string, fs *flag.FlagSet, flags config) (config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config{}, fmt.Errorf("config: %w", err)
+		}
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return config{}, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "driver":
+			cfg.Driver = flags.Driver
+		case "dsn":
+			cfg.DSN = flags.DSN
+		case "dir":
+			cfg.Dir = flags.Dir
+		case "table":
+			cfg.Table = flags.Table
+		case "migration-name":
+			cfg.MigrationName = flags.MigrationName
+		case "transactional":
+			cfg.Transactional = flags.Transactional
+		case "lock-timeout":
+			cfg.LockTimeout = flags.LockTimeout
+		case "quiet":
+			cfg.Quiet = flags.Quiet
+		case "version-format":
+			cfg.VersionFormat = flags.VersionFormat
+		}
+	})
+	return cfg, nil
+}
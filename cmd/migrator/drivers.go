@@ -0,0 +1,7 @@
+package main
+
+// The database/sql drivers the command can connect with.
+import (
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
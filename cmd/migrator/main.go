@@ -0,0 +1,329 @@
+// Command migrator applies and rolls back the SQL migrations of a
+// directory, for CI jobs and deployments that have no Go wrapper of their
+// own:
+//
+//	migrator -driver pgx -dsn "$DATABASE_URL" -dir migrations up
+//
+// Usage:
+//
+//	migrator [flags] up [target]
+//	migrator [flags] down [target]
+//	migrator [flags] status
+//	migrator [flags] create <name>
+//	migrator [flags] version
+//
+// Settings can be read from a JSON config file passed with -config, whose
+// keys are the flag names with underscores ("driver", "dsn", "dir",
+// "table", "migration_name", "transactional", "lock_timeout", "quiet",
+// "version_format").
+// Flags given on the command line override the file. The DSN defaults to
+// $MIGRATOR_DSN so that it stays out of the process list.
+//
+// The pgx (Postgres) and mysql drivers are built in. The command is a
+// separate module so that they are not dependencies of the migrator
+// package.
+//
+// The first SIGINT or SIGTERM stops a run after the current migration; a
+// second one aborts it. The exit code is 0 on success, 1 on failure, 2 on
+// invalid usage and 75 for a stopped run.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aatuh/migrator"
+)
+
+// exitUsage is the exit code of invalid command lines.
+const exitUsage = 2
+
+// usage is printed before the flag defaults by -h and on usage errors.
+const usage = `Usage: migrator [flags] <command> [arguments]
+
+Commands:
+  up [target]     apply pending migrations, up to target if given
+  down [target]   roll back the last applied migration, or every migration
+                  down to target, inclusive
+  status          list applied and pending migrations
+  create <name>   create the up and down files of a new migration
+  version         print the version of the last applied migration
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(
+	ctx context.Context, args []string, stdout io.Writer, stderr io.Writer,
+) int {
+	fs := flag.NewFlagSet("migrator", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "JSON file holding the settings")
+	flags := defaultConfig()
+	bindFlags(fs, &flags)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return migrator.ExitCodeSuccess
+		}
+		return exitUsage
+	}
+	command, params := fs.Arg(0), fs.Args()[min(1, fs.NArg()):]
+	maxParams := map[string]int{
+		"up": 1, "down": 1, "status": 0, "create": 1, "version": 0,
+	}
+	limit, ok := maxParams[command]
+	switch {
+	case command == "":
+		fs.Usage()
+		return exitUsage
+	case !ok:
+		fmt.Fprintf(stderr, "migrator: unknown command %q\n", command)
+		return exitUsage
+	case len(params) > limit || (command == "create" && len(params) == 0):
+		fmt.Fprintf(
+			stderr, "migrator: wrong number of arguments to %s\n", command,
+		)
+		return exitUsage
+	}
+	target := ""
+	if len(params) > 0 {
+		target = params[0]
+	}
+
+	cfg, err := loadConfig(*configPath, fs, flags)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	logger := migrator.Logger(log.New(stderr, "", log.LstdFlags))
+	if cfg.Quiet {
+		logger = migrator.DiscardLogger
+	}
+	if command == "create" {
+		return create(cfg, target, stdout, stderr)
+	}
+	m, err := openMigrator(cfg, logger)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer m.Close()
+
+	switch command {
+	case "up":
+		return up(ctx, m, target, stdout, stderr)
+	case "down":
+		return down(ctx, m, target, stdout, stderr)
+	case "status":
+		return status(ctx, m, stdout, stderr)
+	default:
+		return version(ctx, m, stdout, stderr)
+	}
+}
+
+// openMigrator returns the Migrator configured by cfg.
+func openMigrator(
+	cfg config, logger migrator.Logger,
+) (*migrator.Migrator, error) {
+	if cfg.Driver == "" {
+		return nil, errors.New("no driver: set -driver")
+	}
+	if cfg.DSN == "" {
+		return nil, errors.New("no DSN: set -dsn or $MIGRATOR_DSN")
+	}
+	timeout, err := cfg.lockTimeout()
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrator.NewMigratorDSN(
+		cfg.Driver, cfg.DSN, cfg.Table, cfg.MigrationName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	src := migrator.NewDirMigrationSource(cfg.Dir).WithLogger(logger)
+	m = m.WithSources([]migrator.MigrationSource{src}).
+		WithTransactional(cfg.Transactional).
+		WithLogger(logger)
+	if timeout > 0 {
+		m = m.WithLock(timeout)
+	}
+	return m, nil
+}
+
+// up applies the pending migrations up to target.
+func up(
+	ctx context.Context,
+	m *migrator.Migrator,
+	target string,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	ctx, m, stop := withSignals(ctx, m)
+	defer stop()
+	result, err := m.MigrateUpResult(ctx, target)
+	if result != nil {
+		for _, mig := range result.Migrations {
+			fmt.Fprintf(
+				stdout, "applied %s %s (%s)\n",
+				mig.Version, mig.Name, mig.Duration.Round(time.Millisecond),
+			)
+		}
+	}
+	if err != nil {
+		fail(stderr, err)
+	}
+	return migrator.ExitCode(err)
+}
+
+// down rolls back the migrations down to target, or the last applied
+// migration if target is empty.
+func down(
+	ctx context.Context,
+	m *migrator.Migrator,
+	target string,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	ctx, m, stop := withSignals(ctx, m)
+	defer stop()
+	if target == "" {
+		last, ok, err := m.LastApplied(ctx)
+		if err != nil {
+			return fail(stderr, err)
+		}
+		if !ok {
+			fmt.Fprintln(stdout, "no applied migration to roll back")
+			return migrator.ExitCodeSuccess
+		}
+		target = last.Migration.Version
+	}
+	result, err := m.MigrateDownResult(ctx, target)
+	if result != nil {
+		for _, mig := range result.Migrations {
+			fmt.Fprintf(stdout, "rolled back %s %s\n", mig.Version, mig.Name)
+		}
+	}
+	if err != nil {
+		fail(stderr, err)
+	}
+	return migrator.ExitCode(err)
+}
+
+// status lists the applied and pending migrations.
+func status(
+	ctx context.Context,
+	m *migrator.Migrator,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", "-"
+		if s.Applied {
+			state = "applied"
+			if s.Source == "" {
+				state = "applied, no source"
+			}
+			if !s.AppliedAt.IsZero() {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(stderr, err)
+	}
+	return migrator.ExitCodeSuccess
+}
+
+// version prints the version of the last applied migration, or "none".
+func version(
+	ctx context.Context,
+	m *migrator.Migrator,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	last, ok, err := m.LastApplied(ctx)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	if !ok {
+		fmt.Fprintln(stdout, "none")
+		return migrator.ExitCodeSuccess
+	}
+	fmt.Fprintln(stdout, last.Migration.Version)
+	return migrator.ExitCodeSuccess
+}
+
+// create writes empty up and down files for a migration named name, with
+// the version following those of the directory in the configured format.
+func create(cfg config, name string, stdout io.Writer, stderr io.Writer) int {
+	generator, err := cfg.versionGenerator()
+	if err != nil {
+		return fail(stderr, err)
+	}
+	paths, err := migrator.CreateMigration(cfg.Dir, name, generator)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	for _, path := range paths {
+		fmt.Fprintln(stdout, path)
+	}
+	return migrator.ExitCodeSuccess
+}
+
+// withSignals returns ctx and m set up so that the first SIGINT or SIGTERM
+// stops the run after the current migration and the second one cancels
+// ctx. stop releases the signal handler.
+func withSignals(
+	ctx context.Context, m *migrator.Migrator,
+) (context.Context, *migrator.Migrator, func()) {
+	control := migrator.NewRunControl()
+	ctx, cancel := context.WithCancel(ctx)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			control.Stop()
+		}
+		select {
+		case <-ctx.Done():
+		case <-signals:
+			cancel()
+		}
+	}()
+	return ctx, m.WithRunControl(control), func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// fail prints err and returns the failure exit code.
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "migrator: %v\n", err)
+	return migrator.ExitCodeFailure
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "flag"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestRun_UsageErrors(t *testing.T){
+    for _, args := range [][]string{{}, {"sideways"}, {"status", "extra"}, {"create"}, {"-nope", "up"}} {
+        var stdout, stderr bytes.Buffer
+        if code := run(context.Background(), args, &stdout, &stderr); code != exitUsage { t.Fatalf("%v: expected exit code %d, got %d (%s)", args, exitUsage, code, stderr.String()) }
+    }
+    var stderr bytes.Buffer
+    if code := run(context.Background(), []string{"-dsn", "x", "up"}, &bytes.Buffer{}, &stderr); code != 1 || !strings.Contains(stderr.String(), "no driver") { t.Fatalf("expected a missing driver error, got %d %q", code, stderr.String()) }
+}
+
+func TestLoadConfig_FlagsOverrideFile(t *testing.T){
+    path := filepath.Join(t.TempDir(), "migrator.json")
+    if err := os.WriteFile(path, []byte(`{"driver": "mysql", "dsn": "file-dsn", "dir": "db", "lock_timeout": "5s"}`), 0o644); err != nil { t.Fatal(err) }
+    fs := flag.NewFlagSet("migrator", flag.ContinueOnError)
+    flags := defaultConfig()
+    bindFlags(fs, &flags)
+    if err := fs.Parse([]string{"-driver", "pgx", "-quiet"}); err != nil { t.Fatal(err) }
+    cfg, err := loadConfig(path, fs, flags)
+    if err != nil { t.Fatalf("loadConfig: %v", err) }
+    if cfg.Driver != "pgx" || cfg.DSN != "file-dsn" || cfg.Dir != "db" || cfg.Table != "schema_migrations" || !cfg.Quiet { t.Fatalf("unexpected config %+v", cfg) }
+    if timeout, err := cfg.lockTimeout(); err != nil || timeout.Seconds() != 5 { t.Fatalf("unexpected lock timeout %v, %v", timeout, err) }
+    if err := os.WriteFile(path, []byte(`{"drvier": "pgx"}`), 0o644); err != nil { t.Fatal(err) }
+    if _, err := loadConfig(path, fs, flags); err == nil { t.Fatalf("expected an unknown key to fail") }
+}
+
+func TestRun_CreateWritesNextVersion(t *testing.T){
+    dir := filepath.Join(t.TempDir(), "migrations")
+    for i, want := range []string{"001_add_users", "002_add_orders"} {
+        var stdout, stderr bytes.Buffer
+        name := []string{"Add users", "add-orders"}[i]
+        if code := run(context.Background(), []string{"-dir", dir, "create", name}, &stdout, &stderr); code != 0 { t.Fatalf("create: %d %s", code, stderr.String()) }
+        for _, direction := range []string{"up", "down"} {
+            if _, err := os.Stat(filepath.Join(dir, want+"_"+direction+".sql")); err != nil { t.Fatalf("expected %s %s file: %v (%s)", want, direction, err, stdout.String()) }
+        }
+    }
+    var stdout, stderr bytes.Buffer
+    if code := run(context.Background(), []string{"-dir", dir, "-version-format", "timestamp", "create", "stamp"}, &stdout, &stderr); code != 0 || !strings.HasSuffix(strings.Fields(stdout.String())[0], "_stamp_up.sql") || len(filepath.Base(strings.Fields(stdout.String())[0])) != len("20060102150405_stamp_up.sql") { t.Fatalf("expected a timestamp version, got %d %q %s", code, stdout.String(), stderr.String()) }
+    if code := run(context.Background(), []string{"-dir", dir, "-version-format", "roman", "create", "x"}, &bytes.Buffer{}, &bytes.Buffer{}); code != 1 { t.Fatalf("expected an unknown format to fail, got %d", code) }
+}
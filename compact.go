@@ -0,0 +1,107 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// baselineName is the name recorded for the history row that replaces
+// compacted migrations.
+const baselineName = "baseline"
+
+// CompactHistory replaces the history rows of the Migrator's migration name
+// with versions up to and including upTo by a single baseline row with
+// version upTo. Use it after squashing those migrations into a baseline
+// migration so that the history matches the new source tree. The rows are
+// replaced in one transaction regardless of the Transactional setting,
+// under the run lock if enabled. It fails with a *RunInProgressError while
+// another run of the process uses the same database and history table.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - upTo: The version of the baseline.
+//
+// Returns:
+//   - error: An error if the history cannot be compacted.
+func (m *Migrator) CompactHistory(ctx context.Context, upTo string) error {
+	m.logf("Starting CompactHistory")
+
+	if !m.validVersion(upTo) {
+		return m.versionFormatError("baseline version", upTo)
+	}
+	endRun, err := m.beginRun("up")
+	if err != nil {
+		return err
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return err
+	}
+	release, err := m.acquireLock(ctx, "up")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := m.HistoryManager.AppliedMigrations(
+		ctx, m.DB, m.HistoryTable, m.MigrationName,
+	)
+	if err != nil {
+		m.logf("Error retrieving applied migrations: %v", err)
+		return err
+	}
+	var versions []string
+	for version := range applied {
+		if m.validVersion(version) && m.compareVersions(version, upTo) <= 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		m.logf("No history rows up to version %s to compact", upTo)
+		return nil
+	}
+	slices.SortFunc(versions, m.compareVersions)
+
+	tx := m.WithTransactional(true).WithTxPerMigration(false)
+	_, err = tx.runMigrationsIfTransactional(
+		ctx,
+		func(run *migrationRun) (int, error) {
+			for _, version := range versions {
+				if err := m.HistoryManager.RemoveMigration(
+					ctx,
+					run.history,
+					m.HistoryTable,
+					Migration{Version: version, MigrationName: m.MigrationName},
+					m.MigrationName,
+				); err != nil {
+					m.logf(
+						"Error removing migration record for %s: %v", version, err,
+					)
+					return 0, err
+				}
+			}
+			baseline := run.newEntry(Migration{
+				Version:       upTo,
+				Name:          baselineName,
+				MigrationName: m.MigrationName,
+				Description: fmt.Sprintf(
+					"baseline of %d compacted migrations", len(versions),
+				),
+			})
+			return len(versions), m.recordEntries(
+				ctx, run, []HistoryEntry{baseline},
+			)
+		},
+	)
+	m.InvalidateCache()
+	if err != nil {
+		return err
+	}
+
+	m.logf(
+		"CompactHistory complete. Replaced %d history rows with baseline %s",
+		len(versions), upTo,
+	)
+	return nil
+}
@@ -0,0 +1,263 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// dbKey is the context key of the Migrator's database in steps.
+type dbKey struct{}
+
+// dbFromContext returns the Migrator's database passed to a step, if any.
+func dbFromContext(ctx context.Context) *sql.DB {
+	db, _ := ctx.Value(dbKey{}).(*sql.DB)
+	return db
+}
+
+// ConcurrentIndexStep builds a Postgres index with CREATE INDEX
+// CONCURRENTLY, which cannot run inside a transaction block. In
+// transactional runs the index is therefore built on a separate connection
+// outside the run's transaction; the transaction must not have written to
+// the table, or the build waits for it forever. While the index builds, the
+// step polls pg_stat_progress_create_index and reports its progress as
+// EventStepProgress events. A failed build leaves an INVALID index behind,
+// which the step drops before it retries. An index that already exists
+// and is valid is kept, so the step can be repeated safely. The down
+// direction drops the index concurrently.
+type ConcurrentIndexStep struct {
+	Table string
+	Index string
+	// Definition follows "ON <table>", e.g. "(email)" or "USING gin (doc)
+	// WHERE deleted_at IS NULL".
+	Definition string
+	Unique     bool
+	// MaxAttempts is the number of builds before the step fails.
+	MaxAttempts int
+	// PollInterval is the pause between progress polls.
+	PollInterval time.Duration
+}
+
+// NewConcurrentIndexStep returns a new ConcurrentIndexStep attempting the
+// build three times and polling its progress every five seconds.
+//
+// Parameters:
+//   - table: The table to index.
+//   - index: The name of the index.
+//   - definition: The index definition following "ON <table>".
+//
+// Returns:
+//   - *ConcurrentIndexStep: A new ConcurrentIndexStep.
+func NewConcurrentIndexStep(
+	table string, index string, definition string,
+) *ConcurrentIndexStep {
+	return &ConcurrentIndexStep{
+		Table:        table,
+		Index:        index,
+		Definition:   definition,
+		MaxAttempts:  3,
+		PollInterval: 5 * time.Second,
+	}
+}
+
+// WithUnique returns a new ConcurrentIndexStep building a unique index.
+//
+// Parameters:
+//   - unique: Whether the index is unique.
+//
+// Returns:
+//   - *ConcurrentIndexStep: A new ConcurrentIndexStep.
+func (s *ConcurrentIndexStep) WithUnique(unique bool) *ConcurrentIndexStep {
+	new := *s
+	new.Unique = unique
+	return &new
+}
+
+// WithMaxAttempts returns a new ConcurrentIndexStep building the index at
+// most attempts times.
+//
+// Parameters:
+//   - attempts: The maximum number of builds.
+//
+// Returns:
+//   - *ConcurrentIndexStep: A new ConcurrentIndexStep.
+func (s *ConcurrentIndexStep) WithMaxAttempts(attempts int) *ConcurrentIndexStep {
+	new := *s
+	new.MaxAttempts = attempts
+	return &new
+}
+
+// WithPollInterval returns a new ConcurrentIndexStep polling the build
+// progress every interval.
+//
+// Parameters:
+//   - interval: The pause between progress polls.
+//
+// Returns:
+//   - *ConcurrentIndexStep: A new ConcurrentIndexStep.
+func (s *ConcurrentIndexStep) WithPollInterval(
+	interval time.Duration,
+) *ConcurrentIndexStep {
+	new := *s
+	new.PollInterval = interval
+	return &new
+}
+
+// createSQL returns the statement building the index.
+func (s ConcurrentIndexStep) createSQL() string {
+	unique := ""
+	if s.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf(
+		"CREATE %sINDEX CONCURRENTLY %s ON %s %s",
+		unique, s.Index, s.Table, s.Definition,
+	)
+}
+
+// dropSQL returns the statement dropping the index.
+func (s ConcurrentIndexStep) dropSQL() string {
+	return "DROP INDEX CONCURRENTLY IF EXISTS " + s.Index
+}
+
+// ExecuteUp builds the index.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the last build fails.
+func (s ConcurrentIndexStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	exec = outsideTransaction(ctx, exec)
+	attempts := max(s.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		exists, valid, err := s.indexState(ctx, exec)
+		if err != nil {
+			return err
+		}
+		if valid {
+			contextLogger(ctx).Printf(
+				"Index %s already exists and is valid", s.Index,
+			)
+			return nil
+		}
+		if exists {
+			contextLogger(ctx).Printf("Dropping invalid index %s", s.Index)
+			if _, err := exec.ExecContext(ctx, s.dropSQL()); err != nil {
+				return err
+			}
+		}
+		err = s.build(ctx, exec)
+		if err == nil {
+			contextLogger(ctx).Printf("Built index %s", s.Index)
+			return nil
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			return fmt.Errorf("build index %s: %w", s.Index, err)
+		}
+		contextLogger(ctx).Printf(
+			"Building index %s failed (attempt %d of %d): %v",
+			s.Index, attempt, attempts, err,
+		)
+	}
+}
+
+// ExecuteDown drops the index.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if dropping the index fails.
+func (s ConcurrentIndexStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	_, err := outsideTransaction(ctx, exec).ExecContext(ctx, s.dropSQL())
+	return err
+}
+
+// indexState reports whether the index exists and whether it is valid.
+func (s ConcurrentIndexStep) indexState(
+	ctx context.Context, exec Executor,
+) (bool, bool, error) {
+	q, ok := exec.(Queryer)
+	if !ok {
+		return false, false, fmt.Errorf(
+			"executor %T cannot query index state: %w", exec, errors.ErrUnsupported,
+		)
+	}
+	var valid bool
+	err := q.QueryRowContext(
+		ctx,
+		"SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)",
+		s.Index,
+	).Scan(&valid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("query state of index %s: %w", s.Index, err)
+	}
+	return true, valid, nil
+}
+
+// build builds the index and reports its progress until it completes.
+func (s ConcurrentIndexStep) build(ctx context.Context, exec Executor) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec.ExecContext(ctx, s.createSQL())
+		done <- err
+	}()
+	db := dbFromContext(ctx)
+	interval := s.PollInterval
+	if interval <= 0 || db == nil {
+		return <-done
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			s.reportProgress(ctx, db)
+		}
+	}
+}
+
+// reportProgress reports the progress of the build read from
+// pg_stat_progress_create_index.
+func (s ConcurrentIndexStep) reportProgress(ctx context.Context, db *sql.DB) {
+	var progress StepProgress
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT phase, "+
+			"CASE WHEN blocks_total > 0 THEN blocks_done ELSE tuples_done END, "+
+			"CASE WHEN blocks_total > 0 THEN blocks_total ELSE tuples_total END "+
+			"FROM pg_stat_progress_create_index WHERE relid = to_regclass($1)",
+		s.Table,
+	).Scan(&progress.Phase, &progress.Done, &progress.Total)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			contextLogger(ctx).Printf(
+				"Error polling progress of index %s: %v", s.Index, err,
+			)
+		}
+		return
+	}
+	ReportProgress(ctx, progress)
+}
+
+// outsideTransaction returns the Migrator's database instead of exec in
+// transactional runs.
+func outsideTransaction(ctx context.Context, exec Executor) Executor {
+	if _, ok := TxFromContext(ctx); !ok {
+		return exec
+	}
+	if db := dbFromContext(ctx); db != nil {
+		return db
+	}
+	return exec
+}
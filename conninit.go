@@ -0,0 +1,149 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ConnInitFn initializes a new database connection before the pool hands
+// it out, e.g. with "SET ROLE migrator" or "SET SESSION sql_mode = ...".
+// exec executes statements on that connection only.
+type ConnInitFn func(ctx context.Context, exec Executor) error
+
+// connInitConnector runs ConnInitFns on every connection it opens.
+type connInitConnector struct {
+	connector driver.Connector
+	init      []ConnInitFn
+}
+
+// NewConnInitConnector returns a connector running init on every
+// connection opened through connector. A *sql.DB opened from it with
+// sql.OpenDB initializes all its connections, so that mandatory session
+// settings also apply to the connections the Migrator reads and writes the
+// history with, not just to the migration steps:
+//
+//	db := sql.OpenDB(migrator.NewConnInitConnector(connector, setRole))
+//
+// Connections failing to initialize are closed and their error returned
+// to the operation that needed a connection. NewMigratorDSN takes the
+// hooks directly.
+//
+// Parameters:
+//   - connector: The driver's connector.
+//   - init: The hooks run on every new connection, in order.
+//
+// Returns:
+//   - driver.Connector: The initializing connector.
+func NewConnInitConnector(
+	connector driver.Connector, init ...ConnInitFn,
+) driver.Connector {
+	return &connInitConnector{connector: connector, init: init}
+}
+
+// Connect opens a connection and runs the hooks on it.
+func (c *connInitConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range c.init {
+		if err := fn(ctx, driverConnExecutor{conn: conn}); err != nil {
+			contextLogger(ctx).Printf("Error initializing connection: %v", err)
+			_ = conn.Close()
+			return nil, fmt.Errorf("initialize connection: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Driver returns the driver of the wrapped connector.
+func (c *connInitConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// dsnConnector opens connections of drivers that do not implement
+// driver.DriverContext.
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+// Connect opens a connection to the DSN.
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+// Driver returns the driver.
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// openConnector returns a connector of the registered driver driverName
+// for dsn.
+func openConnector(driverName string, dsn string) (driver.Connector, error) {
+	// sql.Open only looks up the driver; no connection is opened.
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	_ = db.Close()
+	if dc, ok := drv.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return dsnConnector{dsn: dsn, drv: drv}, nil
+}
+
+// driverConnExecutor executes statements directly on a driver connection.
+type driverConnExecutor struct {
+	conn driver.Conn
+}
+
+// ExecContext executes query on the connection.
+func (e driverConnExecutor) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i].Ordinal = i + 1
+		if na, ok := arg.(sql.NamedArg); ok {
+			named[i].Name = na.Name
+			arg = na.Value
+		}
+		value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		named[i].Value = value
+	}
+	if execer, ok := e.conn.(driver.ExecerContext); ok {
+		res, err := execer.ExecContext(ctx, query, named)
+		if !errors.Is(err, driver.ErrSkip) {
+			return res, err
+		}
+	}
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if preparer, ok := e.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = e.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	if execer, ok := stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, named)
+	}
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		values[i] = nv.Value
+	}
+	return stmt.Exec(values)
+}
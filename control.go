@@ -0,0 +1,179 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrRunCanceled is returned by runs stopped with RunControl.Cancel.
+var ErrRunCanceled = errors.New("run canceled")
+
+// ErrRunStopped is returned by runs ended early with RunControl.Stop. The
+// migrations applied before the stop are committed and recorded.
+var ErrRunStopped = errors.New("run stopped")
+
+// RunControl pauses, resumes and cancels in-flight runs from another
+// goroutine, e.g. when a DBA spots load problems mid-run. Runs check it
+// before every step, and BatchMigrationStep and BackfillStep also between
+// batches, so a pause never interrupts a statement. Hook steps can offer
+// the same boundaries by calling WaitIfPaused. A paused transactional run
+// keeps its transaction, and the locks it took, open while it waits.
+//
+// Cancel stops the run at the next boundary; transactional runs roll back.
+// Stop instead lets the current migration finish and ends the run cleanly
+// before the next one. To abort a running statement, cancel the run's
+// context.
+type RunControl struct {
+	mu       sync.Mutex
+	paused   bool
+	canceled bool
+	stopped  bool
+	// resumed is closed when a pause ends.
+	resumed chan struct{}
+}
+
+// NewRunControl returns a new RunControl.
+//
+// Returns:
+//   - *RunControl: A new RunControl.
+func NewRunControl() *RunControl {
+	return &RunControl{}
+}
+
+// WithRunControl returns a new Migrator whose runs are paused, resumed and
+// canceled through control.
+//
+// Parameters:
+//   - control: The control handle.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithRunControl(control *RunControl) *Migrator {
+	new := *m
+	new.RunControl = control
+	return &new
+}
+
+// Pause pauses the run at the next step or batch boundary.
+func (c *RunControl) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused && !c.canceled && !c.stopped {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes a paused run.
+func (c *RunControl) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unpause()
+}
+
+// Cancel stops the run at the next step or batch boundary, ending a pause.
+func (c *RunControl) Cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.canceled = true
+	c.unpause()
+}
+
+// Stop ends the run once the current migration is applied, ending a
+// pause. The run commits the migrations applied so far and returns
+// ErrRunStopped.
+func (c *RunControl) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	c.unpause()
+}
+
+// stopRequested reports whether Stop was called. A nil RunControl is never
+// stopped.
+func (c *RunControl) stopRequested() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// unpause ends a pause. The mutex must be held.
+func (c *RunControl) unpause() {
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+}
+
+// Paused reports whether runs are paused.
+//
+// Returns:
+//   - bool: Whether runs are paused.
+func (c *RunControl) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Canceled reports whether runs are canceled.
+//
+// Returns:
+//   - bool: Whether runs are canceled.
+func (c *RunControl) Canceled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canceled
+}
+
+// wait blocks while runs are paused. It returns ErrRunCanceled once
+// canceled. A nil RunControl never waits.
+func (c *RunControl) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	logged := false
+	for {
+		c.mu.Lock()
+		canceled, paused, resumed := c.canceled, c.paused, c.resumed
+		c.mu.Unlock()
+		switch {
+		case canceled:
+			return ErrRunCanceled
+		case !paused:
+			if logged {
+				contextLogger(ctx).Printf("Run resumed")
+			}
+			return nil
+		}
+		if !logged {
+			contextLogger(ctx).Printf("Run paused, waiting to be resumed")
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+}
+
+// runControlKey is the context key of the RunControl of a run.
+type runControlKey struct{}
+
+// WaitIfPaused blocks while the run executing the step is paused. Steps
+// doing their work in several parts call it between parts.
+//
+// Parameters:
+//   - ctx: The context passed to the step.
+//
+// Returns:
+//   - error: ErrRunCanceled if the run is canceled, or the context's error
+//     if it is done while paused.
+func WaitIfPaused(ctx context.Context) error {
+	control, _ := ctx.Value(runControlKey{}).(*RunControl)
+	return control.wait(ctx)
+}
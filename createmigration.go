@@ -0,0 +1,105 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// CreateMigration writes empty up and down files for a new migration named
+// name to dir, e.g. "004_add_users_up.sql" and "004_add_users_down.sql",
+// creating dir if needed. The version is generated from the versions of the
+// migration files in dir by generator, such as SequentialVersion or
+// TimestampVersion, or by the generator matching their format if it is nil.
+// The name is lower-cased and every run of characters other than letters
+// and digits becomes an underscore. Existing files are never overwritten.
+//
+// Parameters:
+//   - dir: The directory of the migration files.
+//   - name: The name of the migration.
+//   - generator: The generator of the version, nil to detect it.
+//
+// Returns:
+//   - []string: The paths of the up and down files.
+//   - error: An error if the name is empty or the files cannot be written.
+func CreateMigration(
+	dir string, name string, generator VersionGenerator,
+) ([]string, error) {
+	name = migrationFileName(name)
+	if name == "" {
+		return nil, errors.New("create migration: empty migration name")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	src := NewDirMigrationSource(dir).WithLogger(DiscardLogger)
+	entries, _, err := src.scan()
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, entry := range entries {
+		versions = append(versions, entry.version)
+	}
+	if generator == nil {
+		generator = DetectVersionGenerator(versions)
+	}
+	version, err := generator(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(
+			dir, fmt.Sprintf("%s_%s_%s.sql", version, name, direction),
+		)
+		content := fmt.Sprintf(
+			"-- %s migration %s %s\n", direction, version, name,
+		)
+		if err := writeNewFile(path, content); err != nil {
+			for _, created := range paths {
+				os.Remove(created)
+			}
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// migrationFileName returns name lower-cased with every run of characters
+// other than letters and digits replaced by an underscore.
+func migrationFileName(name string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			separate = b.Len() > 0
+			continue
+		}
+		if separate {
+			b.WriteByte('_')
+			separate = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// writeNewFile writes content to a file created at path, failing if the
+// file exists.
+func writeNewFile(path string, content string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,391 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AnnotationAllowDestructive allows a migration to run destructive
+// statements although the Migrator refuses them. Its value is "true" or a
+// justification such as "-- allow-destructive: legacy table, see JIRA-12";
+// "false" does not allow them.
+const AnnotationAllowDestructive = "allow-destructive"
+
+// ErrDestructiveMigration is returned when a pending migration contains
+// destructive statements that are not allowed.
+var ErrDestructiveMigration = errors.New("destructive migration")
+
+// DestructiveFinding is a destructive statement found in a migration.
+type DestructiveFinding struct {
+	// Step is the 1-based index of the up step holding the statement.
+	Step int
+	// Statement is the statement.
+	Statement string
+	// Reason describes why the statement is destructive, e.g. "drops
+	// column email".
+	Reason string
+}
+
+// DestructiveMigrationError lists the destructive statements of a
+// migration. It wraps ErrDestructiveMigration.
+type DestructiveMigrationError struct {
+	Version  string
+	Name     string
+	Findings []DestructiveFinding
+}
+
+// Error returns the error message.
+func (e *DestructiveMigrationError) Error() string {
+	reasons := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		reasons[i] = fmt.Sprintf("step %d %s", f.Step, f.Reason)
+	}
+	return fmt.Sprintf(
+		"migration %s (%s) is destructive: %s", e.Version, e.Name,
+		strings.Join(reasons, "; "),
+	)
+}
+
+// Unwrap returns ErrDestructiveMigration.
+func (e *DestructiveMigrationError) Unwrap() error {
+	return ErrDestructiveMigration
+}
+
+// narrowTypes are column types that may be narrower than the type they
+// replace even without a length.
+var narrowTypes = []string{
+	"TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "INT2", "INT4",
+	"REAL", "FLOAT4", "BIT", "BOOLEAN", "BOOL", "DATE",
+}
+
+// addClauseObjects are the words after ADD that do not add a column.
+var addClauseObjects = []string{
+	"CONSTRAINT", "INDEX", "KEY", "PRIMARY", "UNIQUE", "FOREIGN", "CHECK",
+	"PARTITION", "FULLTEXT", "SPATIAL", "(",
+}
+
+// dropClauseObjects are the words after DROP that do not drop a column.
+var dropClauseObjects = []string{
+	"CONSTRAINT", "INDEX", "KEY", "PRIMARY", "FOREIGN", "CHECK", "DEFAULT",
+	"NOT", "IDENTITY", "EXPRESSION",
+}
+
+// WithAllowDestructive returns a new Migrator that applies migrations with
+// destructive statements. By default MigrateUp refuses to apply a pending
+// migration flagged by AnalyzeDestructive unless the migration carries an
+// "allow-destructive" annotation.
+//
+// Parameters:
+//   - allow: Whether to apply destructive migrations.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAllowDestructive(allow bool) *Migrator {
+	new := *m
+	new.AllowDestructive = allow
+	return &new
+}
+
+// AnalyzeDestructive statically finds the destructive statements of the up
+// steps of mig: DROP and TRUNCATE statements, ALTER TABLE clauses dropping
+// columns or partitions, column type changes to a type with a length or a
+// small type, which may narrow the column, and NOT NULL columns added
+// without a default. Steps wrapped by ForSchemas, templates and partition
+// steps are analyzed by the SQL they run. Hook steps are not analyzed, and
+// any other step whose SQL cannot be read is reported as destructive.
+//
+// Parameters:
+//   - mig: The migration to analyze.
+//
+// Returns:
+//   - []DestructiveFinding: The destructive statements, nil if there are
+//     none.
+//   - error: An error if the SQL of a step cannot be read or split.
+func AnalyzeDestructive(mig Migration) ([]DestructiveFinding, error) {
+	var findings []DestructiveFinding
+	for idx, step := range mig.UpSteps {
+		sqls, ok, err := stepSQLs(step, "up")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if !isHookStep(step) {
+				findings = append(findings, DestructiveFinding{
+					Step:   idx + 1,
+					Reason: fmt.Sprintf("runs %T, which cannot be analyzed", step),
+				})
+			}
+			continue
+		}
+		for _, sql := range sqls {
+			stmts, err := SplitStatements(sql)
+			if err != nil {
+				return nil, fmt.Errorf("migration %s: %w", mig.Version, err)
+			}
+			for _, stmt := range stmts {
+				for _, reason := range analyzeStatement(stmt) {
+					findings = append(findings, DestructiveFinding{
+						Step:      idx + 1,
+						Statement: strings.TrimSpace(stmt),
+						Reason:    reason,
+					})
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+// analyzeStatement returns the reasons why stmt is destructive.
+func analyzeStatement(stmt string) []string {
+	tokens := sqlTokens(stmt)
+	if len(tokens) < 2 {
+		return nil
+	}
+	switch upper(tokens[0]) {
+	case "DROP":
+		object := upper(tokens[1])
+		if slices.Contains([]string{"MATERIALIZED", "FOREIGN"}, object) &&
+			len(tokens) > 2 {
+			object += " " + upper(tokens[2])
+		}
+		return []string{"drops " + strings.ToLower(object)}
+	case "TRUNCATE":
+		return []string{"truncates a table"}
+	case "ALTER":
+		if upper(tokens[1]) != "TABLE" {
+			return nil
+		}
+		rest := skipWords(tokens[2:], "IF", "EXISTS", "ONLY")
+		if len(rest) == 0 {
+			return nil
+		}
+		var reasons []string
+		for _, clause := range splitClauses(rest[1:]) {
+			if reason := analyzeAlterClause(clause); reason != "" {
+				reasons = append(reasons, reason)
+			}
+		}
+		return reasons
+	}
+	return nil
+}
+
+// analyzeAlterClause returns why an ALTER TABLE clause is destructive, or
+// an empty string.
+func analyzeAlterClause(clause []string) string {
+	if len(clause) < 2 {
+		return ""
+	}
+	switch upper(clause[0]) {
+	case "DROP":
+		switch object := upper(clause[1]); {
+		case object == "PARTITION":
+			return "drops partition " + strings.Join(clause[2:], " ")
+		case slices.Contains(dropClauseObjects, object):
+			return ""
+		}
+		col := skipWords(clause[1:], "COLUMN", "IF", "EXISTS")
+		if len(col) == 0 {
+			return ""
+		}
+		return "drops column " + col[0]
+	case "ADD":
+		col := skipWords(clause[1:], "COLUMN", "IF", "NOT", "EXISTS")
+		if len(col) == 0 || slices.Contains(addClauseObjects, upper(col[0])) {
+			return ""
+		}
+		words := make([]string, len(col))
+		for i, w := range col {
+			words[i] = upper(w)
+		}
+		if containsPair(words, "NOT", "NULL") && !slices.Contains(words, "DEFAULT") &&
+			!slices.Contains(words, "GENERATED") && !slices.Contains(words, "AS") {
+			return "adds NOT NULL column " + col[0] + " without a default"
+		}
+	case "ALTER":
+		col := skipWords(clause[1:], "COLUMN")
+		if len(col) < 2 {
+			return ""
+		}
+		typ := col[1:]
+		switch {
+		case upper(typ[0]) == "TYPE":
+			typ = typ[1:]
+		case len(typ) > 3 && upper(typ[0]) == "SET" && upper(typ[1]) == "DATA" &&
+			upper(typ[2]) == "TYPE":
+			typ = typ[3:]
+		default:
+			return ""
+		}
+		return narrowingReason(col[0], typ)
+	case "MODIFY":
+		col := skipWords(clause[1:], "COLUMN")
+		if len(col) < 2 {
+			return ""
+		}
+		return narrowingReason(col[0], col[1:])
+	case "CHANGE":
+		col := skipWords(clause[1:], "COLUMN")
+		if len(col) < 3 {
+			return ""
+		}
+		return narrowingReason(col[0], col[2:])
+	}
+	return ""
+}
+
+// narrowingReason returns why changing column col to the type starting
+// typ may narrow it, or an empty string.
+func narrowingReason(col string, typ []string) string {
+	if len(typ) == 0 {
+		return ""
+	}
+	name := upper(typ[0])
+	if len(typ) > 1 && typ[1] == "(" {
+		end := slices.Index(typ, ")")
+		if end < 0 {
+			return ""
+		}
+		name += "(" + strings.Join(typ[2:end], "") + ")"
+	} else if !slices.Contains(narrowTypes, name) {
+		return ""
+	}
+	return "changes column " + col + " to " + name + ", which may narrow it"
+}
+
+// checkDestructive refuses pending migrations with destructive statements
+// unless they are allowed.
+func (m *Migrator) checkDestructive(pending []Migration) error {
+	if m.AllowDestructive {
+		return nil
+	}
+	for i := range pending {
+		mig := &pending[i]
+		if err := mig.resolveAnnotations(); err != nil {
+			return err
+		}
+		if allowsDestructive(mig.Annotations) {
+			continue
+		}
+		findings, err := AnalyzeDestructive(*mig)
+		if err != nil {
+			return err
+		}
+		if len(findings) > 0 {
+			err := &DestructiveMigrationError{
+				Version: mig.Version, Name: mig.Name, Findings: findings,
+			}
+			m.logf("Refusing destructive migration: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// allowsDestructive reports whether annotations allow destructive
+// statements.
+func allowsDestructive(annotations map[string]string) bool {
+	value, ok := annotations[AnnotationAllowDestructive]
+	if !ok {
+		return false
+	}
+	allow, err := strconv.ParseBool(value)
+	return err != nil || allow
+}
+
+// sqlTokens splits stmt into words, quoted identifiers, string literals
+// and single punctuation characters, dropping comments. Words keep their
+// case and include dots, so that qualified names are single tokens.
+func sqlTokens(stmt string) []string {
+	var tokens []string
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/') {
+				i++
+			}
+			i++
+		case isIdentRune(r) || r == '"' || r == '`' || r == '\'':
+			start := i
+			for i < len(runes) {
+				c := runes[i]
+				if c == '"' || c == '`' || c == '\'' {
+					i++
+					for i < len(runes) && runes[i] != c {
+						i++
+					}
+					i++
+					continue
+				}
+				if !isIdentRune(c) && c != '.' && c != '$' {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, string(runes[start:min(i, len(runes))]))
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+// splitClauses splits tokens at commas outside parentheses.
+func splitClauses(tokens []string) [][]string {
+	var clauses [][]string
+	depth, start := 0, 0
+	for i, tok := range tokens {
+		switch tok {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				clauses = append(clauses, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(clauses, tokens[start:])
+}
+
+// containsPair reports whether first is directly followed by second in
+// words.
+func containsPair(words []string, first, second string) bool {
+	for i := 0; i+1 < len(words); i++ {
+		if words[i] == first && words[i+1] == second {
+			return true
+		}
+	}
+	return false
+}
+
+// skipWords returns tokens without the leading tokens that are one of
+// words, ignoring case.
+func skipWords(tokens []string, words ...string) []string {
+	for len(tokens) > 0 && slices.Contains(words, upper(tokens[0])) {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// upper returns s in upper case.
+func upper(s string) string {
+	return strings.ToUpper(s)
+}
@@ -0,0 +1,154 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Dialect identifies the SQL dialect of a database.
+type Dialect string
+
+const (
+	// DialectUnknown is returned when the dialect cannot be detected.
+	DialectUnknown Dialect = ""
+	// DialectPostgres is the dialect of Postgres and compatible servers.
+	DialectPostgres Dialect = "postgres"
+	// DialectMySQL is the dialect of MySQL and MariaDB.
+	DialectMySQL Dialect = "mysql"
+	// DialectSQLite is the dialect of SQLite.
+	DialectSQLite Dialect = "sqlite"
+)
+
+// driverDialects maps substrings of driver type names to dialects, e.g.
+// "*pq.Driver", "*stdlib.Driver" of pgx, "*mysql.MySQLDriver" and
+// "*sqlite3.SQLiteDriver".
+var driverDialects = []struct {
+	match   string
+	dialect Dialect
+}{
+	{"pq.", DialectPostgres},
+	{"pgx", DialectPostgres},
+	{"stdlib.", DialectPostgres},
+	{"postgres", DialectPostgres},
+	{"mysql", DialectMySQL},
+	{"sqlite", DialectSQLite},
+}
+
+// driverDialect detects the dialect from the type name of the driver of db
+// without querying the database.
+func driverDialect(db *sql.DB) Dialect {
+	if db == nil {
+		return DialectUnknown
+	}
+	name := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+	for _, d := range driverDialects {
+		if strings.Contains(name, d.match) {
+			return d.dialect
+		}
+	}
+	return DialectUnknown
+}
+
+// DetectDialect detects the dialect of db from its driver type and, if the
+// driver is not recognized, by probing the database with version queries.
+//
+// Parameters:
+//   - ctx: Context to use for the probe queries.
+//   - db: The database connection.
+//
+// Returns:
+//   - Dialect: The detected dialect, DialectUnknown if detection fails.
+func DetectDialect(ctx context.Context, db *sql.DB) Dialect {
+	if dialect := driverDialect(db); dialect != DialectUnknown {
+		return dialect
+	}
+	if db == nil {
+		return DialectUnknown
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err == nil {
+		if strings.Contains(version, "PostgreSQL") {
+			return DialectPostgres
+		}
+		return DialectMySQL
+	}
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err == nil {
+		return DialectSQLite
+	}
+	return DialectUnknown
+}
+
+// historyDialect returns the dialect of the built-in HistoryManager hm, or
+// DialectUnknown for other managers.
+func historyDialect(hm HistoryManager) Dialect {
+	switch hm.(type) {
+	case *PostgresHistoryManager, PostgresHistoryManager:
+		return DialectPostgres
+	case *MySQLHistoryManager, MySQLHistoryManager:
+		return DialectMySQL
+	case *SQLiteHistoryManager, SQLiteHistoryManager:
+		return DialectSQLite
+	default:
+		return DialectUnknown
+	}
+}
+
+// HistoryManagerFor returns a new HistoryManager for dialect, or nil if the
+// dialect is unknown.
+//
+// Parameters:
+//   - dialect: The SQL dialect.
+//
+// Returns:
+//   - HistoryManager: The matching HistoryManager.
+func HistoryManagerFor(dialect Dialect) HistoryManager {
+	switch dialect {
+	case DialectPostgres:
+		return NewPostgresHistoryManager()
+	case DialectMySQL:
+		return NewMySQLHistoryManager()
+	case DialectSQLite:
+		return NewSQLiteHistoryManager()
+	default:
+		return nil
+	}
+}
+
+// DetectHistoryManager returns the HistoryManager matching the dialect of
+// db, probing the database if its driver is not recognized.
+//
+// Parameters:
+//   - ctx: Context to use for the probe queries.
+//   - db: The database connection.
+//
+// Returns:
+//   - HistoryManager: The matching HistoryManager.
+//   - error: An error if the dialect cannot be detected.
+func DetectHistoryManager(
+	ctx context.Context, db *sql.DB,
+) (HistoryManager, error) {
+	dialect := DetectDialect(ctx, db)
+	if dialect == DialectUnknown {
+		return nil, fmt.Errorf("cannot detect the SQL dialect of %T", db.Driver())
+	}
+	return HistoryManagerFor(dialect), nil
+}
+
+// defaultHistoryManager returns the HistoryManager used when none is given:
+// the one matching the driver of db, or SQLiteHistoryManager if the driver
+// is not recognized.
+func defaultHistoryManager(db *sql.DB) HistoryManager {
+	if hm := HistoryManagerFor(driverDialect(db)); hm != nil {
+		return hm
+	}
+	if db != nil {
+		defaultLogger.Printf(
+			"Unrecognized driver %T, defaulting to SQLite history manager; "+
+				"pass a HistoryManager or use DetectHistoryManager",
+			db.Driver(),
+		)
+	}
+	return SQLiteHistoryManager{}
+}
@@ -0,0 +1,69 @@
+package migrator
+
+import (
+	"context"
+	"time"
+)
+
+// FileDrift describes an applied migration whose files were edited after
+// it was applied.
+type FileDrift struct {
+	Version       string
+	Name          string
+	MigrationName string
+	AppliedAt     time.Time
+	// AppliedChecksum is the checksum recorded when the migration was
+	// applied.
+	AppliedChecksum string
+	// FileChecksum is the checksum of the currently loaded files.
+	FileChecksum string
+}
+
+// DiffAppliedFiles compares the checksums of the loaded migrations with the
+// checksums recorded in the history when they were applied, and returns
+// every applied migration whose content has changed since. Only migrations
+// with both checksums are compared: sources must compute checksums (see
+// DirMigrationSource.WithChecksums), and migrations applied before the
+// history recorded checksums are skipped. The HistoryManager must
+// implement HistoryReader.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - []FileDrift: The edited migrations in history order.
+//   - error: An error if migrations or the history cannot be read.
+func (m *Migrator) DiffAppliedFiles(ctx context.Context) ([]FileDrift, error) {
+	entries, err := m.HistoryEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := m.LoadAllMigrations()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[[2]string]Migration, len(all))
+	for _, mig := range all {
+		loaded[[2]string{mig.MigrationName, mig.Version}] = mig
+	}
+	var drifts []FileDrift
+	for _, entry := range entries {
+		mig, ok := loaded[[2]string{entry.MigrationName, entry.Migration.Version}]
+		applied := entry.Migration.Checksum
+		if !ok || applied == "" || mig.Checksum == "" || mig.Checksum == applied {
+			continue
+		}
+		drifts = append(drifts, FileDrift{
+			Version:         mig.Version,
+			Name:            mig.Name,
+			MigrationName:   mig.MigrationName,
+			AppliedAt:       entry.AppliedAt,
+			AppliedChecksum: applied,
+			FileChecksum:    mig.Checksum,
+		})
+		m.logf(
+			"Migration %s (%s) was edited after it was applied", mig.Version, mig.Name,
+		)
+	}
+	return drifts, nil
+}
@@ -0,0 +1,149 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// WithDryRun returns a new Migrator whose MigrateUp and MigrateDown only
+// compute the migrations they would apply or roll back and write the SQL
+// of their steps, to the writer set with WithDryRunOutput or to the log.
+// The history is read to compute the plan, but nothing is written to the
+// database: no tables are created, no lock is taken and no step runs.
+// Steps that are not plain SQL, such as hooks, are listed by their label.
+//
+// Parameters:
+//   - enabled: Whether runs are dry runs.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithDryRun(enabled bool) *Migrator {
+	new := *m
+	new.DryRun = enabled
+	return &new
+}
+
+// WithDryRunOutput returns a new Migrator writing the SQL of dry runs to w
+// instead of the log.
+//
+// Parameters:
+//   - w: The writer receiving the SQL.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithDryRunOutput(w io.Writer) *Migrator {
+	new := *m
+	new.DryRunOutput = w
+	return &new
+}
+
+// dryRun writes the SQL that a run of result's direction to target would
+// execute.
+func (m *Migrator) dryRun(
+	ctx context.Context, result *Result, target string,
+) error {
+	all, applied, warnings, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		m.warn(result, warning)
+	}
+	direction := result.Direction
+	var plan []Migration
+	if direction == "down" {
+		plan = m.rollbackPlan(all, applied, target)
+	} else {
+		plan = m.pendingMigrations(all, applied, target)
+	}
+	m.logf("Dry run: %d migrations to %s", len(plan), direction)
+
+	var b strings.Builder
+	for _, mig := range plan {
+		if err := m.writeDryRun(&b, mig, direction); err != nil {
+			return err
+		}
+	}
+	if m.DryRunOutput == nil {
+		m.logf("Dry run SQL:\n%s", b.String())
+		return nil
+	}
+	if _, err := io.WriteString(m.DryRunOutput, b.String()); err != nil {
+		return fmt.Errorf("write dry run: %w", err)
+	}
+	return nil
+}
+
+// writeDryRun writes the steps of mig in the given direction to b. Steps
+// wrapped by ForSchemas are written once per schema, and templates are
+// rendered with every step value shown by its name.
+func (m *Migrator) writeDryRun(
+	b *strings.Builder, mig Migration, direction string,
+) error {
+	steps := mig.UpSteps
+	if direction == "down" {
+		steps = mig.DownSteps
+	}
+	fmt.Fprintf(b, "-- %s %s: %s\n", direction, mig.Version, mig.Name)
+	for idx, step := range steps {
+		sqls, ok, err := stepSQLs(step, direction)
+		if err != nil {
+			return &MigrationError{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				Direction: direction,
+				Step:      idx + 1,
+				Label:     stepLabel(step),
+				Err:       err,
+			}
+		}
+		if !ok {
+			fmt.Fprintf(
+				b, "-- step %d (%s) is not SQL\n", idx+1, stepLabel(step),
+			)
+			continue
+		}
+		for _, sql := range sqls {
+			sql = strings.TrimSpace(m.redactedSQL(sql))
+			if sql == "" {
+				continue
+			}
+			if !strings.HasSuffix(sql, ";") {
+				sql += ";"
+			}
+			fmt.Fprintf(b, "%s\n", sql)
+		}
+	}
+	if direction == "down" {
+		fmt.Fprintf(
+			b, "-- remove %s from %s\n\n", mig.Version, m.HistoryTable,
+		)
+	} else {
+		fmt.Fprintf(b, "-- record %s in %s\n\n", mig.Version, m.HistoryTable)
+	}
+	return nil
+}
+
+// rollbackPlan returns the migrations of all that MigrateDown would roll
+// back to target, in the order they would be rolled back.
+func (m *Migrator) rollbackPlan(
+	all []Migration, applied appliedSet, target string,
+) []Migration {
+	var plan []Migration
+	for _, mig := range slices.Backward(all) {
+		if !applied.has(mig) {
+			continue
+		}
+		if m.isTargetReached(target, mig, "down") {
+			break
+		}
+		if m.excluded(mig, nil) {
+			continue
+		}
+		plan = append(plan, mig)
+	}
+	return plan
+}
@@ -0,0 +1,237 @@
+package migrator
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncryptedExt is the extension of encrypted migration files, e.g.
+// "002_seed_users_up.enc".
+const EncryptedExt = ".enc"
+
+// encryptedMagic prefixes the content of encrypted migration files.
+const encryptedMagic = "MIGRATOR-AESGCM-1\n"
+
+// ErrDecrypt is returned when an encrypted migration file cannot be
+// decrypted, e.g. because the key is wrong or the file was modified.
+var ErrDecrypt = errors.New("decrypt migration")
+
+// KeyProvider provides the AES key encrypted migration files are decrypted
+// with. Implementations may fetch the key from a secret manager or KMS.
+type KeyProvider interface {
+	// Key returns a 16, 24 or 32 byte AES key.
+	Key() ([]byte, error)
+}
+
+// KeyProviderFunc is an adapter to use an ordinary function as a
+// KeyProvider.
+type KeyProviderFunc func() ([]byte, error)
+
+// Key calls f().
+func (f KeyProviderFunc) Key() ([]byte, error) {
+	return f()
+}
+
+// StaticKey returns a KeyProvider of key.
+//
+// Parameters:
+//   - key: The AES key.
+//
+// Returns:
+//   - KeyProvider: The provider.
+func StaticKey(key []byte) KeyProvider {
+	return KeyProviderFunc(func() ([]byte, error) { return key, nil })
+}
+
+// EnvKey returns a KeyProvider reading a base64 encoded AES key from the
+// environment variable name.
+//
+// Parameters:
+//   - name: The name of the environment variable.
+//
+// Returns:
+//   - KeyProvider: The provider.
+func EnvKey(name string) KeyProvider {
+	return KeyProviderFunc(func() ([]byte, error) {
+		value := os.Getenv(name)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	})
+}
+
+// EncryptSQL encrypts sql with AES-GCM for storage in an encrypted
+// migration file.
+//
+// Parameters:
+//   - key: A 16, 24 or 32 byte AES key.
+//   - sql: The SQL to encrypt.
+//
+// Returns:
+//   - []byte: The content of the encrypted file.
+//   - error: An error if the key is invalid.
+func EncryptSQL(key []byte, sql []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(encryptedMagic), nonce...)
+	return gcm.Seal(out, nonce, sql, []byte(encryptedMagic)), nil
+}
+
+// DecryptSQL decrypts the content of an encrypted migration file.
+//
+// Parameters:
+//   - key: The AES key the content was encrypted with.
+//   - data: The content of the encrypted file.
+//
+// Returns:
+//   - []byte: The SQL.
+//   - error: An error wrapping ErrDecrypt if decryption fails.
+func DecryptSQL(key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest, ok := strings.CutPrefix(string(data), encryptedMagic)
+	if !ok || len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: not an encrypted migration file", ErrDecrypt)
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	sql, err := gcm.Open(
+		nil, []byte(nonce), []byte(ciphertext), []byte(encryptedMagic),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return sql, nil
+}
+
+// newGCM returns an AES-GCM cipher of key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedSource decrypts the encrypted migration files of the wrapped
+// source at load time, so that sensitive seed data can be stored encrypted
+// in the repository or object store. Steps reading a file with EncryptedExt
+// are replaced by SQL steps holding the decrypted SQL, which then stays in
+// memory only. Other steps are kept unchanged.
+//
+// Directory sources skip encrypted files unless EncryptedExt is allowed:
+//
+//	src := NewEncryptedSource(
+//		NewDirMigrationSource(dir).WithAllowedExts([]string{".sql", ".enc"}),
+//		EnvKey("MIGRATIONS_KEY"),
+//	)
+type EncryptedSource struct {
+	MigrationSource
+	Keys KeyProvider
+	// Optional Logger receives log messages, defaults to the standard
+	// logger.
+	Logger Logger
+}
+
+// NewEncryptedSource returns a new EncryptedSource.
+//
+// Parameters:
+//   - src: The source to wrap.
+//   - keys: The provider of the decryption key.
+//
+// Returns:
+//   - *EncryptedSource: A new EncryptedSource.
+func NewEncryptedSource(src MigrationSource, keys KeyProvider) *EncryptedSource {
+	return &EncryptedSource{MigrationSource: src, Keys: keys}
+}
+
+// LoadMigrations loads the migrations of the wrapped source and decrypts
+// their encrypted files.
+//
+// Returns:
+//   - []Migration: The loaded migrations.
+//   - error: An error if loading or decryption fails.
+func (e *EncryptedSource) LoadMigrations() ([]Migration, error) {
+	migs, _, err := e.LoadMigrationsWithWarnings()
+	return migs, err
+}
+
+// LoadMigrationsWithWarnings loads the migrations of the wrapped source
+// like LoadMigrations and returns its warnings, if it reports any.
+//
+// Returns:
+//   - []Migration: The loaded migrations.
+//   - []Warning: The warnings found.
+//   - error: An error if loading or decryption fails.
+func (e *EncryptedSource) LoadMigrationsWithWarnings() (
+	[]Migration, []Warning, error,
+) {
+	migs, warnings, err := loadSource(e.MigrationSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key []byte
+	decrypted := 0
+	for i := range migs {
+		mig := &migs[i]
+		for _, up := range []bool{true, false} {
+			steps := mig.DownSteps
+			if up {
+				steps = mig.UpSteps
+			}
+			for idx, step := range steps {
+				f, ok := stepFile(step)
+				if !ok || !strings.EqualFold(filepath.Ext(f.Path), EncryptedExt) {
+					continue
+				}
+				if key == nil {
+					if key, err = e.Keys.Key(); err != nil {
+						return nil, nil, fmt.Errorf("decryption key: %w", err)
+					}
+				}
+				sql, err := decryptFile(key, *f)
+				if err != nil {
+					return nil, nil, err
+				}
+				steps[idx] = &SQLMigrationStep{SQL: sql, NoSplit: f.NoSplit}
+				decrypted++
+				if up {
+					// The source read the annotations of the ciphertext.
+					mig.loadAnnotations = nil
+					mig.setAnnotations(parseAnnotationsString(sql))
+				}
+			}
+		}
+	}
+	loggerOrDefault(e.Logger).Printf("Decrypted %d migration files", decrypted)
+	return migs, warnings, nil
+}
+
+// decryptFile reads and decrypts the encrypted migration file of f.
+func decryptFile(key []byte, f FileSQLMigrationStep) (string, error) {
+	data, err := f.readFile(f.Path)
+	if err != nil {
+		return "", err
+	}
+	sql, err := DecryptSQL(key, data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return string(sql), nil
+}
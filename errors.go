@@ -0,0 +1,126 @@
+package migrator
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MigrationError reports where a run failed: in a step of a migration or
+// while writing the migration's history record. Errors of steps and
+// history writes of MigrateUp, MigrateDown and their variants are returned
+// as *MigrationError.
+type MigrationError struct {
+	// Version is the version of the migration.
+	Version string
+	// Name is the name of the migration.
+	Name string
+	// Direction is "up" or "down".
+	Direction string
+	// Step is the 1-based index of the failed step, or 0 if the history
+	// write failed.
+	Step int
+	// Label describes the failed step, e.g. "sql" or "backfill", see
+	// StepLabeler, or the failed history write.
+	Label string
+	// Statement is the 1-based index of the failing statement within a
+	// step executing several statements, or 0 if it is unknown.
+	Statement int
+	// Line is the line of the step's SQL the failing statement starts on,
+	// or 0 if it is unknown.
+	Line int
+	// SQL is the last statement executed by the step, shown as configured
+	// by the Migrator's RedactSQL mode. It is empty if the step executed no
+	// statement.
+	SQL string
+	// Preview is a truncated single-line preview of SQL in which the
+	// failing statement, if it can be identified, is enclosed in ">>> " and
+	// " <<<". It is empty when SQL is redacted or previews are disabled.
+	Preview string
+	// Redacted reports whether the SQL and the database error are hidden
+	// from the message.
+	Redacted bool
+	// Err is the error returned by the step or the history manager.
+	Err error
+}
+
+// StepError is the former name of MigrationError.
+//
+// Deprecated: Use MigrationError.
+type StepError = MigrationError
+
+// StepLabeler is implemented by steps that describe themselves in errors.
+// Other steps are labeled after their type, e.g. "sql" for
+// SQLMigrationStep.
+type StepLabeler interface {
+	// StepLabel returns the label of the step.
+	StepLabel() string
+}
+
+// stepLabel returns the label of step.
+func stepLabel(step MigrationStep) string {
+	if labeler, ok := step.(StepLabeler); ok {
+		return labeler.StepLabel()
+	}
+	t := reflect.TypeOf(step)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" {
+		return ""
+	}
+	name := strings.TrimSuffix(t.Name(), "Step")
+	name = strings.TrimSuffix(name, "Migration")
+	if name == "" {
+		return strings.ToLower(t.Name())
+	}
+	return strings.ToLower(name)
+}
+
+// Error returns the error message.
+func (e *MigrationError) Error() string {
+	label := fmt.Sprintf("migration %s (%s): %s", e.Version, e.Name, e.Direction)
+	if e.Step == 0 {
+		label += " " + e.Label
+	} else {
+		label += fmt.Sprintf(" step %d", e.Step)
+		if e.Label != "" {
+			label += " (" + e.Label + ")"
+		}
+	}
+	if e.Statement > 0 {
+		label += fmt.Sprintf(", statement %d", e.Statement)
+	}
+	if e.Line > 0 {
+		label += fmt.Sprintf(" at line %d", e.Line)
+	}
+	if e.Redacted {
+		if e.SQL == "" {
+			return label + " failed (details redacted)"
+		}
+		return fmt.Sprintf("%s failed on %s (details redacted)", label, e.SQL)
+	}
+	if e.Preview != "" {
+		return fmt.Sprintf("%s: %v [sql: %s]", label, e.Err, e.Preview)
+	}
+	return fmt.Sprintf("%s: %v", label, e.Err)
+}
+
+// Unwrap returns the error returned by the step or the history manager.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// historyError returns err of a history write for mig as a
+// *MigrationError.
+func historyError(
+	mig Migration, direction string, label string, err error,
+) *MigrationError {
+	return &MigrationError{
+		Version:   mig.Version,
+		Name:      mig.Name,
+		Direction: direction,
+		Label:     label,
+		Err:       err,
+	}
+}
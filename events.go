@@ -0,0 +1,200 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// EventRunStarted is emitted when MigrateUp or MigrateDown starts.
+	EventRunStarted EventType = "run_started"
+	// EventMigrationStarted is emitted before the steps of a migration run.
+	EventMigrationStarted EventType = "migration_started"
+	// EventMigrationApplied is emitted after a migration is applied and
+	// recorded.
+	EventMigrationApplied EventType = "migration_applied"
+	// EventStepCompleted is emitted after a step of a migration completes.
+	EventStepCompleted EventType = "step_completed"
+	// EventStepFailed is emitted when a step of a migration fails.
+	EventStepFailed EventType = "step_failed"
+	// EventStepProgress is emitted when a long-running step reports its
+	// progress.
+	EventStepProgress EventType = "step_progress"
+	// EventMigrationRolledBack is emitted after a migration is rolled back
+	// and its record removed.
+	EventMigrationRolledBack EventType = "migration_rolled_back"
+	// EventLockWaiting is emitted periodically while a run waits for the
+	// run lock held by another runner.
+	EventLockWaiting EventType = "lock_waiting"
+	// EventWarning is emitted for every non-fatal warning.
+	EventWarning EventType = "warning"
+	// EventRunCompleted is emitted when a run succeeds.
+	EventRunCompleted EventType = "run_completed"
+	// EventRunFailed is emitted when a run fails.
+	EventRunFailed EventType = "run_failed"
+)
+
+// Event describes progress of a migration run. Fields that do not apply to
+// the event type are left empty.
+type Event struct {
+	// Type is the kind of the event.
+	Type EventType
+	// Time is when the event occurred.
+	Time time.Time
+	// Direction is "up" or "down".
+	Direction string
+	// Migration describes the migration of migration events.
+	Migration *MigrationResult
+	// Step is the 1-based step number of step events.
+	Step int
+	// Duration is the execution time of step events and the time waited
+	// of EventLockWaiting events.
+	Duration time.Duration
+	// Progress is the progress of EventStepProgress events.
+	Progress *StepProgress
+	// Lock is the holder of the run lock of EventLockWaiting events.
+	Lock *LockHolder
+	// Warning is the warning of EventWarning events.
+	Warning *Warning
+	// Result is the run result of EventRunCompleted and EventRunFailed
+	// events.
+	Result *Result
+	// Err is the error of EventStepFailed and EventRunFailed events.
+	Err error
+}
+
+// EventSink receives the events of migration runs. Events are delivered
+// synchronously from the goroutine running the migrations.
+type EventSink interface {
+	// HandleEvent handles a single event.
+	HandleEvent(event Event)
+}
+
+// EventSinkFunc is an adapter to use an ordinary function as an EventSink.
+type EventSinkFunc func(event Event)
+
+// HandleEvent calls f(event).
+func (f EventSinkFunc) HandleEvent(event Event) {
+	f(event)
+}
+
+// WithEventSink returns a new Migrator that streams run events to sink.
+//
+// Parameters:
+//   - sink: The sink to deliver events to.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithEventSink(sink EventSink) *Migrator {
+	new := *m
+	new.EventSink = sink
+	return &new
+}
+
+// emit delivers an event to the event sink, if any.
+func (m *Migrator) emit(event Event) {
+	if m.EventSink == nil {
+		return
+	}
+	event.Time = nowUTC(m.NowFunc)
+	m.EventSink.HandleEvent(event)
+}
+
+// emitStep emits a step event of mig's step started at start.
+func (m *Migrator) emitStep(
+	eventType EventType,
+	mig Migration,
+	direction string,
+	step int,
+	start time.Time,
+	err error,
+) {
+	if m.EventSink == nil {
+		return
+	}
+	res := newMigrationResult(mig)
+	m.emit(Event{
+		Type:      eventType,
+		Direction: direction,
+		Migration: &res,
+		Step:      step,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// StepProgress is the progress of a long-running step.
+type StepProgress struct {
+	// Phase describes what the step is doing, e.g. "building index".
+	Phase string `json:"phase"`
+	// Done is the amount of work done, in units of Total.
+	Done int64 `json:"done"`
+	// Total is the amount of work, zero if unknown.
+	Total int64 `json:"total"`
+}
+
+// String returns the progress as shown in log messages, e.g. "building
+// index: 40 of 100".
+func (p StepProgress) String() string {
+	if p.Total <= 0 {
+		return p.Phase
+	}
+	return fmt.Sprintf("%s: %d of %d", p.Phase, p.Done, p.Total)
+}
+
+// Percent returns the share of the work done in percent, or -1 if the
+// total is unknown.
+//
+// Returns:
+//   - float64: The percentage done.
+func (p StepProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return float64(p.Done) * 100 / float64(p.Total)
+}
+
+// progressKey is the context key of the progress reporter of a step.
+type progressKey struct{}
+
+// ReportProgress reports the progress of the running step as an
+// EventStepProgress event. It must be called from the goroutine executing
+// the step and does nothing outside a step.
+//
+// Parameters:
+//   - ctx: The context passed to the step.
+//   - progress: The progress of the step.
+func ReportProgress(ctx context.Context, progress StepProgress) {
+	if report, ok := ctx.Value(progressKey{}).(func(StepProgress)); ok {
+		report(progress)
+	}
+}
+
+// withProgress returns ctx reporting the progress of mig's step started at
+// start as events.
+func (m *Migrator) withProgress(
+	ctx context.Context,
+	mig Migration,
+	direction string,
+	step int,
+	start time.Time,
+) context.Context {
+	if m.EventSink == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, func(progress StepProgress) {
+		res := newMigrationResult(mig)
+		m.emit(Event{
+			Type:      EventStepProgress,
+			Direction: direction,
+			Migration: &res,
+			Step:      step,
+			Duration:  time.Since(start),
+			Progress:  &progress,
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package migrator
+
+import (
+	"fmt"
+	"slices"
+)
+
+// WithExclude returns a new Migrator whose runs leave the migrations of
+// versions alone, e.g. for an emergency deploy that must go out while one
+// problematic migration is being fixed. Excluded migrations are neither
+// applied nor rolled back; they stay pending and are reported as warnings.
+// Unlike a change of the migration sources, the exclusion only applies to
+// runs of the returned Migrator.
+//
+// Parameters:
+//   - versions: The versions to exclude.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithExclude(versions ...string) *Migrator {
+	new := *m
+	new.Exclude = versions
+	return &new
+}
+
+// excluded reports whether mig is excluded from runs, warning about it in
+// result if set.
+func (m *Migrator) excluded(mig Migration, result *Result) bool {
+	if !slices.Contains(m.Exclude, mig.Version) {
+		return false
+	}
+	if result != nil {
+		m.warn(result, Warning{
+			Code: WarningExcluded,
+			Message: fmt.Sprintf(
+				"migration %s (%s) is excluded from the run", mig.Version, mig.Name,
+			),
+			Version: mig.Version,
+		})
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// NewRegexFilenameParser returns a ParseFilenameFn extracting the migration
+// details from the named capture groups "version", "name" and "direction"
+// of pattern, as an alternative to writing a parser for a naming scheme:
+//
+//	// V3__add_users.up.sql
+//	`V(?P<version>\d+)__(?P<name>\w+)\.(?P<direction>up|down)\.sql`
+//
+// The pattern must match the whole file name. The version group is
+// required. Without a name group the name is empty, and without a
+// direction group every file is an up migration. The direction must be
+// "up" or "down" in any case; files matching with another direction, or
+// with an empty version, are not migrations.
+//
+// Parameters:
+//   - pattern: The regular expression.
+//
+// Returns:
+//   - ParseFilenameFn: The parser.
+//   - error: An error if pattern is invalid or has no version group.
+func NewRegexFilenameParser(pattern string) (ParseFilenameFn, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("filename pattern: %w", err)
+	}
+	names := re.SubexpNames()
+	group := func(name string) int {
+		return slices.Index(names, name)
+	}
+	versionIdx, nameIdx, directionIdx :=
+		group("version"), group("name"), group("direction")
+	if versionIdx < 0 {
+		return nil, fmt.Errorf(
+			"filename pattern %q: missing named group \"version\"", pattern,
+		)
+	}
+	return func(filename string) (string, string, string, bool) {
+		match := re.FindStringSubmatch(filename)
+		if match == nil || match[versionIdx] == "" {
+			return "", "", "", false
+		}
+		name := ""
+		if nameIdx >= 0 {
+			name = match[nameIdx]
+		}
+		direction := "up"
+		if directionIdx >= 0 {
+			direction = strings.ToLower(match[directionIdx])
+		}
+		if direction != "up" && direction != "down" {
+			return "", "", "", false
+		}
+		return match[versionIdx], name, direction, true
+	}, nil
+}
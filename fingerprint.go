@@ -0,0 +1,92 @@
+package migrator
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+)
+
+// fingerprintEntry is a migration contributing to a fingerprint.
+type fingerprintEntry struct {
+	version  string
+	checksum string
+}
+
+// SourceFingerprint returns a stable hash of the versions and checksums of
+// all migrations of sources, so that applications can embed it in build
+// metadata, or compare it at startup with HistoryFingerprint to detect a
+// binary shipping other migrations than the database has applied. The
+// order of sources and of their migrations does not matter. Only sources
+// computing checksums (see DirMigrationSource.WithChecksums) make the
+// fingerprint cover the content of migrations; otherwise it covers their
+// versions only.
+//
+// Parameters:
+//   - sources: The migration sources.
+//
+// Returns:
+//   - string: The hex encoded SHA-256 fingerprint.
+//   - error: An error if loading a source fails.
+func SourceFingerprint(sources ...MigrationSource) (string, error) {
+	var entries []fingerprintEntry
+	for _, src := range sources {
+		migs, err := src.LoadMigrations()
+		if err != nil {
+			return "", err
+		}
+		for _, mig := range migs {
+			entries = append(entries, fingerprintEntry{
+				version:  mig.Version,
+				checksum: mig.Checksum,
+			})
+		}
+	}
+	return fingerprint(entries), nil
+}
+
+// HistoryFingerprint returns the fingerprint of the applied migrations
+// recorded in the history, computed like SourceFingerprint from the
+// checksums recorded at apply time. It equals the SourceFingerprint of the
+// Migrator's sources once all their migrations are applied unchanged. The
+// HistoryManager must implement HistoryReader.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - string: The hex encoded SHA-256 fingerprint.
+//   - error: An error if the history cannot be read.
+func (m *Migrator) HistoryFingerprint(ctx context.Context) (string, error) {
+	applied, err := m.HistoryEntries(ctx)
+	if err != nil {
+		return "", err
+	}
+	entries := make([]fingerprintEntry, 0, len(applied))
+	for _, entry := range applied {
+		entries = append(entries, fingerprintEntry{
+			version:  entry.Migration.Version,
+			checksum: entry.Migration.Checksum,
+		})
+	}
+	return fingerprint(entries), nil
+}
+
+// fingerprint hashes entries in version order.
+func fingerprint(entries []fingerprintEntry) string {
+	slices.SortFunc(entries, func(a, b fingerprintEntry) int {
+		if c := compareVersions(a.version, b.version); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.checksum, b.checksum)
+	})
+	h := sha256.New()
+	for _, entry := range entries {
+		h.Write([]byte(entry.version))
+		h.Write([]byte{0})
+		h.Write([]byte(entry.checksum))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,290 @@
+package migrator
+
+import (
+	"io/fs"
+	"iter"
+	"os"
+)
+
+// FSMigrationSource loads migrations from a directory of an fs.FS, such as
+// an embed.FS, so that migrations can be compiled into the binary:
+//
+//	//go:embed migrations/*.sql
+//	var migrationFS embed.FS
+//
+//	src := migrator.NewFSMigrationSource(migrationFS, "migrations")
+//
+// Files are parsed, grouped and hooked exactly as by DirMigrationSource;
+// paths passed to filename parsers are base names and paths passed to hooks
+// are slash-separated paths within the file system.
+type FSMigrationSource struct {
+	FS fs.FS
+	// Dir is the directory within FS, "." for its root.
+	Dir string
+	// Optional filename parser, defaults to defaultParseFilename.
+	FilenameParser ParseFilenameFn
+	// Optional allowed extensions, defaults to .sql and .sqlite files.
+	AllowedExts []string
+	// Optional ResolveHooks returns hook functions for the given filename.
+	ResolveHooks func(filename string) (preHook FileHookFn, postHook FileHookFn)
+	// Checksums enables computing Migration.Checksum at load time.
+	Checksums bool
+	// ChecksumWorkers bounds the concurrent checksum computations, defaults
+	// to GOMAXPROCS.
+	ChecksumWorkers int
+	// Optional MigrationName overrides the Migrator's migration name for
+	// the migrations of this source.
+	MigrationName string
+	// Optional Logger receives log messages, defaults to the standard
+	// logger.
+	Logger Logger
+	// NoSplit executes the SQL of every migration in one call even if the
+	// Migrator splits statements, see WithStatementSplitting.
+	NoSplit bool
+	// Optional VersionComparator orders the migrations returned by the
+	// source, defaults to CompareNumeric.
+	VersionComparator VersionComparator
+}
+
+// NewFSMigrationSource creates a new FSMigrationSource for the given
+// directory of fsys. The default parser and allowed extensions are used.
+//
+// Parameters:
+//   - fsys: The file system, e.g. an embed.FS.
+//   - dir: The directory within fsys, "." for its root.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func NewFSMigrationSource(fsys fs.FS, dir string) *FSMigrationSource {
+	return &FSMigrationSource{
+		FS:             fsys,
+		Dir:            dir,
+		FilenameParser: defaultParseFilename,
+		AllowedExts:    []string{".sql", ".sqlite"},
+	}
+}
+
+// WithFilenameParser returns a new FSMigrationSource with the given parser.
+//
+// Parameters:
+//   - parser: The ParseFilenameFn to use.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithFilenameParser(
+	parser ParseFilenameFn,
+) *FSMigrationSource {
+	new := *s
+	new.FilenameParser = parser
+	return &new
+}
+
+// WithAllowedExts returns a new FSMigrationSource with the given allowed
+// extensions.
+//
+// Parameters:
+//   - exts: A slice of allowed extensions.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithAllowedExts(exts []string) *FSMigrationSource {
+	new := *s
+	new.AllowedExts = exts
+	return &new
+}
+
+// WithResolveHooks returns a new FSMigrationSource resolving the hooks of
+// every file with resolve.
+//
+// Parameters:
+//   - resolve: Returns the hooks run before and after a file, either nil.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithResolveHooks(
+	resolve func(filename string) (preHook FileHookFn, postHook FileHookFn),
+) *FSMigrationSource {
+	new := *s
+	new.ResolveHooks = resolve
+	return &new
+}
+
+// WithChecksums returns a new FSMigrationSource with checksum computation
+// enabled or disabled.
+//
+// Parameters:
+//   - enabled: Whether to compute checksums.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithChecksums(enabled bool) *FSMigrationSource {
+	new := *s
+	new.Checksums = enabled
+	return &new
+}
+
+// WithChecksumWorkers returns a new FSMigrationSource with the given number
+// of concurrent checksum workers.
+//
+// Parameters:
+//   - workers: The maximum number of concurrent workers.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithChecksumWorkers(
+	workers int,
+) *FSMigrationSource {
+	new := *s
+	new.ChecksumWorkers = workers
+	return &new
+}
+
+// WithMigrationName returns a new FSMigrationSource whose migrations are
+// recorded under the given migration name instead of the Migrator's.
+//
+// Parameters:
+//   - migrationName: The migration name (history namespace) to use.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithMigrationName(
+	migrationName string,
+) *FSMigrationSource {
+	new := *s
+	new.MigrationName = migrationName
+	return &new
+}
+
+// WithLogger returns a new FSMigrationSource logging to logger instead of the
+// standard logger.
+//
+// Parameters:
+//   - logger: The logger to use, e.g. DiscardLogger.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithLogger(logger Logger) *FSMigrationSource {
+	new := *s
+	new.Logger = logger
+	return &new
+}
+
+// WithSplit returns a new FSMigrationSource whose SQL steps are split into
+// statements when the Migrator splits statements, or always executed in one
+// call if enabled is false, e.g. for files with trigger bodies.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithSplit(enabled bool) *FSMigrationSource {
+	new := *s
+	new.NoSplit = !enabled
+	return &new
+}
+
+// WithVersionComparator returns a new FSMigrationSource returning its
+// migrations in the order of compare. The Migrator orders the migrations of
+// all sources with its own comparator.
+//
+// Parameters:
+//   - compare: The comparator to use, nil for CompareNumeric.
+//
+// Returns:
+//   - *FSMigrationSource: A new FSMigrationSource instance.
+func (s *FSMigrationSource) WithVersionComparator(
+	compare VersionComparator,
+) *FSMigrationSource {
+	new := *s
+	new.VersionComparator = compare
+	return &new
+}
+
+// LoadMigrations loads and merges migrations from the directory.
+//
+// Returns:
+//   - []Migration: A slice containing the loaded migrations.
+//   - error: An error if loading fails.
+func (s *FSMigrationSource) LoadMigrations() ([]Migration, error) {
+	return s.dirSource().LoadMigrations()
+}
+
+// LoadMigrationsWithWarnings loads and merges migrations from the directory
+// and reports skipped files whose names cannot be parsed.
+//
+// Returns:
+//   - []Migration: A slice containing the loaded migrations.
+//   - []Warning: The warnings found.
+//   - error: An error if loading fails.
+func (s *FSMigrationSource) LoadMigrationsWithWarnings() (
+	[]Migration, []Warning, error,
+) {
+	return s.dirSource().LoadMigrationsWithWarnings()
+}
+
+// Migrations returns an iterator over the migrations of the directory in
+// version order, like DirMigrationSource.Migrations.
+//
+// Returns:
+//   - iter.Seq2[Migration, error]: An iterator over the migrations.
+func (s *FSMigrationSource) Migrations() iter.Seq2[Migration, error] {
+	return s.dirSource().Migrations()
+}
+
+// Check validates the directory strictly, like DirMigrationSource.Check.
+//
+// Returns:
+//   - error: The problems found, or nil if the directory is valid.
+func (s *FSMigrationSource) Check() error {
+	return s.dirSource().Check()
+}
+
+// dirSource returns the DirMigrationSource reading the directory of the
+// file system.
+func (s *FSMigrationSource) dirSource() *DirMigrationSource {
+	dir := s.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return &DirMigrationSource{
+		Dir:               dir,
+		FilenameParser:    s.FilenameParser,
+		AllowedExts:       s.AllowedExts,
+		ResolveHooks:      s.ResolveHooks,
+		Checksums:         s.Checksums,
+		ChecksumWorkers:   s.ChecksumWorkers,
+		MigrationName:     s.MigrationName,
+		Logger:            s.Logger,
+		NoSplit:           s.NoSplit,
+		VersionComparator: s.VersionComparator,
+		fsys:              s.FS,
+	}
+}
+
+// readFile reads the named file of fsys, or of the operating system if fsys
+// is nil.
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// readDir reads the named directory of fsys, or of the operating system if
+// fsys is nil.
+func readDir(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	if fsys == nil {
+		return os.ReadDir(name)
+	}
+	return fs.ReadDir(fsys, name)
+}
+
+// openFile opens the named file of fsys, or of the operating system if fsys
+// is nil.
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
@@ -0,0 +1,159 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// GoMigrationFn is the up or down function of a Go migration, called with
+// the transaction the migration runs in.
+type GoMigrationFn func(ctx context.Context, tx *sql.Tx) error
+
+// goMigration is a migration registered with a GoMigrationSource.
+type goMigration struct {
+	version string
+	name    string
+	up      MigrationStep
+	down    MigrationStep
+}
+
+// GoMigrationSource provides migrations written as Go functions, registered
+// by version and name, for changes that SQL cannot express, such as data
+// transformations computed in Go. Register the migrations, e.g. from init
+// functions, before the source is loaded; registration is not safe for
+// concurrent use with loading.
+type GoMigrationSource struct {
+	// Optional MigrationName overrides the Migrator's migration name for
+	// the migrations of this source.
+	MigrationName string
+	migrations    []goMigration
+}
+
+// NewGoMigrationSource returns a new GoMigrationSource without migrations.
+//
+// Returns:
+//   - *GoMigrationSource: A new GoMigrationSource instance.
+func NewGoMigrationSource() *GoMigrationSource {
+	return &GoMigrationSource{}
+}
+
+// Register adds a migration whose up and down functions run in the
+// transaction of the run, or of the migration with WithTxPerMigration.
+// They fail in non-transactional runs; use RegisterNoTx for migrations
+// that cannot run in a transaction. Invalid and duplicate registrations are
+// reported by LoadMigrations.
+//
+// Parameters:
+//   - version: The version of the migration.
+//   - name: The name of the migration.
+//   - up: The function applying the migration.
+//   - down: The function rolling it back, nil if it cannot be rolled back.
+//
+// Returns:
+//   - *GoMigrationSource: The source, for chaining.
+func (s *GoMigrationSource) Register(
+	version string, name string, up GoMigrationFn, down GoMigrationFn,
+) *GoMigrationSource {
+	mig := goMigration{version: version, name: name}
+	if up != nil {
+		mig.up = NewTxHookMigrationStep(TxHookFn(up), nil)
+	}
+	if down != nil {
+		mig.down = NewTxHookMigrationStep(nil, TxHookFn(down))
+	}
+	s.migrations = append(s.migrations, mig)
+	return s
+}
+
+// RegisterNoTx adds a migration whose up and down functions are called with
+// the executor of the run, which is a transaction only in transactional
+// runs, e.g. for statements such as CREATE INDEX CONCURRENTLY.
+//
+// Parameters:
+//   - version: The version of the migration.
+//   - name: The name of the migration.
+//   - up: The function applying the migration.
+//   - down: The function rolling it back, nil if it cannot be rolled back.
+//
+// Returns:
+//   - *GoMigrationSource: The source, for chaining.
+func (s *GoMigrationSource) RegisterNoTx(
+	version string, name string, up HookFn, down HookFn,
+) *GoMigrationSource {
+	mig := goMigration{version: version, name: name}
+	if up != nil {
+		mig.up = &HookMigrationStep{UpHook: up}
+	}
+	if down != nil {
+		mig.down = &HookMigrationStep{DownHook: down}
+	}
+	s.migrations = append(s.migrations, mig)
+	return s
+}
+
+// WithMigrationName returns a new GoMigrationSource whose migrations are
+// recorded under the given migration name instead of the Migrator's.
+//
+// Parameters:
+//   - migrationName: The migration name (history namespace) to use.
+//
+// Returns:
+//   - *GoMigrationSource: A new GoMigrationSource instance.
+func (s *GoMigrationSource) WithMigrationName(
+	migrationName string,
+) *GoMigrationSource {
+	new := *s
+	new.MigrationName = migrationName
+	new.migrations = slices.Clone(s.migrations)
+	return &new
+}
+
+// LoadMigrations returns the registered migrations in version order.
+//
+// Returns:
+//   - []Migration: The registered migrations.
+//   - error: An error if a registration has no version, name or up
+//     function, or reuses a version.
+func (s *GoMigrationSource) LoadMigrations() ([]Migration, error) {
+	var errs []error
+	seen := make(map[string]bool, len(s.migrations))
+	migrations := make([]Migration, 0, len(s.migrations))
+	for _, gm := range s.migrations {
+		switch {
+		case gm.version == "" || gm.name == "":
+			errs = append(errs, fmt.Errorf(
+				"go migration %q (%q): version and name are required",
+				gm.version, gm.name,
+			))
+			continue
+		case gm.up == nil:
+			errs = append(errs, fmt.Errorf(
+				"go migration %s (%s): no up function", gm.version, gm.name,
+			))
+			continue
+		case seen[gm.version]:
+			errs = append(errs, fmt.Errorf(
+				"go migration %s (%s): duplicate version", gm.version, gm.name,
+			))
+			continue
+		}
+		seen[gm.version] = true
+		mig := NewMigration(gm.version, gm.name)
+		mig.MigrationName = s.MigrationName
+		mig.UpSteps = []MigrationStep{gm.up}
+		if gm.down != nil {
+			mig.DownSteps = []MigrationStep{gm.down}
+		}
+		migrations = append(migrations, *mig)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(migrations, func(a, b Migration) int {
+		return compareVersions(a.Version, b.Version)
+	})
+	return migrations, nil
+}
@@ -0,0 +1,72 @@
+package migrator
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRunInProgress is returned by runs started while another run of the
+// same process migrates the same database and history table.
+var ErrRunInProgress = errors.New("migration run in progress")
+
+// RunInProgressError is returned when a run is started while another run
+// of the process is in progress, e.g. after a double-clicked admin
+// endpoint. It wraps ErrRunInProgress. Runs of other processes are
+// serialized by the run lock instead (see WithLock).
+type RunInProgressError struct {
+	// Direction is the direction of the run in progress, "up" or "down".
+	Direction string
+	// Since is when the run in progress started.
+	Since time.Time
+}
+
+// Error returns the run in progress.
+func (e *RunInProgressError) Error() string {
+	return fmt.Sprintf(
+		"%v: %s run since %s",
+		ErrRunInProgress, e.Direction, e.Since.Format(time.RFC3339),
+	)
+}
+
+// Unwrap returns ErrRunInProgress.
+func (e *RunInProgressError) Unwrap() error {
+	return ErrRunInProgress
+}
+
+// runKey identifies the runs that must not overlap within the process.
+type runKey struct {
+	db           *sql.DB
+	historyTable string
+}
+
+var (
+	activeRunsMu sync.Mutex
+	activeRuns   = map[runKey]RunInProgressError{}
+)
+
+// beginRun registers a run on the database and history table of the
+// Migrator, failing with a *RunInProgressError if one is in progress. It
+// returns the function ending the run. The guard is shared by all
+// Migrators of the process, including those derived with the With
+// methods.
+func (m *Migrator) beginRun(direction string) (func(), error) {
+	key := runKey{db: m.DB, historyTable: m.HistoryTable}
+	activeRunsMu.Lock()
+	defer activeRunsMu.Unlock()
+	if active, ok := activeRuns[key]; ok {
+		m.logf("Refusing to start: %s run in progress", active.Direction)
+		return nil, &active
+	}
+	activeRuns[key] = RunInProgressError{
+		Direction: direction,
+		Since:     time.Now().UTC(),
+	}
+	return func() {
+		activeRunsMu.Lock()
+		defer activeRunsMu.Unlock()
+		delete(activeRuns, key)
+	}, nil
+}
@@ -3,7 +3,11 @@ package migrator
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,70 +37,1146 @@ type HistoryManager interface {
 	) (map[string]bool, error)
 }
 
+// HistoryEntry is the history record of an applied migration together with
+// metadata about the run that applied it.
+type HistoryEntry struct {
+	Migration     Migration
+	MigrationName string
+	AppliedAt     time.Time
+	// AppliedBy is the optional service identity given via WithAppliedBy.
+	AppliedBy string
+	// AppliedUser is the OS user running the migrator.
+	AppliedUser string
+	// AppliedHost is the hostname of the machine running the migrator.
+	AppliedHost string
+	// SearchPath is the search_path the migration was applied with, if any.
+	SearchPath string
+	// Duration is the time it took to execute the migration's steps.
+	Duration time.Duration
+	// Attempts is the number of attempts it took to apply the migration:
+	// one, plus one for every failed step execution retried by
+	// WithStepRetry, plus one if the run resumed the migration from the
+	// step checkpoints of an earlier, failed run.
+	Attempts int
+}
+
+// nowUTC returns the current time in UTC using now, or time.Now if now is
+// nil.
+func nowUTC(now func() time.Time) time.Time {
+	if now == nil {
+		return time.Now().UTC()
+	}
+	return now().UTC()
+}
+
+// newHistoryEntry returns an entry for mig applied at appliedAt by this
+// process in a single attempt.
+func newHistoryEntry(
+	mig Migration, migrationName string, appliedAt time.Time,
+) HistoryEntry {
+	identity := currentIdentity("")
+	return HistoryEntry{
+		Migration:     mig,
+		MigrationName: migrationName,
+		AppliedAt:     appliedAt,
+		AppliedUser:   identity.user,
+		AppliedHost:   identity.host,
+		Attempts:      1,
+	}
+}
+
+// EntryHistoryManager is implemented by history managers that store the run
+// metadata of HistoryEntry. The Migrator prefers it over RecordMigration and
+// BatchHistoryManager.
+type EntryHistoryManager interface {
+	// RecordEntries inserts records for all given entries.
+	RecordEntries(
+		ctx context.Context,
+		exec Executor,
+		tableName string,
+		entries []HistoryEntry,
+	) error
+}
+
+// HistoryKeyReader is implemented by history managers that can read the
+// primary key of an existing history table. History tables created by
+// older versions have the version alone as their primary key, so the same
+// version cannot be recorded under two migration names, e.g. by sources
+// with their own migration name or schema runs. The Migrator refuses such
+// tables before writing to them when the HistoryManager implements
+// HistoryKeyReader.
+type HistoryKeyReader interface {
+	// HistoryKey returns the primary key columns of the history table, or
+	// none if the table does not exist.
+	HistoryKey(
+		ctx context.Context, db *sql.DB, tableName string,
+	) ([]string, error)
+}
+
+// HistoryReader is implemented by history managers that can return the full
+// history entries of applied migrations, including run metadata.
+type HistoryReader interface {
+	// AppliedEntries retrieves the entries recorded for migrationName.
+	AppliedEntries(
+		ctx context.Context, db *sql.DB, tableName string, migrationName string,
+	) ([]HistoryEntry, error)
+}
+
+// BatchHistoryManager is implemented by history managers that can record
+// several applied migrations with a single statement.
+type BatchHistoryManager interface {
+	// RecordMigrations inserts records for all given migrations.
+	RecordMigrations(
+		ctx context.Context,
+		exec Executor,
+		tableName string,
+		migs []Migration,
+		migrationName string,
+	) error
+}
+
+// maxBatchRows limits the rows written by one multi-row INSERT so that the
+// number of bound parameters stays below driver limits.
+const maxBatchRows = 100
+
+// historyColumn is a history table column added after the table layout was
+// first released. Existing tables are extended with these columns.
+type historyColumn struct {
+	name       string
+	definition string
+}
+
+// historyColumns lists the columns added to history tables over time.
+var historyColumns = []historyColumn{
+	{name: "description", definition: "TEXT"},
+	{name: "applied_by", definition: "TEXT"},
+	{name: "applied_user", definition: "TEXT"},
+	{name: "applied_host", definition: "TEXT"},
+	{name: "ticket", definition: "TEXT"},
+	{name: "duration_ms", definition: "BIGINT"},
+	{name: "attempts", definition: "INTEGER"},
+	{name: "search_path", definition: "TEXT"},
+	{name: "checksum", definition: "TEXT"},
+}
+
+// ensureHistoryColumns adds the given columns to an existing history table
+// if they are missing. The existing columns are detected with an empty
+// SELECT, which works across dialects.
+func ensureHistoryColumns(
+	ctx context.Context, db *sql.DB, tableName string, columns []historyColumn,
+) error {
+	rows, err := db.QueryContext(
+		ctx, fmt.Sprintf(`SELECT * FROM %s WHERE 1 = 0`, tableName),
+	)
+	if err != nil {
+		return err
+	}
+	existing, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if slices.ContainsFunc(existing, func(name string) bool {
+			return strings.EqualFold(name, col.name)
+		}) {
+			continue
+		}
+		query := fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN %s %s`, tableName, col.name, col.definition,
+		)
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryColumn returns the values of the single column returned by query.
+func queryColumn(
+	ctx context.Context, db *sql.DB, query string, args ...any,
+) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// questionPlaceholder returns the "?" bind variable used by MySQL and
+// SQLite.
+func questionPlaceholder(int) string {
+	return "?"
+}
+
+// dollarPlaceholder returns the "$n" bind variable used by Postgres.
+func dollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// PlaceholderFormatter is implemented by history managers whose dialect
+// does not use "?" bind variables.
+type PlaceholderFormatter interface {
+	// Placeholder returns the bind variable of the n-th argument, counting
+	// from 1.
+	Placeholder(n int) string
+}
+
+// placeholderFunc returns the bind variable format of hm.
+func placeholderFunc(hm HistoryManager) func(int) string {
+	if f, ok := hm.(PlaceholderFormatter); ok {
+		return f.Placeholder
+	}
+	return questionPlaceholder
+}
+
+// placeholderList returns count bind variables starting at argument first,
+// separated by commas.
+func placeholderList(placeholder func(int) string, first, count int) string {
+	vars := make([]string, count)
+	for i := range vars {
+		vars[i] = placeholder(first + i)
+	}
+	return strings.Join(vars, ", ")
+}
+
+// insertHistoryRows inserts history rows using multi-row INSERT statements of
+// at most maxBatchRows rows each.
+func insertHistoryRows(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	entries []HistoryEntry,
+	placeholder func(int) string,
+) error {
+	const columns = "version, name, migration_name, applied_at, description, " +
+		"applied_by, applied_user, applied_host, ticket, duration_ms, " +
+		"attempts, search_path, checksum"
+	const columnCount = 13
+	for start := 0; start < len(entries); start += maxBatchRows {
+		end := min(start+maxBatchRows, len(entries))
+		values := make([]string, 0, end-start)
+		args := make([]any, 0, (end-start)*columnCount)
+		for _, entry := range entries[start:end] {
+			values = append(values, "("+placeholderList(
+				placeholder, len(args)+1, columnCount,
+			)+")")
+			args = append(
+				args,
+				entry.Migration.Version,
+				entry.Migration.Name,
+				entry.MigrationName,
+				entry.AppliedAt,
+				entry.Migration.Description,
+				entry.AppliedBy,
+				entry.AppliedUser,
+				entry.AppliedHost,
+				entry.Migration.Ticket,
+				entry.Duration.Milliseconds(),
+				entry.Attempts,
+				entry.SearchPath,
+				entry.Migration.Checksum,
+			)
+		}
+		query := fmt.Sprintf(
+			`INSERT INTO %s (%s) VALUES %s`,
+			tableName,
+			columns,
+			strings.Join(values, ", "),
+		)
+		if _, err := exec.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectHistoryEntries reads the history entries recorded for migrationName.
+func selectHistoryEntries(
+	ctx context.Context,
+	db *sql.DB,
+	tableName string,
+	migrationName string,
+	placeholder func(int) string,
+) ([]HistoryEntry, error) {
+	query := fmt.Sprintf(
+		`SELECT version, name, migration_name, applied_at, description, `+
+			`applied_by, applied_user, applied_host, ticket, duration_ms, `+
+			`attempts, search_path, checksum FROM %s WHERE migration_name = %s`,
+		tableName,
+		placeholder(1),
+	)
+	rows, err := db.QueryContext(ctx, query, migrationName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var (
+			entry                                HistoryEntry
+			name, description, appliedBy, ticket sql.NullString
+			appliedUser, appliedHost, entryName  sql.NullString
+			searchPath, checksum                 sql.NullString
+			durationMS, attempts                 sql.NullInt64
+			appliedAt                            any
+		)
+		if err := rows.Scan(
+			&entry.Migration.Version,
+			&name,
+			&entryName,
+			&appliedAt,
+			&description,
+			&appliedBy,
+			&appliedUser,
+			&appliedHost,
+			&ticket,
+			&durationMS,
+			&attempts,
+			&searchPath,
+			&checksum,
+		); err != nil {
+			return nil, err
+		}
+		if entry.AppliedAt, err = parseHistoryTime(appliedAt); err != nil {
+			return nil, err
+		}
+		entry.Migration.Name = name.String
+		entry.Migration.MigrationName = entryName.String
+		entry.Migration.Description = description.String
+		entry.Migration.Ticket = ticket.String
+		entry.Migration.Checksum = checksum.String
+		entry.MigrationName = entryName.String
+		entry.AppliedBy = appliedBy.String
+		entry.AppliedUser = appliedUser.String
+		entry.AppliedHost = appliedHost.String
+		entry.Duration = time.Duration(durationMS.Int64) * time.Millisecond
+		entry.Attempts = int(attempts.Int64)
+		entry.SearchPath = searchPath.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// historyTimeLayouts are the layouts used to parse timestamps that drivers
+// return as text.
+var historyTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseHistoryTime converts a timestamp read from a history table into a
+// time.Time. Drivers return timestamps either as time.Time or as text.
+func parseHistoryTime(v any) (time.Time, error) {
+	var text string
+	switch t := v.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return t, nil
+	case string:
+		text = t
+	case []byte:
+		text = string(t)
+	default:
+		return time.Time{}, fmt.Errorf("unsupported timestamp type %T", v)
+	}
+	for _, layout := range historyTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse timestamp %q", text)
+}
+
+// newHistoryEntries returns entries for migs applied at appliedAt by this
+// process.
+func newHistoryEntries(
+	migs []Migration, migrationName string, appliedAt time.Time,
+) []HistoryEntry {
+	entries := make([]HistoryEntry, len(migs))
+	for i, mig := range migs {
+		entries[i] = newHistoryEntry(mig, migrationName, appliedAt)
+	}
+	return entries
+}
+
 // MySQLHistoryManager implements HistoryManager for MySQL.
-type MySQLHistoryManager struct{}
+type MySQLHistoryManager struct {
+	// Engine is the optional storage engine of the history table.
+	Engine string
+	// Charset is the optional default character set of the history table.
+	Charset string
+	// Collation is the optional default collation of the history table.
+	Collation string
+	// NowFunc returns the applied_at time of records written through
+	// RecordMigration and RecordMigrations. It defaults to time.Now.
+	NowFunc func() time.Time
+}
+
+// NewMySQLHistoryManager returns a new MySQLHistoryManager.
+//
+// Returns:
+//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
+func NewMySQLHistoryManager() *MySQLHistoryManager {
+	return &MySQLHistoryManager{}
+}
+
+// WithEngine returns a new MySQLHistoryManager that creates the history
+// table with the given storage engine, such as InnoDB.
+//
+// Parameters:
+//   - engine: The storage engine.
+//
+// Returns:
+//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
+func (m *MySQLHistoryManager) WithEngine(engine string) *MySQLHistoryManager {
+	new := *m
+	new.Engine = engine
+	return &new
+}
+
+// WithCharset returns a new MySQLHistoryManager that creates the history
+// table with the given default character set, such as utf8mb4.
+//
+// Parameters:
+//   - charset: The character set.
+//
+// Returns:
+//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
+func (m *MySQLHistoryManager) WithCharset(charset string) *MySQLHistoryManager {
+	new := *m
+	new.Charset = charset
+	return &new
+}
+
+// WithCollation returns a new MySQLHistoryManager that creates the history
+// table with the given default collation, such as utf8mb4_unicode_ci.
+//
+// Parameters:
+//   - collation: The collation.
+//
+// Returns:
+//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
+func (m *MySQLHistoryManager) WithCollation(
+	collation string,
+) *MySQLHistoryManager {
+	new := *m
+	new.Collation = collation
+	return &new
+}
+
+// WithNowFunc returns a new MySQLHistoryManager that takes applied_at times
+// from now.
+//
+// Parameters:
+//   - now: The clock to use.
+//
+// Returns:
+//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
+func (m *MySQLHistoryManager) WithNowFunc(
+	now func() time.Time,
+) *MySQLHistoryManager {
+	new := *m
+	new.NowFunc = now
+	return &new
+}
+
+// tableOptions returns the table options clause of the CREATE TABLE
+// statement. Option values must be plain identifiers.
+func (m MySQLHistoryManager) tableOptions() (string, error) {
+	var opts []string
+	for _, opt := range []struct{ keyword, value string }{
+		{"ENGINE", m.Engine},
+		{"DEFAULT CHARSET", m.Charset},
+		{"COLLATE", m.Collation},
+	} {
+		if opt.value == "" {
+			continue
+		}
+		if !isPlainIdentifier(opt.value) {
+			return "", fmt.Errorf(
+				"invalid history table option %s=%q", opt.keyword, opt.value,
+			)
+		}
+		opts = append(opts, opt.keyword+"="+opt.value)
+	}
+	if len(opts) == 0 {
+		return "", nil
+	}
+	return " " + strings.Join(opts, " "), nil
+}
+
+// EnsureHistoryTable creates the history table in MySQL with the configured
+// table options and adds columns introduced by newer versions to existing
+// tables.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//
+// Returns:
+//   - error: An error if the table creation fails.
+func (m MySQLHistoryManager) EnsureHistoryTable(
+	ctx context.Context, db *sql.DB, tableName string,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return err
+	}
+	options, err := m.tableOptions()
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+		version VARCHAR(50) NOT NULL,
+		name VARCHAR(255),
+		migration_name VARCHAR(255) NOT NULL DEFAULT '',
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		description TEXT,
+		applied_by TEXT,
+		applied_user TEXT,
+		applied_host TEXT,
+		ticket TEXT,
+		duration_ms BIGINT,
+		attempts INTEGER,
+		search_path TEXT,
+		checksum TEXT,
+		PRIMARY KEY (migration_name, version))%s`,
+		tableName,
+		options,
+	)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return ensureHistoryColumns(ctx, db, tableName, historyColumns)
+}
+
+// HistoryKey returns the primary key columns of the history table in
+// MySQL, in key order, or none if the table does not exist.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//
+// Returns:
+//   - []string: The primary key columns.
+//   - error: An error if the key cannot be queried.
+func (m MySQLHistoryManager) HistoryKey(
+	ctx context.Context, db *sql.DB, tableName string,
+) ([]string, error) {
+	parts, err := splitQualifiedName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	var schema any
+	if len(parts) > 1 {
+		schema = parts[len(parts)-2]
+	}
+	return queryColumn(
+		ctx, db,
+		`SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE `+
+			`WHERE CONSTRAINT_NAME = 'PRIMARY' `+
+			`AND TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ? `+
+			`ORDER BY ORDINAL_POSITION`,
+		schema, parts[len(parts)-1],
+	)
+}
+
+// EnsureSchema creates the given schema in MySQL if it does not exist.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - schema: The name of the schema.
+//
+// Returns:
+//   - error: An error if the schema creation fails.
+func (m MySQLHistoryManager) EnsureSchema(
+	ctx context.Context, db *sql.DB, schema string,
+) error {
+	_, err := db.ExecContext(
+		ctx, "CREATE SCHEMA IF NOT EXISTS "+quoteIdentifier(schema, '`'),
+	)
+	return err
+}
+
+// ServerVersion returns "mysql" or "mariadb" and the server version.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//
+// Returns:
+//   - string: The server name.
+//   - string: The server version.
+//   - error: An error if the version cannot be queried.
+func (m MySQLHistoryManager) ServerVersion(
+	ctx context.Context, db *sql.DB,
+) (string, string, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", "", err
+	}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return "mariadb", version, nil
+	}
+	return "mysql", version, nil
+}
+
+// mysqlGrantsQuery selects the global, schema and table privileges granted
+// to a grantee.
+const mysqlGrantsQuery = `SELECT PRIVILEGE_TYPE, '', '' ` +
+	`FROM information_schema.USER_PRIVILEGES WHERE GRANTEE = ? ` +
+	`UNION ALL SELECT PRIVILEGE_TYPE, TABLE_SCHEMA, '' ` +
+	`FROM information_schema.SCHEMA_PRIVILEGES WHERE GRANTEE = ? ` +
+	`UNION ALL SELECT PRIVILEGE_TYPE, TABLE_SCHEMA, TABLE_NAME ` +
+	`FROM information_schema.TABLE_PRIVILEGES WHERE GRANTEE = ?`
+
+// MissingPrivileges returns the required privileges that the current MySQL
+// user has not been granted directly. Privileges granted through roles are
+// not considered. Database privileges are checked on the current database
+// and table privileges on the table's schema, defaulting to the current
+// database.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - required: The privileges to check.
+//
+// Returns:
+//   - []Privilege: The missing privileges.
+//   - error: An error if the grants cannot be read.
+func (m MySQLHistoryManager) MissingPrivileges(
+	ctx context.Context, db *sql.DB, required []Privilege,
+) ([]Privilege, error) {
+	var user string
+	var database sql.NullString
+	if err := db.QueryRowContext(
+		ctx, "SELECT CURRENT_USER(), DATABASE()",
+	).Scan(&user, &database); err != nil {
+		return nil, err
+	}
+	name, host, _ := strings.Cut(user, "@")
+	grantee := fmt.Sprintf("'%s'@'%s'", name, host)
+	rows, err := db.QueryContext(ctx, mysqlGrantsQuery, grantee, grantee, grantee)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type grant struct{ privilege, schema, table string }
+	var grants []grant
+	for rows.Next() {
+		var g grant
+		if err := rows.Scan(&g.privilege, &g.schema, &g.table); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []Privilege
+	for _, req := range required {
+		schema, table := database.String, ""
+		if req.Table != "" {
+			parts, err := splitQualifiedName(req.Table)
+			if err != nil {
+				return nil, err
+			}
+			table = parts[len(parts)-1]
+			if len(parts) > 1 {
+				schema = parts[len(parts)-2]
+			}
+		}
+		granted := slices.ContainsFunc(grants, func(g grant) bool {
+			return strings.EqualFold(g.privilege, req.Name) &&
+				(g.schema == "" ||
+					g.schema == schema && (g.table == "" || g.table == table))
+		})
+		if !granted {
+			missing = append(missing, req)
+		}
+	}
+	return missing, nil
+}
+
+// RecordMigration inserts an applied migration record in MySQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - mig: The migration to record.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (m MySQLHistoryManager) RecordMigration(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	mig Migration,
+	migrationName string,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx,
+		exec,
+		tableName,
+		newHistoryEntries([]Migration{mig}, migrationName, nowUTC(m.NowFunc)),
+		questionPlaceholder,
+	)
+}
+
+// RecordMigrations inserts applied migration records in MySQL using
+// multi-row INSERT statements.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - migs: The migrations to record.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (m MySQLHistoryManager) RecordMigrations(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	migs []Migration,
+	migrationName string,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx,
+		exec,
+		tableName,
+		newHistoryEntries(migs, migrationName, nowUTC(m.NowFunc)),
+		questionPlaceholder,
+	)
+}
+
+// RecordEntries inserts applied migration records including run metadata in
+// MySQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - entries: The entries to record.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (m MySQLHistoryManager) RecordEntries(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	entries []HistoryEntry,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx, exec, tableName, entries, questionPlaceholder,
+	)
+}
+
+// AppliedEntries retrieves the history entries of applied migrations from
+// MySQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - []HistoryEntry: The recorded entries.
+//   - error: An error if the query fails.
+func (m MySQLHistoryManager) AppliedEntries(
+	ctx context.Context, db *sql.DB, tableName string, migrationName string,
+) ([]HistoryEntry, error) {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return nil, err
+	}
+	return selectHistoryEntries(
+		ctx, db, tableName, migrationName, questionPlaceholder,
+	)
+}
+
+// RemoveMigration deletes the migration record in MySQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - mig: The migration to remove.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - error: An error if the record deletion fails.
+func (m MySQLHistoryManager) RemoveMigration(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	mig Migration,
+	migrationName string,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(
+		`DELETE FROM %s WHERE version = ? AND migration_name = ?`,
+		tableName,
+	)
+	_, err = exec.ExecContext(ctx, query, mig.Version, migrationName)
+	return err
+}
+
+// AppliedMigrations retrieves applied migrations from MySQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - map[string]bool: A map of applied migrations.
+//   - error: An error if the query fails.
+func (m MySQLHistoryManager) AppliedMigrations(
+	ctx context.Context, db *sql.DB, tableName string, migrationName string,
+) (map[string]bool, error) {
+	tableName, err := quoteQualifiedName(tableName, '`')
+	if err != nil {
+		return nil, err
+	}
+	migs := make(map[string]bool)
+	query := fmt.Sprintf(
+		`SELECT version FROM %s WHERE migration_name = ?`, tableName,
+	)
+	rows, err := db.QueryContext(ctx, query, migrationName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ver string
+		if err := rows.Scan(&ver); err != nil {
+			return nil, err
+		}
+		migs[ver] = true
+	}
+	return migs, nil
+}
+
+// SQLiteHistoryManager implements HistoryManager for SQLite.
+type SQLiteHistoryManager struct {
+	// NowFunc returns the applied_at time of records written through
+	// RecordMigration and RecordMigrations. It defaults to time.Now.
+	NowFunc func() time.Time
+}
+
+// NewSQLiteHistoryManager returns a new SQLiteHistoryManager.
+//
+// Returns:
+//   - *SQLiteHistoryManager: A new SQLiteHistoryManager instance.
+func NewSQLiteHistoryManager() *SQLiteHistoryManager {
+	return &SQLiteHistoryManager{}
+}
+
+// WithNowFunc returns a new SQLiteHistoryManager that takes applied_at
+// times from now.
+//
+// Parameters:
+//   - now: The clock to use.
+//
+// Returns:
+//   - *SQLiteHistoryManager: A new SQLiteHistoryManager instance.
+func (s *SQLiteHistoryManager) WithNowFunc(
+	now func() time.Time,
+) *SQLiteHistoryManager {
+	new := *s
+	new.NowFunc = now
+	return &new
+}
+
+// EnsureHistoryTable creates the history table in SQLite and adds columns
+// introduced by newer versions to existing tables.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//
+// Returns:
+//   - error: An error if the table creation fails.
+func (s SQLiteHistoryManager) EnsureHistoryTable(
+	ctx context.Context, db *sql.DB, tableName string,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+		version TEXT NOT NULL,
+		name TEXT,
+		migration_name TEXT NOT NULL DEFAULT '',
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		description TEXT,
+		applied_by TEXT,
+		applied_user TEXT,
+		applied_host TEXT,
+		ticket TEXT,
+		duration_ms BIGINT,
+		attempts INTEGER,
+		search_path TEXT,
+		checksum TEXT,
+		PRIMARY KEY (migration_name, version))`,
+		tableName,
+	)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return ensureHistoryColumns(ctx, db, tableName, historyColumns)
+}
 
-// NewMySQLHistoryManager returns a new MySQLHistoryManager.
+// HistoryKey returns the primary key columns of the history table in
+// SQLite, in key order, or none if the table does not exist.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
 //
 // Returns:
-//   - *MySQLHistoryManager: A new MySQLHistoryManager instance.
-func NewMySQLHistoryManager() *MySQLHistoryManager {
-	return &MySQLHistoryManager{}
+//   - []string: The primary key columns.
+//   - error: An error if the key cannot be queried.
+func (s SQLiteHistoryManager) HistoryKey(
+	ctx context.Context, db *sql.DB, tableName string,
+) ([]string, error) {
+	parts, err := splitQualifiedName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	schema := "main"
+	if len(parts) > 1 {
+		schema = parts[len(parts)-2]
+	}
+	return queryColumn(
+		ctx, db,
+		`SELECT name FROM pragma_table_info(?, ?) WHERE pk > 0 ORDER BY pk`,
+		parts[len(parts)-1], schema,
+	)
+}
+
+// EnsureSchema checks that the given schema is available in SQLite. SQLite
+// schemas are attached databases, which cannot be created by a statement, so
+// a missing schema must be attached before migrating.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - schema: The name of the schema.
+//
+// Returns:
+//   - error: An error if the schema is not attached.
+func (s SQLiteHistoryManager) EnsureSchema(
+	ctx context.Context, db *sql.DB, schema string,
+) error {
+	var name string
+	err := db.QueryRowContext(
+		ctx, `SELECT name FROM pragma_database_list WHERE name = ?`, schema,
+	).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf(
+			"sqlite schema %q is not attached; ATTACH it before migrating",
+			schema,
+		)
+	}
+	return err
+}
+
+// ServerVersion returns "sqlite" and the SQLite library version.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//
+// Returns:
+//   - string: The server name.
+//   - string: The library version.
+//   - error: An error if the version cannot be queried.
+func (s SQLiteHistoryManager) ServerVersion(
+	ctx context.Context, db *sql.DB,
+) (string, string, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version)
+	if err != nil {
+		return "", "", err
+	}
+	return "sqlite", version, nil
 }
 
-// EnsureHistoryTable creates the history table in MySQL.
+// MissingPrivileges returns no privileges since SQLite has no privilege
+// system; access is governed by file permissions.
 //
 // Parameters:
 //   - ctx: Context to use.
 //   - db: The database connection.
+//   - required: The privileges to check.
+//
+// Returns:
+//   - []Privilege: Always nil.
+//   - error: Always nil.
+func (s SQLiteHistoryManager) MissingPrivileges(
+	ctx context.Context, db *sql.DB, required []Privilege,
+) ([]Privilege, error) {
+	return nil, nil
+}
+
+// RecordMigration inserts an applied migration record in SQLite.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
 //   - tableName: The name of the history table.
+//   - mig: The migration to record.
 //
 // Returns:
-//   - error: An error if the table creation fails.
-func (m MySQLHistoryManager) EnsureHistoryTable(
-	ctx context.Context, db *sql.DB, tableName string,
+//   - error: An error if the record insertion fails.
+func (s SQLiteHistoryManager) RecordMigration(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	mig Migration,
+	migrationName string,
 ) error {
-	query := fmt.Sprintf(
-		`CREATE TABLE IF NOT EXISTS %s (
-		version VARCHAR(50) PRIMARY KEY,
-		name VARCHAR(255),
-		migration_name VARCHAR(255),
-		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx,
+		exec,
 		tableName,
+		newHistoryEntries([]Migration{mig}, migrationName, nowUTC(s.NowFunc)),
+		questionPlaceholder,
 	)
-	_, err := db.ExecContext(ctx, query)
-	return err
 }
 
-// RecordMigration inserts an applied migration record in MySQL.
+// RecordMigrations inserts applied migration records in SQLite using
+// multi-row INSERT statements.
 //
 // Parameters:
 //   - ctx: Context to use.
 //   - exec: The executor to use.
 //   - tableName: The name of the history table.
-//   - mig: The migration to record.
+//   - migs: The migrations to record.
 //   - migrationName: The name of the migration.
 //
 // Returns:
 //   - error: An error if the record insertion fails.
-func (m MySQLHistoryManager) RecordMigration(
+func (s SQLiteHistoryManager) RecordMigrations(
 	ctx context.Context,
 	exec Executor,
 	tableName string,
-	mig Migration,
+	migs []Migration,
 	migrationName string,
 ) error {
-	query := fmt.Sprintf(
-		`INSERT INTO %s (version, name, migration_name, applied_at) VALUES (?, ?, ?, ?)`,
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx,
+		exec,
 		tableName,
+		newHistoryEntries(migs, migrationName, nowUTC(s.NowFunc)),
+		questionPlaceholder,
 	)
-	_, err := exec.ExecContext(
-		ctx, query, mig.Version, mig.Name, migrationName, time.Now().UTC(),
+}
+
+// RecordEntries inserts applied migration records including run metadata in
+// SQLite.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - entries: The entries to record.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (s SQLiteHistoryManager) RecordEntries(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	entries []HistoryEntry,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(
+		ctx, exec, tableName, entries, questionPlaceholder,
 	)
-	return err
 }
 
-// RemoveMigration deletes the migration record in MySQL.
+// AppliedEntries retrieves the history entries of applied migrations from
+// SQLite.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - []HistoryEntry: The recorded entries.
+//   - error: An error if the query fails.
+func (s SQLiteHistoryManager) AppliedEntries(
+	ctx context.Context, db *sql.DB, tableName string, migrationName string,
+) ([]HistoryEntry, error) {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return nil, err
+	}
+	return selectHistoryEntries(
+		ctx, db, tableName, migrationName, questionPlaceholder,
+	)
+}
+
+// RemoveMigration deletes the migration record in SQLite.
 //
 // Parameters:
 //   - ctx: Context to use.
@@ -107,22 +1187,26 @@ func (m MySQLHistoryManager) RecordMigration(
 //
 // Returns:
 //   - error: An error if the record deletion fails.
-func (m MySQLHistoryManager) RemoveMigration(
+func (s SQLiteHistoryManager) RemoveMigration(
 	ctx context.Context,
 	exec Executor,
 	tableName string,
 	mig Migration,
 	migrationName string,
 ) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
 	query := fmt.Sprintf(
 		`DELETE FROM %s WHERE version = ? AND migration_name = ?`,
 		tableName,
 	)
-	_, err := exec.ExecContext(ctx, query, mig.Version, migrationName)
+	_, err = exec.ExecContext(ctx, query, mig.Version, migrationName)
 	return err
 }
 
-// AppliedMigrations retrieves applied migrations from MySQL.
+// AppliedMigrations retrieves applied migrations from SQLite.
 //
 // Parameters:
 //   - ctx: Context to use.
@@ -133,12 +1217,17 @@ func (m MySQLHistoryManager) RemoveMigration(
 // Returns:
 //   - map[string]bool: A map of applied migrations.
 //   - error: An error if the query fails.
-func (m MySQLHistoryManager) AppliedMigrations(
+func (s SQLiteHistoryManager) AppliedMigrations(
 	ctx context.Context, db *sql.DB, tableName string, migrationName string,
 ) (map[string]bool, error) {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return nil, err
+	}
 	migs := make(map[string]bool)
 	query := fmt.Sprintf(
-		`SELECT version FROM %s AND migration_name = ?`, tableName,
+		`SELECT version FROM %s WHERE migration_name = ?`,
+		tableName,
 	)
 	rows, err := db.QueryContext(ctx, query, migrationName)
 	if err != nil {
@@ -155,18 +1244,50 @@ func (m MySQLHistoryManager) AppliedMigrations(
 	return migs, nil
 }
 
-// SQLiteHistoryManager implements HistoryManager for SQLite.
-type SQLiteHistoryManager struct{}
+// PostgresHistoryManager implements HistoryManager for Postgres.
+type PostgresHistoryManager struct {
+	// NowFunc returns the applied_at time of records written through
+	// RecordMigration and RecordMigrations. It defaults to time.Now.
+	NowFunc func() time.Time
+}
 
-// NewSQLiteHistoryManager returns a new SQLiteHistoryManager.
+// NewPostgresHistoryManager returns a new PostgresHistoryManager.
 //
 // Returns:
-//   - *SQLiteHistoryManager: A new SQLiteHistoryManager instance.
-func NewSQLiteHistoryManager() *SQLiteHistoryManager {
-	return &SQLiteHistoryManager{}
+//   - *PostgresHistoryManager: A new PostgresHistoryManager instance.
+func NewPostgresHistoryManager() *PostgresHistoryManager {
+	return &PostgresHistoryManager{}
+}
+
+// WithNowFunc returns a new PostgresHistoryManager that takes applied_at
+// times from now.
+//
+// Parameters:
+//   - now: The clock to use.
+//
+// Returns:
+//   - *PostgresHistoryManager: A new PostgresHistoryManager instance.
+func (p *PostgresHistoryManager) WithNowFunc(
+	now func() time.Time,
+) *PostgresHistoryManager {
+	new := *p
+	new.NowFunc = now
+	return &new
+}
+
+// Placeholder returns the "$n" bind variable of the n-th argument.
+//
+// Parameters:
+//   - n: The argument position, counting from 1.
+//
+// Returns:
+//   - string: The bind variable.
+func (p PostgresHistoryManager) Placeholder(n int) string {
+	return dollarPlaceholder(n)
 }
 
-// EnsureHistoryTable creates the history table in SQLite.
+// EnsureHistoryTable creates the history table in Postgres and adds columns
+// introduced by newer versions to existing tables.
 //
 // Parameters:
 //   - ctx: Context to use.
@@ -175,49 +1296,250 @@ func NewSQLiteHistoryManager() *SQLiteHistoryManager {
 //
 // Returns:
 //   - error: An error if the table creation fails.
-func (s SQLiteHistoryManager) EnsureHistoryTable(
+func (p PostgresHistoryManager) EnsureHistoryTable(
 	ctx context.Context, db *sql.DB, tableName string,
 ) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
 	query := fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS %s (
-		version TEXT PRIMARY KEY,
-		name TEXT,
-		migration_name TEXT,
-		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		version VARCHAR(255) NOT NULL,
+		name VARCHAR(255),
+		migration_name VARCHAR(255) NOT NULL DEFAULT '',
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		description TEXT,
+		applied_by TEXT,
+		applied_user TEXT,
+		applied_host TEXT,
+		ticket TEXT,
+		duration_ms BIGINT,
+		attempts INTEGER,
+		search_path TEXT,
+		checksum TEXT,
+		PRIMARY KEY (migration_name, version))`,
 		tableName,
 	)
-	_, err := db.ExecContext(ctx, query)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return ensureHistoryColumns(ctx, db, tableName, historyColumns)
+}
+
+// HistoryKey returns the primary key columns of the history table in
+// Postgres, in key order, or none if the table does not exist.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//
+// Returns:
+//   - []string: The primary key columns.
+//   - error: An error if the key cannot be queried.
+func (p PostgresHistoryManager) HistoryKey(
+	ctx context.Context, db *sql.DB, tableName string,
+) ([]string, error) {
+	table, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return nil, err
+	}
+	return queryColumn(
+		ctx, db,
+		`SELECT a.attname FROM pg_index i `+
+			`JOIN pg_attribute a ON a.attrelid = i.indrelid `+
+			`AND a.attnum = ANY(i.indkey) `+
+			`WHERE i.indisprimary AND i.indrelid = to_regclass($1::text) `+
+			`ORDER BY array_position(i.indkey::int2[], a.attnum)`,
+		table,
+	)
+}
+
+// EnsureSchema creates the given schema in Postgres if it does not exist.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - schema: The name of the schema.
+//
+// Returns:
+//   - error: An error if the schema creation fails.
+func (p PostgresHistoryManager) EnsureSchema(
+	ctx context.Context, db *sql.DB, schema string,
+) error {
+	_, err := db.ExecContext(
+		ctx, "CREATE SCHEMA IF NOT EXISTS "+quoteIdentifier(schema, '"'),
+	)
 	return err
 }
 
-// RecordMigration inserts an applied migration record in SQLite.
+// ServerVersion returns "postgres" and the server version.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//
+// Returns:
+//   - string: The server name.
+//   - string: The server version.
+//   - error: An error if the version cannot be queried.
+func (p PostgresHistoryManager) ServerVersion(
+	ctx context.Context, db *sql.DB,
+) (string, string, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", "", err
+	}
+	return "postgres", version, nil
+}
+
+// MissingPrivileges returns the required privileges that the current
+// Postgres role lacks. CREATE is checked on the current schema and table
+// privileges on the table, or CREATE on its schema if the table does not
+// exist yet. Other database-wide privileges depend on the tables a statement
+// touches or on ownership in Postgres and are not checked.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - required: The privileges to check.
+//
+// Returns:
+//   - []Privilege: The missing privileges.
+//   - error: An error if the privileges cannot be queried.
+func (p PostgresHistoryManager) MissingPrivileges(
+	ctx context.Context, db *sql.DB, required []Privilege,
+) ([]Privilege, error) {
+	var missing []Privilege
+	for _, req := range required {
+		var query string
+		var args []any
+		switch {
+		case req.Table != "":
+			table, err := quoteQualifiedName(req.Table, '"')
+			if err != nil {
+				return nil, err
+			}
+			query = `SELECT CASE WHEN to_regclass($1::text) IS NULL ` +
+				`THEN has_schema_privilege(current_schema(), 'CREATE') ` +
+				`ELSE has_table_privilege($1::text, $2::text) END`
+			args = []any{table, req.Name}
+		case req.Name == "CREATE":
+			query = `SELECT has_schema_privilege(current_schema(), 'CREATE')`
+		default:
+			continue
+		}
+		var granted bool
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&granted); err != nil {
+			return nil, err
+		}
+		if !granted {
+			missing = append(missing, req)
+		}
+	}
+	return missing, nil
+}
+
+// RecordMigration inserts an applied migration record in Postgres.
 //
 // Parameters:
 //   - ctx: Context to use.
 //   - exec: The executor to use.
 //   - tableName: The name of the history table.
 //   - mig: The migration to record.
+//   - migrationName: The name of the migration.
 //
 // Returns:
 //   - error: An error if the record insertion fails.
-func (s SQLiteHistoryManager) RecordMigration(
+func (p PostgresHistoryManager) RecordMigration(
 	ctx context.Context,
 	exec Executor,
 	tableName string,
 	mig Migration,
 	migrationName string,
 ) error {
-	query := fmt.Sprintf(
-		`INSERT INTO %s (version, name, migration_name, applied_at) VALUES (?, ?, ?, ?)`,
+	return p.RecordMigrations(
+		ctx, exec, tableName, []Migration{mig}, migrationName,
+	)
+}
+
+// RecordMigrations inserts applied migration records in Postgres using
+// multi-row INSERT statements.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - migs: The migrations to record.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (p PostgresHistoryManager) RecordMigrations(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	migs []Migration,
+	migrationName string,
+) error {
+	return p.RecordEntries(
+		ctx,
+		exec,
 		tableName,
+		newHistoryEntries(migs, migrationName, nowUTC(p.NowFunc)),
 	)
-	_, err := exec.ExecContext(
-		ctx, query, mig.Version, mig.Name, migrationName, time.Now().UTC(),
+}
+
+// RecordEntries inserts applied migration records including run metadata in
+// Postgres.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor to use.
+//   - tableName: The name of the history table.
+//   - entries: The entries to record.
+//
+// Returns:
+//   - error: An error if the record insertion fails.
+func (p PostgresHistoryManager) RecordEntries(
+	ctx context.Context,
+	exec Executor,
+	tableName string,
+	entries []HistoryEntry,
+) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
+	return insertHistoryRows(ctx, exec, tableName, entries, dollarPlaceholder)
+}
+
+// AppliedEntries retrieves the history entries of applied migrations from
+// Postgres.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - db: The database connection.
+//   - tableName: The name of the history table.
+//   - migrationName: The name of the migration.
+//
+// Returns:
+//   - []HistoryEntry: The recorded entries.
+//   - error: An error if the query fails.
+func (p PostgresHistoryManager) AppliedEntries(
+	ctx context.Context, db *sql.DB, tableName string, migrationName string,
+) ([]HistoryEntry, error) {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return nil, err
+	}
+	return selectHistoryEntries(
+		ctx, db, tableName, migrationName, dollarPlaceholder,
 	)
-	return err
 }
 
-// RemoveMigration deletes the migration record in SQLite.
+// RemoveMigration deletes the migration record in Postgres.
 //
 // Parameters:
 //   - ctx: Context to use.
@@ -228,22 +1550,26 @@ func (s SQLiteHistoryManager) RecordMigration(
 //
 // Returns:
 //   - error: An error if the record deletion fails.
-func (s SQLiteHistoryManager) RemoveMigration(
+func (p PostgresHistoryManager) RemoveMigration(
 	ctx context.Context,
 	exec Executor,
 	tableName string,
 	mig Migration,
 	migrationName string,
 ) error {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return err
+	}
 	query := fmt.Sprintf(
-		`DELETE FROM %s WHERE version = ? AND migration_name = ?`,
+		`DELETE FROM %s WHERE version = $1 AND migration_name = $2`,
 		tableName,
 	)
-	_, err := exec.ExecContext(ctx, query, mig.Version, migrationName)
+	_, err = exec.ExecContext(ctx, query, mig.Version, migrationName)
 	return err
 }
 
-// AppliedMigrations retrieves applied migrations from SQLite.
+// AppliedMigrations retrieves applied migrations from Postgres.
 //
 // Parameters:
 //   - ctx: Context to use.
@@ -254,12 +1580,16 @@ func (s SQLiteHistoryManager) RemoveMigration(
 // Returns:
 //   - map[string]bool: A map of applied migrations.
 //   - error: An error if the query fails.
-func (s SQLiteHistoryManager) AppliedMigrations(
+func (p PostgresHistoryManager) AppliedMigrations(
 	ctx context.Context, db *sql.DB, tableName string, migrationName string,
 ) (map[string]bool, error) {
+	tableName, err := quoteQualifiedName(tableName, '"')
+	if err != nil {
+		return nil, err
+	}
 	migs := make(map[string]bool)
 	query := fmt.Sprintf(
-		`SELECT version FROM %s WHERE migration_name = ?`,
+		`SELECT version FROM %s WHERE migration_name = $1`,
 		tableName,
 	)
 	rows, err := db.QueryContext(ctx, query, migrationName)
@@ -274,5 +1604,5 @@ func (s SQLiteHistoryManager) AppliedMigrations(
 		}
 		migs[ver] = true
 	}
-	return migs, nil
+	return migs, rows.Err()
 }
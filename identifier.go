@@ -0,0 +1,175 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SchemaCreator is implemented by history managers that can create the
+// schema of a schema-qualified history table.
+type SchemaCreator interface {
+	// EnsureSchema creates the schema if it does not exist.
+	EnsureSchema(ctx context.Context, db *sql.DB, schema string) error
+}
+
+// splitQualifiedName splits a possibly schema-qualified name such as
+// "ops.schema_migrations" into its unquoted parts. Parts may already be
+// quoted with double quotes or backticks, in which case dots inside them do
+// not separate parts and doubled quote characters are unescaped.
+func splitQualifiedName(name string) ([]string, error) {
+	var (
+		parts []string
+		part  strings.Builder
+		quote rune
+	)
+	runes := []rune(name)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			if i+1 < len(runes) && runes[i+1] == quote {
+				part.WriteRune(r)
+				i++
+				continue
+			}
+			quote = 0
+		case quote != 0:
+			part.WriteRune(r)
+		case r == '"' || r == '`':
+			quote = r
+		case r == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in name %q", name)
+	}
+	parts = append(parts, part.String())
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("empty part in name %q", name)
+		}
+	}
+	return parts, nil
+}
+
+// quoteQualifiedName returns name with every part that is not a plain
+// identifier quoted with quote, so that schema-qualified names and names
+// with special characters can be interpolated into statements safely. Plain
+// identifiers are left unquoted to keep their case folding unchanged.
+func quoteQualifiedName(name string, quote rune) (string, error) {
+	parts, err := splitQualifiedName(name)
+	if err != nil {
+		return "", err
+	}
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part, quote)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// quoteIdentifier returns the unqualified identifier quoted with quote
+// unless it is a plain identifier.
+func quoteIdentifier(ident string, quote rune) string {
+	if isPlainIdentifier(ident) {
+		return ident
+	}
+	q := string(quote)
+	return q + strings.ReplaceAll(ident, q, q+q) + q
+}
+
+// historyQuote returns the identifier quote character of the dialect of
+// the history manager: a backtick for MySQL, a double quote otherwise.
+func (m *Migrator) historyQuote() rune {
+	if historyDialect(m.HistoryManager) == DialectMySQL {
+		return '`'
+	}
+	return '"'
+}
+
+// quotedTable returns name quoted with historyQuote for interpolation into
+// statements. An invalid name is returned as is and fails in the database.
+func (m *Migrator) quotedTable(name string) string {
+	quoted, err := quoteQualifiedName(name, m.historyQuote())
+	if err != nil {
+		return name
+	}
+	return quoted
+}
+
+// derivedTable returns the name of a table kept next to the history table,
+// the history table name with suffix appended to its last part, quoted with
+// historyQuote. For "ops.\"Schema Migrations\"" and "_lock" it returns
+// "ops.\"Schema Migrations_lock\"".
+func (m *Migrator) derivedTable(suffix string) string {
+	parts, err := splitQualifiedName(m.HistoryTable)
+	if err != nil {
+		return m.HistoryTable + suffix
+	}
+	parts[len(parts)-1] += suffix
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part, m.historyQuote())
+	}
+	return strings.Join(parts, ".")
+}
+
+// isPlainIdentifier reports whether s can be used unquoted as an identifier.
+func isPlainIdentifier(s string) bool {
+	for i, r := range s {
+		if !isIdentRune(r) || (i == 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// WithCreateSchema returns a new Migrator that creates the schema of a
+// schema-qualified history table, such as "ops" in "ops.schema_migrations",
+// if it does not exist. The HistoryManager must implement SchemaCreator.
+//
+// Parameters:
+//   - enabled: Whether to create the history table schema.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithCreateSchema(enabled bool) *Migrator {
+	new := *m
+	new.CreateSchema = enabled
+	return &new
+}
+
+// ensureHistorySchema creates the schema of the history table if enabled and
+// the table name is schema-qualified.
+func (m *Migrator) ensureHistorySchema(ctx context.Context) error {
+	if !m.CreateSchema {
+		return nil
+	}
+	parts, err := splitQualifiedName(m.HistoryTable)
+	if err != nil {
+		return err
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	creator, ok := m.HistoryManager.(SchemaCreator)
+	if !ok {
+		return fmt.Errorf(
+			"history manager %T cannot create schemas: %w",
+			m.HistoryManager, errors.ErrUnsupported,
+		)
+	}
+	schema := parts[len(parts)-2]
+	if err := creator.EnsureSchema(ctx, m.DB, schema); err != nil {
+		m.logf("Error ensuring schema %s: %v", schema, err)
+		return err
+	}
+	m.logf("Schema %s ensured", schema)
+	return nil
+}
@@ -0,0 +1,31 @@
+package migrator
+
+import (
+	"os"
+	"os/user"
+)
+
+// applierIdentity identifies who applies migrations.
+type applierIdentity struct {
+	appliedBy string
+	user      string
+	host      string
+}
+
+// currentIdentity returns the identity of this process. The OS user falls
+// back to the USER and USERNAME environment variables when it cannot be
+// looked up.
+func currentIdentity(appliedBy string) applierIdentity {
+	identity := applierIdentity{appliedBy: appliedBy}
+	if u, err := user.Current(); err == nil {
+		identity.user = u.Username
+	} else if name := os.Getenv("USER"); name != "" {
+		identity.user = name
+	} else {
+		identity.user = os.Getenv("USERNAME")
+	}
+	if host, err := os.Hostname(); err == nil {
+		identity.host = host
+	}
+	return identity
+}
@@ -0,0 +1,139 @@
+package migrator
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AnnotationIsolation requests a transaction isolation level for a
+// migration, e.g. "-- isolation: serializable" for a data migration that
+// must not see concurrent writes.
+const AnnotationIsolation = "isolation"
+
+// ErrReadOnlyTransaction is returned by transactional runs whose TxOptions
+// request a read-only transaction, in which no migration could be applied.
+var ErrReadOnlyTransaction = errors.New("migration transaction is read-only")
+
+// isolationLevels are the isolation levels accepted in annotations.
+var isolationLevels = []sql.IsolationLevel{
+	sql.LevelDefault,
+	sql.LevelReadUncommitted,
+	sql.LevelReadCommitted,
+	sql.LevelWriteCommitted,
+	sql.LevelRepeatableRead,
+	sql.LevelSnapshot,
+	sql.LevelSerializable,
+	sql.LevelLinearizable,
+}
+
+// ParseIsolationLevel parses the name of an isolation level, such as
+// "serializable" or "repeatable read", in any case and with words separated
+// by spaces, hyphens or underscores.
+//
+// Parameters:
+//   - name: The name of the isolation level.
+//
+// Returns:
+//   - sql.IsolationLevel: The isolation level.
+//   - error: An error if name is not an isolation level.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	normalized := strings.Join(strings.FieldsFunc(
+		strings.ToLower(name),
+		func(r rune) bool { return r == ' ' || r == '-' || r == '_' },
+	), " ")
+	for _, level := range isolationLevels {
+		if strings.ToLower(level.String()) == normalized {
+			return level, nil
+		}
+	}
+	return sql.LevelDefault, fmt.Errorf("unknown isolation level %q", name)
+}
+
+// WithTxOptions returns a new Migrator beginning the transaction of
+// transactional runs with opts, e.g. sql.LevelSerializable for runs of data
+// migrations. Migrations annotated with "-- isolation: <level>" raise the
+// isolation level of the run they are part of, since a run applies all
+// its migrations in one transaction; the highest level requested is used.
+// opts must not be read-only.
+//
+// Parameters:
+//   - opts: The transaction options.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithTxOptions(opts sql.TxOptions) *Migrator {
+	new := *m
+	new.TxOptions = opts
+	return &new
+}
+
+// withRunIsolation returns the Migrator to run migs with: m itself, or a
+// copy whose TxOptions use the highest isolation level annotated on migs.
+func (m *Migrator) withRunIsolation(migs []Migration) (*Migrator, error) {
+	level := m.TxOptions.Isolation
+	for i := range migs {
+		mig := &migs[i]
+		if err := mig.resolveAnnotations(); err != nil {
+			return nil, err
+		}
+		name, ok := mig.Annotations[AnnotationIsolation]
+		if !ok {
+			continue
+		}
+		annotated, err := ParseIsolationLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"migration %s (%s): %w", mig.Version, mig.Name, err,
+			)
+		}
+		if m.TxPerMigration {
+			// The level applies to the migration's own transaction.
+			continue
+		}
+		if !m.Transactional {
+			m.logf(
+				"Ignoring isolation %s of migration %s in a non-transactional run",
+				annotated, mig.Version,
+			)
+			continue
+		}
+		level = max(level, annotated)
+	}
+	if level == m.TxOptions.Isolation {
+		return m, nil
+	}
+	m.logf("Running migrations with isolation level %s", level)
+	new := *m
+	new.TxOptions.Isolation = level
+	return &new, nil
+}
+
+// txOptions returns the options of the run transaction, nil for the
+// driver's defaults.
+func (m *Migrator) txOptions() (*sql.TxOptions, error) {
+	if m.TxOptions.ReadOnly {
+		return nil, ErrReadOnlyTransaction
+	}
+	if m.TxOptions == (sql.TxOptions{}) {
+		return nil, nil
+	}
+	opts := m.TxOptions
+	return &opts, nil
+}
+
+// rollbackCandidates returns the applied migrations of all that a rollback
+// to target may roll back.
+func (m *Migrator) rollbackCandidates(
+	all []Migration, applied appliedSet, target string,
+) []Migration {
+	var migs []Migration
+	for _, mig := range all {
+		if applied.has(mig) &&
+			(target == "" || m.compareVersions(mig.Version, target) >= 0) {
+			migs = append(migs, mig)
+		}
+	}
+	return migs
+}
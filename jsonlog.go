@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Log levels of JSONLogSink records.
+const (
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// JSONLogSink is an EventSink that writes run progress as JSON log lines
+// with stable field names, so migration logs can be queried in Loki or
+// Elasticsearch without custom parsing. Every line has "time", "level",
+// "msg" and "event", plus "version", "name", "direction", "step",
+// "duration_ms" and "error" where they apply, e.g.:
+//
+//	{"time":"...","level":"error","msg":"up step 2 of migration 003 failed",
+//	 "event":"step_failed","direction":"up","version":"003",
+//	 "name":"backfill","step":2,"duration_ms":15,"error":"..."}
+type JSONLogSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLogSink returns a new JSONLogSink writing to w.
+//
+// Parameters:
+//   - w: The writer to write log lines to, e.g. os.Stderr.
+//
+// Returns:
+//   - *JSONLogSink: A new JSONLogSink.
+func NewJSONLogSink(w io.Writer) *JSONLogSink {
+	return &JSONLogSink{enc: json.NewEncoder(w)}
+}
+
+// HandleEvent writes event as a JSON log line. Write errors are ignored
+// like those of the standard logger.
+//
+// Parameters:
+//   - event: The event to log.
+func (s *JSONLogSink) HandleEvent(event Event) {
+	record := newEventRecord(event)
+	record.Level, record.Msg = logLevel(event.Type), logMessage(event)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(record)
+}
+
+// logLevel returns the log level of events of type t.
+func logLevel(t EventType) string {
+	switch t {
+	case EventStepFailed, EventRunFailed:
+		return LogLevelError
+	case EventWarning:
+		return LogLevelWarn
+	default:
+		return LogLevelInfo
+	}
+}
+
+// logMessage returns the human-readable log message of event.
+func logMessage(event Event) string {
+	var version string
+	if event.Migration != nil {
+		version = event.Migration.Version
+	}
+	switch event.Type {
+	case EventRunStarted:
+		return fmt.Sprintf("migrate %s started", event.Direction)
+	case EventMigrationStarted:
+		return fmt.Sprintf("beginning migration %s", version)
+	case EventStepCompleted:
+		return fmt.Sprintf(
+			"%s step %d of migration %s completed",
+			event.Direction, event.Step, version,
+		)
+	case EventStepFailed:
+		return fmt.Sprintf(
+			"%s step %d of migration %s failed",
+			event.Direction, event.Step, version,
+		)
+	case EventStepProgress:
+		return fmt.Sprintf(
+			"%s step %d of migration %s: %s",
+			event.Direction, event.Step, version, event.Progress,
+		)
+	case EventLockWaiting:
+		return fmt.Sprintf("waiting for migration lock held by %s", event.Lock)
+	case EventMigrationApplied:
+		return fmt.Sprintf("migration %s applied", version)
+	case EventMigrationRolledBack:
+		return fmt.Sprintf("migration %s rolled back", version)
+	case EventWarning:
+		return event.Warning.Message
+	case EventRunCompleted:
+		return fmt.Sprintf("migrate %s complete", event.Direction)
+	case EventRunFailed:
+		return fmt.Sprintf("migrate %s failed", event.Direction)
+	default:
+		return string(event.Type)
+	}
+}
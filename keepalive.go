@@ -0,0 +1,81 @@
+package migrator
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrConnectionLost is returned when the connection used by a migration run
+// was closed by the server or by an intermediate proxy during the run.
+var ErrConnectionLost = errors.New("migration connection lost")
+
+// isConnectionLost reports whether err indicates a dropped connection: a
+// driver.ErrBadConn, a reset or broken pipe, or a failed read or write on an
+// established network connection. Dial errors and a bare io.EOF, which a
+// hook may return for reasons of its own, do not count.
+func isConnectionLost(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "read" || opErr.Op == "write")
+}
+
+// connectionLostError wraps err with ErrConnectionLost if it indicates a
+// dropped connection. Other errors are returned unchanged.
+func connectionLostError(err error) error {
+	if err == nil || errors.Is(err, ErrConnectionLost) ||
+		!isConnectionLost(err) {
+		return err
+	}
+	return fmt.Errorf(
+		"%w: the server or a proxy closed the connection during the run, "+
+			"possibly due to an idle timeout: %w",
+		ErrConnectionLost,
+		err,
+	)
+}
+
+// startKeepalive pings the database every interval on a connection other
+// than the one held by the run, until the returned stop function is called.
+// Ping failures are logged since the run itself reports the actual error.
+func (m *Migrator) startKeepalive(ctx context.Context) (stop func()) {
+	if m.KeepaliveInterval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.KeepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pingCtx, pingCancel := context.WithTimeout(
+					ctx, m.KeepaliveInterval,
+				)
+				err := m.DB.PingContext(pingCtx)
+				pingCancel()
+				if err != nil && ctx.Err() == nil {
+					m.logf("Keepalive ping failed: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
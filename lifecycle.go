@@ -0,0 +1,114 @@
+package migrator
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PoolConfig holds the connection pool settings of a database opened by
+// NewMigratorDSN. Zero fields keep the database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// DefaultPoolConfig is the pool of databases opened by NewMigratorDSN. A
+// run needs few connections: one for the steps and history, plus one each
+// for the lock, keepalive pings and post-commit work.
+var DefaultPoolConfig = PoolConfig{
+	MaxOpenConns:    4,
+	MaxIdleConns:    2,
+	ConnMaxIdleTime: time.Minute,
+}
+
+// apply sets the non-zero settings on db.
+func (c PoolConfig) apply(db *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// NewMigratorDSN returns a new Migrator owning a database it opens from a
+// driver name and DSN, with DefaultPoolConfig applied, for CLIs and
+// one-shot jobs that have no database of their own. The history manager
+// matching the driver is used. The database is closed by Close, which must
+// be called once the Migrator and the Migrators derived from it with With*
+// methods are no longer used. connInit hooks run on every connection the
+// database opens, see NewConnInitConnector.
+//
+// Parameters:
+//   - driverName: The name of a registered database/sql driver.
+//   - dsn: The data source name passed to the driver.
+//   - historyTable: The name of the table used to record applied
+//     migrations.
+//   - migrationName: The name of the migration.
+//   - connInit: Optional hooks initializing every connection.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+//   - error: An error if the database cannot be opened.
+func NewMigratorDSN(
+	driverName string,
+	dsn string,
+	historyTable string,
+	migrationName string,
+	connInit ...ConnInitFn,
+) (*Migrator, error) {
+	connector, err := openConnector(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", driverName, err)
+	}
+	if len(connInit) > 0 {
+		connector = NewConnInitConnector(connector, connInit...)
+	}
+	db := sql.OpenDB(connector)
+	DefaultPoolConfig.apply(db)
+	m := NewMigrator(db, historyTable, nil, migrationName)
+	m.ownsDB = true
+	return m, nil
+}
+
+// WithPoolConfig returns a new Migrator after applying pool to its
+// database. As the setting changes the shared *sql.DB, it is meant for
+// databases opened by NewMigratorDSN.
+//
+// Parameters:
+//   - pool: The pool settings.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithPoolConfig(pool PoolConfig) *Migrator {
+	new := *m
+	if new.DB != nil {
+		pool.apply(new.DB)
+	}
+	return &new
+}
+
+// Close closes the database if the Migrator opened it with NewMigratorDSN.
+// A database passed in by the caller is left open.
+//
+// Returns:
+//   - error: An error if closing the database fails.
+func (m *Migrator) Close() error {
+	if !m.ownsDB || m.DB == nil {
+		return nil
+	}
+	if err := m.DB.Close(); err != nil {
+		m.logf("Error closing database: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// lockTableSuffix is appended to the history table name to derive the
+// name of the table holding the run lock.
+const lockTableSuffix = "_lock"
+
+// defaultLockPollInterval is the pause between attempts to take a held
+// lock and between EventLockWaiting events.
+const defaultLockPollInterval = 5 * time.Second
+
+// ErrLockTimeout is returned when the run lock is not released within the
+// lock timeout.
+var ErrLockTimeout = errors.New("migration lock timeout")
+
+// LockHolder describes the runner holding the run lock.
+type LockHolder struct {
+	// Owner identifies the runner, by default "user@host:pid".
+	Owner string `json:"owner"`
+	// Since is when the runner took the lock.
+	Since time.Time `json:"since"`
+}
+
+// String returns the holder as shown in log messages.
+func (h LockHolder) String() string {
+	if h.Since.IsZero() {
+		return h.Owner
+	}
+	return fmt.Sprintf("%s since %s", h.Owner, h.Since.Format(time.RFC3339))
+}
+
+// LockHeldError is returned when the run lock is still held by another
+// runner after the lock timeout. It wraps ErrLockTimeout.
+type LockHeldError struct {
+	Holder LockHolder
+	// Waited is how long the run waited for the lock.
+	Waited time.Duration
+}
+
+// Error returns the holder and the time waited.
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf(
+		"%v: held by %s, gave up after %s",
+		ErrLockTimeout, e.Holder, e.Waited.Round(time.Millisecond),
+	)
+}
+
+// Unwrap returns ErrLockTimeout.
+func (e *LockHeldError) Unwrap() error {
+	return ErrLockTimeout
+}
+
+// WithLock returns a new Migrator that serializes runs through a lock row
+// in a table named after the history table suffixed with "_lock", or
+// through the Locker set with WithLocker. A run finding the lock held
+// reports the holder in EventLockWaiting events and log messages while it
+// waits, and fails with a *LockHeldError once timeout passes. A zero
+// timeout waits until the context is done. A lock row left behind by a
+// crashed runner must be removed by deleting the row.
+//
+// Parameters:
+//   - timeout: The longest wait for the lock.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithLock(timeout time.Duration) *Migrator {
+	new := *m
+	new.Lock = true
+	new.LockTimeout = timeout
+	return &new
+}
+
+// WithLockOwner returns a new Migrator identifying itself as owner in the
+// run lock instead of "user@host:pid".
+//
+// Parameters:
+//   - owner: The owner ID, e.g. a deployment or pod name.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithLockOwner(owner string) *Migrator {
+	new := *m
+	new.LockOwner = owner
+	return &new
+}
+
+// WithLockPollInterval returns a new Migrator retrying a held lock every
+// interval instead of every five seconds.
+//
+// Parameters:
+//   - interval: The pause between attempts.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithLockPollInterval(interval time.Duration) *Migrator {
+	new := *m
+	new.LockPollInterval = interval
+	return &new
+}
+
+// lockTable returns the name of the lock table.
+func (m *Migrator) lockTable() string {
+	return m.derivedTable(lockTableSuffix)
+}
+
+// lockOwner returns the owner ID written to the lock.
+func (m *Migrator) lockOwner() string {
+	if m.LockOwner != "" {
+		return m.LockOwner
+	}
+	identity := currentIdentity(m.AppliedBy)
+	return identity.user + "@" + identity.host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// locker returns the Locker of the run lock: the configured one, or the
+// lock table.
+func (m *Migrator) locker() Locker {
+	if m.Locker != nil {
+		return m.Locker
+	}
+	return &TableLocker{
+		Owner:       m.lockOwner(),
+		Placeholder: placeholderFunc(m.HistoryManager),
+		Now:         m.NowFunc,
+	}
+}
+
+// acquireLock takes the run lock, waiting while another runner holds it.
+// It returns the function releasing the lock.
+func (m *Migrator) acquireLock(
+	ctx context.Context, direction string,
+) (func(), error) {
+	if !m.Lock {
+		return func() {}, nil
+	}
+	ctx = m.withLogger(ctx)
+	locker := m.locker()
+	interval := m.LockPollInterval
+	if interval <= 0 {
+		interval = defaultLockPollInterval
+	}
+	start := time.Now()
+	for {
+		release, ok, err := locker.TryLock(ctx, m.DB, m.lockTable())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.logf("Acquired migration lock as %s", m.lockOwner())
+			return release, nil
+		}
+		holder, err := m.lockHolder(ctx, locker)
+		if err != nil {
+			return nil, err
+		}
+		waited := time.Since(start)
+		if m.LockTimeout > 0 && waited >= m.LockTimeout {
+			return nil, &LockHeldError{Holder: holder, Waited: waited}
+		}
+		m.logf("Waiting for migration lock held by %s", holder)
+		m.emit(Event{
+			Type:      EventLockWaiting,
+			Direction: direction,
+			Duration:  waited,
+			Lock:      &holder,
+		})
+		wait := interval
+		if m.LockTimeout > 0 {
+			wait = min(wait, m.LockTimeout-waited)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// lockHolder returns the holder of the run lock as reported by locker, or
+// an unknown holder if locker cannot report it.
+func (m *Migrator) lockHolder(
+	ctx context.Context, locker Locker,
+) (LockHolder, error) {
+	unknown := LockHolder{Owner: "another runner"}
+	reporter, ok := locker.(LockHolderReporter)
+	if !ok {
+		return unknown, nil
+	}
+	holder, held, err := reporter.LockHolder(ctx, m.DB, m.lockTable())
+	if err != nil || !held {
+		// The lock may have been released since.
+		return unknown, err
+	}
+	return holder, nil
+}
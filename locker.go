@@ -0,0 +1,217 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// mysqlMaxLockName is the longest lock name GET_LOCK accepts.
+const mysqlMaxLockName = 64
+
+// Locker takes the run lock that keeps several processes, such as
+// application instances migrating at startup, from running migrations at
+// the same time. The Migrator calls TryLock until it succeeds, emitting
+// EventLockWaiting events in between, for at most the lock timeout.
+type Locker interface {
+	// TryLock tries to take the lock named key without waiting. It reports
+	// whether the lock was taken and returns the function releasing it.
+	TryLock(
+		ctx context.Context, db *sql.DB, key string,
+	) (release func(), ok bool, err error)
+}
+
+// LockHolderReporter is implemented by Lockers that can tell who holds a
+// lock.
+type LockHolderReporter interface {
+	// LockHolder returns the holder of the lock named key and whether it
+	// is held.
+	LockHolder(
+		ctx context.Context, db *sql.DB, key string,
+	) (LockHolder, bool, error)
+}
+
+// WithLocker returns a new Migrator serializing runs through locker
+// instead of the lock table, e.g. PostgresAdvisoryLocker or MySQLLocker.
+// The lock is named after the history table suffixed with "_lock" and
+// waited for as configured with WithLock and WithLockPollInterval.
+//
+// Parameters:
+//   - locker: The locker to use.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithLocker(locker Locker) *Migrator {
+	new := *m
+	new.Lock = true
+	new.Locker = locker
+	return &new
+}
+
+// PostgresAdvisoryLocker takes session-level Postgres advisory locks, which
+// the server releases when the connection holding them closes, so that a
+// crashed runner never leaves a lock behind. The key is hashed to the
+// 64-bit lock ID.
+type PostgresAdvisoryLocker struct{}
+
+// TryLock takes the advisory lock of key with pg_try_advisory_lock on a
+// dedicated connection.
+func (PostgresAdvisoryLocker) TryLock(
+	ctx context.Context, db *sql.DB, key string,
+) (func(), bool, error) {
+	id := advisoryLockID(key)
+	return trySessionLock(
+		ctx, db,
+		"SELECT pg_try_advisory_lock($1)",
+		"SELECT pg_advisory_unlock($1)",
+		id,
+	)
+}
+
+// MySQLLocker takes MySQL named locks with GET_LOCK, which the server
+// releases when the connection holding them closes. Keys longer than 64
+// characters are replaced by their hash.
+type MySQLLocker struct{}
+
+// TryLock takes the named lock of key with GET_LOCK on a dedicated
+// connection.
+func (MySQLLocker) TryLock(
+	ctx context.Context, db *sql.DB, key string,
+) (func(), bool, error) {
+	return trySessionLock(
+		ctx, db,
+		"SELECT COALESCE(GET_LOCK(?, 0), 0) = 1",
+		"SELECT RELEASE_LOCK(?)",
+		mysqlLockName(key),
+	)
+}
+
+// TableLocker takes the lock by inserting the single row of a lock table,
+// which works on every database including SQLite. The key is the name of
+// the lock table, created if it does not exist. A lock left behind by a
+// crashed runner must be removed by deleting the row.
+type TableLocker struct {
+	// Owner identifies the runner in the lock row.
+	Owner string
+	// Placeholder formats bind variables, "?" if nil.
+	Placeholder func(int) string
+	// Now returns the time recorded in the lock row. Nil uses time.Now.
+	Now func() time.Time
+	// ensured is set once the lock table exists.
+	ensured bool
+}
+
+// TryLock inserts the lock row into the lock table key.
+func (l *TableLocker) TryLock(
+	ctx context.Context, db *sql.DB, key string,
+) (func(), bool, error) {
+	if !l.ensured {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER NOT NULL PRIMARY KEY,
+		owner VARCHAR(255) NOT NULL,
+		acquired_at TIMESTAMP NOT NULL
+	)`, key)); err != nil {
+			contextLogger(ctx).Printf(
+				"Error ensuring lock table %s: %v", key, err,
+			)
+			return nil, false, err
+		}
+		l.ensured = true
+	}
+	bind := l.Placeholder
+	if bind == nil {
+		bind = questionPlaceholder
+	}
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (id, owner, acquired_at) VALUES (1, %s)",
+		key, placeholderList(bind, 1, 2),
+	)
+	_, err := db.ExecContext(ctx, insert, l.Owner, nowUTC(l.Now))
+	if err != nil {
+		if _, held, holderErr := l.LockHolder(ctx, db, key); holderErr != nil {
+			return nil, false, holderErr
+		} else if !held {
+			// The insert failed for another reason than a held lock.
+			return nil, false, fmt.Errorf("acquire migration lock: %w", err)
+		}
+		return nil, false, nil
+	}
+	release := fmt.Sprintf(
+		"DELETE FROM %s WHERE id = 1 AND owner = %s", key, bind(1),
+	)
+	return func() {
+		// The run's context may be done; release the lock anyway.
+		ctx := context.WithoutCancel(ctx)
+		if _, err := db.ExecContext(ctx, release, l.Owner); err != nil {
+			contextLogger(ctx).Printf("Error releasing migration lock: %v", err)
+		}
+	}, true, nil
+}
+
+// LockHolder returns the holder recorded in the lock table key.
+func (l *TableLocker) LockHolder(
+	ctx context.Context, db *sql.DB, key string,
+) (LockHolder, bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT owner, acquired_at FROM %s WHERE id = 1", key,
+	))
+	if err != nil {
+		return LockHolder{}, false, fmt.Errorf("read migration lock: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return LockHolder{}, false, rows.Err()
+	}
+	var holder LockHolder
+	if err := rows.Scan(&holder.Owner, &holder.Since); err != nil {
+		return LockHolder{}, false, fmt.Errorf("read migration lock: %w", err)
+	}
+	return holder, true, nil
+}
+
+// trySessionLock runs the boolean lock query on a dedicated connection and
+// keeps the connection until the lock is released, since session locks
+// belong to the connection that took them.
+func trySessionLock(
+	ctx context.Context, db *sql.DB, lock string, unlock string, arg any,
+) (func(), bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("acquire migration lock: %w", err)
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, lock, arg).Scan(&ok); err != nil {
+		_ = conn.Close()
+		return nil, false, fmt.Errorf("acquire migration lock: %w", err)
+	}
+	if !ok {
+		_ = conn.Close()
+		return nil, false, nil
+	}
+	return func() {
+		defer conn.Close()
+		ctx := context.WithoutCancel(ctx)
+		if _, err := conn.ExecContext(ctx, unlock, arg); err != nil {
+			contextLogger(ctx).Printf("Error releasing migration lock: %v", err)
+		}
+	}, true, nil
+}
+
+// advisoryLockID returns the Postgres advisory lock ID of key.
+func advisoryLockID(key string) int64 {
+	sum := sha256.Sum256([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// mysqlLockName returns key, or its hash if it is too long for GET_LOCK.
+func mysqlLockName(key string) string {
+	if len(key) <= mysqlMaxLockName {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:mysqlMaxLockName/2])
+}
@@ -0,0 +1,111 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger receives the progress and error messages of the Migrator, its
+// steps and its sources. *log.Logger implements it.
+type Logger interface {
+	// Printf logs a message formatted as by fmt.Printf.
+	Printf(format string, v ...any)
+}
+
+// DiscardLogger is a Logger discarding every message.
+var DiscardLogger Logger = discardLogger{}
+
+// discardLogger implements DiscardLogger.
+type discardLogger struct{}
+
+// Printf discards the message.
+func (discardLogger) Printf(string, ...any) {}
+
+// defaultLogger is used when no Logger is configured: the standard logger
+// of the log package.
+var defaultLogger Logger = log.Default()
+
+// loggerKey is the context key of the Logger of a run.
+type loggerKey struct{}
+
+// slogLogger adapts a *slog.Logger to Logger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing messages to logger. Messages
+// reporting errors are logged at slog.LevelError, warnings at
+// slog.LevelWarn and all others at slog.LevelInfo.
+//
+// Parameters:
+//   - logger: The structured logger to write to.
+//
+// Returns:
+//   - Logger: The adapted logger.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+// Printf logs the message at the level matching its content.
+func (l slogLogger) Printf(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "Error"):
+		level = slog.LevelError
+	case strings.HasPrefix(msg, "Warning"):
+		level = slog.LevelWarn
+	}
+	l.logger.Log(context.Background(), level, msg)
+}
+
+// WithLogger returns a new Migrator writing its messages, and those of the
+// steps it runs, to logger instead of the standard logger. Use
+// DiscardLogger to silence it or NewSlogLogger for structured logging.
+// Sources log through their own WithLogger option.
+//
+// Parameters:
+//   - logger: The logger to use, nil for the standard logger.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithLogger(logger Logger) *Migrator {
+	new := *m
+	new.Logger = logger
+	return &new
+}
+
+// logger returns the Logger of the Migrator.
+func (m *Migrator) logger() Logger {
+	return loggerOrDefault(m.Logger)
+}
+
+// logf logs a message through the Logger of the Migrator.
+func (m *Migrator) logf(format string, v ...any) {
+	m.logger().Printf(format, v...)
+}
+
+// withLogger returns ctx carrying the Logger of the Migrator, used by steps,
+// lockers and policies.
+func (m *Migrator) withLogger(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loggerKey{}, m.logger())
+}
+
+// contextLogger returns the Logger carried by ctx, or the standard logger.
+func contextLogger(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return defaultLogger
+}
+
+// loggerOrDefault returns logger, or the standard logger if it is nil.
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return defaultLogger
+	}
+	return logger
+}
@@ -0,0 +1,304 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnnotationSafe marks a migration as safe to apply outside maintenance
+// windows, e.g. "-- safe: true" for an additive change.
+const AnnotationSafe = "safe"
+
+// maxWindowDuration bounds the duration of a maintenance window.
+const maxWindowDuration = 31 * 24 * time.Hour
+
+// ErrOutsideMaintenanceWindow is returned by runs started outside the
+// maintenance windows whose first pending migration is not safe.
+var ErrOutsideMaintenanceWindow = errors.New("outside maintenance window")
+
+// MaintenanceWindow is a recurring period in which migrations may be
+// applied, parsed from a spec of a cron expression of the window's start
+// followed by its duration, optionally prefixed with a time zone:
+//
+//	TZ=Europe/Helsinki 0 2 * * SAT 4h
+//
+// opens every Saturday at 02:00 Helsinki time for four hours. The cron
+// fields are minute, hour, day of month, month and day of week (0 or 7 is
+// Sunday); they accept "*", numbers, ranges "1-5", lists "1,3", steps
+// "*/15" and three-letter month and day names. As in cron, a day matching
+// either a restricted day of month or a restricted day of week matches.
+// Without a time zone, UTC is used.
+type MaintenanceWindow struct {
+	spec     string
+	location *time.Location
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	// domAny and dowAny are set when the fields are "*".
+	domAny   bool
+	dowAny   bool
+	duration time.Duration
+}
+
+var (
+	monthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	dayNames = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+)
+
+// ParseMaintenanceWindow parses a maintenance window spec.
+//
+// Parameters:
+//   - spec: The window, e.g. "TZ=Europe/Helsinki 0 2 * * SAT 4h".
+//
+// Returns:
+//   - MaintenanceWindow: The window.
+//   - error: An error if the spec is invalid.
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	w := MaintenanceWindow{spec: spec, location: time.UTC}
+	fields := strings.Fields(spec)
+	if len(fields) > 0 {
+		for _, prefix := range []string{"TZ=", "CRON_TZ="} {
+			zone, ok := strings.CutPrefix(fields[0], prefix)
+			if !ok {
+				continue
+			}
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return MaintenanceWindow{}, fmt.Errorf(
+					"maintenance window %q: %w", spec, err,
+				)
+			}
+			w.location = loc
+			fields = fields[1:]
+			break
+		}
+	}
+	if len(fields) != 6 {
+		return MaintenanceWindow{}, fmt.Errorf(
+			"maintenance window %q: want 5 cron fields and a duration", spec,
+		)
+	}
+	var err error
+	parsers := []struct {
+		field       *uint64
+		first, last int
+		names       map[string]int
+	}{
+		{&w.minute, 0, 59, nil},
+		{&w.hour, 0, 23, nil},
+		{&w.dom, 1, 31, nil},
+		{&w.month, 1, 12, monthNames},
+		{&w.dow, 0, 7, dayNames},
+	}
+	for i, p := range parsers {
+		*p.field, err = parseCronField(fields[i], p.first, p.last, p.names)
+		if err != nil {
+			return MaintenanceWindow{}, fmt.Errorf(
+				"maintenance window %q: %w", spec, err,
+			)
+		}
+	}
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1
+	}
+	w.domAny, w.dowAny = fields[2] == "*", fields[4] == "*"
+	w.duration, err = time.ParseDuration(fields[5])
+	if err != nil || w.duration <= 0 || w.duration > maxWindowDuration {
+		return MaintenanceWindow{}, fmt.Errorf(
+			"maintenance window %q: invalid duration %q", spec, fields[5],
+		)
+	}
+	return w, nil
+}
+
+// parseCronField returns the bit set of the values of a cron field.
+func parseCronField(
+	field string, first int, last int, names map[string]int,
+) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+		lo, hi := first, last
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(loText, first, last, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(hiText, first, last, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = last
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// cronValue parses a number or name of a cron field.
+func cronValue(
+	text string, first int, last int, names map[string]int,
+) (int, error) {
+	if v, ok := names[strings.ToUpper(text)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < first || v > last {
+		return 0, fmt.Errorf("invalid value %q, want %d-%d", text, first, last)
+	}
+	return v, nil
+}
+
+// String returns the spec of the window.
+func (w MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// Contains reports whether the window is open at t.
+//
+// Parameters:
+//   - t: The time to check.
+//
+// Returns:
+//   - bool: Whether a window starting at or before t is open at t.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	// Look for a start within the duration before t.
+	start := t.Truncate(time.Minute)
+	for t.Sub(start) < w.duration {
+		if w.starts(start.In(w.location)) {
+			return true
+		}
+		start = start.Add(-time.Minute)
+	}
+	return false
+}
+
+// starts reports whether the window opens at the minute t.
+func (w MaintenanceWindow) starts(t time.Time) bool {
+	if w.minute&(1<<t.Minute()) == 0 || w.hour&(1<<t.Hour()) == 0 ||
+		w.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := w.dom&(1<<t.Day()) != 0
+	dow := w.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case w.domAny || w.dowAny:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
+
+// WithMaintenanceWindow returns a new Migrator that applies migrations
+// only within the given windows (see MaintenanceWindow for the spec
+// format). A run started outside every window applies the pending
+// migrations annotated "-- safe: true" up to the first one that is not,
+// which stays pending with a warning, and fails with
+// ErrOutsideMaintenanceWindow if the first pending migration is not safe.
+// Invalid specs fail the run. Rollbacks are not restricted.
+//
+// Parameters:
+//   - specs: The window specs, e.g. "TZ=Europe/Helsinki 0 2 * * SAT 4h".
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithMaintenanceWindow(specs ...string) *Migrator {
+	new := *m
+	new.MaintenanceWindows = specs
+	return &new
+}
+
+// WithMaintenanceOverride returns a new Migrator that applies migrations
+// outside the maintenance windows, for emergencies. The override is
+// logged.
+//
+// Parameters:
+//   - enabled: Whether to ignore the maintenance windows.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithMaintenanceOverride(enabled bool) *Migrator {
+	new := *m
+	new.MaintenanceOverride = enabled
+	return &new
+}
+
+// maintenanceHold returns the first pending migration that must not be
+// applied because no maintenance window is open, or nil if all may be
+// applied. It fails if that is the first pending migration.
+func (m *Migrator) maintenanceHold(
+	pending []Migration, result *Result,
+) (*Migration, error) {
+	if len(m.MaintenanceWindows) == 0 || len(pending) == 0 {
+		return nil, nil
+	}
+	now := nowUTC(m.NowFunc)
+	for _, spec := range m.MaintenanceWindows {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		if w.Contains(now) {
+			m.logf("Within maintenance window %s", w)
+			return nil, nil
+		}
+	}
+	if m.MaintenanceOverride {
+		m.logf("Outside maintenance windows, overridden")
+		return nil, nil
+	}
+	for i := range pending {
+		mig := &pending[i]
+		if err := mig.resolveAnnotations(); err != nil {
+			return nil, err
+		}
+		safe, _ := strconv.ParseBool(mig.Annotations[AnnotationSafe])
+		if safe {
+			continue
+		}
+		if i == 0 {
+			return nil, fmt.Errorf(
+				"migration %s (%s) is not safe: %w %q",
+				mig.Version, mig.Name, ErrOutsideMaintenanceWindow,
+				m.MaintenanceWindows,
+			)
+		}
+		m.warn(result, Warning{
+			Code: WarningMaintenanceWindow,
+			Message: fmt.Sprintf(
+				"migration %s (%s) and later ones wait for a maintenance window",
+				mig.Version, mig.Name,
+			),
+			Version: mig.Version,
+		})
+		return mig, nil
+	}
+	return nil, nil
+}
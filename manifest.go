@@ -0,0 +1,180 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// manifestInlineSource is the source of manifest entries whose SQL is not
+// read from a file.
+const manifestInlineSource = "inline"
+
+// Manifest lists the migrations of a migration set with their checksums,
+// so that release pipelines can attest exactly which migrations ship in an
+// artifact. Its JSON encoding is deterministic: the same migration set
+// always produces the same bytes, which can be signed or compared.
+type Manifest struct {
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestEntry describes a migration in a Manifest.
+type ManifestEntry struct {
+	// Version is the version of the migration.
+	Version string `json:"version"`
+	// Name is the name of the migration.
+	Name string `json:"name"`
+	// MigrationName is the history namespace of the migration.
+	MigrationName string `json:"migration_name,omitempty"`
+	// Checksum is the hex encoded SHA-256 of the migration's SQL,
+	// computed from the up and down steps as they are executed, whether
+	// or not the source computes checksums. Steps without readable SQL,
+	// such as hooks, contribute their type.
+	Checksum string `json:"checksum"`
+	// Size is the total size in bytes of the migration's SQL.
+	Size int64 `json:"size"`
+	// Source is the path of the migration's up SQL file, or "inline" for
+	// migrations whose SQL is defined in code or read at load time.
+	Source string `json:"source"`
+}
+
+// Manifest returns the manifest of all migrations of the Migrator's sources
+// and partitions, in execution order. It reads the SQL of every migration
+// but does not touch the database.
+//
+// Returns:
+//   - *Manifest: The manifest.
+//   - error: An error if loading the migrations or reading their SQL
+//     fails.
+func (m *Migrator) Manifest() (*Manifest, error) {
+	all, err := m.LoadAllMigrations()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{Migrations: make([]ManifestEntry, 0, len(all))}
+	for _, mig := range all {
+		entry, err := newManifestEntry(mig)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"manifest of migration %s (%s): %w", mig.Version, mig.Name, err,
+			)
+		}
+		manifest.Migrations = append(manifest.Migrations, entry)
+	}
+	m.logf("Manifest built for %d migrations", len(manifest.Migrations))
+	return manifest, nil
+}
+
+// JSON returns the indented JSON encoding of the manifest, terminated by a
+// newline.
+//
+// Returns:
+//   - []byte: The encoded manifest.
+//   - error: An error if encoding fails.
+func (mf *Manifest) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Digest returns the hex encoded SHA-256 of the manifest's JSON encoding,
+// a fingerprint of the whole migration set.
+//
+// Returns:
+//   - string: The digest.
+//   - error: An error if encoding fails.
+func (mf *Manifest) Digest() (string, error) {
+	data, err := mf.JSON()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newManifestEntry returns the manifest entry of mig.
+func newManifestEntry(mig Migration) (ManifestEntry, error) {
+	entry := ManifestEntry{
+		Version:       mig.Version,
+		Name:          mig.Name,
+		MigrationName: mig.MigrationName,
+		Source:        migrationSource(mig),
+	}
+	h := sha256.New()
+	for _, direction := range []string{"up", "down"} {
+		steps := mig.UpSteps
+		if direction == "down" {
+			steps = mig.DownSteps
+		}
+		size, err := hashSteps(h, direction, steps)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		entry.Size += size
+	}
+	entry.Checksum = hex.EncodeToString(h.Sum(nil))
+	return entry, nil
+}
+
+// migrationSource returns the path of the up SQL file of mig, or "inline"
+// if its SQL is not read from a file.
+func migrationSource(mig Migration) string {
+	for _, step := range mig.UpSteps {
+		if f, ok := stepFile(step); ok {
+			return f.Path
+		}
+	}
+	return manifestInlineSource
+}
+
+// hashSteps writes the direction and SQL of every step to h in the format
+// of checksumFiles. It returns the total size of the SQL.
+func hashSteps(h hash.Hash, direction string, steps []MigrationStep) (int64, error) {
+	var size int64
+	for _, step := range steps {
+		content, sqlSize, err := stepContent(step)
+		if err != nil {
+			return 0, err
+		}
+		size += sqlSize
+		writeChecksumPart(h, direction, []byte(content))
+	}
+	return size, nil
+}
+
+// stepContent returns the content step contributes to a manifest checksum
+// and the size of its SQL: the SQL of the step, prefixed with the schemas
+// for steps wrapped by ForSchemas, or the type of steps without readable
+// SQL.
+func stepContent(step MigrationStep) (string, int64, error) {
+	var schema *SchemaMigrationStep
+	switch s := step.(type) {
+	case SchemaMigrationStep:
+		schema = &s
+	case *SchemaMigrationStep:
+		schema = s
+	case sqlStep:
+		sql, err := s.stepSQL()
+		return sql, int64(len(sql)), err
+	default:
+		return fmt.Sprintf("%T", step), 0, nil
+	}
+	content, size, err := stepContent(schema.Step)
+	return strings.Join(schema.Schemas, ",") + "\x00" + content, size, err
+}
+
+// stepFile returns the file step of step, if it is one.
+func stepFile(step MigrationStep) (*FileSQLMigrationStep, bool) {
+	switch s := step.(type) {
+	case FileSQLMigrationStep:
+		return &s, true
+	case *FileSQLMigrationStep:
+		return s, true
+	}
+	return nil, false
+}
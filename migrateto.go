@@ -0,0 +1,83 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MigrateTo brings the schema to version, deciding the direction from the
+// applied migrations: migrations after version that are applied are rolled
+// back like MigrateDown, then pending migrations up to and including
+// version are applied like MigrateUp. Both happen when a migration after
+// version was applied out of order before an earlier pending one. Nothing
+// runs if the applied migrations already match version.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - version: The version the schema should be at.
+//
+// Returns:
+//   - error: An error if version is invalid or any migration fails.
+func (m *Migrator) MigrateTo(ctx context.Context, version string) error {
+	if version == "" {
+		return errors.New("migrate to: empty version")
+	}
+	if !m.validVersion(version) {
+		return fmt.Errorf("migrate to: %w", m.versionFormatError("version", version))
+	}
+	if m.SchemaProvider != nil {
+		return m.ForEachSchema(
+			ctx,
+			func(ctx context.Context, _ string, sm *Migrator) error {
+				return sm.MigrateTo(ctx, version)
+			},
+		)
+	}
+	if !m.DryRun && !m.AssertOnly {
+		if err := m.ensureHistoryTable(ctx); err != nil {
+			return err
+		}
+	}
+	all, applied, _, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	down := m.firstAppliedAfter(all, applied, version)
+	up := len(m.pendingMigrations(all, applied, version)) > 0
+	if down == "" && !up {
+		m.logf("Already at version %s", version)
+		return nil
+	}
+	if down != "" {
+		m.logf("Migrating down to version %s", version)
+		if err := m.MigrateDown(ctx, down); err != nil {
+			return err
+		}
+	}
+	if up {
+		m.logf("Migrating up to version %s", version)
+		if err := m.MigrateUp(ctx, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstAppliedAfter returns the version of the first applied migration of
+// all after version that a rollback would undo, or "" if there is none.
+func (m *Migrator) firstAppliedAfter(
+	all []Migration, applied appliedSet, version string,
+) string {
+	for _, mig := range all {
+		if m.compareVersions(mig.Version, version) <= 0 || !applied.has(mig) {
+			continue
+		}
+		if m.excluded(mig, nil) {
+			continue
+		}
+		return mig.Version
+	}
+	return ""
+}
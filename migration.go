@@ -1,12 +1,18 @@
 package migrator
 
 import (
+	"cmp"
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log"
-	"sort"
-	"strconv"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Executor is an interface that both *sql.DB and *sql.Tx implement.
@@ -33,6 +39,27 @@ type Migration struct {
 	Name      string
 	UpSteps   []MigrationStep
 	DownSteps []MigrationStep
+	// Checksum is the hex encoded SHA-256 of the migration content. It is
+	// empty when the source does not compute checksums.
+	Checksum string
+	// MigrationName is the history namespace of the migration. When empty,
+	// the Migrator's MigrationName is used.
+	MigrationName string
+	// Description is a human-readable summary of the migration, usually read
+	// from a "-- description: ..." header of its SQL.
+	Description string
+	// Ticket references the change-management record of the migration,
+	// usually read from a "-- ticket: JIRA-123" header of its SQL.
+	Ticket string
+	// Annotations holds the "-- key: value" header of the migration's SQL.
+	Annotations map[string]string
+	// BindVars holds named values bound to ":name" parameters of the SQL
+	// steps of the migration.
+	BindVars map[string]any
+
+	// loadAnnotations reads the annotations of sources that defer file
+	// reads until a migration is about to run.
+	loadAnnotations func() (map[string]string, error)
 }
 
 // NewMigration returns a new migration.
@@ -54,6 +81,20 @@ func NewMigration(
 	}
 }
 
+// details returns the description and ticket of the migration formatted for
+// log messages, or an empty string if neither is set.
+func (m Migration) details() string {
+	switch {
+	case m.Description != "" && m.Ticket != "":
+		return fmt.Sprintf(" (%s, ticket %s)", m.Description, m.Ticket)
+	case m.Description != "":
+		return fmt.Sprintf(" (%s)", m.Description)
+	case m.Ticket != "":
+		return fmt.Sprintf(" (ticket %s)", m.Ticket)
+	}
+	return ""
+}
+
 // WithVersion returns a new Migration with the given version.
 //
 // Parameters:
@@ -106,6 +147,19 @@ func (m *Migration) WithDownSteps(downSteps []MigrationStep) *Migration {
 	return &new
 }
 
+// WithBindVars returns a new migration with the given bind variables.
+//
+// Parameters:
+//   - vars: The named values bound to the SQL steps of the migration.
+//
+// Returns:
+//   - *Migration: A new migration.
+func (m *Migration) WithBindVars(vars map[string]any) *Migration {
+	new := *m
+	new.BindVars = vars
+	return &new
+}
+
 // Migrator holds migrations from one or more sources and manages history.
 type Migrator struct {
 	Sources        []MigrationSource
@@ -114,16 +168,213 @@ type Migrator struct {
 	HistoryManager HistoryManager
 	MigrationName  string
 	Transactional  bool
+	Cache          bool
+	BatchHistory   bool
+	// KeepaliveInterval enables pinging the database during runs when set.
+	KeepaliveInterval time.Duration
+	// SessionSetup statements run on the migration connection or
+	// transaction before any step.
+	SessionSetup []string
+	// SingleConnection pins non-transactional runs to one connection.
+	SingleConnection bool
+	// TxOptions are the options of the transaction of transactional runs.
+	TxOptions sql.TxOptions
+	// SkipReadOnlyCheck disables failing runs connected to a replica.
+	SkipReadOnlyCheck bool
+	// SQLParser validates the SQL of pending migrations before a run.
+	SQLParser SQLParser
+	// MaintenanceWindows are the specs of the windows migrations are
+	// applied in. Empty allows runs at any time.
+	MaintenanceWindows []string
+	// MaintenanceOverride allows runs outside the maintenance windows.
+	MaintenanceOverride bool
+	// AppliedBy is an optional service identity stored in history records.
+	AppliedBy string
+	// NowFunc returns the current time for history records, audit rows,
+	// events and results. It defaults to time.Now.
+	NowFunc func() time.Time
+	// EventSink receives run events when set.
+	EventSink EventSink
+	// Logger receives log messages. Nil uses the standard logger.
+	Logger Logger
+	// RedactSQL selects how SQL appears in log output and errors.
+	RedactSQL RedactMode
+	// SQLPreviewLength is the maximum length of the SQL preview in step
+	// errors. Zero uses DefaultSQLPreviewLength and a negative value
+	// disables the preview.
+	SQLPreviewLength int
+	// SearchPath is the Postgres search_path set before any step runs and
+	// stored in history records.
+	SearchPath string
+	// Audit selects what is stored in the audit table for every executed
+	// migration.
+	Audit AuditMode
+	// AuditTable is the name of the audit table. It defaults to the history
+	// table name suffixed with "_audit".
+	AuditTable string
+	// CreateSchema creates the schema of a schema-qualified history table.
+	CreateSchema bool
+	// AssertOnly makes MigrateUp fail on pending migrations instead of
+	// applying them.
+	AssertOnly bool
+	// TxPerMigration runs every migration in its own transaction instead
+	// of the whole run in one.
+	TxPerMigration bool
+	// StatementSplitting executes the statements of SQL steps one by one.
+	StatementSplitting bool
+	// DryRun makes MigrateUp and MigrateDown write the SQL they would
+	// execute instead of modifying the database.
+	DryRun bool
+	// DryRunOutput receives the SQL of dry runs. Nil logs it.
+	DryRunOutput io.Writer
+	// VersionComparator orders versions. Nil uses CompareNumeric.
+	VersionComparator VersionComparator
+	// RepairOrphans makes Repair remove the history records of migrations
+	// no source defines.
+	RepairOrphans bool
+	// Partitions are migration sets with their own migration names run
+	// after the Migrator's own sources.
+	Partitions []Partition
+	// SchemaProvider lists the schemas runs apply the migrations to, each
+	// with its own history records.
+	SchemaProvider SchemaProvider
+	// StepCheckpoints records completed steps of non-transactional runs so
+	// that a failed migration resumes at the failed step.
+	StepCheckpoints bool
+	// StepHistory keeps a record of every completed up step.
+	StepHistory bool
+	// StepRetryAttempts is the maximum number of executions of a failed
+	// step of a transactional run, each within a savepoint.
+	StepRetryAttempts int
+	// StepRetryDelay is the pause before a step is retried.
+	StepRetryDelay time.Duration
+	// StepRetryable reports whether a step error is retried. Nil retries
+	// every error.
+	StepRetryable func(error) bool
+	// HookContext derives the context the steps of a migration run with.
+	HookContext func(ctx context.Context) context.Context
+	// BindVars holds named values bound to the SQL steps of migrations,
+	// keyed by migration version.
+	BindVars map[string]map[string]any
+	// SignatureKeys are the public keys migrations must be signed with.
+	SignatureKeys []ed25519.PublicKey
+	// SignedManifest is the manifest migrations are verified against.
+	SignedManifest []byte
+	// ManifestSignature is the signature of SignedManifest.
+	ManifestSignature []byte
+	// AllowDestructive applies migrations with destructive statements.
+	AllowDestructive bool
+	// PolicyHook decides whether pending migrations may be applied.
+	PolicyHook PolicyHook
+	// SecretProvider resolves the secrets of template steps.
+	SecretProvider SecretProvider
+	// ObjectsDir holds the definitions of repeatable code objects.
+	ObjectsDir string
+	// RunControl pauses, resumes and cancels runs.
+	RunControl *RunControl
+	// Lock serializes runs through a lock table or Locker.
+	Lock bool
+	// Locker takes the run lock instead of the lock table, if set.
+	Locker Locker
+	// LockTimeout bounds the wait for a held lock. Zero waits until the
+	// context is done.
+	LockTimeout time.Duration
+	// LockOwner identifies the Migrator in the lock.
+	LockOwner string
+	// LockPollInterval is the pause between attempts to take a held lock.
+	LockPollInterval time.Duration
+	// Exclude lists the versions left alone by runs.
+	Exclude []string
+	// Notifiers are notified when a run fails or executes migrations.
+	Notifiers []Notifier
+	// PostCommitHooks are called after a run that executed migrations has
+	// committed.
+	PostCommitHooks []PostCommitHookFn
+	// BeforeAll and AfterAll are called before the first and after the
+	// last migration a run executes.
+	BeforeAll []RunHookFn
+	AfterAll  []RunHookFn
+	// BeforeEach and AfterEach are called before and after every migration
+	// a run executes.
+	BeforeEach []MigrationHookFn
+	AfterEach  []MigrationHookFn
+	// Preflights are checked before any migration of a run.
+	Preflights []Preflight
+	// VerifyPrivileges checks the privileges needed by pending migrations
+	// before applying them.
+	VerifyPrivileges bool
+	// UnmetRequirements selects whether migrations whose "requires"
+	// annotation does not hold fail the run or are skipped.
+	UnmetRequirements UnmetRequirementMode
+
+	cache *migrationCache
+	// schema is the schema a Migrator of a schema run runs against.
+	schema string
+	// innerRun is set on the per-schema Migrators of a schema run, which
+	// leave run events and notifications to the combined run.
+	innerRun bool
+	// rangeFrom is the lowest version applied by MigrateRange.
+	rangeFrom string
+	// ownsDB is set when the Migrator opened DB and closes it in Close.
+	ownsDB bool
+}
+
+// migrationCache holds the loaded migrations and the applied set so that
+// composite operations do not repeat full loads and history scans.
+type migrationCache struct {
+	owner    *Migrator
+	all      []Migration
+	warnings []Warning
+	applied  appliedSet
+}
+
+// appliedSet holds the applied versions per migration name.
+type appliedSet map[string]map[string]bool
+
+// has reports whether the migration is applied.
+func (a appliedSet) has(mig Migration) bool {
+	return a[mig.MigrationName][mig.Version]
+}
+
+// set marks the migration as applied or not applied.
+func (a appliedSet) set(mig Migration, applied bool) {
+	if !applied {
+		delete(a[mig.MigrationName], mig.Version)
+		return
+	}
+	if a[mig.MigrationName] == nil {
+		a[mig.MigrationName] = make(map[string]bool)
+	}
+	a[mig.MigrationName][mig.Version] = true
+}
+
+// count returns the number of applied versions over all migration names.
+func (a appliedSet) count() int {
+	n := 0
+	for _, versions := range a {
+		n += len(versions)
+	}
+	return n
+}
+
+// clone returns a deep copy of the set.
+func (a appliedSet) clone() appliedSet {
+	c := make(appliedSet, len(a))
+	for name, versions := range a {
+		c[name] = maps.Clone(versions)
+	}
+	return c
 }
 
 // NewMigrator returns a new Migrator instance.
-// If historyManager is nil, it defaults to SQLiteHistoryManager.
+// If historyManager is nil, the HistoryManager matching the driver of db is
+// used, falling back to SQLiteHistoryManager for unrecognized drivers.
 //
 // Parameters:
 //   - db: A connection to the target database.
 //   - historyTable: The name of the table used to record applied migrations.
-//   - historyManager: Optional HistoryManager. Defaults to
-//     SQLiteHistoryManager.
+//   - historyManager: Optional HistoryManager. Defaults to the manager
+//     matching the driver of db.
 //   - migrationName: The name of the migration. It is used to distinguish
 //     migrations between multiple systems.
 //
@@ -136,7 +387,7 @@ func NewMigrator(
 	migrationName string,
 ) *Migrator {
 	if historyManager == nil {
-		historyManager = SQLiteHistoryManager{}
+		historyManager = defaultHistoryManager(db)
 	}
 	return &Migrator{
 		DB:             db,
@@ -159,7 +410,8 @@ func (m *Migrator) WithSources(sources []MigrationSource) *Migrator {
 	return &new
 }
 
-// WithDB returns a new Migrator with the given database connection.
+// WithDB returns a new Migrator with the given database connection. The
+// new Migrator does not close db in Close.
 //
 // Parameters:
 //   - db: A database connection.
@@ -169,6 +421,7 @@ func (m *Migrator) WithSources(sources []MigrationSource) *Migrator {
 func (m *Migrator) WithDB(db *sql.DB) *Migrator {
 	new := *m
 	new.DB = db
+	new.ownsDB = false
 	return &new
 }
 
@@ -224,26 +477,231 @@ func (m *Migrator) WithTransactional(transactional bool) *Migrator {
 	return &new
 }
 
+// WithBatchHistory returns a new Migrator with batched history writes enabled
+// or disabled. When enabled in a transactional run, the history rows of all
+// applied migrations are written with multi-row INSERT statements right
+// before the transaction commits. Non-transactional runs always record each
+// migration as soon as it is applied.
+//
+// Parameters:
+//   - batch: Whether to batch history writes.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithBatchHistory(batch bool) *Migrator {
+	new := *m
+	new.BatchHistory = batch
+	return &new
+}
+
+// WithKeepalive returns a new Migrator that pings the database every
+// interval on a separate connection while migrations run. Zero disables the
+// keepalive. Independently of this setting, errors caused by a dropped
+// connection are reported as ErrConnectionLost.
+//
+// Parameters:
+//   - interval: The interval between pings.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithKeepalive(interval time.Duration) *Migrator {
+	new := *m
+	new.KeepaliveInterval = interval
+	return &new
+}
+
+// WithSessionSetup returns a new Migrator that executes the given statements
+// on the migration transaction, or on a dedicated connection for
+// non-transactional runs, before any step runs. Use it to enforce safety
+// settings such as lock or statement timeouts for every migration.
+//
+// Parameters:
+//   - stmts: The session setup statements.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSessionSetup(stmts []string) *Migrator {
+	new := *m
+	new.SessionSetup = stmts
+	return &new
+}
+
+// WithSingleConnection returns a new Migrator whose non-transactional runs
+// execute every step and history write on one dedicated connection instead
+// of spreading them over the pool, so that session state such as SET ROLE,
+// search_path or temporary tables created by hooks persists across steps.
+// Transactional runs are always on one connection. As with session setup,
+// the connection is discarded after the run instead of being returned to
+// the pool.
+//
+// Parameters:
+//   - enabled: Whether to pin runs to one connection.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSingleConnection(enabled bool) *Migrator {
+	new := *m
+	new.SingleConnection = enabled
+	return &new
+}
+
+// WithAppliedBy returns a new Migrator that stores the given identity, such
+// as a service or deployment job name, in the history record of every applied
+// migration. The OS user and hostname are recorded regardless.
+//
+// Parameters:
+//   - appliedBy: The identity of the applier.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAppliedBy(appliedBy string) *Migrator {
+	new := *m
+	new.AppliedBy = appliedBy
+	return &new
+}
+
+// WithSearchPath returns a new Migrator that sets the Postgres search_path on
+// the migration transaction, or on a dedicated connection for
+// non-transactional runs, before any step runs. Unqualified table names in
+// migrations then resolve against the given schemas. The search_path is
+// stored in the history record of every applied migration.
+//
+// Parameters:
+//   - searchPath: The search_path value, for example "tenant_a, public".
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSearchPath(searchPath string) *Migrator {
+	new := *m
+	new.SearchPath = searchPath
+	return &new
+}
+
+// WithNowFunc returns a new Migrator that takes the applied_at time of
+// history records, and the timestamps of audit rows, events and results,
+// from now instead of time.Now. Use it for deterministic tests or a trusted
+// time source.
+//
+// Parameters:
+//   - now: The clock to use.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithNowFunc(now func() time.Time) *Migrator {
+	new := *m
+	new.NowFunc = now
+	return &new
+}
+
+// WithCache returns a new Migrator with caching of loaded migrations and the
+// applied set enabled or disabled. A cached Migrator keeps both between calls
+// and updates the applied set itself after successful runs. Changes made to
+// the sources or the history table by others are only seen after
+// InvalidateCache is called.
+//
+// Parameters:
+//   - enabled: Whether to cache migrations and the applied set.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithCache(enabled bool) *Migrator {
+	new := *m
+	new.Cache = enabled
+	new.cache = nil
+	return &new
+}
+
+// InvalidateCache drops cached migrations and the cached applied set so that
+// the next operation reloads them from the sources and the history table.
+func (m *Migrator) InvalidateCache() {
+	m.cache = nil
+}
+
+// runCache returns the cache of this Migrator, creating a fresh one if the
+// current cache is missing or was inherited from another Migrator.
+func (m *Migrator) runCache() *migrationCache {
+	if m.cache == nil || m.cache.owner != m {
+		m.cache = &migrationCache{owner: m}
+	}
+	return m.cache
+}
+
+// updateCache stores the applied set resulting from a run. A failed run
+// leaves the history state unknown, so the applied set is dropped.
+func (m *Migrator) updateCache(applied appliedSet, runErr error) {
+	if !m.Cache {
+		return
+	}
+	c := m.runCache()
+	if runErr != nil {
+		c.applied = nil
+		return
+	}
+	c.applied = applied
+}
+
 // LoadAllMigrations loads and merges migrations from all sources and validates
-// that each migration has at least one up step.
+// that each migration has at least one up step. Migrations without a
+// MigrationName are assigned the Migrator's MigrationName.
 //
 // Returns:
 //   - A slice of loaded migrations.
 //   - An error if any migration is missing up steps or loading fails.
 func (m *Migrator) LoadAllMigrations() ([]Migration, error) {
-	var all []Migration
-	for _, src := range m.Sources {
-		migs, err := src.LoadMigrations()
-		if err != nil {
-			return nil, err
+	all, _, err := m.loadAllMigrations()
+	return all, err
+}
+
+// loadAllMigrations loads all migrations like LoadAllMigrations and also
+// returns the warnings reported by the sources and found in the loaded
+// migrations.
+func (m *Migrator) loadAllMigrations() ([]Migration, []Warning, error) {
+	var (
+		loaded   []sourcedMigration
+		warnings []Warning
+	)
+	idx := 0
+	for part, partition := range m.partitions() {
+		for _, src := range partition.Sources {
+			migs, srcWarnings, err := loadSource(src)
+			if err != nil {
+				return nil, nil, err
+			}
+			warnings = append(warnings, srcWarnings...)
+			priority := sourcePriority(src)
+			for _, mig := range migs {
+				if mig.MigrationName == "" {
+					mig.MigrationName = partition.MigrationName
+				} else if m.schema != "" {
+					mig.MigrationName = schemaMigrationName(
+						mig.MigrationName, m.schema,
+					)
+				}
+				loaded = append(loaded, sourcedMigration{
+					mig:       mig,
+					partition: part,
+					priority:  priority,
+					source:    idx,
+				})
+			}
+			idx++
 		}
-		all = append(all, migs...)
+	}
+
+	// Sort migrations by partition, version, source priority, source
+	// position and name, so that repeated runs produce identical plans.
+	slices.SortStableFunc(
+		loaded, compareSourcedMigrations(m.compareVersions),
+	)
+	all := make([]Migration, len(loaded))
+	for i, l := range loaded {
+		all[i] = l.mig
 	}
 
 	// Validate that every migration has at least one up step.
 	for _, mig := range all {
 		if len(mig.UpSteps) == 0 {
-			return nil, fmt.Errorf(
+			return nil, nil, fmt.Errorf(
 				"migration %s (%s) has no up steps defined",
 				mig.Version,
 				mig.Name,
@@ -251,14 +709,17 @@ func (m *Migrator) LoadAllMigrations() ([]Migration, error) {
 		}
 	}
 
-	// Sort migrations by version (assumes numeric versions).
-	sort.Slice(all, func(i, j int) bool {
-		vi, _ := strconv.Atoi(all[i].Version)
-		vj, _ := strconv.Atoi(all[j].Version)
-		return vi < vj
-	})
-	log.Printf("Total loaded migrations: %d", len(all))
-	return all, nil
+	m.logf("Total loaded migrations: %d", len(all))
+	return all, append(warnings, migrationWarnings(all)...), nil
+}
+
+// loadSource loads the migrations of src and the warnings it reports.
+func loadSource(src MigrationSource) ([]Migration, []Warning, error) {
+	if reporter, ok := src.(WarningReporter); ok {
+		return reporter.LoadMigrationsWithWarnings()
+	}
+	migs, err := src.LoadMigrations()
+	return migs, nil, err
 }
 
 // MigrateUp applies pending migrations up to a target version.
@@ -269,32 +730,136 @@ func (m *Migrator) LoadAllMigrations() ([]Migration, error) {
 //   - target: The target migration version to stop at (empty means all).
 //
 // Returns:
-//   - An error if any migration fails.
+//   - An error if any migration fails. In assert-only mode, a
+//     *PendingMigrationsError if migrations up to target are pending.
 func (m *Migrator) MigrateUp(ctx context.Context, target string) error {
-	log.Println("Starting MigrateUp")
+	_, err := m.MigrateUpResult(ctx, target)
+	return err
+}
+
+// MigrateUpResult applies pending migrations like MigrateUp and returns a
+// summary of the run.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - target: The target migration version to stop at (empty means all).
+//
+// Returns:
+//   - *Result: The summary of the run. It is returned even if the run
+//     fails.
+//   - error: An error if any migration fails.
+func (m *Migrator) MigrateUpResult(
+	ctx context.Context, target string,
+) (*Result, error) {
+	if m.SchemaProvider != nil {
+		return m.schemaRunResult(
+			ctx,
+			"up",
+			func(ctx context.Context, sm *Migrator) (*Result, error) {
+				return sm.MigrateUpResult(ctx, target)
+			},
+		)
+	}
+	m.logf("Starting MigrateUp")
+	result := m.startRun("up")
+	if m.AssertOnly {
+		return m.finishRun(ctx, result, m.assertUpToDate(ctx, target))
+	}
+	if m.DryRun {
+		return m.finishRun(ctx, result, m.dryRun(ctx, result, target))
+	}
+	endRun, err := m.beginRun("up")
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.RunPreflights(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
 
-	err := m.ensureHistoryTable(ctx)
+	err = m.ensureHistoryTable(ctx)
 	if err != nil {
-		return err
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.checkHistoryKey(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureAuditTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureStepTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureObjectTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
 	}
+	release, err := m.acquireLock(ctx, "up")
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	defer release()
 
-	all, applied, err := m.getAllAndAppliedMigrations(ctx)
+	all, applied, warnings, err := m.getAllAndAppliedMigrations(ctx)
 	if err != nil {
-		return err
+		return m.finishRun(ctx, result, err)
+	}
+	for _, warning := range warnings {
+		m.warn(result, warning)
+	}
+	pending := m.pendingMigrations(all, applied, target)
+	hold, err := m.maintenanceHold(pending, result)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.validateSQL(pending); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.verifyPrivileges(ctx, pending); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.verifySignatures(pending); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.checkDestructive(pending); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.checkPolicy(ctx, pending); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	runner, err := m.withRunIsolation(pending)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
 	}
 
-	count, err := m.runMigrationsIfTransactional(
+	stopped := false
+	count, err := runner.runMigrationsIfTransactional(
 		ctx,
-		func(exec Executor) (int, error) {
-			return m.applyMigrations(ctx, exec, all, applied, target)
+		func(run *migrationRun) (int, error) {
+			run.result = result
+			run.holdFrom = hold
+			count, err := m.applyMigrations(ctx, run, all, applied, target)
+			stopped = run.stopped
+			if err != nil || target != "" || stopped {
+				return count, err
+			}
+			result.Objects, err = m.applyObjects(ctx, run)
+			return count, err
 		},
 	)
+	m.updateCache(applied, err)
 	if err != nil {
-		return err
+		return m.finishRun(ctx, result, err)
 	}
 
-	log.Printf("MigrateUp complete. Total migrations applied: %d", count)
-	return nil
+	m.logf("MigrateUp complete. Total migrations applied: %d", count)
+	m.runPostCommitHooks(ctx, result)
+	if stopped {
+		return m.finishRun(ctx, result, ErrRunStopped)
+	}
+	return m.finishRun(ctx, result, nil)
 }
 
 // MigrateDown rolls back applied migrations down to a target version.
@@ -308,125 +873,566 @@ func (m *Migrator) MigrateUp(ctx context.Context, target string) error {
 // Returns:
 //   - An error if any rollback step fails.
 func (m *Migrator) MigrateDown(ctx context.Context, target string) error {
-	log.Println("Starting MigrateDown")
+	_, err := m.MigrateDownResult(ctx, target)
+	return err
+}
 
-	all, applied, err := m.getAllAndAppliedMigrations(ctx)
+// MigrateDownResult rolls back applied migrations like MigrateDown and
+// returns a summary of the run.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - target: The migration version at which to stop rolling back
+//     (empty means rollback all).
+//
+// Returns:
+//   - *Result: The summary of the run. It is returned even if the run
+//     fails.
+//   - error: An error if any rollback step fails.
+func (m *Migrator) MigrateDownResult(
+	ctx context.Context, target string,
+) (*Result, error) {
+	if m.SchemaProvider != nil {
+		return m.schemaRunResult(
+			ctx,
+			"down",
+			func(ctx context.Context, sm *Migrator) (*Result, error) {
+				return sm.MigrateDownResult(ctx, target)
+			},
+		)
+	}
+	m.logf("Starting MigrateDown")
+	result := m.startRun("down")
+	if m.DryRun {
+		return m.finishRun(ctx, result, m.dryRun(ctx, result, target))
+	}
+	endRun, err := m.beginRun("down")
 	if err != nil {
-		return err
+		return m.finishRun(ctx, result, err)
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.RunPreflights(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.checkHistoryKey(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
 	}
 
-	// Sort migrations in reverse order by version.
-	sort.Slice(all, func(i, j int) bool {
-		vi, _ := strconv.Atoi(all[i].Version)
-		vj, _ := strconv.Atoi(all[j].Version)
-		return vi > vj
-	})
+	if err := m.ensureAuditTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureStepTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	release, err := m.acquireLock(ctx, "down")
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	defer release()
+	all, applied, warnings, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	for _, warning := range warnings {
+		m.warn(result, warning)
+	}
 
-	count, err := m.runMigrationsIfTransactional(
+	runner, err := m.withRunIsolation(
+		m.rollbackCandidates(all, applied, target),
+	)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+
+	// Roll back in the reverse order of application.
+	slices.Reverse(all)
+
+	stopped := false
+	count, err := runner.runMigrationsIfTransactional(
 		ctx,
-		func(exec Executor) (int, error) {
-			return m.rollbackMigrations(ctx, exec, all, applied, target)
+		func(run *migrationRun) (int, error) {
+			run.result = result
+			count, err := m.rollbackMigrations(ctx, run, all, applied, target)
+			stopped = run.stopped
+			return count, err
 		},
 	)
+	m.updateCache(applied, err)
 	if err != nil {
-		return err
+		return m.finishRun(ctx, result, err)
 	}
 
-	log.Printf("MigrateDown complete. Total migrations rolled back: %d", count)
-	return nil
+	m.logf("MigrateDown complete. Total migrations rolled back: %d", count)
+	m.runPostCommitHooks(ctx, result)
+	if stopped {
+		return m.finishRun(ctx, result, ErrRunStopped)
+	}
+	return m.finishRun(ctx, result, nil)
 }
 
 // ensureHistoryTable ensures the history table exists.
 func (m *Migrator) ensureHistoryTable(ctx context.Context) error {
-	// Ensure history table exists.
-	log.Println("Starting MigrateUp")
+	if err := m.ensureHistorySchema(ctx); err != nil {
+		return err
+	}
 	if err := m.HistoryManager.EnsureHistoryTable(
 		ctx, m.DB, m.HistoryTable,
 	); err != nil {
-		log.Printf("Error ensuring history table %s: %v", m.HistoryTable, err)
+		m.logf("Error ensuring history table %s: %v", m.HistoryTable, err)
 		return err
 	}
-	log.Printf("History table %s ensured", m.HistoryTable)
+	m.logf("History table %s ensured", m.HistoryTable)
 	return nil
 }
 
-// getAllAndAppliedMigrations loads all migrations and their applied status.
+// checkHistoryKey fails if the history table exists with a primary key
+// that does not include the migration name, which would make the history
+// rows of one migration name collide with those of another partway through
+// a run.
+func (m *Migrator) checkHistoryKey(ctx context.Context) error {
+	reader, ok := m.HistoryManager.(HistoryKeyReader)
+	if !ok {
+		return nil
+	}
+	key, err := reader.HistoryKey(ctx, m.DB, m.HistoryTable)
+	if err != nil {
+		return fmt.Errorf("read primary key of %s: %w", m.HistoryTable, err)
+	}
+	if len(key) == 0 || slices.ContainsFunc(key, func(col string) bool {
+		return strings.EqualFold(col, "migration_name")
+	}) {
+		return nil
+	}
+	err = fmt.Errorf(
+		"history table %s has the legacy primary key (%s); change it to "+
+			"(migration_name, version) before migrating",
+		m.HistoryTable, strings.Join(key, ", "),
+	)
+	m.logf("Refusing to migrate: %v", err)
+	return err
+}
+
+// getAllAndAppliedMigrations loads all migrations, their applied status and
+// the warnings found while loading. When caching is enabled, previously
+// loaded values are reused and copies are returned so callers may reorder
+// and modify them freely.
 func (m *Migrator) getAllAndAppliedMigrations(
 	ctx context.Context,
-) ([]Migration, map[string]bool, error) {
+) ([]Migration, appliedSet, []Warning, error) {
+	if !m.Cache {
+		return m.loadAllAndAppliedMigrations(ctx)
+	}
+
+	c := m.runCache()
+	if c.all == nil {
+		all, warnings, err := m.loadAllMigrations()
+		if err != nil {
+			m.logf("Error loading migrations: %v", err)
+			return nil, nil, nil, err
+		}
+		c.all, c.warnings = all, warnings
+	} else {
+		m.logf("Using %d cached migrations", len(c.all))
+	}
+	if c.applied == nil {
+		applied, err := m.appliedMigrations(ctx, c.all)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c.applied = applied
+	}
+	m.logf("Previously applied migrations count: %d", c.applied.count())
+
+	return slices.Clone(c.all), c.applied.clone(), slices.Clone(c.warnings), nil
+}
+
+// loadAllAndAppliedMigrations loads all migrations, their applied status and
+// the warnings found while loading without consulting the cache.
+func (m *Migrator) loadAllAndAppliedMigrations(
+	ctx context.Context,
+) ([]Migration, appliedSet, []Warning, error) {
 	// Load all migrations.
-	all, err := m.LoadAllMigrations()
+	all, warnings, err := m.loadAllMigrations()
 	if err != nil {
-		log.Printf("Error loading migrations: %v", err)
-		return nil, nil, err
+		m.logf("Error loading migrations: %v", err)
+		return nil, nil, nil, err
 	}
 
 	// Get a list of migrations that have been applied.
-	applied, err := m.HistoryManager.AppliedMigrations(
-		ctx, m.DB, m.HistoryTable, m.MigrationName,
-	)
+	applied, err := m.appliedMigrations(ctx, all)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	m.logf("Previously applied migrations count: %d", applied.count())
+
+	return all, applied, warnings, nil
+}
+
+// appliedMigrations retrieves the applied set from the history manager for
+// the Migrator's migration name and every migration name used by all. The
+// returned set is owned by the caller and is updated as migrations run.
+func (m *Migrator) appliedMigrations(
+	ctx context.Context, all []Migration,
+) (appliedSet, error) {
+	names := m.historyNames(all)
+	applied := make(appliedSet, len(names))
+	for _, name := range names {
+		versions, err := m.HistoryManager.AppliedMigrations(
+			ctx, m.DB, m.HistoryTable, name,
+		)
+		if err != nil {
+			m.logf("Error retrieving applied migrations: %v", err)
+			return nil, err
+		}
+		applied[name] = maps.Clone(versions)
+		if applied[name] == nil {
+			applied[name] = make(map[string]bool)
+		}
+	}
+	return applied, nil
+}
+
+// historyNames returns the Migrator's migration name followed by every other
+// migration name used by all.
+func (m *Migrator) historyNames(all []Migration) []string {
+	names := []string{m.MigrationName}
+	for _, mig := range all {
+		if !slices.Contains(names, mig.MigrationName) {
+			names = append(names, mig.MigrationName)
+		}
+	}
+	return names
+}
+
+// HistoryEntries returns the recorded history entries of the Migrator's
+// migration name and of every migration name used by its sources. The
+// HistoryManager must implement HistoryReader.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - []HistoryEntry: The recorded entries.
+//   - error: An error if the history cannot be read.
+func (m *Migrator) HistoryEntries(ctx context.Context) ([]HistoryEntry, error) {
+	reader, ok := m.HistoryManager.(HistoryReader)
+	if !ok {
+		return nil, fmt.Errorf(
+			"history manager %T cannot read entries: %w",
+			m.HistoryManager, errors.ErrUnsupported,
+		)
+	}
+
+	all, err := m.LoadAllMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, name := range m.historyNames(all) {
+		named, err := reader.AppliedEntries(ctx, m.DB, m.HistoryTable, name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, named...)
+	}
+	return entries, nil
+}
+
+// SlowestMigrations returns up to limit history entries ordered by
+// descending execution duration. A limit of zero or less returns all
+// entries.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - limit: The maximum number of entries to return.
+//
+// Returns:
+//   - []HistoryEntry: The slowest entries.
+//   - error: An error if the history cannot be read.
+func (m *Migrator) SlowestMigrations(
+	ctx context.Context, limit int,
+) ([]HistoryEntry, error) {
+	entries, err := m.HistoryEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(entries, func(a, b HistoryEntry) int {
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// WhenApplied returns when the migration with the given version was
+// applied. If several history namespaces applied the version, the latest
+// time is returned.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - version: The migration version.
+//
+// Returns:
+//   - time.Time: The time the migration was applied.
+//   - bool: Whether the migration is applied.
+//   - error: An error if the history cannot be read.
+func (m *Migrator) WhenApplied(
+	ctx context.Context, version string,
+) (time.Time, bool, error) {
+	entries, err := m.HistoryEntries(ctx)
 	if err != nil {
-		log.Printf("Error retrieving applied migrations: %v", err)
-		return nil, nil, err
+		return time.Time{}, false, err
+	}
+	var (
+		appliedAt time.Time
+		found     bool
+	)
+	for _, entry := range entries {
+		if entry.Migration.Version != version {
+			continue
+		}
+		if !found || entry.AppliedAt.After(appliedAt) {
+			appliedAt = entry.AppliedAt
+		}
+		found = true
+	}
+	return appliedAt, found, nil
+}
+
+// LastApplied returns the history entry of the applied migration with the
+// highest version, i.e. the schema version the database is on, and when it
+// was applied.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - HistoryEntry: The entry of the highest applied version.
+//   - bool: Whether any migration is applied.
+//   - error: An error if the history cannot be read.
+func (m *Migrator) LastApplied(ctx context.Context) (HistoryEntry, bool, error) {
+	entries, err := m.HistoryEntries(ctx)
+	if err != nil || len(entries) == 0 {
+		return HistoryEntry{}, false, err
+	}
+	last := slices.MaxFunc(entries, func(a, b HistoryEntry) int {
+		if c := m.compareVersions(a.Migration.Version, b.Migration.Version); c != 0 {
+			return c
+		}
+		return a.AppliedAt.Compare(b.AppliedAt)
+	})
+	return last, true, nil
+}
+
+// migrationRun holds the executors used during a single run.
+type migrationRun struct {
+	// exec executes the migration steps.
+	exec Executor
+	// history executes history writes using prepared statements that are
+	// reused for the whole run.
+	history *stmtCache
+	// batchHistory defers history records until the end of the run.
+	batchHistory bool
+	// pendingRecords holds the history entries that are deferred.
+	pendingRecords []HistoryEntry
+	// identity identifies who applies the migrations of the run.
+	identity applierIdentity
+	// searchPath is the search_path set for the run.
+	searchPath string
+	// result collects the summary of the run, if any.
+	result *Result
+	// now returns the time recorded in history entries.
+	now func() time.Time
+	// conn is the connection pinned for a non-transactional run, if any.
+	conn *sql.Conn
+	// server holds the server name and version once queried by the run.
+	server *serverVersion
+	// stopped is set when the run ended early because of RunControl.Stop.
+	stopped bool
+	// holdFrom is the first migration held back until a maintenance
+	// window opens, if any.
+	holdFrom *Migration
+	// logger receives the messages of the run.
+	logger Logger
+}
+
+// holds reports whether mig is held back until a maintenance window opens.
+func (r *migrationRun) holds(mig Migration) bool {
+	return r.holdFrom != nil && r.holdFrom.Version == mig.Version &&
+		r.holdFrom.MigrationName == mig.MigrationName
+}
+
+// newMigrationRun returns a migrationRun executing on exec.
+func newMigrationRun(exec Executor) *migrationRun {
+	return &migrationRun{
+		exec:    exec,
+		history: newStmtCache(exec),
+	}
+}
+
+// newEntry returns the history entry of a migration applied by the run.
+func (r *migrationRun) newEntry(mig Migration) HistoryEntry {
+	return HistoryEntry{
+		Migration:     mig,
+		MigrationName: mig.MigrationName,
+		AppliedAt:     nowUTC(r.now),
+		AppliedBy:     r.identity.appliedBy,
+		AppliedUser:   r.identity.user,
+		AppliedHost:   r.identity.host,
+		SearchPath:    r.searchPath,
+		Attempts:      1,
 	}
-	log.Printf("Previously applied migrations count: %d", len(applied))
+}
 
-	return all, applied, nil
+// addResult adds a migration to the result of the run, if any.
+func (r *migrationRun) addResult(res MigrationResult) {
+	if r.result != nil {
+		r.result.Migrations = append(r.result.Migrations, res)
+	}
+}
+
+// close releases the resources held by the run. A pinned connection is
+// discarded instead of being returned to the pool so that session settings
+// applied by the run do not leak into other users of the pool.
+func (r *migrationRun) close() {
+	if err := r.history.Close(); err != nil {
+		loggerOrDefault(r.logger).Printf(
+			"Error closing history statements: %v", err,
+		)
+	}
+	if r.conn != nil {
+		_ = r.conn.Raw(func(any) error { return driver.ErrBadConn })
+		_ = r.conn.Close()
+	}
 }
 
 // runMigrationsIfTransactional applies or rolls back migrations.
 func (m *Migrator) runMigrationsIfTransactional(
-	ctx context.Context, migrationFn func(exec Executor) (int, error),
+	ctx context.Context, migrationFn func(run *migrationRun) (int, error),
 ) (int, error) {
+	ctx = m.withLogger(ctx)
+	stopKeepalive := m.startKeepalive(ctx)
+	defer stopKeepalive()
+
 	// Begin transaction.
 	exec, tx, err := m.getTransactionIfTransactional(ctx)
 	if err != nil {
-		return 0, err
+		return 0, connectionLostError(err)
+	}
+	run := newMigrationRun(exec)
+	run.batchHistory = m.BatchHistory && m.runTransactional()
+	run.identity = currentIdentity(m.AppliedBy)
+	run.searchPath = m.SearchPath
+	run.now = m.NowFunc
+	run.logger = m.logger()
+	if conn, ok := exec.(*sql.Conn); ok {
+		run.conn = conn
+	}
+	defer run.close()
+
+	// Apply session settings before any step runs.
+	if err := m.applySessionSetup(ctx, run.exec); err != nil {
+		return 0, m.rollbackIfTransactional(tx, connectionLostError(err))
 	}
 
 	// Run migrations.
-	rollbackCount, err := migrationFn(exec)
+	rollbackCount, err := migrationFn(run)
 	if err != nil {
-		return 0, m.rollbackIfTransactional(tx, err)
+		return 0, m.rollbackIfTransactional(tx, connectionLostError(err))
 	}
 
 	// Commit the transaction.
 	err = m.commitIfTransactional(tx)
 	if err != nil {
-		return 0, err
+		return 0, connectionLostError(err)
 	}
 
 	return rollbackCount, nil
 }
 
 // getTransactionIfTransactional creates a transaction if transactional is true.
+// Non-transactional runs with session setup statements or SingleConnection
+// are pinned to a single connection so that the session settings apply to
+// every step.
 func (m *Migrator) getTransactionIfTransactional(
 	ctx context.Context,
 ) (Executor, *sql.Tx, error) {
-	if m.Transactional {
-		tx, err := m.DB.BeginTx(ctx, nil)
+	if m.runTransactional() {
+		opts, err := m.txOptions()
+		if err != nil {
+			return nil, nil, err
+		}
+		tx, err := m.DB.BeginTx(ctx, opts)
 		if err != nil {
 			return nil, nil, err
 		}
 		return tx, tx, nil
+	} else if m.SingleConnection || len(m.sessionStatements()) > 0 {
+		conn, err := m.DB.Conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, nil, nil
 	} else {
 		return m.DB, nil, nil
 	}
 }
 
-// rollbackIfTransactional rolls back the transaction if it exists.
+// applySessionSetup executes the session setup statements on exec.
+func (m *Migrator) applySessionSetup(ctx context.Context, exec Executor) error {
+	stmts := m.sessionStatements()
+	for _, stmt := range stmts {
+		if _, err := exec.ExecContext(ctx, stmt); err != nil {
+			shown := m.redactedSQL(stmt)
+			if m.RedactSQL != RedactOff {
+				m.logf("Error applying session setup %s", shown)
+				return fmt.Errorf("session setup %s: %w", shown, redactedError{err})
+			}
+			m.logf("Error applying session setup %q: %v", stmt, err)
+			return fmt.Errorf("session setup %q: %w", stmt, err)
+		}
+	}
+	if len(stmts) > 0 {
+		m.logf("Applied %d session setup statements", len(stmts))
+	}
+	return nil
+}
+
+// sessionStatements returns the statements run before any step: the
+// search_path setting, if any, followed by the session setup statements. In
+// a transaction the search_path is set with SET LOCAL so that it does not
+// outlive the run on a pooled connection.
+func (m *Migrator) sessionStatements() []string {
+	if m.SearchPath == "" {
+		return m.SessionSetup
+	}
+	set := "SET search_path TO "
+	if m.runTransactional() {
+		set = "SET LOCAL search_path TO "
+	}
+	return append([]string{set + m.SearchPath}, m.SessionSetup...)
+}
+
+// rollbackIfTransactional rolls back the transaction if it exists. A
+// transaction that database/sql already rolled back because the context of
+// the run was cancelled counts as rolled back.
 func (m *Migrator) rollbackIfTransactional(tx *sql.Tx, err error) error {
-	if m.Transactional {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			log.Printf("Error rolling back transaction: %v", rbErr)
+	if m.runTransactional() {
+		if rbErr := tx.Rollback(); rbErr != nil &&
+			!errors.Is(rbErr, sql.ErrTxDone) {
+			m.logf("Error rolling back transaction: %v", rbErr)
 			return fmt.Errorf(
-				"rollbackIfTransactional: error processing migration: %v, "+
+				"rollbackIfTransactional: error processing migration: %w, "+
 					"also error rolling back transaction: %v",
 				err,
 				rbErr,
 			)
 		}
-		log.Printf("Error processing migration: %v", err)
+		m.logf("Error processing migration: %v", err)
 	}
 
 	return err
@@ -434,9 +1440,9 @@ func (m *Migrator) rollbackIfTransactional(tx *sql.Tx, err error) error {
 
 // commitIfTransactional commits the transaction if it exists.
 func (m *Migrator) commitIfTransactional(tx *sql.Tx) error {
-	if m.Transactional {
+	if m.runTransactional() {
 		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
+			m.logf("Error committing transaction: %v", err)
 			return err
 		}
 	}
@@ -446,22 +1452,75 @@ func (m *Migrator) commitIfTransactional(tx *sql.Tx) error {
 // applyMigrations applies migrations a slice of migrations to the database.
 func (m *Migrator) applyMigrations(
 	ctx context.Context,
-	exec Executor,
+	run *migrationRun,
 	all []Migration,
-	applied map[string]bool,
+	applied appliedSet,
 	target string,
 ) (int, error) {
 	count := 0
 	for _, mig := range all {
-		if applied[mig.Version] {
-			log.Printf("Skip applied migration %s: %s", mig.Version, mig.Name)
+		if applied.has(mig) {
+			m.logf("Skip applied migration %s: %s", mig.Version, mig.Name)
+			continue
+		}
+		if m.beforeRange(mig) {
+			m.logf("Skip migration %s: before the version range", mig.Version)
 			continue
 		}
 		if m.isTargetReached(target, mig, "up") {
 			break
 		}
+		if m.excluded(mig, run.result) {
+			continue
+		}
+		if run.holds(mig) {
+			m.logf("Migration %s waits for a maintenance window", mig.Version)
+			break
+		}
+		if m.RunControl.stopRequested() {
+			m.logf("Run stopped before migration %s", mig.Version)
+			run.stopped = true
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			m.logf("Run cancelled before migration %s", mig.Version)
+			return 0, fmt.Errorf("before migration %s: %w", mig.Version, err)
+		}
+		if err := mig.resolveAnnotations(); err != nil {
+			return 0, err
+		}
+		ok, err := m.checkRequirements(ctx, run, mig)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			m.logf("Skip migration %s: server requirement not met", mig.Version)
+			continue
+		}
+		if count == 0 {
+			if err := m.runHooks(
+				ctx, run, "before-all", m.BeforeAll, "up",
+			); err != nil {
+				return 0, err
+			}
+		}
 		count++
-		if err := m.executeAndRecordMigration(ctx, exec, mig); err != nil {
+		if err := m.inMigrationTx(ctx, run, mig, func() error {
+			return m.executeWithHooks(ctx, run, mig, "up", func() error {
+				return m.executeAndRecordMigration(ctx, run, mig)
+			})
+		}); err != nil {
+			return 0, err
+		}
+		applied.set(mig, true)
+	}
+	if err := m.recordBatchedMigrations(ctx, run); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		if err := m.runHooks(
+			ctx, run, "after-all", m.AfterAll, "up",
+		); err != nil {
 			return 0, err
 		}
 	}
@@ -472,22 +1531,55 @@ func (m *Migrator) applyMigrations(
 // rollbackMigrations rolls back a slice of migrations from the database.
 func (m *Migrator) rollbackMigrations(
 	ctx context.Context,
-	exec Executor,
+	run *migrationRun,
 	all []Migration,
-	applied map[string]bool,
+	applied appliedSet,
 	target string,
 ) (int, error) {
 	count := 0
 	for _, mig := range all {
-		if !applied[mig.Version] {
-			log.Printf("Skip unapplied migration %s: %s", mig.Version, mig.Name)
+		if !applied.has(mig) {
+			m.logf("Skip unapplied migration %s: %s", mig.Version, mig.Name)
 			continue
 		}
 		if m.isTargetReached(target, mig, "down") {
 			break
 		}
+		if m.excluded(mig, run.result) {
+			continue
+		}
+		if m.RunControl.stopRequested() {
+			m.logf("Run stopped before rolling back migration %s", mig.Version)
+			run.stopped = true
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			m.logf("Run cancelled before rolling back migration %s", mig.Version)
+			return 0, fmt.Errorf(
+				"before rolling back migration %s: %w", mig.Version, err,
+			)
+		}
+		if count == 0 {
+			if err := m.runHooks(
+				ctx, run, "before-all", m.BeforeAll, "down",
+			); err != nil {
+				return 0, err
+			}
+		}
 		count++
-		if err := m.rollbackAndRemoveMigration(ctx, exec, mig); err != nil {
+		if err := m.inMigrationTx(ctx, run, mig, func() error {
+			return m.executeWithHooks(ctx, run, mig, "down", func() error {
+				return m.rollbackAndRemoveMigration(ctx, run, mig)
+			})
+		}); err != nil {
+			return 0, err
+		}
+		applied.set(mig, false)
+	}
+	if count > 0 {
+		if err := m.runHooks(
+			ctx, run, "after-all", m.AfterAll, "down",
+		); err != nil {
 			return 0, err
 		}
 	}
@@ -500,11 +1592,9 @@ func (m *Migrator) isTargetReached(
 	target string, mig Migration, direction string,
 ) bool {
 	if target != "" {
-		t, _ := strconv.Atoi(target)
-		v, _ := strconv.Atoi(mig.Version)
-
-		if (direction == "up" && v > t) || (direction == "down" && v < t) {
-			log.Printf(
+		c := m.compareVersions(mig.Version, target)
+		if (direction == "up" && c > 0) || (direction == "down" && c < 0) {
+			m.logf(
 				"Reached target version. Stopping at migration %s",
 				mig.Version,
 			)
@@ -516,88 +1606,296 @@ func (m *Migrator) isTargetReached(
 
 // executeAndRecordMigration executes a migration and records it.
 func (m *Migrator) executeAndRecordMigration(
-	ctx context.Context, exec Executor, mig Migration,
+	ctx context.Context, run *migrationRun, mig Migration,
 ) error {
-	log.Printf("Beginning migration %s: %s", mig.Version, mig.Name)
+	m.logf("Beginning migration %s: %s%s", mig.Version, mig.Name, mig.details())
+	res := newMigrationResult(mig)
+	m.emit(Event{Type: EventMigrationStarted, Direction: "up", Migration: &res})
 
 	// Execute the migration.
-	if err := executeSteps(
-		ctx, exec, mig.UpSteps, mig.Version, "up",
-	); err != nil {
+	checkpoint, err := m.loadCheckpoint(ctx, run.history, mig)
+	if err != nil {
+		return err
+	}
+	exec, audit := m.auditExec(run)
+	exec, recorder := m.previewExec(exec)
+	start := time.Now()
+	rows, retries, err := m.executeSteps(
+		ctx, run.exec, exec, mig, "up", checkpoint,
+	)
+	if err != nil {
+		return err
+	}
+	res.RowsAffected = rows
+	res.SQLPreview = recorder.preview()
+	if err := m.recordAudit(ctx, run, audit, mig, "up"); err != nil {
 		return err
 	}
 
-	// Record the applied migration.
-	if err := m.HistoryManager.RecordMigration(
-		ctx, exec, m.HistoryTable, mig, m.MigrationName,
-	); err != nil {
-		log.Printf("Error recording migration %s: %v", mig.Version, err)
+	// Record the applied migration, or defer it when batching.
+	entry := run.newEntry(mig)
+	entry.Duration = time.Since(start)
+	entry.Attempts += retries
+	if checkpoint.resumed() {
+		entry.Attempts++
+	}
+	res.Duration = entry.Duration
+	if run.batchHistory {
+		run.pendingRecords = append(run.pendingRecords, entry)
+		m.logf("Migration %s applied, history record deferred", mig.Version)
+	} else {
+		if err := m.recordEntries(ctx, run, []HistoryEntry{entry}); err != nil {
+			return err
+		}
+		if err := checkpoint.clear(ctx); err != nil {
+			return err
+		}
+		m.logf("Migration %s applied successfully", mig.Version)
+	}
+	run.addResult(res)
+	m.emit(Event{Type: EventMigrationApplied, Direction: "up", Migration: &res})
+	return nil
+}
+
+// recordBatchedMigrations writes the history records deferred during the run.
+func (m *Migrator) recordBatchedMigrations(
+	ctx context.Context, run *migrationRun,
+) error {
+	if len(run.pendingRecords) == 0 {
+		return nil
+	}
+	if err := m.recordEntries(ctx, run, run.pendingRecords); err != nil {
 		return err
 	}
+	m.logf("Recorded %d batched migrations", len(run.pendingRecords))
+	run.pendingRecords = nil
+	return nil
+}
+
+// recordEntries writes history entries using the richest interface the
+// history manager implements: EntryHistoryManager, BatchHistoryManager for
+// several entries, or RecordMigration for each entry.
+func (m *Migrator) recordEntries(
+	ctx context.Context, run *migrationRun, entries []HistoryEntry,
+) error {
+	if recorder, ok := m.HistoryManager.(EntryHistoryManager); ok {
+		if err := recorder.RecordEntries(
+			ctx, run.history, m.HistoryTable, entries,
+		); err != nil {
+			m.logf("Error recording migrations: %v", err)
+			return recordError(entries, err)
+		}
+		return nil
+	}
 
-	log.Printf("Migration %s applied successfully", mig.Version)
+	batcher, ok := m.HistoryManager.(BatchHistoryManager)
+	if ok && len(entries) > 1 {
+		// Each batch shares one migration name.
+		for len(entries) > 0 {
+			n := 1
+			for n < len(entries) &&
+				entries[n].MigrationName == entries[0].MigrationName {
+				n++
+			}
+			migs := make([]Migration, n)
+			for i, entry := range entries[:n] {
+				migs[i] = entry.Migration
+			}
+			if err := batcher.RecordMigrations(
+				ctx, run.history, m.HistoryTable, migs, entries[0].MigrationName,
+			); err != nil {
+				m.logf("Error recording batched migrations: %v", err)
+				return recordError(entries[:n], err)
+			}
+			entries = entries[n:]
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		if err := m.HistoryManager.RecordMigration(
+			ctx,
+			run.history,
+			m.HistoryTable,
+			entry.Migration,
+			entry.MigrationName,
+		); err != nil {
+			m.logf(
+				"Error recording migration %s: %v", entry.Migration.Version, err,
+			)
+			return recordError([]HistoryEntry{entry}, err)
+		}
+	}
 	return nil
 }
 
+// recordError returns the error of recording entries as a
+// *MigrationError of the first entry.
+func recordError(entries []HistoryEntry, err error) error {
+	label := "history record"
+	if len(entries) > 1 {
+		label = fmt.Sprintf("history record of %d migrations", len(entries))
+	}
+	return historyError(entries[0].Migration, "up", label, err)
+}
+
 // rollbackAndRemoveMigration rolls back a migration and removes its record.
 func (m *Migrator) rollbackAndRemoveMigration(
-	ctx context.Context, exec Executor, mig Migration,
+	ctx context.Context, run *migrationRun, mig Migration,
 ) error {
-	log.Printf("Rolling back migration %s: %s", mig.Version, mig.Name)
+	m.logf("Rolling back migration %s: %s", mig.Version, mig.Name)
+	res := newMigrationResult(mig)
+	m.emit(Event{Type: EventMigrationStarted, Direction: "down", Migration: &res})
 
-	if err := executeSteps(
-		ctx, exec, mig.DownSteps, mig.Version, "down",
-	); err != nil {
+	exec, audit := m.auditExec(run)
+	exec, recorder := m.previewExec(exec)
+	start := time.Now()
+	rows, _, err := m.executeSteps(ctx, run.exec, exec, mig, "down", nil)
+	if err != nil {
+		return err
+	}
+	res.RowsAffected = rows
+	res.SQLPreview = recorder.preview()
+	res.Duration = time.Since(start)
+	if err := m.recordAudit(ctx, run, audit, mig, "down"); err != nil {
 		return err
 	}
 	if err := m.HistoryManager.RemoveMigration(
-		ctx, exec, m.HistoryTable, mig, m.MigrationName,
+		ctx, run.history, m.HistoryTable, mig, mig.MigrationName,
 	); err != nil {
-		log.Printf(
+		m.logf(
 			"Error removing migration record for %s: %v", mig.Version, err,
 		)
-		return err
+		return historyError(mig, "down", "history record removal", err)
+	}
+	if m.StepHistory {
+		if err := m.removeSteps(ctx, run.history, mig); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Migration %s rolled back successfully", mig.Version)
+	m.logf("Migration %s rolled back successfully", mig.Version)
+	run.addResult(res)
+	m.emit(Event{
+		Type: EventMigrationRolledBack, Direction: "down", Migration: &res,
+	})
 	return nil
 }
 
-// executeSteps executes a slice of migration steps in the given direction.
-func executeSteps(
+// executeSteps executes the steps of a migration in the given direction.
+// It returns the rows affected by each step and the number of failed step
+// executions that were retried. Step failures are returned as
+// *MigrationError. Steps completed according to checkpoint are skipped and
+// reported with zero rows affected. Retry savepoints are executed on tx,
+// which is not wrapped like exec. The steps share a new StepValues and run
+// with the context returned by HookContext, carrying the migration's bind
+// variables.
+func (m *Migrator) executeSteps(
 	ctx context.Context,
+	tx Executor,
 	exec Executor,
-	steps []MigrationStep,
-	migVersion string,
+	mig Migration,
 	direction string,
-) error {
+	checkpoint *stepCheckpoint,
+) ([]int64, int, error) {
+	mig, err := m.verifiedMigration(mig)
+	if err != nil {
+		return nil, 0, err
+	}
+	steps := mig.UpSteps
+	if direction == "down" {
+		steps = mig.DownSteps
+	}
+	ctx = withBindVars(m.stepContext(ctx, tx), m.bindVars(mig))
+	rows := make([]int64, 0, len(steps))
+	retries := 0
 	for idx, step := range steps {
-		log.Printf(
+		if checkpoint.completed(idx + 1) {
+			m.logf(
+				"Skip %s step %d for migration %s, completed by an earlier run",
+				direction,
+				idx+1,
+				mig.Version,
+			)
+			rows = append(rows, 0)
+			continue
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = m.RunControl.wait(ctx)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf(
+				"before %s step %d of migration %s: %w",
+				direction, idx+1, mig.Version, err,
+			)
+		}
+		m.logf(
 			"Executing %s step %d for migration %s",
 			direction,
 			idx+1,
-			migVersion,
+			mig.Version,
 		)
-		var err error
-		if direction == "up" {
-			err = step.ExecuteUp(ctx, exec)
-		} else {
-			err = step.ExecuteDown(ctx, exec)
-		}
+		tracker := &queryTracker{exec: exec}
+		stepStart := time.Now()
+		stepCtx := m.withProgress(ctx, mig, direction, idx+1, stepStart)
+		retried, err := m.retryStep(ctx, tx, mig, idx+1, func() error {
+			*tracker = queryTracker{exec: exec}
+			if direction == "up" {
+				return step.ExecuteUp(stepCtx, withQueryer(tracker, exec, nil))
+			}
+			return step.ExecuteDown(stepCtx, withQueryer(tracker, exec, nil))
+		})
+		retries += retried
 		if err != nil {
-			return err
+			statement, line := locateStatement(tracker.last, err.Error())
+			var stmtErr *statementError
+			if errors.As(err, &stmtErr) {
+				statement, line = stmtErr.index, stmtErr.line
+			}
+			stepErr := &MigrationError{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				Direction: direction,
+				Step:      idx + 1,
+				Label:     stepLabel(step),
+				Statement: statement,
+				Line:      line,
+				SQL:       m.redactedSQL(tracker.last),
+				Preview:   m.sqlPreview(tracker.last, err),
+				Redacted:  m.RedactSQL != RedactOff,
+				Err:       err,
+			}
+			m.logf("Error executing step: %v", stepErr)
+			m.emitStep(EventStepFailed, mig, direction, idx+1, stepStart, stepErr)
+			return nil, 0, stepErr
+		}
+		m.emitStep(EventStepCompleted, mig, direction, idx+1, stepStart, nil)
+		rows = append(rows, tracker.rows)
+		if err := checkpoint.record(
+			ctx, idx+1, time.Since(stepStart),
+		); err != nil {
+			return nil, 0, err
 		}
-		log.Printf(
+		m.logf(
 			"Successfully executed %s step %d for migration %s",
 			direction,
 			idx+1,
-			migVersion,
+			mig.Version,
 		)
 	}
-	log.Printf(
+	m.logf(
 		"Successfully executed all %s steps for migration %s",
 		direction,
-		migVersion,
+		mig.Version,
 	)
-	return nil
+	return rows, retries, nil
+}
+
+// redactedSQL returns sql as it may be shown in log output and errors.
+func (m *Migrator) redactedSQL(sql string) string {
+	if sql == "" {
+		return ""
+	}
+	return m.RedactSQL.apply(sql)
 }
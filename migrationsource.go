@@ -3,12 +3,13 @@ package migrator
 import (
 	"context"
 	"fmt"
-	"log"
+	"io/fs"
+	"iter"
 	"os"
 	"path"
-	"sort"
-	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"slices"
 )
@@ -27,25 +28,41 @@ type ParseFilenameFn func(filename string) (
 )
 
 // defaultParseFilename is the built-in parser that expects file names in the
-// format "001_create_table_up.sql" or "001_create_table_down.sql".
+// format "001_create_table_up.sql" or "001_create_table_down.sql". Names
+// with an empty version or name, whitespace in the version, control
+// characters, path separators or invalid UTF-8 are rejected.
 func defaultParseFilename(filename string) (string, string, string, bool) {
+	if !utf8.ValidString(filename) || strings.ContainsAny(filename, `/\`) ||
+		strings.IndexFunc(filename, unicode.IsControl) >= 0 {
+		return "", "", "", false
+	}
 	base := strings.TrimSuffix(filename, path.Ext(filename))
 	parts := strings.Split(base, "_")
 	if len(parts) < 3 {
 		return "", "", "", false
 	}
 	version := parts[0]
+	if version == "" || strings.IndexFunc(version, unicode.IsSpace) >= 0 {
+		return "", "", "", false
+	}
 	direction := strings.ToLower(parts[len(parts)-1])
 	if direction != "up" && direction != "down" {
 		return "", "", "", false
 	}
 	name := strings.Join(parts[1:len(parts)-1], "_")
+	if name == "" {
+		return "", "", "", false
+	}
 	return version, name, direction, true
 }
 
-// SQLMigrationStep executes a plain SQL statement.
+// SQLMigrationStep executes a plain SQL statement, binding Args if set.
 type SQLMigrationStep struct {
-	SQL string
+	SQL  string
+	Args []any
+	// NoSplit executes SQL in one call even if the Migrator splits
+	// statements, see WithStatementSplitting.
+	NoSplit bool
 }
 
 // NewSQLMigrationStep returns a new SQLMigrationStep.
@@ -74,6 +91,21 @@ func (s *SQLMigrationStep) WithSQL(sql string) *SQLMigrationStep {
 	return &new
 }
 
+// WithSplit returns a new SQLMigrationStep whose SQL is split into
+// statements when the Migrator splits statements, or always executed in
+// one call if enabled is false.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *SQLMigrationStep: A new SQLMigrationStep.
+func (s *SQLMigrationStep) WithSplit(enabled bool) *SQLMigrationStep {
+	new := *s
+	new.NoSplit = !enabled
+	return &new
+}
+
 // ExecuteUp executes the SQL query for upward migration.
 //
 // Parameters:
@@ -83,8 +115,7 @@ func (s *SQLMigrationStep) WithSQL(sql string) *SQLMigrationStep {
 // Returns:
 //   - error: An error if the query execution fails.
 func (s SQLMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
-	_, err := exec.ExecContext(ctx, s.SQL)
-	return err
+	return s.execute(ctx, exec)
 }
 
 // ExecuteDown executes the SQL query for downward migration.
@@ -98,8 +129,96 @@ func (s SQLMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
 func (s SQLMigrationStep) ExecuteDown(
 	ctx context.Context, exec Executor,
 ) error {
-	_, err := exec.ExecContext(ctx, s.SQL)
-	return err
+	return s.execute(ctx, exec)
+}
+
+// execute executes the SQL with its args bound.
+func (s SQLMigrationStep) execute(ctx context.Context, exec Executor) error {
+	return execSQL(ctx, exec, s.SQL, s.Args, s.NoSplit)
+}
+
+// FileSQLMigrationStep executes the SQL stored in a file. The file is read
+// only when the step is executed.
+type FileSQLMigrationStep struct {
+	Path string
+	// FS is the file system holding Path, nil for the operating system's.
+	FS fs.FS
+	// NoSplit executes the file in one call even if the Migrator splits
+	// statements, see WithStatementSplitting.
+	NoSplit bool
+}
+
+// NewFileSQLMigrationStep returns a new FileSQLMigrationStep.
+//
+// Parameters:
+//   - filePath: The path of the SQL file to execute.
+//
+// Returns:
+//   - *FileSQLMigrationStep: A new FileSQLMigrationStep.
+func NewFileSQLMigrationStep(filePath string) *FileSQLMigrationStep {
+	return &FileSQLMigrationStep{
+		Path: filePath,
+	}
+}
+
+// WithSplit returns a new FileSQLMigrationStep whose SQL is split into
+// statements when the Migrator splits statements, or always executed in
+// one call if enabled is false.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *FileSQLMigrationStep: A new FileSQLMigrationStep.
+func (f *FileSQLMigrationStep) WithSplit(enabled bool) *FileSQLMigrationStep {
+	new := *f
+	new.NoSplit = !enabled
+	return &new
+}
+
+// ExecuteUp reads the file and executes its SQL for upward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if reading the file or executing the query fails.
+func (f FileSQLMigrationStep) ExecuteUp(
+	ctx context.Context, exec Executor,
+) error {
+	return f.execute(ctx, exec)
+}
+
+// ExecuteDown reads the file and executes its SQL for downward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if reading the file or executing the query fails.
+func (f FileSQLMigrationStep) ExecuteDown(
+	ctx context.Context, exec Executor,
+) error {
+	return f.execute(ctx, exec)
+}
+
+// execute reads the file content and executes it with the bind variables
+// of the migration bound.
+func (f FileSQLMigrationStep) execute(
+	ctx context.Context, exec Executor,
+) error {
+	content, err := f.readFile(f.Path)
+	if err != nil {
+		return err
+	}
+	return execSQL(ctx, exec, string(content), nil, f.NoSplit)
+}
+
+// readFile reads the named file from the file system of the step.
+func (f FileSQLMigrationStep) readFile(name string) ([]byte, error) {
+	return readFile(f.FS, name)
 }
 
 // HookMigrationStep executes custom hook functions.
@@ -184,6 +303,25 @@ type DirMigrationSource struct {
 	AllowedExts []string
 	// Optional ResolveHooks returns hook functions for the given filename.
 	ResolveHooks func(filename string) (preHook FileHookFn, postHook FileHookFn)
+	// Checksums enables computing Migration.Checksum at load time.
+	Checksums bool
+	// ChecksumWorkers bounds the concurrent checksum computations, defaults
+	// to GOMAXPROCS.
+	ChecksumWorkers int
+	// Optional MigrationName overrides the Migrator's migration name for
+	// the migrations of this source.
+	MigrationName string
+	// Optional Logger receives log messages, defaults to the standard
+	// logger.
+	Logger Logger
+	// NoSplit executes the SQL of every migration in one call even if the
+	// Migrator splits statements, see WithStatementSplitting.
+	NoSplit bool
+	// Optional VersionComparator orders the migrations returned by the
+	// source, defaults to CompareNumeric.
+	VersionComparator VersionComparator
+	// fsys is the file system holding Dir, nil for the operating system's.
+	fsys fs.FS
 }
 
 // NewDirMigrationSource creates a new DirMigrationSource for the given
@@ -233,15 +371,196 @@ func (d *DirMigrationSource) WithAllowedExts(
 	return &new
 }
 
+// WithChecksums returns a new DirMigrationSource with checksum computation
+// enabled or disabled. Checksums are computed concurrently at load time.
+//
+// Parameters:
+//   - enabled: Whether to compute checksums.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithChecksums(enabled bool) *DirMigrationSource {
+	new := *d
+	new.Checksums = enabled
+	return &new
+}
+
+// WithChecksumWorkers returns a new DirMigrationSource with the given number
+// of concurrent checksum workers.
+//
+// Parameters:
+//   - workers: The maximum number of concurrent workers.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithChecksumWorkers(
+	workers int,
+) *DirMigrationSource {
+	new := *d
+	new.ChecksumWorkers = workers
+	return &new
+}
+
+// dirEntry is a parsed migration file of a DirMigrationSource.
+type dirEntry struct {
+	version   string
+	name      string
+	direction string
+	filename  string
+}
+
+// WithMigrationName returns a new DirMigrationSource whose migrations are
+// recorded under the given migration name instead of the Migrator's.
+//
+// Parameters:
+//   - migrationName: The migration name (history namespace) to use.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithMigrationName(
+	migrationName string,
+) *DirMigrationSource {
+	new := *d
+	new.MigrationName = migrationName
+	return &new
+}
+
+// WithLogger returns a new DirMigrationSource logging to logger instead of the
+// standard logger.
+//
+// Parameters:
+//   - logger: The logger to use, e.g. DiscardLogger.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithLogger(logger Logger) *DirMigrationSource {
+	new := *d
+	new.Logger = logger
+	return &new
+}
+
+// WithSplit returns a new DirMigrationSource whose SQL steps are split into
+// statements when the Migrator splits statements, or always executed in one
+// call if enabled is false, e.g. for files with trigger bodies.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithSplit(enabled bool) *DirMigrationSource {
+	new := *d
+	new.NoSplit = !enabled
+	return &new
+}
+
+// WithVersionComparator returns a new DirMigrationSource returning its
+// migrations in the order of compare. The Migrator orders the migrations of
+// all sources with its own comparator.
+//
+// Parameters:
+//   - compare: The comparator to use, nil for CompareNumeric.
+//
+// Returns:
+//   - *DirMigrationSource: A new DirMigrationSource instance.
+func (d *DirMigrationSource) WithVersionComparator(
+	compare VersionComparator,
+) *DirMigrationSource {
+	new := *d
+	new.VersionComparator = compare
+	return &new
+}
+
 // LoadMigrations loads and merges migrations from the directory.
 //
 // Returns:
 //   - []Migration: A slice containing the loaded migrations.
 //   - error: An error if loading fails.
 func (d *DirMigrationSource) LoadMigrations() ([]Migration, error) {
-	entries, err := os.ReadDir(d.Dir)
+	migrations, _, err := d.LoadMigrationsWithWarnings()
+	return migrations, err
+}
+
+// LoadMigrationsWithWarnings loads and merges migrations from the directory
+// and reports skipped files whose names cannot be parsed.
+//
+// Returns:
+//   - []Migration: A slice containing the loaded migrations.
+//   - []Warning: The warnings found.
+//   - error: An error if loading fails.
+func (d *DirMigrationSource) LoadMigrationsWithWarnings() (
+	[]Migration, []Warning, error,
+) {
+	entries, warnings, err := d.scan()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	groups := groupDirEntries(entries)
+	migrations := make([]Migration, 0, len(groups))
+	for _, group := range groups {
+		migrations = append(migrations, d.buildMigration(group))
+	}
+
+	if d.Checksums {
+		jobs := make([][]checksumFile, len(groups))
+		for i, group := range groups {
+			jobs[i] = d.checksumFiles(group)
+		}
+		sums, err := computeChecksums(jobs, d.ChecksumWorkers)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range migrations {
+			migrations[i].Checksum = sums[i]
+		}
+	}
+
+	loggerOrDefault(d.Logger).Printf(
+		"Loaded %d migrations from directory %s", len(migrations), d.Dir,
+	)
+	return migrations, warnings, nil
+}
+
+// Migrations returns an iterator over the migrations of the directory in
+// version order. Unlike LoadMigrations, only the file names are held in
+// memory; each migration is built when it is yielded. Checksums, if enabled,
+// are computed sequentially as migrations are yielded.
+//
+// Returns:
+//   - iter.Seq2[Migration, error]: An iterator over the migrations. A
+//     non-nil error is yielded once and ends the iteration.
+func (d *DirMigrationSource) Migrations() iter.Seq2[Migration, error] {
+	return func(yield func(Migration, error) bool) {
+		entries, _, err := d.scan()
+		if err != nil {
+			yield(Migration{}, err)
+			return
+		}
+		for _, group := range groupDirEntries(entries) {
+			mig := d.buildMigration(group)
+			if d.Checksums {
+				mig.Checksum, err = checksumFiles(d.checksumFiles(group))
+				if err != nil {
+					yield(Migration{}, err)
+					return
+				}
+			}
+			if !yield(mig, nil) {
+				return
+			}
+		}
+	}
+}
+
+// scan reads the directory and returns the parsed migration files sorted by
+// version. Files of the same version keep their file name order. Files with
+// an allowed extension whose names cannot be parsed are skipped and
+// reported as warnings.
+func (d *DirMigrationSource) scan() ([]dirEntry, []Warning, error) {
+	files, err := readDir(d.fsys, d.Dir)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	parser := d.FilenameParser
@@ -253,41 +572,92 @@ func (d *DirMigrationSource) LoadMigrations() ([]Migration, error) {
 		allowed = []string{".sql", ".sqlite"}
 	}
 
-	mMap := make(map[string]*Migration)
-	for _, entry := range entries {
-		if entry.IsDir() {
+	entries := make([]dirEntry, 0, len(files))
+	var warnings []Warning
+	for _, file := range files {
+		if file.IsDir() {
 			continue
 		}
-		name := entry.Name()
+		name := file.Name()
 		ext := strings.ToLower(path.Ext(name))
 		if !slices.Contains(allowed, ext) {
-			log.Printf("Skipping file %s due to unsupported ext %s", name, ext)
+			loggerOrDefault(d.Logger).Printf(
+				"Skipping file %s due to unsupported ext %s", name, ext,
+			)
 			continue
 		}
 		version, migName, direction, ok := parser(name)
 		if !ok {
-			log.Printf("Skipping file %s due to parsing failure", name)
+			loggerOrDefault(d.Logger).Printf(
+				"Skipping file %s due to parsing failure", name,
+			)
+			warnings = append(warnings, Warning{
+				Code: WarningUnparseableFile,
+				Message: fmt.Sprintf(
+					"skipped file %s: name does not match the filename format",
+					name,
+				),
+				File: path.Join(d.Dir, name),
+			})
 			continue
 		}
+		if direction != "up" && direction != "down" {
+			return nil, nil, fmt.Errorf("invalid direction: %s", direction)
+		}
+		entries = append(entries, dirEntry{
+			version:   version,
+			name:      migName,
+			direction: direction,
+			filename:  name,
+		})
+	}
+
+	compare := d.VersionComparator
+	if compare == nil {
+		compare = compareVersions
+	}
+	slices.SortStableFunc(entries, func(a, b dirEntry) int {
+		return compare(a.version, b.version)
+	})
+	return entries, warnings, nil
+}
 
-		mig, exists := mMap[version]
-		if !exists {
-			mig = NewMigration(version, migName)
-			mMap[version] = mig
+// groupDirEntries splits sorted entries into groups sharing a version.
+func groupDirEntries(entries []dirEntry) [][]dirEntry {
+	var groups [][]dirEntry
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].version == entries[start].version {
+			end++
 		}
+		groups = append(groups, entries[start:end])
+		start = end
+	}
+	return groups
+}
 
-		fullPath := path.Join(d.Dir, name)
-		content, err := os.ReadFile(fullPath)
-		if err != nil {
-			return nil, err
+// buildMigration builds the migration of a group of entries sharing a
+// version. The name is taken from the first entry. Directions are validated
+// by scan.
+func (d *DirMigrationSource) buildMigration(group []dirEntry) Migration {
+	mig := NewMigration(group[0].version, group[0].name)
+	mig.MigrationName = d.MigrationName
+	for _, entry := range group {
+		if entry.direction == "up" && mig.loadAnnotations == nil {
+			mig.loadAnnotations = fileAnnotationsLoader(
+				d.fsys, path.Join(d.Dir, entry.filename),
+			)
 		}
+		// The file content is read lazily by the step so that migrations
+		// which are already applied never touch the file system.
+		fullPath := path.Join(d.Dir, entry.filename)
 
 		var preHook, postHook FileHookFn
 		if d.ResolveHooks != nil {
-			preHook, postHook = d.ResolveHooks(name)
+			preHook, postHook = d.ResolveHooks(entry.filename)
 		}
 
-		switch direction {
+		switch entry.direction {
 		case "up":
 			if preHook != nil {
 				preStep := NewHookMigrationStep().WithUpHook(
@@ -299,7 +669,9 @@ func (d *DirMigrationSource) LoadMigrations() ([]Migration, error) {
 			}
 			mig.UpSteps = append(
 				mig.UpSteps,
-				NewSQLMigrationStep(string(content)),
+				&FileSQLMigrationStep{
+					Path: fullPath, FS: d.fsys, NoSplit: d.NoSplit,
+				},
 			)
 			if postHook != nil {
 				postStep := NewHookMigrationStep().WithUpHook(
@@ -320,7 +692,9 @@ func (d *DirMigrationSource) LoadMigrations() ([]Migration, error) {
 			}
 			mig.DownSteps = append(
 				mig.DownSteps,
-				NewSQLMigrationStep(string(content)),
+				&FileSQLMigrationStep{
+					Path: fullPath, FS: d.fsys, NoSplit: d.NoSplit,
+				},
 			)
 			if postHook != nil {
 				postStep := NewHookMigrationStep().WithDownHook(
@@ -330,25 +704,30 @@ func (d *DirMigrationSource) LoadMigrations() ([]Migration, error) {
 				)
 				mig.DownSteps = append(mig.DownSteps, postStep)
 			}
-		default:
-			return nil, fmt.Errorf("invalid direction: %s", direction)
 		}
 	}
+	return *mig
+}
 
-	var migrations []Migration
-	for _, mig := range mMap {
-		migrations = append(migrations, *mig)
+// checksumFiles returns the files contributing to the checksum of a group.
+func (d *DirMigrationSource) checksumFiles(group []dirEntry) []checksumFile {
+	files := make([]checksumFile, len(group))
+	for i, entry := range group {
+		files[i] = checksumFile{
+			direction: entry.direction,
+			path:      path.Join(d.Dir, entry.filename),
+			fsys:      d.fsys,
+		}
 	}
-	sort.Slice(migrations, func(i, j int) bool {
-		vi, _ := strconv.Atoi(migrations[i].Version)
-		vj, _ := strconv.Atoi(migrations[j].Version)
-		return vi < vj
-	})
-	log.Printf("Loaded %d migrations from directory %s", len(migrations), d.Dir)
-	return migrations, nil
+	return files
 }
 
 // FileMigrationSource loads a single migration file and supports optional hooks.
+// The up SQL is separated from the down SQL by a "-- DOWN" line, or the file
+// is divided into sections by sql-migrate style "-- +migrate Up" and
+// "-- +migrate Down" lines, each section becoming a step of its own.
+// Sections holding a "-- +migrate StatementBegin" line are never split into
+// statements.
 type FileMigrationSource struct {
 	FilePath string
 	// Optional filename parser, defaults to defaultParseFilename
@@ -357,6 +736,14 @@ type FileMigrationSource struct {
 	PreHook FileHookFn
 	// Optional post-hook.
 	PostHook FileHookFn
+	// Optional MigrationName overrides the Migrator's migration name.
+	MigrationName string
+	// Optional Logger receives log messages, defaults to the standard
+	// logger.
+	Logger Logger
+	// NoSplit executes the SQL of every migration in one call even if the
+	// Migrator splits statements, see WithStatementSplitting.
+	NoSplit bool
 }
 
 // NewFileMigrationSource returns a new FileMigrationSource.
@@ -414,6 +801,51 @@ func (f *FileMigrationSource) WithPostHook(
 	return &new
 }
 
+// WithMigrationName returns a new FileMigrationSource whose migration is
+// recorded under the given migration name instead of the Migrator's.
+//
+// Parameters:
+//   - migrationName: The migration name (history namespace) to use.
+//
+// Returns:
+//   - *FileMigrationSource: A new FileMigrationSource instance.
+func (f *FileMigrationSource) WithMigrationName(
+	migrationName string,
+) *FileMigrationSource {
+	new := *f
+	new.MigrationName = migrationName
+	return &new
+}
+
+// WithLogger returns a new FileMigrationSource logging to logger instead of the
+// standard logger.
+//
+// Parameters:
+//   - logger: The logger to use, e.g. DiscardLogger.
+//
+// Returns:
+//   - *FileMigrationSource: A new FileMigrationSource instance.
+func (f *FileMigrationSource) WithLogger(logger Logger) *FileMigrationSource {
+	new := *f
+	new.Logger = logger
+	return &new
+}
+
+// WithSplit returns a new FileMigrationSource whose SQL steps are split into
+// statements when the Migrator splits statements, or always executed in one
+// call if enabled is false, e.g. for files with trigger bodies.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *FileMigrationSource: A new FileMigrationSource instance.
+func (f *FileMigrationSource) WithSplit(enabled bool) *FileMigrationSource {
+	new := *f
+	new.NoSplit = !enabled
+	return &new
+}
+
 // LoadMigrations loads the migration from the file.
 //
 // Returns:
@@ -424,12 +856,7 @@ func (f *FileMigrationSource) LoadMigrations() ([]Migration, error) {
 	if err != nil {
 		return nil, err
 	}
-	parts := strings.Split(string(content), "-- DOWN")
-	upSQL := strings.TrimSpace(parts[0])
-	downSQL := ""
-	if len(parts) > 1 {
-		downSQL = strings.TrimSpace(parts[1])
-	}
+	up, down := parseFileSections(string(content))
 	var version, name string
 	parser := f.FilenameParser
 	if parser == nil {
@@ -448,6 +875,8 @@ func (f *FileMigrationSource) LoadMigrations() ([]Migration, error) {
 		}
 	}
 	mig := NewMigration(version, name)
+	mig.MigrationName = f.MigrationName
+	mig.setAnnotations(parseAnnotationsString(string(content)))
 	if f.PreHook != nil {
 		preStep := NewHookMigrationStep().WithUpHook(
 			func(ctx context.Context, exec Executor) error {
@@ -456,7 +885,11 @@ func (f *FileMigrationSource) LoadMigrations() ([]Migration, error) {
 		)
 		mig.UpSteps = append(mig.UpSteps, preStep)
 	}
-	mig.UpSteps = append(mig.UpSteps, NewSQLMigrationStep(upSQL))
+	for _, section := range up {
+		mig.UpSteps = append(mig.UpSteps, &SQLMigrationStep{
+			SQL: section.sql, NoSplit: f.NoSplit || section.noSplit,
+		})
+	}
 	if f.PostHook != nil {
 		postStep := NewHookMigrationStep().WithUpHook(
 			func(ctx context.Context, exec Executor) error {
@@ -473,7 +906,11 @@ func (f *FileMigrationSource) LoadMigrations() ([]Migration, error) {
 		)
 		mig.DownSteps = append(mig.DownSteps, preStep)
 	}
-	mig.DownSteps = append(mig.DownSteps, NewSQLMigrationStep(downSQL))
+	for _, section := range down {
+		mig.DownSteps = append(mig.DownSteps, &SQLMigrationStep{
+			SQL: section.sql, NoSplit: f.NoSplit || section.noSplit,
+		})
+	}
 	if f.PostHook != nil {
 		postStep := NewHookMigrationStep().WithDownHook(
 			func(ctx context.Context, exec Executor) error {
@@ -482,7 +919,9 @@ func (f *FileMigrationSource) LoadMigrations() ([]Migration, error) {
 		)
 		mig.DownSteps = append(mig.DownSteps, postStep)
 	}
-	log.Printf("Loaded migration from file: %s", f.FilePath)
+	loggerOrDefault(f.Logger).Printf(
+		"Loaded migration from file: %s", f.FilePath,
+	)
 	return []Migration{*mig}, nil
 }
 
@@ -492,6 +931,14 @@ type VarMigrationSource struct {
 	Name    string
 	UpSQL   string
 	DownSQL string
+	// Optional MigrationName overrides the Migrator's migration name.
+	MigrationName string
+	// Optional Logger receives log messages, defaults to the standard
+	// logger.
+	Logger Logger
+	// NoSplit executes the SQL of every migration in one call even if the
+	// Migrator splits statements, see WithStatementSplitting.
+	NoSplit bool
 }
 
 // NewVarMigrationSource creates a new VarMigrationSource.
@@ -515,6 +962,51 @@ func NewVarMigrationSource(
 	}
 }
 
+// WithMigrationName returns a new VarMigrationSource whose migration is
+// recorded under the given migration name instead of the Migrator's.
+//
+// Parameters:
+//   - migrationName: The migration name (history namespace) to use.
+//
+// Returns:
+//   - *VarMigrationSource: A new VarMigrationSource instance.
+func (v *VarMigrationSource) WithMigrationName(
+	migrationName string,
+) *VarMigrationSource {
+	new := *v
+	new.MigrationName = migrationName
+	return &new
+}
+
+// WithLogger returns a new VarMigrationSource logging to logger instead of the
+// standard logger.
+//
+// Parameters:
+//   - logger: The logger to use, e.g. DiscardLogger.
+//
+// Returns:
+//   - *VarMigrationSource: A new VarMigrationSource instance.
+func (v *VarMigrationSource) WithLogger(logger Logger) *VarMigrationSource {
+	new := *v
+	new.Logger = logger
+	return &new
+}
+
+// WithSplit returns a new VarMigrationSource whose SQL steps are split into
+// statements when the Migrator splits statements, or always executed in one
+// call if enabled is false, e.g. for files with trigger bodies.
+//
+// Parameters:
+//   - enabled: Whether the SQL may be split into statements.
+//
+// Returns:
+//   - *VarMigrationSource: A new VarMigrationSource instance.
+func (v *VarMigrationSource) WithSplit(enabled bool) *VarMigrationSource {
+	new := *v
+	new.NoSplit = !enabled
+	return &new
+}
+
 // LoadMigrations loads the variable-defined migration.
 //
 // Returns:
@@ -522,8 +1014,16 @@ func NewVarMigrationSource(
 //   - error: An error if loading fails.
 func (v *VarMigrationSource) LoadMigrations() ([]Migration, error) {
 	mig := NewMigration(v.Version, v.Name).
-		WithUpSteps([]MigrationStep{NewSQLMigrationStep(v.UpSQL)}).
-		WithDownSteps([]MigrationStep{NewSQLMigrationStep(v.DownSQL)})
-	log.Printf("Loaded var migration: version %s, name %s", v.Version, v.Name)
+		WithUpSteps([]MigrationStep{
+			&SQLMigrationStep{SQL: v.UpSQL, NoSplit: v.NoSplit},
+		}).
+		WithDownSteps([]MigrationStep{
+			&SQLMigrationStep{SQL: v.DownSQL, NoSplit: v.NoSplit},
+		})
+	mig.MigrationName = v.MigrationName
+	mig.setAnnotations(parseAnnotationsString(v.UpSQL))
+	loggerOrDefault(v.Logger).Printf(
+		"Loaded var migration: version %s, name %s", v.Version, v.Name,
+	)
 	return []Migration{*mig}, nil
 }
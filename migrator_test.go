@@ -1,28 +1,45 @@
 package migrator
 
 import (
+    "bytes"
     "context"
+    "crypto/ed25519"
     "database/sql"
     "database/sql/driver"
+    "encoding/base64"
+    "encoding/json"
     "errors"
+    "fmt"
     "io"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httptest"
     "os"
     "path/filepath"
+    "reflect"
+    "slices"
+    "strconv"
     "strings"
     "sync"
+    "syscall"
     "testing"
+    "testing/fstest"
+    "time"
 )
 
 // --- Test Driver & Fakes ---
 
 type record struct{
     query string
+    args []any
 }
 
 type testDrv struct{}
 type testConn struct{}
 type testTx struct{}
 type testResult struct{}
+type testStmt struct{ query string }
 type testRows struct{
     cols []string
     data [][]driver.Value
@@ -34,47 +51,98 @@ var (
     recs  []record
     txCommits int
     txRollbacks int
+    // options of every transaction begun
+    txOptions []driver.TxOptions
+    prepares []string
+    pings int
     rowsMu sync.Mutex
     rowsForNextQuery [][]driver.Value
+    colsForNextQuery []string
+    // rows returned by every query starting with a key, checked first
+    rowsForQueryPrefix map[string][][]driver.Value
+    // columns of rowsForQueryPrefix entries, "value" if unset
+    colsForQueryPrefix map[string][]string
+    // execs starting with one of these fail
+    failExecPrefixes []string
 )
 
-func addRec(q string){
+// primary key queries of the history managers, answered with no key unless
+// rowsForQueryPrefix holds them
+var historyKeyQueries = []string{"SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE", "SELECT name FROM pragma_table_info", "SELECT a.attname FROM pg_index"}
+
+func failingExec(query string) bool {
+    rowsMu.Lock(); defer rowsMu.Unlock()
+    if strings.HasPrefix(query, "FAIL") { return true }
+    for _, prefix := range failExecPrefixes { if strings.HasPrefix(query, prefix) { return true } }
+    return false
+}
+
+func addRec(q string, args ...any){
     recMu.Lock(); defer recMu.Unlock()
-    recs = append(recs, record{query: q})
+    recs = append(recs, record{query: q, args: args})
 }
 
 func resetRecs(){
     recMu.Lock(); defer recMu.Unlock()
     recs = nil
+    prepares = nil
 }
 
 func (d testDrv) Open(name string) (driver.Conn, error) { return testConn{}, nil }
-func (c testConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+    recMu.Lock(); prepares = append(prepares, query); recMu.Unlock()
+    return testStmt{query: query}, nil
+}
 func (c testConn) Close() error { return nil }
 func (c testConn) Begin() (driver.Tx, error) { return testTx{}, nil }
-func (c testConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) { return testTx{}, nil }
+func (c testConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) { recMu.Lock(); txOptions = append(txOptions, opts); recMu.Unlock(); return testTx{}, nil }
 func (t testTx) Commit() error { recMu.Lock(); txCommits++; recMu.Unlock(); return nil }
 func (t testTx) Rollback() error { recMu.Lock(); txRollbacks++; recMu.Unlock(); return nil }
 
 // ExecContext support
 func (c testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-    addRec(query)
-    if query == "FAIL" { return nil, errors.New("forced exec failure") }
+    vals := make([]any, len(args))
+    for i, a := range args { vals[i] = a.Value }
+    addRec(query, vals...)
+    if failingExec(query) { return nil, errors.New("forced exec failure") }
     return testResult{}, nil
 }
 func (c testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
     addRec(query)
     if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "SELECT") {
         rowsMu.Lock()
-        data := rowsForNextQuery
-        rowsForNextQuery = nil
+        for prefix, data := range rowsForQueryPrefix {
+            if strings.HasPrefix(query, prefix) {
+                cols := colsForQueryPrefix[prefix]
+                rowsMu.Unlock()
+                if cols == nil { cols = []string{"value"} }
+                return &testRows{cols: cols, data: data}, nil
+            }
+        }
+        for _, prefix := range historyKeyQueries {
+            if strings.HasPrefix(query, prefix) { rowsMu.Unlock(); return &testRows{cols: []string{"name"}, data: [][]driver.Value{}}, nil }
+        }
+        data, cols := rowsForNextQuery, colsForNextQuery
+        rowsForNextQuery, colsForNextQuery = nil, nil
         rowsMu.Unlock()
         if data == nil { data = [][]driver.Value{} }
-        return &testRows{cols: []string{"version"}, data: data}, nil
+        if cols == nil { cols = []string{"version"} }
+        return &testRows{cols: cols, data: data}, nil
     }
     return nil, errors.New("not implemented")
 }
+func (c testConn) Ping(ctx context.Context) error { recMu.Lock(); pings++; recMu.Unlock(); return nil }
 func (c testConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+func (s testStmt) Close() error { return nil }
+func (s testStmt) NumInput() int { return -1 }
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+    vals := make([]any, len(args))
+    for i, a := range args { vals[i] = a }
+    addRec(s.query, vals...)
+    if failingExec(s.query) { return nil, errors.New("forced exec failure") }
+    return testResult{}, nil
+}
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) { return nil, errors.New("not implemented") }
 func (testResult) LastInsertId() (int64, error) { return 0, nil }
 func (testResult) RowsAffected() (int64, error) { return 1, nil }
 func (r *testRows) Columns() []string { return r.cols }
@@ -104,6 +172,10 @@ type fakeHistory struct{
     recorded []Migration
     removed  []Migration
     applied  map[string]bool
+    appliedCalls int
+    // optional per migration name state; used instead of applied when set
+    appliedByName map[string]map[string]bool
+    recordedNames []string
 }
 
 func (f *fakeHistory) EnsureHistoryTable(ctx context.Context, db *sql.DB, table string) error {
@@ -112,6 +184,7 @@ func (f *fakeHistory) EnsureHistoryTable(ctx context.Context, db *sql.DB, table
 }
 func (f *fakeHistory) RecordMigration(ctx context.Context, exec Executor, table string, mig Migration, name string) error {
     f.recorded = append(f.recorded, mig)
+    f.recordedNames = append(f.recordedNames, name)
     return nil
 }
 func (f *fakeHistory) RemoveMigration(ctx context.Context, exec Executor, table string, mig Migration, name string) error {
@@ -119,6 +192,8 @@ func (f *fakeHistory) RemoveMigration(ctx context.Context, exec Executor, table
     return nil
 }
 func (f *fakeHistory) AppliedMigrations(ctx context.Context, db *sql.DB, table string, name string) (map[string]bool, error) {
+    f.appliedCalls++
+    if f.appliedByName != nil { return f.appliedByName[name], nil }
     if f.applied == nil { return map[string]bool{}, nil }
     return f.applied, nil
 }
@@ -130,6 +205,20 @@ func TestDefaultParseFilename(t *testing.T){
     if !ok || v != "001" || n != "init" || d != "up" {
         t.Fatalf("unexpected parse: %v %v %v ok=%v", v, n, d, ok)
     }
+    for _, name := range []string{"", "_init_up.sql", "001__up.sql", "001_up.sql", "0 1_init_up.sql", "001_in\nit_up.sql", "../001_init_up.sql", "001_init_up.sql.bak", "001_\xff_up.sql", ".up.sql"} {
+        if _, _, _, ok := defaultParseFilename(name); ok { t.Fatalf("expected %q to be rejected", name) }
+    }
+}
+
+func FuzzDefaultParseFilename(f *testing.F){
+    for _, seed := range []string{"001_init_up.sql", "002_add_users_table_DOWN.sqlite", "__up", "001__up.sql", "20240101_a b_up"} { f.Add(seed) }
+    f.Fuzz(func(t *testing.T, filename string){
+        v, n, d, ok := defaultParseFilename(filename)
+        if !ok { return }
+        if v == "" || n == "" || (d != "up" && d != "down") || strings.ContainsAny(v, " _\t") { t.Fatalf("%q parsed to %q %q %q", filename, v, n, d) }
+        v2, n2, d2, ok := defaultParseFilename(v + "_" + n + "_" + d + ".sql")
+        if !ok || v2 != v || n2 != n || d2 != d { t.Fatalf("%q does not round-trip: %q %q %q", filename, v2, n2, d2) }
+    })
 }
 
 func TestDirMigrationSource_LoadMigrations_ParsesSortsAndHooks(t *testing.T){
@@ -411,25 +500,2488 @@ func TestDirMigrationSource_CustomParser(t *testing.T){
     if len(migs) != 1 || migs[0].Version != "100" || migs[0].Name != "custom" { t.Fatalf("expected custom parsed migration, got %+v", migs) }
 }
 
-// --- Helpers ---
+func TestDirMigrationSource_AppliedFilesAreNotRead(t *testing.T){
+    resetRecs()
+    dir := t.TempDir()
+    // the applied migration's file cannot be read; it must never be opened
+    if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "001_init_up.sql")); err != nil { t.Fatalf("symlink: %v", err) }
+    mustWrite(t, filepath.Join(dir, "002_next_up.sql"), "UP_002")
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    fh := &fakeHistory{applied: map[string]bool{"001": true}}
+    m := NewMigrator(db, "hist", fh, "app").WithSources([]MigrationSource{NewDirMigrationSource(dir)})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsExec("UP_002") || containsExec("UP_001") { t.Fatalf("expected only UP_002 executed: %v", recStrings()) }
+}
 
-type staticSource struct{ migs []Migration }
-func (s *staticSource) LoadMigrations() ([]Migration, error) { return s.migs, nil }
+func TestMigrator_CacheReusesLoadsUntilInvalidated(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := *NewMigration("001","a")
+    mig.UpSteps = []MigrationStep{ NewSQLMigrationStep("UP_CACHED") }
+    src := &staticSource{migs: []Migration{mig}}
+    fh := &fakeHistory{}
+    m := NewMigrator(db, "hist", fh, "app").WithSources([]MigrationSource{src}).WithCache(true)
+    ctx := context.Background()
+    if err := m.MigrateUp(ctx, ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    resetRecs()
+    // second run uses the cached applied set and does not reapply
+    if err := m.MigrateUp(ctx, ""); err != nil { t.Fatalf("MigrateUp 2: %v", err) }
+    if containsExec("UP_CACHED") { t.Fatalf("did not expect re-execution: %v", recStrings()) }
+    if src.loads != 1 || fh.appliedCalls != 1 { t.Fatalf("expected 1 load and 1 history scan, got %d and %d", src.loads, fh.appliedCalls) }
+    // invalidation reloads both; the fake history never recorded 001
+    m.InvalidateCache()
+    if err := m.MigrateUp(ctx, ""); err != nil { t.Fatalf("MigrateUp 3: %v", err) }
+    if src.loads != 2 || fh.appliedCalls != 2 { t.Fatalf("expected reload after invalidation, got %d and %d", src.loads, fh.appliedCalls) }
+    if !containsExec("UP_CACHED") { t.Fatalf("expected execution after invalidation: %v", recStrings()) }
+}
 
-func mustWrite(t *testing.T, p, s string){
-    t.Helper()
-    if err := os.WriteFile(p, []byte(s), 0o600); err != nil { t.Fatalf("write %s: %v", p, err) }
+func TestMigrator_HistoryStatementsPreparedOncePerRun(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} {
+        migs = append(migs, *NewMigration(v, "m").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_" + v)}))
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{&staticSource{migs: migs}}).
+        WithTransactional(true)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    recMu.Lock(); prepared := 0
+    for _, q := range prepares { if strings.HasPrefix(q, "INSERT INTO hist") { prepared++ } }
+    recMu.Unlock()
+    inserts := 0
+    for _, q := range recStrings() { if strings.HasPrefix(q, "INSERT INTO hist") { inserts++ } }
+    if prepared != 1 || inserts != 3 { t.Fatalf("expected 1 prepare and 3 inserts, got %d and %d", prepared, inserts) }
 }
 
-func containsExec(sub string) bool {
-    recMu.Lock(); defer recMu.Unlock()
-    for _, r := range recs { if r.query == sub { return true } }
-    return false
+func TestMigrator_BatchHistoryInsertsOnce(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} {
+        migs = append(migs, *NewMigration(v, "m").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_" + v)}))
+    }
+    m := NewMigrator(db, "hist", NewMySQLHistoryManager(), "app").
+        WithSources([]MigrationSource{&staticSource{migs: migs}}).
+        WithTransactional(true).
+        WithBatchHistory(true)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    var inserts []string
+    for _, q := range recStrings() { if strings.HasPrefix(q, "INSERT INTO hist") { inserts = append(inserts, q) } }
+    if len(inserts) != 1 || strings.Count(inserts[0], "), (") != 2 { t.Fatalf("expected one 3-row insert, got %v", inserts) }
+    // the batched insert happens after all steps
+    recs := recStrings()
+    if recs[len(recs)-1] != inserts[0] { t.Fatalf("expected history insert last: %v", recs) }
 }
-func containsSubstr(sub string) bool {
-    recMu.Lock(); defer recMu.Unlock()
-    for _, r := range recs { if strings.Contains(r.query, sub) { return true } }
-    return false
+
+func TestDirMigrationSource_Checksums(t *testing.T){
+    dir := t.TempDir()
+    for i := 1; i <= 20; i++ {
+        v := fmt.Sprintf("%03d", i)
+        mustWrite(t, filepath.Join(dir, v+"_m_up.sql"), "CREATE "+v)
+        mustWrite(t, filepath.Join(dir, v+"_m_down.sql"), "DROP "+v)
+    }
+    migs, err := NewDirMigrationSource(dir).LoadMigrations()
+    if err != nil { t.Fatalf("LoadMigrations: %v", err) }
+    if migs[0].Checksum != "" { t.Fatalf("expected no checksum when disabled") }
+    src := NewDirMigrationSource(dir).WithChecksums(true).WithChecksumWorkers(4)
+    migs, err = src.LoadMigrations()
+    if err != nil { t.Fatalf("LoadMigrations: %v", err) }
+    seen := map[string]bool{}
+    for _, m := range migs {
+        if len(m.Checksum) != 64 || seen[m.Checksum] { t.Fatalf("expected unique sha256 checksum, got %q", m.Checksum) }
+        seen[m.Checksum] = true
+    }
+    first := migs[0].Checksum
+    mustWrite(t, filepath.Join(dir, "001_m_up.sql"), "CREATE changed")
+    migs, _ = src.LoadMigrations()
+    if migs[0].Checksum == first { t.Fatalf("expected checksum to change with content") }
+}
+
+func TestSplitStatements_QuotesCommentsAndDollarBodies(t *testing.T){
+    in := "CREATE TABLE a(x text default 'a;b''c');\n" +
+        "-- only a comment; here\n" +
+        "/* block; /* nested; */ still */ INSERT INTO a VALUES (\"q;\");\n" +
+        "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;\n" +
+        "SELECT $1, a$b FROM t; ; \n-- trailing comment"
+    stmts, err := SplitStatements(in)
+    if err != nil { t.Fatalf("split: %v", err) }
+    if len(stmts) != 4 { t.Fatalf("expected 4 statements, got %d: %q", len(stmts), stmts) }
+    if stmts[0] != "CREATE TABLE a(x text default 'a;b''c')" { t.Fatalf("unexpected first statement %q", stmts[0]) }
+    if !strings.HasSuffix(stmts[2], "$body$ LANGUAGE sql") { t.Fatalf("dollar body split: %q", stmts[2]) }
+    if stmts[3] != "SELECT $1, a$b FROM t" { t.Fatalf("unexpected last statement %q", stmts[3]) }
+    if _, err := SplitStatements("SELECT 'open"); err == nil { t.Fatalf("expected unterminated string error") }
+}
+
+func TestStatementScanner_BoundedAndLineNumbers(t *testing.T){
+    sc := NewStatementScanner(strings.NewReader("SELECT 1;\n\n  SELECT 2;"))
+    var lines []int
+    for sc.Scan() { lines = append(lines, sc.Line()) }
+    if sc.Err() != nil || len(lines) != 2 || lines[0] != 1 || lines[1] != 3 { t.Fatalf("unexpected lines %v err=%v", lines, sc.Err()) }
+    sc = NewStatementScanner(strings.NewReader("SELECT 1; SELECT 'far too long';"))
+    sc.MaxStatementSize = 10
+    if !sc.Scan() || sc.Statement() != "SELECT 1" { t.Fatalf("expected first statement within limit") }
+    if sc.Scan() || !errors.Is(sc.Err(), ErrStatementTooLarge) { t.Fatalf("expected ErrStatementTooLarge, got %v", sc.Err()) }
+}
+
+func TestMigrator_KeepaliveAndConnectionLost(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    recMu.Lock(); pings = 0; recMu.Unlock()
+    slow := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error { time.Sleep(50 * time.Millisecond); return nil })
+    src := &staticSource{migs: []Migration{*NewMigration("001", "slow").WithUpSteps([]MigrationStep{slow})}}
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{src}).WithKeepalive(5 * time.Millisecond)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    recMu.Lock(); p := pings; recMu.Unlock()
+    if p == 0 { t.Fatalf("expected keepalive pings during slow step") }
+
+    dropped := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error { return driver.ErrBadConn })
+    src = &staticSource{migs: []Migration{*NewMigration("001", "dropped").WithUpSteps([]MigrationStep{dropped})}}
+    m = NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{src})
+    err := m.MigrateUp(context.Background(), "")
+    if !errors.Is(err, ErrConnectionLost) || !errors.Is(err, driver.ErrBadConn) { t.Fatalf("expected ErrConnectionLost wrapping ErrBadConn, got %v", err) }
+}
+
+func TestIsConnectionLost(t *testing.T){
+    cases := []struct{ err error; want bool }{
+        {driver.ErrBadConn, true},
+        {fmt.Errorf("exec: %w", syscall.ECONNRESET), true},
+        {fmt.Errorf("exec: %w", syscall.EPIPE), true},
+        {&net.OpError{Op: "read", Net: "tcp", Err: io.EOF}, true},
+        {&net.OpError{Op: "write", Net: "tcp", Err: errors.New("i/o timeout")}, true},
+        {&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, false},
+        {io.EOF, false},
+        {fmt.Errorf("read seed file: %w", io.EOF), false},
+        {errors.New("syntax error"), false},
+    }
+    for _, c := range cases {
+        if got := isConnectionLost(c.err); got != c.want { t.Fatalf("isConnectionLost(%v) = %v, want %v", c.err, got, c.want) }
+    }
+}
+
+func TestMigrator_SessionSetupRunsBeforeSteps(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := *NewMigration("001", "a").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_SESSION")})
+    for _, tx := range []bool{true, false} {
+        resetRecs()
+        m := NewMigrator(db, "hist", &fakeHistory{}, "app").
+            WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}}).
+            WithTransactional(tx).
+            WithSessionSetup([]string{"SET lock_timeout='5s'", "SET statement_timeout='30min'"})
+        if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp tx=%v: %v", tx, err) }
+        recs := recStrings()
+        if len(recs) < 3 || recs[0] != "SET lock_timeout='5s'" || recs[1] != "SET statement_timeout='30min'" || recs[2] != "UP_SESSION" {
+            t.Fatalf("expected session setup before steps (tx=%v): %v", tx, recs)
+        }
+    }
+}
+
+func TestDirMigrationSource_MigrationsIterator(t *testing.T){
+    dir := t.TempDir()
+    for _, v := range []string{"10", "2", "1"} {
+        mustWrite(t, filepath.Join(dir, v+"_m_up.sql"), "UP")
+        mustWrite(t, filepath.Join(dir, v+"_m_down.sql"), "DOWN")
+    }
+    src := NewDirMigrationSource(dir).WithChecksums(true)
+    var versions []string
+    for mig, err := range src.Migrations() {
+        if err != nil { t.Fatalf("iterate: %v", err) }
+        if len(mig.UpSteps) != 1 || len(mig.DownSteps) != 1 || mig.Checksum == "" { t.Fatalf("unexpected migration %+v", mig) }
+        versions = append(versions, mig.Version)
+        if len(versions) == 2 { break }
+    }
+    if strings.Join(versions, ",") != "1,2" { t.Fatalf("expected numeric order 1,2 got %v", versions) }
+    loaded, err := src.LoadMigrations()
+    if err != nil || len(loaded) != 3 || loaded[2].Version != "10" { t.Fatalf("unexpected LoadMigrations result %+v err=%v", loaded, err) }
+}
+
+func TestMigrator_PerSourceMigrationNames(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    core := NewVarMigrationSource("001", "core", "UP_CORE", "DOWN_CORE")
+    plugin := NewVarMigrationSource("001", "plugin", "UP_PLUGIN", "DOWN_PLUGIN").WithMigrationName("plugin")
+    fh := &fakeHistory{appliedByName: map[string]map[string]bool{"app": {"001": true}}}
+    m := NewMigrator(db, "hist", fh, "app").WithSources([]MigrationSource{core, plugin})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if containsExec("UP_CORE") || !containsExec("UP_PLUGIN") { t.Fatalf("expected only plugin migration to run: %v", recStrings()) }
+    if len(fh.recordedNames) != 1 || fh.recordedNames[0] != "plugin" { t.Fatalf("expected record under plugin name, got %v", fh.recordedNames) }
+}
+
+func TestParseAnnotations_DescriptionHeader(t *testing.T){
+    a, err := ParseAnnotations(strings.NewReader("-- Description: Add users table\n-- a plain comment\n\n-- ticket: OPS-1\nCREATE TABLE users(id int);\n-- description: ignored"))
+    if err != nil { t.Fatalf("parse: %v", err) }
+    if a["description"] != "Add users table" || a["ticket"] != "OPS-1" || len(a) != 2 { t.Fatalf("unexpected annotations %v", a) }
+}
+
+func TestDirMigrationSource_DescriptionResolvedForPendingAndRecorded(t *testing.T){
+    resetRecs()
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_users_up.sql"), "-- description: Create the users table\nCREATE TABLE users(id int);")
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{NewDirMigrationSource(dir)})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsSubstr("ALTER TABLE hist ADD COLUMN description TEXT") { t.Fatalf("expected description column upgrade: %v", recStrings()) }
+    fh := &fakeHistory{}
+    m = m.WithHistoryManager(fh)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if len(fh.recorded) != 1 || fh.recorded[0].Description != "Create the users table" { t.Fatalf("expected recorded description, got %+v", fh.recorded) }
+}
+
+func TestMigrator_RecordsApplierIdentity(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP", "DOWN")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{src}).
+        WithAppliedBy("deploy-job")
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 || len(inserts[0]) != 13 { t.Fatalf("expected one insert with 13 args, got %v", inserts) }
+    host, _ := os.Hostname()
+    if inserts[0][5] != "deploy-job" || inserts[0][7] != host || inserts[0][6] == "" { t.Fatalf("unexpected identity args %v", inserts[0]) }
+}
+
+func TestMigrator_TicketAnnotationRecorded(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "-- ticket: JIRA-123\nCREATE TABLE t(x int)", "DROP TABLE t")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 || inserts[0][8] != "JIRA-123" { t.Fatalf("expected ticket in history insert, got %v", inserts) }
+}
+
+func TestMigrator_RecordsDurationAndAttempts(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP", "DOWN")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 { t.Fatalf("expected one insert, got %v", inserts) }
+    if ms, ok := inserts[0][9].(int64); !ok || ms < 0 { t.Fatalf("expected duration_ms arg, got %v", inserts[0][9]) }
+    if inserts[0][10] != 1 { t.Fatalf("expected one attempt without retries, got %v", inserts[0][10]) }
+}
+
+func TestMigrator_SlowestMigrations(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app")
+    rowsMu.Lock()
+    colsForNextQuery = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+    rowsForNextQuery = [][]driver.Value{
+        {"001", "fast", "app", "2024-01-02 03:04:05", nil, nil, nil, nil, nil, int64(5), int64(1), nil, nil},
+        {"002", "slow", "app", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), nil, nil, nil, nil, nil, int64(1500), int64(1), nil, nil},
+        {"003", "legacy", "app", "2024-01-04T00:00:00Z", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+    }
+    rowsMu.Unlock()
+    slowest, err := m.SlowestMigrations(context.Background(), 2)
+    if err != nil { t.Fatalf("SlowestMigrations: %v", err) }
+    if len(slowest) != 2 || slowest[0].Migration.Version != "002" || slowest[0].Duration != 1500*time.Millisecond || slowest[1].Migration.Version != "001" {
+        t.Fatalf("unexpected slowest migrations %+v", slowest)
+    }
+}
+
+func TestMigrator_SlowestMigrationsRequiresReader(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app")
+    if _, err := m.SlowestMigrations(context.Background(), 1); !errors.Is(err, errors.ErrUnsupported) {
+        t.Fatalf("expected ErrUnsupported, got %v", err)
+    }
+}
+
+func TestMigrator_AuditStoresExecutedSQL(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "CREATE TABLE t(x int)", "DROP TABLE t")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{src}).
+        WithAudit(AuditSQL)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsSubstr("CREATE TABLE IF NOT EXISTS hist_audit") { t.Fatalf("expected audit table creation, got %v", recStrings()) }
+    audits := recArgs("INSERT INTO hist_audit")
+    if len(audits) != 1 || audits[0][1] != "001" || audits[0][2] != "up" || audits[0][5] != "CREATE TABLE t(x int)" {
+        t.Fatalf("unexpected audit rows %v", audits)
+    }
+    if sum, ok := audits[0][4].(string); !ok || len(sum) != 64 { t.Fatalf("expected checksum, got %v", audits[0][4]) }
+
+    resetRecs()
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}}; rowsMu.Unlock()
+    if err := m.WithAudit(AuditChecksum).MigrateDown(context.Background(), ""); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    audits = recArgs("INSERT INTO hist_audit")
+    if len(audits) != 1 || audits[0][2] != "down" || audits[0][5] != nil { t.Fatalf("expected checksum-only down audit, got %v", audits) }
+}
+
+func TestMigrator_CompactHistory(t *testing.T){
+    resetRecs(); recMu.Lock(); txCommits, txRollbacks = 0, 0; recMu.Unlock()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app")
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}, {"002"}, {"005"}}; rowsMu.Unlock()
+    if err := m.CompactHistory(context.Background(), "002"); err != nil { t.Fatalf("CompactHistory: %v", err) }
+    deletes := recArgs("DELETE FROM hist")
+    if len(deletes) != 2 || deletes[0][0] != "001" || deletes[1][0] != "002" { t.Fatalf("unexpected deletes %v", deletes) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 || inserts[0][0] != "002" || inserts[0][1] != "baseline" { t.Fatalf("unexpected baseline insert %v", inserts) }
+    recMu.Lock(); c := txCommits; recMu.Unlock()
+    if c != 1 { t.Fatalf("expected compaction in one transaction, got %d commits", c) }
+}
+
+func TestMigrator_AssertOnlyReportsPending(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("UP1")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("UP2")}},
+        {Version: "003", Name: "c", UpSteps: []MigrationStep{NewSQLMigrationStep("UP3")}},
+    }}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithAssertOnly(true)
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}}; rowsMu.Unlock()
+    err := m.MigrateUp(context.Background(), "")
+    var pending *PendingMigrationsError
+    if !errors.As(err, &pending) || !errors.Is(err, ErrPendingMigrations) || !reflect.DeepEqual(pending.Versions, []string{"002", "003"}) {
+        t.Fatalf("expected pending 002 and 003, got %v", err)
+    }
+    if containsExec("UP2") || containsSubstr("INSERT INTO hist") || containsSubstr("CREATE TABLE") { t.Fatalf("assert-only mode modified the database: %v", recStrings()) }
+
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}, {"002"}, {"003"}}; rowsMu.Unlock()
+    if err := m.AssertUpToDate(context.Background()); err != nil { t.Fatalf("expected up to date, got %v", err) }
+}
+
+func TestMigrator_CheckReportsProblemsWithoutDB(t *testing.T){
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_a_up.sql"), "CREATE TABLE a(x int);")
+    mustWrite(t, filepath.Join(dir, "001_a_down.sql"), "DROP TABLE a;")
+    mustWrite(t, filepath.Join(dir, "002_b_up.sql"), "INSERT INTO a VALUES ('unterminated);")
+    mustWrite(t, filepath.Join(dir, "002_b_down.sql"), "DELETE FROM a;")
+    mustWrite(t, filepath.Join(dir, "003_c_up.sql"), "CREATE TABLE c(x int);")
+    mustWrite(t, filepath.Join(dir, "notes.sql"), "-- not a migration")
+    m := NewMigrator(nil, "hist", nil, "app").WithSources([]MigrationSource{NewDirMigrationSource(dir)})
+    err := m.Check(context.Background())
+    if err == nil { t.Fatal("expected check errors") }
+    for _, want := range []string{"notes.sql does not match", "migration 002 (b): up step 1: unterminated", "migration 003 (c): no down steps"} {
+        if !strings.Contains(err.Error(), want) { t.Fatalf("expected %q in %v", want, err) }
+    }
+    if strings.Contains(err.Error(), "migration 001") { t.Fatalf("valid migration reported: %v", err) }
+}
+
+func TestMigrator_SearchPathSetAndRecorded(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "CREATE TABLE t(x int)", "DROP TABLE t")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{src}).
+        WithTransactional(true).
+        WithSearchPath("tenant_a, public")
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    got := recStrings()
+    setIdx, createIdx := slices.Index(got, "SET LOCAL search_path TO tenant_a, public"), slices.Index(got, "CREATE TABLE t(x int)")
+    if setIdx < 0 || setIdx > createIdx { t.Fatalf("expected search_path before steps, got %v", got) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 || inserts[0][11] != "tenant_a, public" { t.Fatalf("expected search_path in history, got %v", inserts) }
+}
+
+func TestQuoteQualifiedName(t *testing.T){
+    cases := []struct{ in, want string }{
+        {"hist", "hist"},
+        {"ops.schema_migrations", "ops.schema_migrations"},
+        {"my-ops.schema migrations", "`my-ops`.`schema migrations`"},
+        {"`a.b`.c", "`a.b`.c"},
+        {`"we""ird".t`, "`we\"ird`.t"},
+    }
+    for _, c := range cases {
+        got, err := quoteQualifiedName(c.in, '`')
+        if err != nil || got != c.want { t.Fatalf("quote %q: got %q, %v; want %q", c.in, got, err, c.want) }
+    }
+    for _, bad := range []string{"ops.", "`ops.t", ""} {
+        if _, err := quoteQualifiedName(bad, '`'); err == nil { t.Fatalf("expected error for %q", bad) }
+    }
+}
+
+func TestMigrator_SchemaQualifiedHistoryTable(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP", "DOWN")
+    m := NewMigrator(db, "my-ops.schema_migrations", NewMySQLHistoryManager(), "app").
+        WithSources([]MigrationSource{src}).
+        WithCreateSchema(true)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    got := recStrings()
+    if !slices.Contains(got, "CREATE SCHEMA IF NOT EXISTS `my-ops`") { t.Fatalf("expected schema creation, got %v", got) }
+    if !containsSubstr("CREATE TABLE IF NOT EXISTS `my-ops`.schema_migrations") || len(recArgs("INSERT INTO `my-ops`.schema_migrations")) != 1 {
+        t.Fatalf("expected quoted history table, got %v", got)
+    }
+
+    m = NewMigrator(db, "ops.schema_migrations", &fakeHistory{}, "app").WithCreateSchema(true)
+    if err := m.MigrateUp(context.Background(), ""); !errors.Is(err, errors.ErrUnsupported) { t.Fatalf("expected ErrUnsupported, got %v", err) }
+}
+
+func TestForSchemas_ExpandsStepPerSchema(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    step := ForSchemas(NewSQLMigrationStep("CREATE TABLE {{schema}}.events(id int)"), []string{"audit", "archive"})
+    if err := step.ExecuteUp(context.Background(), db); err != nil { t.Fatalf("up: %v", err) }
+    if err := step.ExecuteDown(context.Background(), db); err != nil { t.Fatalf("down: %v", err) }
+    want := []string{"CREATE TABLE audit.events(id int)", "CREATE TABLE archive.events(id int)", "CREATE TABLE archive.events(id int)", "CREATE TABLE audit.events(id int)"}
+    if got := recStrings(); !reflect.DeepEqual(got, want) { t.Fatalf("got %v, want %v", got, want) }
+}
+
+func TestMySQLHistoryManager_TableOptions(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    hm := NewMySQLHistoryManager().WithEngine("InnoDB").WithCharset("utf8mb4").WithCollation("utf8mb4_unicode_ci")
+    if err := hm.EnsureHistoryTable(context.Background(), db, "hist"); err != nil { t.Fatalf("ensure: %v", err) }
+    if !containsSubstr("PRIMARY KEY (migration_name, version)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci") { t.Fatalf("expected table options, got %v", recStrings()) }
+    if err := hm.WithEngine("InnoDB; DROP TABLE x").EnsureHistoryTable(context.Background(), db, "hist"); err == nil { t.Fatal("expected invalid option error") }
+}
+
+func TestPostgresHistoryManager_UsesDollarPlaceholders(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP", "DOWN")
+    m := NewMigrator(db, "ops.hist", NewPostgresHistoryManager(), "app").WithSources([]MigrationSource{src}).WithAudit(AuditChecksum).WithReadOnlyCheck(false)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}}; rowsMu.Unlock()
+    if err := m.MigrateDown(context.Background(), ""); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    for _, want := range []string{"applied_at TIMESTAMPTZ", "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)", "WHERE migration_name = $1", "DELETE FROM ops.hist WHERE version = $1 AND migration_name = $2", "sql_text) VALUES ($1, $2, $3, $4, $5, $6)"} {
+        if !containsSubstr(want) { t.Fatalf("expected %q; recs=%v", want, recStrings()) }
+    }
+    if containsSubstr("= ?") { t.Fatalf("unexpected ? placeholder; recs=%v", recStrings()) }
+}
+
+func TestDetectDialect(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    if _, ok := NewMigrator(db, "hist", nil, "app").HistoryManager.(SQLiteHistoryManager); !ok { t.Fatalf("expected SQLite fallback for unrecognized driver") }
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"PostgreSQL 16.2 on x86_64-pc-linux-gnu"}}; rowsMu.Unlock()
+    hm, err := DetectHistoryManager(context.Background(), db)
+    if _, ok := hm.(*PostgresHistoryManager); err != nil || !ok { t.Fatalf("expected postgres manager, got %T, %v", hm, err) }
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"10.11.2-MariaDB"}}; rowsMu.Unlock()
+    if d := DetectDialect(context.Background(), db); d != DialectMySQL { t.Fatalf("expected mysql, got %q", d) }
+}
+
+func TestMigrator_RedactSQLInErrors(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "seed", "FAIL INSERT INTO users VALUES ('hunter2')", "")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+
+    err := m.MigrateUp(context.Background(), "")
+    var stepErr *StepError
+    if !errors.As(err, &stepErr) || stepErr.Step != 1 || !strings.Contains(err.Error(), "forced exec failure") { t.Fatalf("expected step error, got %v", err) }
+
+    err = m.WithRedactSQL(RedactHash).MigrateUp(context.Background(), "")
+    if err == nil || strings.Contains(err.Error(), "hunter2") || strings.Contains(err.Error(), "forced exec failure") { t.Fatalf("expected redacted error, got %v", err) }
+    if !strings.Contains(err.Error(), "migration 001 (seed): up step 1 (sql) failed on sha256:") { t.Fatalf("expected step label and hash, got %v", err) }
+    if !errors.As(err, &stepErr) || !strings.Contains(stepErr.Err.Error(), "forced exec failure") { t.Fatalf("expected underlying error to remain available, got %v", err) }
+
+    err = m.WithRedactSQL(RedactFull).WithSessionSetup([]string{"FAIL SET secret='hunter2'"}).MigrateUp(context.Background(), "")
+    if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "[redacted]") { t.Fatalf("expected redacted session setup error, got %v", err) }
+}
+
+func TestBuildSQLPreview_HighlightsFailingStatement(t *testing.T){
+    script := "CREATE TABLE a(x int);\nINSERT INTO a VALUES (1);\nSELEC * FROM a;\nDROP TABLE a;"
+    got := buildSQLPreview(script, `near "SELEC": syntax error`, 200)
+    want := "CREATE TABLE a(x int); INSERT INTO a VALUES (1); >>> SELEC * FROM a <<<; DROP TABLE a"
+    if got != want { t.Fatalf("got %q, want %q", got, want) }
+    if got := buildSQLPreview(script, "You have an error in your SQL syntax at line 3", 200); !strings.Contains(got, ">>> SELEC * FROM a <<<") { t.Fatalf("expected line-based highlight, got %q", got) }
+    got = buildSQLPreview(strings.Repeat("INSERT INTO a VALUES (1);\n", 50)+"SELEC 1;", `near "SELEC"`, 60)
+    if !strings.HasPrefix(got, "...") || !strings.Contains(got, ">>> SELEC 1 <<<") || len(got) > 63 { t.Fatalf("expected truncated preview around failure, got %q", got) }
+}
+
+func TestMigrator_StepErrorIncludesPreview(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "FAIL "+strings.Repeat("x", 500), "")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithSQLPreviewLength(20)
+    var stepErr *StepError
+    if err := m.MigrateUp(context.Background(), ""); !errors.As(err, &stepErr) || stepErr.Preview != ">>> FAIL xxxxxxxxxxx..." { t.Fatalf("unexpected preview in %v", err) }
+    if err := m.WithSQLPreviewLength(-1).MigrateUp(context.Background(), ""); !errors.As(err, &stepErr) || stepErr.Preview != "" { t.Fatalf("expected no preview, got %v", err) }
+}
+
+func TestMigrator_ResultCollectsWarningsAndEvents(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_a_up.sql"), "CREATE TABLE a(x int);")
+    mustWrite(t, filepath.Join(dir, "001_a_down.sql"), "DROP TABLE a;")
+    mustWrite(t, filepath.Join(dir, "003_c_up.sql"), "-- ticket: OPS-7\nCREATE TABLE c(x int);")
+    mustWrite(t, filepath.Join(dir, "notes.sql"), "-- not a migration")
+    var events []EventType
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{NewDirMigrationSource(dir)}).
+        WithEventSink(EventSinkFunc(func(e Event){ events = append(events, e.Type) }))
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    var codes []WarningCode
+    for _, w := range result.Warnings { codes = append(codes, w.Code) }
+    if !reflect.DeepEqual(codes, []WarningCode{WarningUnparseableFile, WarningVersionGap, WarningMissingDownSteps}) { t.Fatalf("unexpected warnings %v", result.Warnings) }
+    if len(result.Migrations) != 2 || result.Migrations[1].Version != "003" || result.Migrations[1].Ticket != "OPS-7" { t.Fatalf("unexpected migrations %+v", result.Migrations) }
+    want := []EventType{EventRunStarted, EventWarning, EventWarning, EventWarning, EventMigrationStarted, EventStepCompleted, EventMigrationApplied, EventMigrationStarted, EventStepCompleted, EventMigrationApplied, EventRunCompleted}
+    if !reflect.DeepEqual(events, want) { t.Fatalf("got events %v, want %v", events, want) }
+}
+
+func TestMigrator_ResultRecordsRowsAffected(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    steps := []MigrationStep{NewSQLMigrationStep("UPDATE a SET x = 1"), ForSchemas(NewSQLMigrationStep("UPDATE {{schema}}.b SET x = 1"), []string{"s1", "s2"})}
+    var applied *MigrationResult
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").
+        WithSources([]MigrationSource{&staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: steps}}}}).
+        WithEventSink(EventSinkFunc(func(e Event){ if e.Type == EventMigrationApplied { applied = e.Migration } }))
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    if got := result.Migrations[0].RowsAffected; !reflect.DeepEqual(got, []int64{1, 2}) { t.Fatalf("unexpected rows affected %v", got) }
+    if applied == nil || applied.TotalRowsAffected() != 3 { t.Fatalf("expected rows in event, got %+v", applied) }
+    if got := (MigrationResult{RowsAffected: []int64{4, -1}}).TotalRowsAffected(); got != -1 { t.Fatalf("expected unknown total, got %d", got) }
+}
+
+func TestMigrator_PostCommitHooksRunAfterCommit(t *testing.T){
+    resetRecs(); recMu.Lock(); txCommits, txRollbacks = 0, 0; recMu.Unlock()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var calls []string
+    commitsAtHook := -1
+    hooks := []PostCommitHookFn{
+        func(ctx context.Context, r *Result) error { recMu.Lock(); commitsAtHook = txCommits; recMu.Unlock(); calls = append(calls, "a"); return errors.New("bus down") },
+        func(ctx context.Context, r *Result) error { calls = append(calls, "b:" + r.Migrations[0].Version); return nil },
+    }
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("UP")}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithTransactional(true).WithPostCommitHooks(hooks...)
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("hook failure must not fail the run: %v", err) }
+    if !reflect.DeepEqual(calls, []string{"a", "b:001"}) || commitsAtHook != 1 { t.Fatalf("unexpected hook calls %v, commits at hook %d", calls, commitsAtHook) }
+    if len(result.Warnings) != 2 || result.Warnings[1].Code != WarningPostCommitHookFailed || !strings.Contains(result.Warnings[1].Message, "bus down") { t.Fatalf("expected hook warning, got %+v", result.Warnings) }
+
+    resetRecs(); calls = nil
+    failing := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("FAIL")}}}}
+    if _, err := m.WithSources([]MigrationSource{failing}).MigrateUpResult(context.Background(), ""); err == nil { t.Fatalf("expected failure") }
+    if len(calls) != 0 { t.Fatalf("hooks must not run after a failed run, got %v", calls) }
+}
+
+func TestMigrator_PreflightsAbortBeforeMigrating(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    rowsMu.Lock(); rowsForNextQuery, colsForNextQuery = [][]driver.Value{{"pgcrypto"}}, []string{"extname"}; rowsMu.Unlock()
+    ran := false
+    custom := NewPreflight("maintenance window", func(ctx context.Context, db *sql.DB) error { ran = true; return errors.New("closed") })
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("UP")}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).
+        WithPreflights(RequireExtensions("pgcrypto", "postgis"), custom)
+    err := m.MigrateUp(context.Background(), "")
+    if err == nil || !ran { t.Fatalf("expected preflight failure after running every check, got %v", err) }
+    for _, want := range []string{"preflight required extensions: extensions not installed: postgis", "preflight maintenance window: closed"} {
+        if !strings.Contains(err.Error(), want) { t.Fatalf("expected %q in %v", want, err) }
+    }
+    if containsExec("UP") || containsSubstr("CREATE TABLE") { t.Fatalf("nothing must run after failed preflights; recs=%v", recStrings()) }
+}
+
+type privilegeHistory struct{
+    SQLiteHistoryManager
+    required []Privilege
+    granted map[string]bool
+}
+
+func (p *privilegeHistory) MissingPrivileges(ctx context.Context, db *sql.DB, required []Privilege) ([]Privilege, error) {
+    p.required = required
+    var missing []Privilege
+    for _, r := range required { if !p.granted[r.String()] { missing = append(missing, r) } }
+    return missing, nil
+}
+
+func TestMigrator_VerifyPrivileges(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    hist := &privilegeHistory{granted: map[string]bool{"CREATE": true, "INSERT": true}}
+    steps := []MigrationStep{NewSQLMigrationStep("-- add column\nALTER TABLE a ADD b int; create index i on a(b); insert into a values (1)")}
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: steps}}}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{src}).WithVerifyPrivileges(true)
+    err := m.MigrateUp(context.Background(), "")
+    var privErr *MissingPrivilegesError
+    if !errors.As(err, &privErr) || !errors.Is(err, ErrMissingPrivileges) { t.Fatalf("expected missing privileges, got %v", err) }
+    if err.Error() != "missing privilege ALTER; missing privilege INSERT on hist" { t.Fatalf("unexpected message %q", err.Error()) }
+    want := []Privilege{{Name: "ALTER"}, {Name: "CREATE"}, {Name: "INSERT"}, {Name: "INSERT", Table: "hist"}}
+    if !reflect.DeepEqual(hist.required, want) { t.Fatalf("got required %v, want %v", hist.required, want) }
+    if containsSubstr("ALTER TABLE a ADD") { t.Fatalf("no step must run; recs=%v", recStrings()) }
+
+    hist.granted["ALTER"], hist.granted["INSERT on hist"] = true, true
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+}
+
+type versionHistory struct{
+    SQLiteHistoryManager
+    calls int
+}
+
+func (v *versionHistory) ServerVersion(ctx context.Context, db *sql.DB) (string, string, error) { v.calls++; return "postgres", "13.4 (Debian 13.4-1)", nil }
+
+func TestMigrator_RequiresAnnotation(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v, requires string) Migration {
+        return Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP_" + v)}, Annotations: map[string]string{AnnotationRequires: requires}}
+    }
+    src := &staticSource{migs: []Migration{mig("001", "postgres >= 12, postgres < 14"), mig("002", "postgres >= 14"), mig("003", "")}}
+    hist := &versionHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{src})
+    err := m.MigrateUp(context.Background(), "")
+    if !errors.Is(err, ErrRequirementNotMet) || !strings.Contains(err.Error(), "requires postgres >= 14, connected to postgres 13.4") { t.Fatalf("expected unmet requirement, got %v", err) }
+
+    resetRecs()
+    result, err := m.WithUnmetRequirements(UnmetRequirementSkip).MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    if !containsExec("UP_001") || containsExec("UP_002") || !containsExec("UP_003") { t.Fatalf("expected 002 skipped; recs=%v", recStrings()) }
+    if last := result.Warnings[len(result.Warnings)-1]; last.Code != WarningRequirementNotMet || last.Version != "002" { t.Fatalf("expected requirement warning, got %+v", result.Warnings) }
+    if hist.calls != 2 { t.Fatalf("expected one version query per run, got %d", hist.calls) }
+
+    for _, c := range []struct{ req, server, version string; want bool }{
+        {"mysql >= 8.0.13", "mysql", "8.0.34-log", true},
+        {"mysql > 8.0", "mysql", "8.0.0", false},
+        {"mysql = 8", "mysql", "8.0", true},
+        {"postgres >= 14", "mysql", "99", false},
+    } {
+        reqs, err := ParseRequirements(c.req)
+        if err != nil { t.Fatalf("ParseRequirements(%q): %v", c.req, err) }
+        if got, err := reqs[0].Satisfied(c.server, c.version); err != nil || got != c.want { t.Fatalf("%q on %s %s = %v, %v", c.req, c.server, c.version, got, err) }
+    }
+    if _, err := ParseRequirements("postgres 14"); err == nil { t.Fatalf("expected invalid requirement") }
+}
+
+func TestMigrator_PartitionsRunInOneCombinedPlan(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v, up string) Migration { return Migration{Version: v, Name: up, UpSteps: []MigrationStep{NewSQLMigrationStep(up)}} }
+    core := &staticSource{migs: []Migration{mig("002", "CORE_2"), mig("001", "CORE_1")}}
+    billing := &staticSource{migs: []Migration{mig("001", "BILLING_1")}}
+    search := &staticSource{migs: []Migration{mig("001", "SEARCH_1")}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "core").WithSources([]MigrationSource{core}).
+        WithPartitions(Partition{MigrationName: "billing", Sources: []MigrationSource{billing}}, Partition{MigrationName: "search", Sources: []MigrationSource{search}})
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    var order []string
+    for _, r := range result.Migrations { order = append(order, r.MigrationName + ":" + r.Name) }
+    if !reflect.DeepEqual(order, []string{"core:CORE_1", "core:CORE_2", "billing:BILLING_1", "search:SEARCH_1"}) { t.Fatalf("unexpected plan %v", order) }
+    if got := result.PartitionMigrations("billing"); len(got) != 1 || got[0].Version != "001" { t.Fatalf("unexpected billing migrations %+v", got) }
+    if !containsSubstr("SELECT version FROM hist WHERE migration_name = ?") { t.Fatalf("expected history lookups; recs=%v", recStrings()) }
+}
+
+func TestMigrator_StepCheckpointsResumeAtFailedStep(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := func(second string) MigrationSource {
+        return &staticSource{migs: []Migration{{Version: "001", Name: "backfill", UpSteps: []MigrationStep{NewSQLMigrationStep("STEP_1"), NewSQLMigrationStep(second), NewSQLMigrationStep("STEP_3")}}}}
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithStepCheckpoints(true)
+    if err := m.WithSources([]MigrationSource{src("FAIL_2")}).MigrateUp(context.Background(), ""); err == nil { t.Fatalf("expected failure") }
+    if !containsSubstr("CREATE TABLE IF NOT EXISTS hist_steps") || countExec("INSERT INTO hist_steps (migration_name, version, step, completed_at, duration_ms) VALUES (?, ?, ?, ?, ?)") != 1 { t.Fatalf("expected one checkpoint; recs=%v", recStrings()) }
+
+    resetRecs()
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{"SELECT step FROM hist_steps": {{int64(1)}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    result, err := m.WithSources([]MigrationSource{src("STEP_2")}).MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("resume: %v", err) }
+    if containsExec("STEP_1") || !containsExec("STEP_2") || !containsExec("STEP_3") { t.Fatalf("expected resume at step 2; recs=%v", recStrings()) }
+    if !containsSubstr("DELETE FROM hist_steps WHERE migration_name = ? AND version = ?") { t.Fatalf("expected checkpoints cleared; recs=%v", recStrings()) }
+    if got := result.Migrations[0].RowsAffected; !reflect.DeepEqual(got, []int64{0, 1, 1}) { t.Fatalf("unexpected rows %v", got) }
+    if inserts := recArgs("INSERT INTO hist ("); len(inserts) != 1 || inserts[0][10] != 2 { t.Fatalf("expected attempts = 2 for a resumed migration, got %v", inserts) }
+
+    resetRecs()
+    if err := m.WithTransactional(true).WithSources([]MigrationSource{src("STEP_2")}).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("transactional: %v", err) }
+    if containsSubstr("hist_steps") { t.Fatalf("transactional runs must not checkpoint; recs=%v", recStrings()) }
+}
+
+func TestMigrator_StepHistoryKeepsStepRecords(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("S1"), NewSQLMigrationStep("S2")}, DownSteps: []MigrationStep{NewSQLMigrationStep("D")}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithTransactional(true).WithStepHistory(true)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if countExec("INSERT INTO hist_steps (migration_name, version, step, completed_at, duration_ms) VALUES (?, ?, ?, ?, ?)") != 2 || containsSubstr("DELETE FROM hist_steps") { t.Fatalf("expected kept step records; recs=%v", recStrings()) }
+
+    rowsMu.Lock(); rowsForNextQuery = [][]driver.Value{{"001"}}; rowsMu.Unlock()
+    if err := m.MigrateDown(context.Background(), ""); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    if !containsSubstr("DELETE FROM hist_steps WHERE migration_name = ? AND version = ?") { t.Fatalf("expected step records removed on rollback; recs=%v", recStrings()) }
+
+    rowsMu.Lock()
+    rowsForNextQuery = [][]driver.Value{{"app", "001", int64(2), "2024-01-02 03:04:05", int64(1500)}}
+    colsForNextQuery = []string{"migration_name", "version", "step", "completed_at", "duration_ms"}
+    rowsMu.Unlock()
+    entries, err := m.StepEntries(context.Background())
+    if err != nil || len(entries) != 1 || entries[0].Step != 2 || entries[0].Duration != 1500*time.Millisecond || entries[0].CompletedAt.Hour() != 3 { t.Fatalf("unexpected entries %+v, %v", entries, err) }
+}
+
+func TestMigrator_StepRetryUsesSavepoints(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    calls := 0
+    flaky := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        calls++
+        if calls == 1 { _, err := exec.ExecContext(ctx, "FAIL lock timeout"); return err }
+        _, err := exec.ExecContext(ctx, "UPDATE t SET x = 1")
+        return err
+    })
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{flaky}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithTransactional(true)
+    result, err := m.WithStepRetry(3, 0, nil).MigrateUpResult(context.Background(), "")
+    if err != nil || calls != 2 { t.Fatalf("expected retried step to succeed, calls=%d err=%v", calls, err) }
+    if countExec("SAVEPOINT migrator_step") != 2 || countExec("ROLLBACK TO SAVEPOINT migrator_step") != 1 || countExec("RELEASE SAVEPOINT migrator_step") != 1 { t.Fatalf("unexpected savepoint usage; recs=%v", recStrings()) }
+    if got := result.Migrations[0].RowsAffected; !reflect.DeepEqual(got, []int64{1}) { t.Fatalf("rows must count the successful attempt only, got %v", got) }
+    if inserts := recArgs("INSERT INTO hist"); len(inserts) != 1 || inserts[0][10] != 2 { t.Fatalf("expected attempts = 2 after one retry, got %v", inserts) }
+
+    resetRecs(); calls = 0
+    err = m.WithStepRetry(3, 0, func(error) bool { return false }).MigrateUp(context.Background(), "")
+    if err == nil || calls != 1 { t.Fatalf("expected non-retryable failure after one call, calls=%d err=%v", calls, err) }
+}
+
+func TestMigrator_StepValuesPassedBetweenSteps(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    compute := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        StepValuesFromContext(ctx).Set("max_id", 42)
+        return nil
+    })
+    use := NewTemplateMigrationStep("DELETE FROM t WHERE id <= {{.max_id}}", "")
+    first := Migration{Version: "001", Name: "a", UpSteps: []MigrationStep{compute, use}}
+    second := Migration{Version: "002", Name: "b", UpSteps: []MigrationStep{use}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{first, second}}})
+    err := m.MigrateUp(context.Background(), "")
+    if !containsExec("DELETE FROM t WHERE id <= 42") { t.Fatalf("expected rendered template; recs=%v", recStrings()) }
+    var stepErr *StepError
+    if !errors.As(err, &stepErr) || stepErr.Version != "002" || !strings.Contains(err.Error(), "max_id") { t.Fatalf("values must not leak into other migrations, got %v", err) }
+    if StepValuesFromContext(context.Background()) != nil { t.Fatalf("expected no values outside a migration") }
+}
+
+type searchClientKey struct{}
+
+func TestMigrator_HookContextInjectsServices(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var got any
+    hook := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        got = ctx.Value(searchClientKey{})
+        if StepValuesFromContext(ctx) == nil { return errors.New("step values lost") }
+        return nil
+    })
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{hook}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).
+        WithHookContext(func(ctx context.Context) context.Context { return context.WithValue(ctx, searchClientKey{}, "client") })
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if got != "client" { t.Fatalf("expected injected client, got %v", got) }
+}
+
+func TestMigrator_TxHookReceivesTransaction(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var got *sql.Tx
+    step := NewTxHookMigrationStep(func(ctx context.Context, tx *sql.Tx) error {
+        got = tx
+        _, err := tx.ExecContext(ctx, "COPY_IN")
+        return err
+    }, nil)
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{step}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    if err := m.WithTransactional(true).MigrateUp(context.Background(), ""); err != nil || got == nil || !containsExec("COPY_IN") { t.Fatalf("expected tx hook to run, tx=%v err=%v", got, err) }
+    err := m.MigrateUp(context.Background(), "")
+    if err == nil || !strings.Contains(err.Error(), "requires a transactional run") { t.Fatalf("expected non-transactional failure, got %v", err) }
+}
+
+func TestMigrator_StepsCanQueryInTransaction(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{"SELECT max(id)": {{int64(7)}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    var maxIDs []int64
+    hook := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        q, ok := exec.(Queryer)
+        if !ok { return fmt.Errorf("executor %T cannot query", exec) }
+        var maxID int64
+        if err := q.QueryRowContext(ctx, "SELECT max(id) FROM {{schema}}.t").Scan(&maxID); err != nil { return err }
+        maxIDs = append(maxIDs, maxID)
+        return nil
+    })
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{ForSchemas(hook, []string{"s1"})}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithTransactional(true).WithAudit(AuditChecksum)
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !reflect.DeepEqual(maxIDs, []int64{7}) || !containsExec("SELECT max(id) FROM s1.t") { t.Fatalf("unexpected query results %v; recs=%v", maxIDs, recStrings()) }
+}
+
+func TestMigrator_SQLStepArgsBindNamedParameters(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    steps := []MigrationStep{
+        NewSQLMigrationStepArgs("DELETE FROM t WHERE tenant = ?", "acme"),
+        NewSQLMigrationStepArgs("UPDATE t SET note = ':skip', at = created::date WHERE tenant = :tenant AND created < :cutoff OR owner = :tenant -- :x", sql.Named("tenant", "acme"), sql.Named("cutoff", "2024-01-01")),
+    }
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: steps}}}
+    m := NewMigrator(db, "hist", NewPostgresHistoryManager(), "app").WithSources([]MigrationSource{src})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    want := map[string][]any{
+        "DELETE FROM t WHERE tenant = ?": {"acme"},
+        "UPDATE t SET note = ':skip', at = created::date WHERE tenant = $1 AND created < $2 OR owner = $3 -- :x": {"acme", "2024-01-01", "acme"},
+    }
+    recMu.Lock(); defer recMu.Unlock()
+    for _, r := range recs {
+        if args, ok := want[r.query]; ok {
+            if !reflect.DeepEqual(r.args, args) { t.Fatalf("args of %q = %v, want %v", r.query, r.args, args) }
+            delete(want, r.query)
+        }
+    }
+    if len(want) != 0 { t.Fatalf("statements not executed: %v", want) }
+    if _, _, err := bindArgs(context.Background(), "SELECT :missing", []any{sql.Named("other", 1)}); err == nil { t.Fatalf("expected error for unbound parameter") }
+    if _, _, err := bindArgs(context.Background(), "SELECT :a, ?", []any{sql.Named("a", 1), 2}); err == nil { t.Fatalf("expected error for mixed args") }
+}
+
+func TestMigrator_BindVarsFromConfiguration(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    file := filepath.Join(t.TempDir(), "001_purge_up.sql")
+    mustWrite(t, file, "DELETE FROM events WHERE tenant = :tenant AND age > :days AND note <> ':days' AND x = :other")
+    mig := NewMigration("001", "purge").WithUpSteps([]MigrationStep{NewFileSQLMigrationStep(file), NewSQLMigrationStep("SELECT 1")}).WithBindVars(map[string]any{"tenant": "dev", "days": 7})
+    src := &staticSource{migs: []Migration{*mig}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithBindVars(map[string]map[string]any{"001": {"tenant": "prod"}})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    recMu.Lock(); defer recMu.Unlock()
+    for _, r := range recs {
+        if strings.HasPrefix(r.query, "DELETE FROM events") {
+            if r.query != "DELETE FROM events WHERE tenant = ? AND age > ? AND note <> ':days' AND x = :other" || !reflect.DeepEqual(r.args, []any{"prod", 7}) { t.Fatalf("unexpected bound statement %q %v", r.query, r.args) }
+            return
+        }
+    }
+    t.Fatalf("statement not executed: %v", recs)
+}
+
+func TestMigrator_NotifiersReportFailedRuns(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var mu sync.Mutex
+    var hook Notification
+    var slack map[string]string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        mu.Lock(); defer mu.Unlock()
+        switch r.URL.Path {
+        case "/hook":
+            if r.Header.Get("Authorization") != "Bearer t" { w.WriteHeader(http.StatusUnauthorized); return }
+            _ = json.NewDecoder(r.Body).Decode(&hook)
+        case "/slack": _ = json.NewDecoder(r.Body).Decode(&slack)
+        default: w.WriteHeader(http.StatusNotFound)
+        }
+    }))
+    defer srv.Close()
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE a")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("FAIL")}},
+    }}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).
+        WithNotifiers(NewWebhookNotifier(srv.URL+"/hook").WithHeader("Authorization", "Bearer t"), NewSlackNotifier(srv.URL+"/slack"), NewWebhookNotifier(srv.URL+"/missing"))
+    res, err := m.MigrateUpResult(context.Background(), "")
+    if err == nil { t.Fatalf("expected failure") }
+    mu.Lock(); defer mu.Unlock()
+    if hook.Succeeded || hook.Direction != "up" || hook.Error == "" || len(hook.Migrations) != 1 || hook.Migrations[0].Version != "001" { t.Fatalf("unexpected webhook payload %+v", hook) }
+    if !strings.HasPrefix(slack["text"], ":rotating_light: migrate up failed") || !strings.Contains(slack["text"], "(001)") { t.Fatalf("unexpected slack message %q", slack["text"]) }
+    last := res.Warnings[len(res.Warnings)-1]
+    if last.Code != WarningNotifyFailed || last.Message != "notifier 3 failed: post notification: 404 Not Found" { t.Fatalf("unexpected warnings %+v", res.Warnings) }
+}
+
+func TestJSONAuditSink_WritesNDJSON(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    path := filepath.Join(t.TempDir(), "audit.ndjson")
+    sink, err := OpenJSONAuditSink(path)
+    if err != nil { t.Fatalf("open: %v", err) }
+    var types []EventType
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", Checksum: "abc", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE a")}, DownSteps: []MigrationStep{NewSQLMigrationStep("DROP TABLE a")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("FAIL")}, DownSteps: []MigrationStep{NewSQLMigrationStep("X")}},
+    }}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).
+        WithEventSink(MultiEventSink(sink, EventSinkFunc(func(e Event){ types = append(types, e.Type) })))
+    if err := m.MigrateUp(context.Background(), ""); err == nil { t.Fatalf("expected failure") }
+    if err := sink.Close(); err != nil || sink.Err() != nil { t.Fatalf("close: %v %v", err, sink.Err()) }
+    data, err := os.ReadFile(path)
+    if err != nil { t.Fatalf("read: %v", err) }
+    lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+    if len(lines) != len(types) { t.Fatalf("got %d lines for %d events", len(lines), len(types)) }
+    var events []map[string]any
+    for _, line := range lines {
+        var e map[string]any
+        if err := json.Unmarshal([]byte(line), &e); err != nil { t.Fatalf("line %q: %v", line, err) }
+        events = append(events, e)
+    }
+    if events[0]["event"] != "run_started" || events[len(events)-1]["event"] != "run_failed" || events[len(events)-1]["error"] == "" { t.Fatalf("unexpected events %v", events) }
+    for _, e := range events {
+        if e["event"] == "migration_applied" && (e["version"] != "001" || e["checksum"] != "abc" || e["rows_affected"] != float64(1)) { t.Fatalf("unexpected applied event %v", e) }
+    }
+}
+
+func TestJSONLogSink_StableFields(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var buf strings.Builder
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE a"), NewSQLMigrationStep("FAIL")}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithEventSink(NewJSONLogSink(&buf))
+    if err := m.MigrateUp(context.Background(), ""); err == nil { t.Fatalf("expected failure") }
+    var failed map[string]any
+    for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+        var e map[string]any
+        if err := json.Unmarshal([]byte(line), &e); err != nil { t.Fatalf("line %q: %v", line, err) }
+        if e["level"] == nil || e["msg"] == nil || e["time"] == nil { t.Fatalf("missing common fields in %v", e) }
+        if e["event"] == "step_failed" { failed = e }
+    }
+    if failed == nil || failed["level"] != "error" || failed["version"] != "001" || failed["name"] != "a" || failed["direction"] != "up" || failed["step"] != float64(2) || failed["duration_ms"] == nil || failed["error"] == "" || failed["msg"] != "up step 2 of migration 001 failed" { t.Fatalf("unexpected step_failed line %v", failed) }
+}
+
+func TestResult_RenderReports(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE a (x INT)"), NewSQLMigrationStep("INSERT INTO a\nVALUES ('<b>|')")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("FAIL")}},
+    }}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithSQLPreviewLength(30)
+    res, err := m.MigrateUpResult(context.Background(), "")
+    if err == nil || res.Err != err { t.Fatalf("expected failure recorded in result, got %v / %v", err, res.Err) }
+    if res.Migrations[0].SQLPreview != "CREATE TABLE a (x INT); INSERT..." { t.Fatalf("unexpected preview %q", res.Migrations[0].SQLPreview) }
+    md := res.RenderMarkdown()
+    for _, want := range []string{"## Migration report (up)", "- Status: failed: ", "| 001 | a | ", "| 2 | `CREATE TABLE a (x INT); INSERT...` |", "### Warnings", "`missing_down_steps`"} {
+        if !strings.Contains(md, want) { t.Fatalf("markdown lacks %q:\n%s", want, md) }
+    }
+    res.Migrations[0].SQLPreview = "VALUES ('<b>|')"
+    html := res.RenderHTML()
+    if !strings.Contains(html, "<td>001</td><td>a</td>") || !strings.Contains(html, "<code>VALUES (&#39;&lt;b&gt;|&#39;)</code>") { t.Fatalf("unexpected html:\n%s", html) }
+    if !strings.Contains(res.RenderMarkdown(), "`VALUES ('<b>\\|')`") { t.Fatalf("pipes not escaped:\n%s", res.RenderMarkdown()) }
+    redacted, _ := m.WithRedactSQL(RedactFull).MigrateUpResult(context.Background(), "")
+    for _, mig := range redacted.Migrations { if mig.SQLPreview != "" { t.Fatalf("preview of redacted run: %q", mig.SQLPreview) } }
+}
+
+func TestMigrator_ManifestListsMigrationSet(t *testing.T){
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_init_up.sql"), "CREATE TABLE a (x INT);")
+    mustWrite(t, filepath.Join(dir, "001_init_down.sql"), "DROP TABLE a;")
+    vars := NewVarMigrationSource("002", "seed", "INSERT INTO a VALUES (1)", "DELETE FROM a")
+    build := func(src *DirMigrationSource) *Manifest {
+        mf, err := NewMigrator(nil, "hist", nil, "app").WithSources([]MigrationSource{src, vars}).Manifest()
+        if err != nil { t.Fatalf("Manifest: %v", err) }
+        return mf
+    }
+    mf := build(NewDirMigrationSource(dir))
+    withSums := build(NewDirMigrationSource(dir).WithChecksums(true))
+    want := []ManifestEntry{
+        {Version: "001", Name: "init", MigrationName: "app", Checksum: mf.Migrations[0].Checksum, Size: 36, Source: filepath.Join(dir, "001_init_up.sql")},
+        {Version: "002", Name: "seed", MigrationName: "app", Checksum: mf.Migrations[1].Checksum, Size: 37, Source: "inline"},
+    }
+    if !reflect.DeepEqual(mf.Migrations, want) || len(want[0].Checksum) != 64 || len(want[1].Checksum) != 64 { t.Fatalf("unexpected manifest %+v", mf.Migrations) }
+    all, _ := NewMigrator(nil, "hist", nil, "app").WithSources([]MigrationSource{NewDirMigrationSource(dir).WithChecksums(true)}).LoadAllMigrations()
+    if withSums.Migrations[0].Checksum != mf.Migrations[0].Checksum || all[0].Checksum == mf.Migrations[0].Checksum { t.Fatalf("expected the checksum computed from the steps, not the source: %+v", withSums.Migrations[0]) }
+    d1, err := mf.Digest()
+    if err != nil { t.Fatalf("Digest: %v", err) }
+    mustWrite(t, filepath.Join(dir, "001_init_down.sql"), "DROP TABLE IF EXISTS a;")
+    d2, _ := build(NewDirMigrationSource(dir)).Digest()
+    if d1 == d2 { t.Fatalf("digest did not change with the SQL") }
+}
+
+func TestMigrator_SignatureKeysRequireSignedSQL(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    pub, priv, _ := ed25519.GenerateKey(nil)
+    _, other, _ := ed25519.GenerateKey(nil)
+    dir := t.TempDir()
+    for _, f := range []string{"001_a_up.sql", "001_a_down.sql", "002_b_up.sql"} {
+        mustWrite(t, filepath.Join(dir, f), "CREATE TABLE "+f[:5])
+    }
+    for _, f := range []string{"001_a_up.sql", "001_a_down.sql"} {
+        if err := SignFile(priv, filepath.Join(dir, f)); err != nil { t.Fatalf("SignFile: %v", err) }
+    }
+    if err := SignFile(other, filepath.Join(dir, "002_b_up.sql")); err != nil { t.Fatalf("SignFile: %v", err) }
+    key, err := ParseSignatureKey(base64.StdEncoding.EncodeToString(pub))
+    if err != nil { t.Fatalf("ParseSignatureKey: %v", err) }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{NewDirMigrationSource(dir)}).WithSignatureKeys(key)
+    if err := m.MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSignature) || !strings.Contains(err.Error(), "002_b_up.sql") { t.Fatalf("expected invalid signature of 002, got %v", err) }
+    if containsSubstr("CREATE TABLE 001_a") { t.Fatalf("migration executed before verification: %v", recStrings()) }
+    if err := SignFile(priv, filepath.Join(dir, "002_b_up.sql")); err != nil { t.Fatalf("SignFile: %v", err) }
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+
+    inline := &staticSource{migs: []Migration{{Version: "003", Name: "c", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE c")}}}}
+    signed := m.WithSources([]MigrationSource{inline})
+    if err := signed.MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected inline SQL to be rejected, got %v", err) }
+    mf, _ := signed.Manifest()
+    data, _ := mf.JSON()
+    if err := signed.WithSignedManifest(data, Sign(other, data)).MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected manifest signed by another key to be rejected, got %v", err) }
+    tampered := signed.WithSources([]MigrationSource{&staticSource{migs: []Migration{{Version: "003", Name: "c", UpSteps: []MigrationStep{NewSQLMigrationStep("DROP TABLE c")}}}}})
+    if err := tampered.WithSignedManifest(data, Sign(priv, data)).MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected tampered migration to be rejected, got %v", err) }
+    if err := signed.WithSignedManifest(data, Sign(priv, data)).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp with signed manifest: %v", err) }
+}
+
+func TestMigrator_SignatureVerifiedContentIsExecuted(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    pub, priv, _ := ed25519.GenerateKey(nil)
+    swap := func(ctx context.Context, exec Executor, path string) error { return os.WriteFile(path, []byte("DROP TABLE swapped"), 0o600) }
+    source := func(dir string) *DirMigrationSource {
+        mustWrite(t, filepath.Join(dir, "001_a_up.sql"), "CREATE TABLE signed_a")
+        if err := SignFile(priv, filepath.Join(dir, "001_a_up.sql")); err != nil { t.Fatalf("SignFile: %v", err) }
+        src := NewDirMigrationSource(dir).WithChecksums(true)
+        src.ResolveHooks = func(string) (FileHookFn, FileHookFn) { return swap, nil }
+        return src
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSignatureKeys(pub)
+    resetRecs()
+    if err := m.WithSources([]MigrationSource{source(t.TempDir())}).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsExec("CREATE TABLE signed_a") || containsSubstr("swapped") { t.Fatalf("expected the verified file content to run, got %v", recStrings()) }
+
+    manifested := m.WithSources([]MigrationSource{source(t.TempDir())})
+    mf, _ := manifested.Manifest()
+    data, _ := mf.JSON()
+    resetRecs()
+    if err := manifested.WithSignedManifest(data, Sign(priv, data)).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp with signed manifest: %v", err) }
+    if !containsExec("CREATE TABLE signed_a") || containsSubstr("swapped") { t.Fatalf("expected the verified manifest content to run, got %v", recStrings()) }
+
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "s.sql"), "CREATE TABLE {{schema}}.t")
+    if err := SignFile(priv, filepath.Join(dir, "s.sql")); err != nil { t.Fatalf("SignFile: %v", err) }
+    wrapped := &staticSource{migs: []Migration{{Version: "001", Name: "s", UpSteps: []MigrationStep{ForSchemas(NewFileSQLMigrationStep(filepath.Join(dir, "s.sql")), []string{"x"})}}}}
+    resetRecs()
+    if err := m.WithSources([]MigrationSource{wrapped}).MigrateUp(context.Background(), ""); err != nil || !containsExec("CREATE TABLE x.t") { t.Fatalf("expected signed file in ForSchemas to run: %v %v", err, recStrings()) }
+    for _, step := range []MigrationStep{ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.t"), []string{"x"}), NewTemplateMigrationStep("DROP TABLE t", ""), &PartitionStep{UpSQL: "DROP TABLE t"}} {
+        src := &staticSource{migs: []Migration{{Version: "002", Name: "u", UpSteps: []MigrationStep{step}}}}
+        if err := m.WithSources([]MigrationSource{src}).MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected %T to be rejected, got %v", step, err) }
+    }
+}
+
+func TestMigrator_SignedManifestHashesExecutedSQL(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    pub, priv, _ := ed25519.GenerateKey(nil)
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_a_up.sql"), "CREATE TABLE a")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSignatureKeys(pub)
+    dirSrc := NewDirMigrationSource(dir).WithChecksums(true)
+    loaded, _ := m.WithSources([]MigrationSource{dirSrc}).LoadAllMigrations()
+    mf, _ := m.WithSources([]MigrationSource{dirSrc}).Manifest()
+    data, _ := mf.JSON()
+    signed := m.WithSignedManifest(data, Sign(priv, data))
+    run := func(mig Migration) error { return signed.WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}}).MigrateUp(context.Background(), "") }
+
+    resetRecs()
+    inline := Migration{Version: "001", Name: "a", MigrationName: "app", Checksum: mf.Migrations[0].Checksum, UpSteps: []MigrationStep{NewSQLMigrationStep("DELETE FROM a")}}
+    if err := run(inline); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected a claimed checksum to be ignored, got %v", err) }
+    extra := loaded[0]
+    extra.UpSteps = append(slices.Clone(extra.UpSteps), NewSQLMigrationStep("DELETE FROM a"))
+    if err := run(extra); !errors.Is(err, ErrInvalidSignature) { t.Fatalf("expected an inline step next to a signed file to be covered, got %v", err) }
+    if containsSubstr("DELETE FROM a") { t.Fatalf("tampered SQL executed: %v", recStrings()) }
+    if err := signed.WithSources([]MigrationSource{dirSrc}).MigrateUp(context.Background(), ""); err != nil || !containsExec("CREATE TABLE a") { t.Fatalf("expected the signed migration to run: %v", err) }
+}
+
+func TestEncryptedSource_DecryptsAtLoadTime(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    key := make([]byte, 32)
+    dir := t.TempDir()
+    enc, err := EncryptSQL(key, []byte("-- ticket: SEC-1\nINSERT INTO users VALUES ('secret')"))
+    if err != nil { t.Fatalf("EncryptSQL: %v", err) }
+    mustWrite(t, filepath.Join(dir, "001_seed_up.enc"), string(enc))
+    mustWrite(t, filepath.Join(dir, "001_seed_down.sql"), "DELETE FROM users")
+    if strings.Contains(string(enc), "secret") { t.Fatalf("SQL stored in plain text") }
+    t.Setenv("MIGRATIONS_KEY", base64.StdEncoding.EncodeToString(key))
+    src := NewEncryptedSource(NewDirMigrationSource(dir).WithAllowedExts([]string{".sql", ".enc"}), EnvKey("MIGRATIONS_KEY"))
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    res, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsSubstr("INSERT INTO users VALUES ('secret')") || res.Migrations[0].Ticket != "SEC-1" { t.Fatalf("encrypted migration not applied: %v %+v", recStrings(), res.Migrations) }
+    wrong := NewEncryptedSource(NewDirMigrationSource(dir).WithAllowedExts([]string{".sql", ".enc"}), StaticKey(make([]byte, 16)))
+    if _, err := wrong.LoadMigrations(); !errors.Is(err, ErrDecrypt) { t.Fatalf("expected ErrDecrypt, got %v", err) }
+}
+
+func TestMigrator_SecretProvidersRenderAndMaskSecrets(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        switch {
+        case r.URL.Path == "/v1/secret/data/db" && r.Header.Get("X-Vault-Token") == "tok":
+            _, _ = w.Write([]byte(`{"data":{"data":{"reporting_password":"s3cr3t"}}}`))
+        case r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue" && strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"):
+            _, _ = w.Write([]byte(`{"SecretString":"{\"password\":\"hunter2\"}"}`))
+        default: w.WriteHeader(http.StatusForbidden)
+        }
+    }))
+    defer srv.Close()
+    vault := NewVaultSecretProvider(srv.URL, "tok")
+    aws := &AWSSecretsManagerProvider{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "key", Endpoint: srv.URL}
+    provider := SecretProviderFunc(func(ctx context.Context, name string) (string, error) {
+        if strings.Contains(name, "#") { return aws.Secret(ctx, name) }
+        return vault.Secret(ctx, name)
+    })
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", UpSteps: []MigrationStep{NewTemplateMigrationStep(`CREATE ROLE reporting PASSWORD '{{ secret "db/reporting_password" }}'`, "")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewTemplateMigrationStep(`FAIL SERVER OPTIONS (password '{{ secret "prod/reporting#password" }}')`, "")}},
+    }}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithSecretProvider(provider)
+    res, err := m.MigrateUpResult(context.Background(), "")
+    if !containsExec("CREATE ROLE reporting PASSWORD 's3cr3t'") || !containsExec("FAIL SERVER OPTIONS (password 'hunter2')") { t.Fatalf("expected rendered secrets; recs=%v", recStrings()) }
+    if len(res.Migrations) != 1 || res.Migrations[0].SQLPreview != "CREATE ROLE reporting PASSWORD '[secret]'" { t.Fatalf("expected masked preview, got %+v", res.Migrations) }
+    var stepErr *StepError
+    if !errors.As(err, &stepErr) || strings.Contains(err.Error(), "hunter2") || !strings.Contains(stepErr.SQL, "[secret]") { t.Fatalf("expected masked error, got %v", err) }
+    _, err = NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).MigrateUpResult(context.Background(), "")
+    if err == nil || !strings.Contains(err.Error(), "no secret provider configured") { t.Fatalf("expected missing provider error, got %v", err) }
+}
+
+func TestMigrator_PolicyHookGatesDestructiveMigrations(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    hook := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error { return nil })
+    src := &staticSource{migs: []Migration{
+        {Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("CREATE TABLE a(id INT); ALTER TABLE a ALTER COLUMN id DROP DEFAULT")}},
+        {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("INSERT INTO a VALUES (1)"), hook}},
+        {Version: "003", Name: "c", UpSteps: []MigrationStep{NewSQLMigrationStep("UPDATE a SET id = 2; ALTER TABLE a DROP COLUMN id; DROP TABLE b")}},
+    }}
+    var checks []PolicyCheck
+    calls := 0
+    approve := func(ctx context.Context, check PolicyCheck) (bool, error) { calls++; return calls >= 2, nil }
+    gate := RequireApproval(approve, time.Millisecond, ClassDestructive)
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithAllowDestructive(true).
+        WithPolicyHook(func(ctx context.Context, check PolicyCheck) error { checks = append(checks, check); return gate(ctx, check) })
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("migrate: %v", err) }
+    if len(checks) != 3 || checks[0].Class != ClassSchemaOnly || checks[1].Class != ClassDataModifying || checks[2].Class != ClassDestructive { t.Fatalf("unexpected checks %+v", checks) }
+    if got := checks[2].Statements; len(got) != 2 || got[0] != "ALTER TABLE a DROP COLUMN id" || got[1] != "DROP TABLE b" { t.Fatalf("unexpected destructive statements %q", got) }
+    if calls != 2 || !containsSubstr("DROP TABLE b") { t.Fatalf("expected approval before applying, calls=%d recs=%v", calls, recStrings()) }
+    resetRecs()
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond); defer cancel()
+    never := func(ctx context.Context, check PolicyCheck) (bool, error) { return false, nil }
+    err := m.WithPolicyHook(RequireApproval(never, time.Millisecond, ClassDestructive)).MigrateUp(ctx, "")
+    if !errors.Is(err, ErrPolicyDenied) || !strings.Contains(err.Error(), "migration 003 (c) blocked by policy") { t.Fatalf("expected denial, got %v", err) }
+    if containsSubstr("CREATE TABLE a(id INT)") { t.Fatalf("no migration may run before the policy passes; recs=%v", recStrings()) }
+}
+
+func TestMigrator_RefusesDestructiveMigrations(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := Migration{Version: "001", Name: "a", UpSteps: []MigrationStep{
+        NewSQLMigrationStep("CREATE TABLE a(id INT); ALTER TABLE a ADD COLUMN b INT NOT NULL DEFAULT 0, ALTER COLUMN id DROP DEFAULT, DROP CONSTRAINT c"),
+        NewSQLMigrationStep("-- DROP TABLE a\nALTER TABLE a ADD COLUMN email TEXT NOT NULL, DROP COLUMN name, ALTER COLUMN id TYPE smallint; ALTER TABLE b MODIFY code VARCHAR(10) NOT NULL; TRUNCATE c; DROP INDEX i"),
+    }}
+    findings, err := AnalyzeDestructive(mig)
+    if err != nil { t.Fatalf("analyze: %v", err) }
+    var reasons []string
+    for _, f := range findings { if f.Step != 2 { t.Fatalf("unexpected finding %+v", f) }; reasons = append(reasons, f.Reason) }
+    want := []string{"adds NOT NULL column email without a default", "drops column name", "changes column id to SMALLINT, which may narrow it", "changes column code to VARCHAR(10), which may narrow it", "truncates a table", "drops index"}
+    if !reflect.DeepEqual(reasons, want) { t.Fatalf("unexpected reasons %q", reasons) }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}})
+    err = m.MigrateUp(context.Background(), "")
+    var destructive *DestructiveMigrationError
+    if !errors.As(err, &destructive) || !errors.Is(err, ErrDestructiveMigration) || !strings.HasPrefix(err.Error(), "migration 001 (a) is destructive: step 2 adds NOT NULL column email") { t.Fatalf("expected refusal, got %v", err) }
+    if containsSubstr("CREATE TABLE a") { t.Fatalf("no migration may run; recs=%v", recStrings()) }
+    if err := m.WithAllowDestructive(true).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("allowed migrate: %v", err) }
+    resetRecs()
+    annotated := NewVarMigrationSource("002", "b", "-- allow-destructive: legacy table, see JIRA-12\nDROP TABLE legacy", "")
+    if err := m.WithSources([]MigrationSource{annotated}).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("annotated migrate: %v", err) }
+    if !containsSubstr("DROP TABLE legacy") { t.Fatalf("expected annotated migration to run; recs=%v", recStrings()) }
+}
+
+type batchExec struct{ rows []int64; queries []string }
+
+func (b *batchExec) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+    b.queries = append(b.queries, query)
+    n := b.rows[0]; b.rows = b.rows[1:]
+    return driver.RowsAffected(n), nil
+}
+
+func TestBatchMigrationStep_PacesOnReplicaLag(t *testing.T){
+    resetRecs()
+    replica, _ := sql.Open("testdrv", ""); defer replica.Close()
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{"SELECT lag_ok": {{float64(0.5)}}, "SELECT lag_high": {{float64(3)}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    pacer := NewReplicaPacer(DialectPostgres, time.Second).WithReplicas(replica).WithQuery("SELECT lag_ok").WithPollInterval(time.Millisecond)
+    if pacer.WithQuery("").Query != "" || NewReplicaPacer(DialectPostgres, 0).Query != ReplicaLagQueries[DialectPostgres] { t.Fatalf("unexpected pacer queries") }
+    exec := &batchExec{rows: []int64{1000, 20, 0}}
+    if err := NewBatchMigrationStep("UPDATE t SET x = 1 LIMIT 1000").WithPacer(pacer).ExecuteUp(context.Background(), exec); err != nil { t.Fatalf("batches: %v", err) }
+    if len(exec.queries) != 3 || countExec("SELECT lag_ok") != 2 { t.Fatalf("expected 3 batches paced twice, got %d batches recs=%v", len(exec.queries), recStrings()) }
+    exec = &batchExec{rows: []int64{5, 0}}
+    err := NewBatchMigrationStep("UPDATE t SET x = 1").WithPacer(pacer.WithQuery("SELECT lag_high").WithMaxWait(5*time.Millisecond)).ExecuteUp(context.Background(), exec)
+    if !errors.Is(err, ErrReplicaLag) || !strings.Contains(err.Error(), "after batch 1: replica lag: 3s still exceeds 1s") { t.Fatalf("expected lag error, got %v", err) }
+}
+
+func TestOnlineSchemaChangeStep_RunsTool(t *testing.T){
+    conn := OnlineSchemaConn{Host: "db", Port: 3307, User: "u", Password: "p", Database: "app"}
+    cmd, err := NewOnlineSchemaChangeStep(ToolPtOnlineSchemaChange, conn, "users", "ADD COLUMN age INT").WithArgs("--chunk-size=500").Command()
+    if err != nil || !reflect.DeepEqual(cmd, []string{"pt-online-schema-change", "--alter", "ADD COLUMN age INT", "--execute", "h=db,P=3307,u=u,p=p,D=app,t=users", "--chunk-size=500"}) { t.Fatalf("unexpected command %q %v", cmd, err) }
+    dir := t.TempDir()
+    tool := filepath.Join(dir, "gh-ost")
+    mustWrite(t, tool, "#!/bin/sh\necho \"$@\"\nif [ \"$SLEEP\" ]; then exec sleep 5; fi\n")
+    if err := os.Chmod(tool, 0o755); err != nil { t.Fatal(err) }
+    var out bytes.Buffer
+    step := NewOnlineSchemaChangeStep(ToolGhost, conn, "users", "DROP COLUMN age").WithPath(tool).WithOutput(&out)
+    if err := step.ExecuteUp(context.Background(), nil); err != nil { t.Fatalf("run: %v", err) }
+    if got := out.String(); got != "--host=db --user=u --password=p --database=app --table=users --alter=DROP COLUMN age --execute --port=3307\n" { t.Fatalf("unexpected output %q", got) }
+    findings, _ := AnalyzeDestructive(Migration{Version: "001", UpSteps: []MigrationStep{step}})
+    if len(findings) != 1 || findings[0].Reason != "drops column age" { t.Fatalf("expected the alter to be analyzed, got %+v", findings) }
+    t.Setenv("SLEEP", "1")
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond); defer cancel()
+    start := time.Now()
+    err = step.ExecuteUp(ctx, nil)
+    if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 3*time.Second { t.Fatalf("expected interrupted tool, got %v after %s", err, time.Since(start)) }
+}
+
+type slowExec struct{ *sql.DB }
+
+func (s slowExec) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+    if strings.HasPrefix(query, "CREATE") { time.Sleep(50*time.Millisecond) }
+    return s.DB.ExecContext(ctx, query, args...)
+}
+
+func TestConcurrentIndexStep_RebuildsInvalidIndexWithProgress(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    rowsMu.Lock()
+    rowsForQueryPrefix = map[string][][]driver.Value{"SELECT indisvalid": {{false}}}
+    rowsForNextQuery, colsForNextQuery = [][]driver.Value{{"building index: scanning table", int64(40), int64(100)}}, []string{"phase", "done", "total"}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix, rowsForNextQuery, colsForNextQuery = nil, nil, nil; rowsMu.Unlock() }()
+    var progress []string
+    var mu sync.Mutex
+    m := NewMigrator(db, "hist", NewPostgresHistoryManager(), "app").WithEventSink(EventSinkFunc(func(e Event){
+        if e.Type == EventStepProgress { mu.Lock(); progress = append(progress, logMessage(e)); mu.Unlock() }
+    }))
+    ctx := m.withProgress(context.WithValue(context.Background(), dbKey{}, db), Migration{Version: "001"}, "up", 2, time.Now())
+    step := NewConcurrentIndexStep("users", "users_email_idx", "(email)").WithUnique(true).WithPollInterval(5*time.Millisecond)
+    if err := step.ExecuteUp(ctx, slowExec{db}); err != nil { t.Fatalf("build: %v", err) }
+    if !containsExec("DROP INDEX CONCURRENTLY IF EXISTS users_email_idx") || !containsExec("CREATE UNIQUE INDEX CONCURRENTLY users_email_idx ON users (email)") { t.Fatalf("expected invalid index rebuilt; recs=%v", recStrings()) }
+    mu.Lock(); defer mu.Unlock()
+    if len(progress) == 0 || progress[0] != "up step 2 of migration 001: building index: scanning table: 40 of 100" { t.Fatalf("unexpected progress %q", progress) }
+    tx, _ := db.Begin(); defer tx.Rollback()
+    txCtx := context.WithValue(context.WithValue(context.Background(), dbKey{}, db), txKey{}, tx)
+    if outsideTransaction(txCtx, tx) != Executor(db) || outsideTransaction(ctx, tx) != Executor(tx) { t.Fatalf("expected the index built outside the transaction") }
+}
+
+func TestPartitionedTable_ManagesTimePartitions(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    jan := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+    pg := NewPartitionedTable(DialectPostgres, "events", PartitionMonthly)
+    if s := pg.CreatePartition(jan); s.UpSQL != "CREATE TABLE IF NOT EXISTS events_p202601 PARTITION OF events FOR VALUES FROM ('2026-01-01') TO ('2026-02-01')" || s.DownSQL != "DROP TABLE IF EXISTS events_p202601" { t.Fatalf("unexpected create step %+v", s) }
+    if s := pg.DetachPartition("events_p202601", jan); s.UpSQL != "ALTER TABLE events DETACH PARTITION events_p202601" || s.DownSQL != "ALTER TABLE events ATTACH PARTITION events_p202601 FOR VALUES FROM ('2026-01-01') TO ('2026-02-01')" { t.Fatalf("unexpected detach step %+v", s) }
+    if got := NewPartitionedTable(DialectPostgres, "logs", PartitionDaily).PartitionName(jan); got != "logs_p20260117" { t.Fatalf("unexpected daily name %s", got) }
+    my := NewPartitionedTable(DialectMySQL, "events", PartitionMonthly)
+    if s := my.AttachPartition("events_archive", jan); s.UpSQL != "ALTER TABLE events EXCHANGE PARTITION p202601 WITH TABLE events_archive" || s.DownSQL != s.UpSQL { t.Fatalf("unexpected mysql attach step %+v", s) }
+    now := func() time.Time { return jan }
+    if err := pg.EnsurePartitions(1).WithNowFunc(now).ExecuteUp(context.Background(), db); err != nil { t.Fatalf("ensure pg: %v", err) }
+    if !containsExec("CREATE TABLE IF NOT EXISTS events_p202602 PARTITION OF events FOR VALUES FROM ('2026-02-01') TO ('2026-03-01')") || countExec("CREATE TABLE IF NOT EXISTS events_p202601 PARTITION OF events FOR VALUES FROM ('2026-01-01') TO ('2026-02-01')") != 1 { t.Fatalf("unexpected pg partitions; recs=%v", recStrings()) }
+    resetRecs()
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{"SELECT PARTITION_NAME": {{"p202601"}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    if err := my.EnsurePartitions(2).WithNowFunc(now).ExecuteUp(context.Background(), db); err != nil { t.Fatalf("ensure mysql: %v", err) }
+    var adds []string
+    for _, q := range recStrings() { if strings.HasPrefix(q, "ALTER TABLE events ADD PARTITION") { adds = append(adds, q) } }
+    if !reflect.DeepEqual(adds, []string{"ALTER TABLE events ADD PARTITION (PARTITION p202602 VALUES LESS THAN ('2026-03-01'))", "ALTER TABLE events ADD PARTITION (PARTITION p202603 VALUES LESS THAN ('2026-04-01'))"}) { t.Fatalf("unexpected mysql partitions %q", adds) }
+}
+
+func TestMigrator_RecreatesChangedCodeObjects(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    dir := t.TempDir()
+    if err := os.Mkdir(filepath.Join(dir, "views"), 0o700); err != nil { t.Fatal(err) }
+    mustWrite(t, filepath.Join(dir, "a_report.sql"), "-- depends: users_view\nCREATE OR REPLACE VIEW a_report AS SELECT count(*) FROM users_view")
+    mustWrite(t, filepath.Join(dir, "views", "users_view.sql"), "CREATE VIEW users_view AS SELECT id FROM users")
+    mustWrite(t, filepath.Join(dir, "add_one.sql"), "CREATE FUNCTION add_one(x integer DEFAULT 1) RETURNS integer AS $$ SELECT x + 1 $$ LANGUAGE sql")
+    objects, err := LoadCodeObjects(dir, DialectPostgres)
+    if err != nil { t.Fatalf("load: %v", err) }
+    var names, drops []string
+    for _, o := range objects { names = append(names, o.Name); drops = append(drops, o.DropSQL) }
+    if !reflect.DeepEqual(names, []string{"users_view", "a_report", "add_one"}) { t.Fatalf("unexpected order %q", names) }
+    if !reflect.DeepEqual(drops, []string{"DROP VIEW IF EXISTS users_view", "DROP VIEW IF EXISTS a_report", "DROP FUNCTION IF EXISTS add_one(x integer)"}) { t.Fatalf("unexpected drops %q", drops) }
+    m := NewMigrator(db, "hist", NewPostgresHistoryManager(), "app").WithObjectsDir(dir)
+    rowsMu.Lock()
+    rowsForNextQuery, colsForNextQuery = [][]driver.Value{{"users_view", "stale", "DROP VIEW users_view CASCADE"}, {"add_one", objects[2].Checksum, objects[2].DropSQL}, {"old_view", "x", "DROP VIEW IF EXISTS old_view"}}, []string{"name", "checksum", "drop_sql"}
+    rowsMu.Unlock()
+    recreated, err := m.applyObjects(context.Background(), newMigrationRun(db))
+    if err != nil { t.Fatalf("apply: %v", err) }
+    if !reflect.DeepEqual(recreated, []string{"users_view", "a_report"}) { t.Fatalf("expected changed view and dependent recreated, got %q", recreated) }
+    var ddl []string
+    for _, q := range recStrings() { if strings.Contains(q, "VIEW") { ddl = append(ddl, q) } }
+    want := []string{"DROP VIEW IF EXISTS a_report", "DROP VIEW users_view CASCADE", "DROP VIEW IF EXISTS old_view", "CREATE VIEW users_view AS SELECT id FROM users", "-- depends: users_view\nCREATE OR REPLACE VIEW a_report AS SELECT count(*) FROM users_view"}
+    if !reflect.DeepEqual(ddl, want) { t.Fatalf("unexpected ddl %q", ddl) }
+    if !containsExec("DELETE FROM hist_objects WHERE name = $1") { t.Fatalf("expected object records updated; recs=%v", recStrings()) }
+    mustWrite(t, filepath.Join(dir, "views", "loop.sql"), "-- depends: a_report\n-- drop: DROP VIEW loop\nCREATE VIEW loop AS SELECT 1")
+    mustWrite(t, filepath.Join(dir, "a_report.sql"), "-- depends: users_view, loop\nCREATE VIEW a_report AS SELECT 1")
+    if _, err := LoadCodeObjects(dir, DialectPostgres); err == nil || !strings.Contains(err.Error(), "a_report -> loop -> a_report") { t.Fatalf("expected cycle error, got %v", err) }
+}
+
+func TestAnonymizeStep_RewritesColumnsInAllowedEnvironments(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    step := NewAnonymizeStep(DialectPostgres, "users", "id", HashColumn("email", "s'alt"), MaskColumn("phone", 4), FakeColumn("name", "user{key}")).WithWhere("id > 0").WithEnvGuard("MIGRATOR_TEST_ENV", "staging")
+    want := "UPDATE users SET email = CASE WHEN email IS NULL THEN NULL ELSE encode(sha256(convert_to(email::text || 's''alt', 'UTF8')), 'hex') END, " +
+        "phone = CASE WHEN phone IS NULL THEN NULL ELSE repeat('*', greatest(char_length(phone) - 4, 0)) || right(phone, 4) END, " +
+        "name = CASE WHEN name IS NULL THEN NULL ELSE REPLACE('user{key}', '{key}', CAST(id AS TEXT)) END WHERE id > 0"
+    if got, err := step.SQL(); err != nil || got != want { t.Fatalf("unexpected sql %q (%v)", got, err) }
+    t.Setenv("MIGRATOR_TEST_ENV", "production")
+    if err := step.ExecuteUp(context.Background(), db); err != nil || len(recStrings()) != 0 { t.Fatalf("expected step skipped in production: %v %v", err, recStrings()) }
+    t.Setenv("MIGRATOR_TEST_ENV", "staging")
+    if err := step.ExecuteUp(context.Background(), db); err != nil || !containsExec(want) { t.Fatalf("expected anonymization in staging: %v %v", err, recStrings()) }
+    my, _ := NewAnonymizeStep(DialectMySQL, "users", "id", HashColumn("email", "")).SQL()
+    if my != "UPDATE users SET email = CASE WHEN email IS NULL THEN NULL ELSE SHA2(CONCAT(email, ''), 256) END" { t.Fatalf("unexpected mysql sql %q", my) }
+    if _, err := NewAnonymizeStep(DialectPostgres, "users", "", FakeColumn("name", "u{key}")).SQL(); err == nil { t.Fatalf("expected error for fake value without key") }
+}
+
+// scriptedDB answers the queries starting with a key of rows with the next
+// queued result.
+type scriptedDB struct {
+    *sql.DB
+    rows map[string][][][]driver.Value
+    cols map[string][]string
+}
+
+func (s scriptedDB) script(query string){
+    for prefix, queue := range s.rows {
+        if strings.HasPrefix(query, prefix) && len(queue) > 0 {
+            rowsMu.Lock(); rowsForNextQuery, colsForNextQuery = queue[0], s.cols[prefix]; rowsMu.Unlock()
+            s.rows[prefix] = queue[1:]
+        }
+    }
+}
+
+func (s scriptedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+    s.script(query)
+    return s.DB.QueryRowContext(ctx, query, args...)
+}
+
+func TestBackfillStep_ResumesFromPersistedCursor(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    fake := scriptedDB{DB: db, rows: map[string][][][]driver.Value{
+        "SELECT last_key": {{{"100", int64(100), false}}},
+        "SELECT COUNT(*)": {{{int64(150)}}},
+        "SELECT MIN(id)": {{{"101", "200", int64(100)}}, {{"201", "250", int64(50)}}, {{nil, nil, int64(0)}}},
+    }, cols: map[string][]string{"SELECT last_key": {"last_key", "done", "completed"}, "SELECT MIN(id)": {"from", "to", "n"}}}
+    var progress []StepProgress
+    m := NewMigrator(db, "hist", NewPostgresHistoryManager(), "app").WithEventSink(EventSinkFunc(func(e Event){
+        if e.Type == EventStepProgress { progress = append(progress, *e.Progress) }
+    }))
+    ctx := m.withProgress(m.stepContext(context.Background(), db), Migration{Version: "001"}, "up", 1, time.Now())
+    step := NewBackfillStep("users_email", "users", "id", "UPDATE users SET email_lower = lower(email) WHERE id BETWEEN :from AND :to").WithBatchSize(100)
+    if err := step.ExecuteUp(ctx, fake); err != nil { t.Fatalf("backfill: %v", err) }
+    if !containsExec("SELECT MIN(id), MAX(id), COUNT(*) FROM (SELECT id FROM users WHERE id > $1 ORDER BY id LIMIT 100) batch") { t.Fatalf("expected batches after the cursor; recs=%v", recStrings()) }
+    var updates [][]any
+    recMu.Lock()
+    for _, r := range recs { if strings.HasPrefix(r.query, "UPDATE users") { updates = append(updates, r.args) } }
+    recMu.Unlock()
+    if !reflect.DeepEqual(updates, [][]any{{"101", "200"}, {"201", "250"}}) { t.Fatalf("unexpected batches %v", updates) }
+    if len(progress) != 2 || progress[1].Done != 250 || progress[1].Total != 250 || progress[0].Percent() != 80 { t.Fatalf("unexpected progress %+v", progress) }
+    if countExec("DELETE FROM hist_backfills WHERE name = $1") != 3 || !containsSubstr("CREATE TABLE IF NOT EXISTS hist_backfills") { t.Fatalf("expected cursor saved after every batch; recs=%v", recStrings()) }
+}
+
+func TestMigrator_RunControlPausesAndCancels(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    ctl := NewRunControl()
+    pause := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error { ctl.Pause(); return nil })
+    mig := *NewMigration("001", "a").WithUpSteps([]MigrationStep{pause, NewSQLMigrationStep("AFTER_PAUSE")})
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}}).WithRunControl(ctl)
+    done := make(chan error, 1)
+    go func(){ done <- m.MigrateUp(context.Background(), "") }()
+    time.Sleep(30 * time.Millisecond)
+    if !ctl.Paused() || containsExec("AFTER_PAUSE") { t.Fatalf("expected run paused before step 2; recs=%v", recStrings()) }
+    ctl.Resume()
+    if err := <-done; err != nil || !containsExec("AFTER_PAUSE") { t.Fatalf("expected run resumed: %v", err) }
+
+    ctl = NewRunControl()
+    ctl.Cancel()
+    m = m.WithRunControl(ctl)
+    err := m.MigrateUp(context.Background(), "")
+    if !errors.Is(err, ErrRunCanceled) { t.Fatalf("expected ErrRunCanceled, got %v", err) }
+    ctx := context.WithValue(context.Background(), runControlKey{}, ctl)
+    if err := NewBatchMigrationStep("UPDATE t SET x = 1").ExecuteUp(ctx, db); !errors.Is(err, ErrRunCanceled) || countExec("UPDATE t SET x = 1") != 1 { t.Fatalf("expected batches stopped after the first, got %v", err) }
+}
+
+func TestMigrator_RunWithSignalsFinishesCurrentMigration(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    interrupt := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        p, _ := os.FindProcess(os.Getpid())
+        if err := p.Signal(os.Interrupt); err != nil { return err }
+        time.Sleep(30 * time.Millisecond)
+        return nil
+    })
+    migs := []Migration{
+        *NewMigration("001", "a").WithUpSteps([]MigrationStep{interrupt, NewSQLMigrationStep("FIRST_DONE")}),
+        *NewMigration("002", "b").WithUpSteps([]MigrationStep{NewSQLMigrationStep("SECOND")}),
+    }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    if code := m.RunWithSignals(context.Background(), ""); code != ExitCodeStopped { t.Fatalf("expected exit code %d, got %d", ExitCodeStopped, code) }
+    if !containsExec("FIRST_DONE") || containsExec("SECOND") { t.Fatalf("expected run stopped after migration 001; recs=%v", recStrings()) }
+    if ExitCode(nil) != ExitCodeSuccess || ExitCode(errors.New("boom")) != ExitCodeFailure || ExitCode(fmt.Errorf("x: %w", ErrRunCanceled)) != ExitCodeStopped { t.Fatalf("unexpected exit codes") }
+}
+
+func TestMigrator_LockWaitReportsHolderAndTimesOut(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    rowsMu.Lock()
+    failExecPrefixes = []string{"INSERT INTO hist_lock"}
+    rowsForQueryPrefix = map[string][][]driver.Value{"SELECT owner, acquired_at FROM hist_lock": {{"deployer@ci:42", since}}}
+    colsForQueryPrefix = map[string][]string{"SELECT owner, acquired_at FROM hist_lock": {"owner", "acquired_at"}}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); failExecPrefixes, rowsForQueryPrefix, colsForQueryPrefix = nil, nil, nil; rowsMu.Unlock() }()
+    var waits []string
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithLock(35*time.Millisecond).WithLockPollInterval(10*time.Millisecond).WithEventSink(EventSinkFunc(func(e Event){
+        if e.Type == EventLockWaiting { waits = append(waits, logMessage(e)) }
+    }))
+    err := m.MigrateUp(context.Background(), "")
+    var held *LockHeldError
+    if !errors.As(err, &held) || !errors.Is(err, ErrLockTimeout) || held.Holder.Owner != "deployer@ci:42" || !held.Holder.Since.Equal(since) { t.Fatalf("expected LockHeldError, got %v", err) }
+    if len(waits) < 2 || waits[0] != "waiting for migration lock held by deployer@ci:42 since 2026-01-02T03:04:05Z" { t.Fatalf("unexpected wait events %q", waits) }
+    rowsMu.Lock(); failExecPrefixes = nil; rowsMu.Unlock()
+    resetRecs()
+    if err := m.WithLockOwner("runner-1").MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsExec("DELETE FROM hist_lock WHERE id = 1 AND owner = ?") { t.Fatalf("expected lock released; recs=%v", recStrings()) }
+}
+
+func TestMigrator_MigrateRangeAppliesVersionWindow(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003", "004"} { migs = append(migs, *NewMigration(v, "m").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_" + v)})) }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    res, err := m.MigrateRangeResult(context.Background(), "002", "003")
+    if err != nil { t.Fatalf("MigrateRange: %v", err) }
+    var applied []string
+    for _, r := range res.Migrations { applied = append(applied, r.Version) }
+    if !reflect.DeepEqual(applied, []string{"002", "003"}) || containsExec("UP_001") || containsExec("UP_004") { t.Fatalf("unexpected migrations %v; recs=%v", applied, recStrings()) }
+    if err := m.MigrateRange(context.Background(), "003", "002"); err == nil { t.Fatalf("expected error for reversed range") }
+    if err := m.MigrateRange(context.Background(), "x", ""); err == nil { t.Fatalf("expected error for invalid bound") }
+}
+
+func TestMigrator_ExcludeLeavesMigrationsPending(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} { migs = append(migs, *NewMigration(v, "m").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_" + v)})) }
+    hist := &fakeHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    res, err := m.WithExclude("002").MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsExec("UP_001") || containsExec("UP_002") || !containsExec("UP_003") { t.Fatalf("expected 002 excluded; recs=%v", recStrings()) }
+    var excluded []string
+    for _, w := range res.Warnings { if w.Code == WarningExcluded { excluded = append(excluded, w.Version) } }
+    if !reflect.DeepEqual(excluded, []string{"002"}) { t.Fatalf("unexpected warnings %+v", res.Warnings) }
+    if m.excluded(migs[1], nil) { t.Fatalf("expected exclusion limited to the derived Migrator") }
+}
+
+func TestMigrator_RerunReplacesHistoryRecord(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := *NewMigration("002", "m").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_002")}).WithDownSteps([]MigrationStep{NewSQLMigrationStep("DOWN_002")})
+    hist := &fakeHistory{applied: map[string]bool{"002": true}}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}})
+    if err := m.Rerun(context.Background(), "002", true); err != nil { t.Fatalf("Rerun: %v", err) }
+    recs := recStrings()
+    if !reflect.DeepEqual(recs[len(recs)-2:], []string{"DOWN_002", "UP_002"}) { t.Fatalf("expected down then up; recs=%v", recs) }
+    if len(hist.removed) != 1 || len(hist.recorded) != 1 || hist.recorded[0].Version != "002" { t.Fatalf("expected history record replaced: removed=%v recorded=%v", hist.removed, hist.recorded) }
+    resetRecs()
+    if err := m.Rerun(context.Background(), "002", false); err != nil || containsExec("DOWN_002") || !containsExec("UP_002") || len(hist.removed) != 2 { t.Fatalf("expected up steps only: %v %v", err, recStrings()) }
+    if err := m.Rerun(context.Background(), "003", false); err == nil || !strings.Contains(err.Error(), "not applied") { t.Fatalf("expected error for unapplied migration, got %v", err) }
+}
+
+func TestMigrator_DiffAppliedFilesReportsEditedMigrations(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} {
+        mig := *NewMigration(v, "m" + v).WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP")})
+        mig.Checksum = "sum" + v
+        migs = append(migs, mig)
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    rowsMu.Lock()
+    colsForNextQuery = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+    rowsForNextQuery = [][]driver.Value{
+        {"001", "m001", "app", "2024-01-02 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "sum001"},
+        {"002", "m002", "app", "2024-01-03 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "old002"},
+        {"003", "m003", "app", "2024-01-04 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+    }
+    rowsMu.Unlock()
+    drifts, err := m.DiffAppliedFiles(context.Background())
+    if err != nil { t.Fatalf("DiffAppliedFiles: %v", err) }
+    if len(drifts) != 1 || drifts[0].Version != "002" || drifts[0].AppliedChecksum != "old002" || drifts[0].FileChecksum != "sum002" || drifts[0].AppliedAt.Day() != 3 { t.Fatalf("unexpected drifts %+v", drifts) }
+}
+
+func TestSourceFingerprintMatchesHistoryFingerprint(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    a := Migration{Version: "2", Name: "b", Checksum: "sum2"}
+    b := Migration{Version: "10", Name: "c", Checksum: "sum10"}
+    fp, err := SourceFingerprint(&staticSource{migs: []Migration{a}}, &staticSource{migs: []Migration{b}})
+    if err != nil { t.Fatalf("SourceFingerprint: %v", err) }
+    swapped, _ := SourceFingerprint(&staticSource{migs: []Migration{b, a}})
+    if fp != swapped || len(fp) != 64 { t.Fatalf("fingerprint depends on order: %s vs %s", fp, swapped) }
+    b.Checksum = "edited"
+    if edited, _ := SourceFingerprint(&staticSource{migs: []Migration{a, b}}); edited == fp { t.Fatalf("edited checksum kept fingerprint %s", fp) }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{}})
+    rowsMu.Lock()
+    colsForNextQuery = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+    rowsForNextQuery = [][]driver.Value{
+        {"10", "c", "app", "2024-01-02 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "sum10"},
+        {"2", "b", "app", "2024-01-01 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "sum2"},
+    }
+    rowsMu.Unlock()
+    hist, err := m.HistoryFingerprint(context.Background())
+    if err != nil { t.Fatalf("HistoryFingerprint: %v", err) }
+    if hist != fp { t.Fatalf("history fingerprint %s, want %s", hist, fp) }
+}
+
+func TestMigrator_WhenAppliedAndLastApplied(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{}})
+    history := func(){
+        rowsMu.Lock(); defer rowsMu.Unlock()
+        colsForNextQuery = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+        rowsForNextQuery = [][]driver.Value{
+            {"10", "c", "app", "2024-01-05 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+            {"9", "b", "app", "2024-01-07 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+        }
+    }
+    history()
+    at, ok, err := m.WhenApplied(context.Background(), "9")
+    if err != nil || !ok || at.Day() != 7 { t.Fatalf("WhenApplied(9) = %v, %v, %v", at, ok, err) }
+    history()
+    if _, ok, err := m.WhenApplied(context.Background(), "11"); err != nil || ok { t.Fatalf("WhenApplied(11) = %v, %v", ok, err) }
+    history()
+    last, ok, err := m.LastApplied(context.Background())
+    if err != nil || !ok || last.Migration.Version != "10" || last.AppliedAt.Day() != 5 { t.Fatalf("LastApplied = %+v, %v, %v", last, ok, err) }
+    if _, ok, err := m.LastApplied(context.Background()); err != nil || ok { t.Fatalf("LastApplied on empty history = %v, %v", ok, err) }
+}
+
+func TestNewMigratorDSNOwnsAndClosesDatabase(t *testing.T){
+    if _, err := NewMigratorDSN("nodrv", "", "hist", "app"); err == nil { t.Fatalf("expected error for unknown driver") }
+    m, err := NewMigratorDSN("testdrv", "", "hist", "app")
+    if err != nil { t.Fatalf("NewMigratorDSN: %v", err) }
+    if st := m.DB.Stats(); st.MaxOpenConnections != DefaultPoolConfig.MaxOpenConns { t.Fatalf("max open conns %d", st.MaxOpenConnections) }
+    m = m.WithPoolConfig(PoolConfig{MaxOpenConns: 1})
+    if st := m.DB.Stats(); st.MaxOpenConnections != 1 { t.Fatalf("pool config not applied: %d", st.MaxOpenConnections) }
+    borrowed, _ := sql.Open("testdrv", ""); defer borrowed.Close()
+    if err := m.WithDB(borrowed).Close(); err != nil { t.Fatalf("Close: %v", err) }
+    if err := borrowed.Ping(); err != nil { t.Fatalf("borrowed database closed: %v", err) }
+    if err := m.Close(); err != nil { t.Fatalf("Close: %v", err) }
+    if err := m.DB.Ping(); err == nil { t.Fatalf("owned database still open") }
+}
+
+func TestMigrator_SingleConnectionPinsNonTransactionalRuns(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var execs []Queryer
+    hook := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        var q Queryer = exec.(Queryer)
+        for { inner, ok := q.(queryingExecutor); if !ok { break }; q = inner.queryer }
+        execs = append(execs, q); return nil
+    })
+    migs := []Migration{*NewMigration("001", "a").WithUpSteps([]MigrationStep{hook}), *NewMigration("002", "b").WithUpSteps([]MigrationStep{hook})}
+    for _, single := range []bool{false, true} {
+        resetRecs(); execs = nil
+        m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithSingleConnection(single)
+        if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp single=%v: %v", single, err) }
+        if len(execs) != 2 { t.Fatalf("expected 2 hook calls, got %d", len(execs)) }
+        conn, pinned := execs[0].(*sql.Conn)
+        if pinned != single || (single && execs[1] != Queryer(conn)) { t.Fatalf("single=%v: hooks ran on %T and %T", single, execs[0], execs[1]) }
+    }
+}
+
+func TestNewMigratorDSNInitializesEveryConnection(t *testing.T){
+    resetRecs()
+    setRole := func(ctx context.Context, exec Executor) error { _, err := exec.ExecContext(ctx, "SET ROLE migrator"); return err }
+    m, err := NewMigratorDSN("testdrv", "", "hist", "app", setRole)
+    if err != nil { t.Fatalf("NewMigratorDSN: %v", err) }
+    defer m.Close()
+    m = m.WithSources([]MigrationSource{&staticSource{migs: []Migration{*NewMigration("001", "a").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_1")})}}})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    recs := recStrings()
+    if len(recs) == 0 || recs[0] != "SET ROLE migrator" { t.Fatalf("connection not initialized before history access: %v", recs) }
+    failing, _ := NewMigratorDSN("testdrv", "", "hist", "app", func(context.Context, Executor) error { return errors.New("role missing") })
+    defer failing.Close()
+    if err := failing.DB.Ping(); err == nil || !strings.Contains(err.Error(), "role missing") { t.Fatalf("expected init error, got %v", err) }
+}
+
+func TestMigrator_RefusesToMigrateReplica(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP_REPLICA", "DOWN")
+    check := "SELECT pg_is_in_recovery()"
+    defer func(){ rowsMu.Lock(); delete(rowsForQueryPrefix, check); delete(colsForQueryPrefix, check); rowsMu.Unlock() }()
+    for _, state := range [][]driver.Value{{true, "off"}, {false, "on"}, {false, "off"}} {
+        resetRecs()
+        rowsMu.Lock()
+        if rowsForQueryPrefix == nil { rowsForQueryPrefix = map[string][][]driver.Value{} }
+        if colsForQueryPrefix == nil { colsForQueryPrefix = map[string][]string{} }
+        rowsForQueryPrefix[check] = [][]driver.Value{state}
+        colsForQueryPrefix[check] = []string{"recovery", "read_only"}
+        rowsMu.Unlock()
+        m := NewMigrator(db, "hist", NewPostgresHistoryManager(), "app").WithSources([]MigrationSource{src})
+        err := m.MigrateUp(context.Background(), "")
+        writable := state[0] == false && state[1] == "off"
+        if writable != (err == nil) || (!writable && !errors.Is(err, ErrReadOnlyDatabase)) { t.Fatalf("state %v: MigrateUp error %v", state, err) }
+        if !writable && containsSubstr("UP_REPLICA") { t.Fatalf("state %v: migration executed on a replica", state) }
+        if err := m.WithReadOnlyCheck(false).MigrateDown(context.Background(), ""); err != nil { t.Fatalf("MigrateDown without check: %v", err) }
+    }
+}
+
+func TestMigrator_MigrationErrorLocatesFailure(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    hook := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error {
+        _, err := exec.ExecContext(ctx, "CREATE TABLE a(x int);\nINSERT INTO a VALUES (1);\nSELEC * FROM a;")
+        if err == nil { err = errors.New(`near "SELEC": syntax error`) }
+        return err
+    })
+    mig := *NewMigration("001", "a").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP"), hook})
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}})
+    err := m.MigrateUp(context.Background(), "")
+    var migErr *MigrationError
+    if !errors.As(err, &migErr) || migErr.Step != 2 || migErr.Label != "hook" || migErr.Statement != 3 || migErr.Line != 3 || migErr.Direction != "up" { t.Fatalf("unexpected error %#v", migErr) }
+    if !strings.HasPrefix(err.Error(), "migration 001 (a): up step 2 (hook), statement 3 at line 3: near") { t.Fatalf("unexpected message %q", err) }
+
+    resetRecs()
+    rowsMu.Lock(); failExecPrefixes = []string{"INSERT INTO hist"}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); failExecPrefixes = nil; rowsMu.Unlock() }()
+    m = NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{NewVarMigrationSource("002", "b", "UP", "DOWN")})
+    err = m.MigrateUp(context.Background(), "")
+    if !errors.As(err, &migErr) || migErr.Step != 0 || migErr.Version != "002" || !strings.Contains(err.Error(), "migration 002 (b): up history record: ") { t.Fatalf("expected history error, got %v", err) }
+}
+
+func TestMigrator_SQLValidationRejectsRunBeforeExecuting(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    parser := SQLParserFunc(func(sql string) error {
+        if i := strings.Index(sql, "SELEC "); i >= 0 { return &SyntaxError{Message: "syntax error at SELEC", Offset: i} }
+        return nil
+    })
+    migs := []Migration{
+        *NewMigration("001", "a").WithUpSteps([]MigrationStep{NewSQLMigrationStep("CREATE TABLE a(x int)")}),
+        *NewMigration("002", "b").WithUpSteps([]MigrationStep{NewSQLMigrationStep("CREATE TABLE b(x int);\nINSERT INTO b VALUES (1);\nSELEC * FROM b;")}),
+        *NewMigration("003", "c").WithUpSteps([]MigrationStep{NewHookMigrationStep().WithUpHook(func(context.Context, Executor) error { return nil }), NewSQLMigrationStep("SELEC 1")}),
+    }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithSQLValidation(parser)
+    err := m.MigrateUp(context.Background(), "")
+    if !errors.Is(err, ErrInvalidSQL) || containsExec("CREATE TABLE a") { t.Fatalf("expected validation failure before executing, got %v; recs=%v", err, recStrings()) }
+    for _, want := range []string{"migration 002 (b): up step 1 (sql), statement 3 at line 3: invalid SQL: syntax error at SELEC", "migration 003 (c): up step 2 (sql) at line 1: invalid SQL"} {
+        if !strings.Contains(err.Error(), want) { t.Fatalf("expected %q in %v", want, err) }
+    }
+    if err := m.WithSQLValidation(nil).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp without validation: %v", err) }
+}
+
+func TestMigrator_HistoryTimelineAndVersionAt(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{}}).WithAudit(AuditChecksum)
+    audit := "SELECT migration_name, version, direction, executed_at FROM hist_audit"
+    rowsMu.Lock()
+    if rowsForQueryPrefix == nil { rowsForQueryPrefix = map[string][][]driver.Value{} }
+    if colsForQueryPrefix == nil { colsForQueryPrefix = map[string][]string{} }
+    rowsForQueryPrefix[audit] = [][]driver.Value{
+        {"app", "001", "up", "2024-01-01 10:00:00"},
+        {"app", "002", "up", "2024-01-02 10:00:00"},
+        {"app", "002", "down", "2024-01-03 10:00:00"},
+        {"app", "002", "up", "2024-01-05 10:00:00"},
+    }
+    colsForQueryPrefix[audit] = []string{"migration_name", "version", "direction", "executed_at"}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); delete(rowsForQueryPrefix, audit); delete(colsForQueryPrefix, audit); rowsMu.Unlock() }()
+    history := func(){
+        rowsMu.Lock(); defer rowsMu.Unlock()
+        colsForNextQuery = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+        rowsForNextQuery = [][]driver.Value{
+            {"000", "init", "app", "2023-12-31 10:00:00", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+            {"001", "a", "app", "2024-01-01 10:00:00", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+            {"002", "b", "app", "2024-01-05 10:00:00", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+        }
+    }
+    history()
+    events, err := m.HistoryTimeline(context.Background())
+    if err != nil { t.Fatalf("HistoryTimeline: %v", err) }
+    var got []string
+    for _, e := range events { got = append(got, e.Version+" "+e.Direction+" -> "+e.SchemaVersion) }
+    want := []string{"000 up -> 000", "001 up -> 001", "002 up -> 002", "002 down -> 001", "002 up -> 002"}
+    if !slices.Equal(got, want) { t.Fatalf("timeline %v, want %v", got, want) }
+    for at, want := range map[string]string{"2023-12-30 00:00:00": "", "2024-01-01 09:00:00": "000", "2024-01-02 10:00:00": "002", "2024-01-04 02:13:00": "001", "2024-02-01 00:00:00": "002"} {
+        history()
+        ts, _ := time.Parse(time.DateTime, at)
+        if v, err := m.VersionAt(context.Background(), ts); err != nil || v != want { t.Fatalf("VersionAt(%s) = %q, %v, want %q", at, v, err, want) }
+    }
+}
+
+func TestMigrator_MaintenanceWindow(t *testing.T){
+    w, err := ParseMaintenanceWindow("TZ=Europe/Helsinki 0 2 * * SAT 4h")
+    if err != nil { t.Fatalf("ParseMaintenanceWindow: %v", err) }
+    helsinki, _ := time.LoadLocation("Europe/Helsinki")
+    for at, want := range map[string]bool{"2024-06-08 03:00": true, "2024-06-08 02:00": true, "2024-06-08 06:00": false, "2024-06-07 03:00": false, "2024-06-08 01:59": false} {
+        ts, _ := time.ParseInLocation("2006-01-02 15:04", at, helsinki)
+        if got := w.Contains(ts.UTC()); got != want { t.Fatalf("Contains(%s) = %v, want %v", at, got, want) }
+    }
+    every, err := ParseMaintenanceWindow("*/15 22-23 * * MON-FRI 10m")
+    if err != nil { t.Fatalf("ParseMaintenanceWindow: %v", err) }
+    if !every.Contains(time.Date(2024, 6, 7, 22, 50, 0, 0, time.UTC)) || every.Contains(time.Date(2024, 6, 7, 22, 55, 0, 0, time.UTC)) { t.Fatalf("expected quarter-hourly windows") }
+    for _, bad := range []string{"0 2 * * SAT", "0 24 * * * 1h", "0 2 * * SAT 0s", "TZ=Nowhere/City 0 2 * * * 1h"} {
+        if _, err := ParseMaintenanceWindow(bad); err == nil { t.Fatalf("expected %q to be invalid", bad) }
+    }
+
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v string, safe bool) Migration {
+        return Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP_" + v)}, Annotations: map[string]string{AnnotationSafe: strconv.FormatBool(safe)}}
+    }
+    outside := func() time.Time { return time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC) }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithNowFunc(outside).WithMaintenanceWindow("TZ=Europe/Helsinki 0 2 * * SAT 4h")
+    resetRecs()
+    result, err := m.WithSources([]MigrationSource{&staticSource{migs: []Migration{mig("001", true), mig("002", false), mig("003", true)}}}).MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    if !containsExec("UP_001") || containsExec("UP_002") || containsExec("UP_003") { t.Fatalf("expected only the safe 001 applied; recs=%v", recStrings()) }
+    if last := result.Warnings[len(result.Warnings)-1]; last.Code != WarningMaintenanceWindow || last.Version != "002" { t.Fatalf("expected maintenance warning, got %+v", result.Warnings) }
+
+    unsafe := m.WithSources([]MigrationSource{&staticSource{migs: []Migration{mig("002", false)}}})
+    resetRecs()
+    if err := unsafe.MigrateUp(context.Background(), ""); !errors.Is(err, ErrOutsideMaintenanceWindow) { t.Fatalf("expected ErrOutsideMaintenanceWindow, got %v", err) }
+    if containsExec("UP_002") { t.Fatalf("expected nothing applied; recs=%v", recStrings()) }
+    resetRecs()
+    if err := unsafe.WithMaintenanceOverride(true).MigrateUp(context.Background(), ""); err != nil || !containsExec("UP_002") { t.Fatalf("expected override to apply 002: %v", err) }
+    resetRecs()
+    inside := func() time.Time { return time.Date(2024, 6, 8, 0, 30, 0, 0, time.UTC) }
+    if err := unsafe.WithNowFunc(inside).MigrateUp(context.Background(), ""); err != nil || !containsExec("UP_002") { t.Fatalf("expected 002 applied within the window: %v", err) }
+}
+
+func TestMigrator_ConcurrentRunsInProcessFail(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    started, proceed := make(chan struct{}), make(chan struct{})
+    block := NewHookMigrationStep().WithUpHook(func(ctx context.Context, exec Executor) error { close(started); <-proceed; return nil })
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "slow", UpSteps: []MigrationStep{block}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    done := make(chan error, 1)
+    go func(){ done <- m.MigrateUp(context.Background(), "") }()
+    <-started
+    var inProgress *RunInProgressError
+    if err := m.WithTransactional(false).MigrateUp(context.Background(), ""); !errors.As(err, &inProgress) || !errors.Is(err, ErrRunInProgress) || inProgress.Direction != "up" { t.Fatalf("expected *RunInProgressError, got %v", err) }
+    if err := m.MigrateDown(context.Background(), ""); !errors.Is(err, ErrRunInProgress) { t.Fatalf("expected down to fail, got %v", err) }
+    if err := m.Baseline(context.Background(), "001"); !errors.Is(err, ErrRunInProgress) { t.Fatalf("expected baseline to fail, got %v", err) }
+    if _, err := m.Repair(context.Background()); !errors.Is(err, ErrRunInProgress) { t.Fatalf("expected repair to fail, got %v", err) }
+    if err := m.CompactHistory(context.Background(), "001"); !errors.Is(err, ErrRunInProgress) { t.Fatalf("expected compaction to fail, got %v", err) }
+    other, _ := sql.Open("testdrv", ""); defer other.Close()
+    if _, err := m.WithDB(other).WithSources(nil).MigrateUpResult(context.Background(), ""); err != nil { t.Fatalf("expected another database to run: %v", err) }
+    close(proceed)
+    if err := <-done; err != nil { t.Fatalf("first run: %v", err) }
+    if err := m.MigrateDown(context.Background(), ""); errors.Is(err, ErrRunInProgress) { t.Fatalf("expected the guard released, got %v", err) }
+}
+
+func TestNewRegexFilenameParser(t *testing.T){
+    parse, err := NewRegexFilenameParser(`V(?P<version>\d+)__(?P<name>\w+)\.(?P<direction>\w+)\.sql`)
+    if err != nil { t.Fatalf("NewRegexFilenameParser: %v", err) }
+    if v, n, d, ok := parse("V3__add_users.DOWN.sql"); !ok || v != "3" || n != "add_users" || d != "down" { t.Fatalf("unexpected parse: %v %v %v ok=%v", v, n, d, ok) }
+    for _, name := range []string{"V3__add_users.undo.sql", "xV3__a.up.sql", "V3__a.up.sql.bak", "V__a.up.sql"} {
+        if _, _, _, ok := parse(name); ok { t.Fatalf("expected %q to be rejected", name) }
+    }
+    upOnly, err := NewRegexFilenameParser(`(?P<version>\d{14})-(?P<name>.+)\.sql`)
+    if err != nil { t.Fatalf("NewRegexFilenameParser: %v", err) }
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "20240101120000-create-users.sql"), "CREATE TABLE users(id int);")
+    mustWrite(t, filepath.Join(dir, "README.md"), "docs")
+    migs, err := NewDirMigrationSource(dir).WithFilenameParser(upOnly).WithAllowedExts([]string{".sql", ".md"}).LoadMigrations()
+    if err != nil { t.Fatalf("LoadMigrations: %v", err) }
+    if len(migs) != 1 || migs[0].Version != "20240101120000" || migs[0].Name != "create-users" || len(migs[0].UpSteps) != 1 { t.Fatalf("unexpected migrations %+v", migs) }
+    for _, bad := range []string{`(?P<name>\w+)\.sql`, `(?P<version>\d+`} {
+        if _, err := NewRegexFilenameParser(bad); err == nil { t.Fatalf("expected %q to be rejected", bad) }
+    }
+}
+
+func TestMigrator_TxOptionsAndIsolationAnnotation(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v, isolation string) Migration {
+        m := Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP_" + v)}, DownSteps: []MigrationStep{NewSQLMigrationStep("DOWN_" + v)}}
+        if isolation != "" { m.Annotations = map[string]string{AnnotationIsolation: isolation} }
+        return m
+    }
+    src := &staticSource{migs: []Migration{mig("001", ""), mig("002", "Serializable")}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithTransactional(true).WithTxOptions(sql.TxOptions{Isolation: sql.LevelReadCommitted})
+    lastIsolation := func() sql.IsolationLevel {
+        recMu.Lock(); defer recMu.Unlock()
+        if len(txOptions) == 0 { return -1 }
+        return sql.IsolationLevel(txOptions[len(txOptions)-1].Isolation)
+    }
+    if err := m.MigrateUp(context.Background(), "001"); err != nil || lastIsolation() != sql.LevelReadCommitted { t.Fatalf("expected read committed, got %v, %v", lastIsolation(), err) }
+    if err := m.MigrateUp(context.Background(), ""); err != nil || lastIsolation() != sql.LevelSerializable { t.Fatalf("expected the annotation to raise isolation, got %v, %v", lastIsolation(), err) }
+    applied := "SELECT version FROM hist"
+    rowsMu.Lock()
+    if rowsForQueryPrefix == nil { rowsForQueryPrefix = map[string][][]driver.Value{} }
+    rowsForQueryPrefix[applied] = [][]driver.Value{{"001"}, {"002"}}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); delete(rowsForQueryPrefix, applied); rowsMu.Unlock() }()
+    resetRecs()
+    if err := m.MigrateDown(context.Background(), "002"); err != nil || !containsExec("DOWN_002") || lastIsolation() != sql.LevelSerializable { t.Fatalf("expected serializable rollback, got %v, %v", lastIsolation(), err) }
+    if err := m.MigrateDown(context.Background(), "003"); err != nil || lastIsolation() != sql.LevelReadCommitted { t.Fatalf("expected read committed rollback, got %v, %v", lastIsolation(), err) }
+    rowsMu.Lock(); delete(rowsForQueryPrefix, applied); rowsMu.Unlock()
+
+    bad := m.WithSources([]MigrationSource{&staticSource{migs: []Migration{mig("003", "eventual")}}})
+    if err := bad.MigrateUp(context.Background(), ""); err == nil || !strings.Contains(err.Error(), `unknown isolation level "eventual"`) { t.Fatalf("expected invalid isolation, got %v", err) }
+    if err := m.WithTxOptions(sql.TxOptions{ReadOnly: true}).MigrateUp(context.Background(), "001"); !errors.Is(err, ErrReadOnlyTransaction) { t.Fatalf("expected ErrReadOnlyTransaction, got %v", err) }
+    if level, err := ParseIsolationLevel("repeatable-read"); err != nil || level != sql.LevelRepeatableRead { t.Fatalf("ParseIsolationLevel = %v, %v", level, err) }
+}
+
+func TestFSMigrationSource_LoadsFromFS(t *testing.T){
+    fsys := fstest.MapFS{
+        "migrations/001_init_up.sql": {Data: []byte("-- ticket: OPS-7\nCREATE TABLE fs1(x int);")},
+        "migrations/001_init_down.sql": {Data: []byte("DROP TABLE fs1;")},
+        "migrations/002_more_up.sql": {Data: []byte("CREATE TABLE fs2(x int);")},
+        "migrations/notes.md": {Data: []byte("docs")},
+        "migrations/bogus.sql": {Data: []byte("SELECT 1")},
+        "migrations/sub/003_nested_up.sql": {Data: []byte("CREATE TABLE nested(x int);")},
+    }
+    var hooked []string
+    src := NewFSMigrationSource(fsys, "migrations").WithChecksums(true).WithResolveHooks(func(filename string) (FileHookFn, FileHookFn) {
+        return func(ctx context.Context, exec Executor, p string) error { hooked = append(hooked, p); return nil }, nil
+    })
+    migs, warnings, err := src.LoadMigrationsWithWarnings()
+    if err != nil { t.Fatalf("LoadMigrations: %v", err) }
+    if len(migs) != 2 || migs[0].Version != "001" || migs[1].Version != "002" || len(migs[0].DownSteps) != 2 || migs[0].Checksum == "" { t.Fatalf("unexpected migrations %+v", migs) }
+    if len(warnings) != 1 || warnings[0].File != "migrations/bogus.sql" { t.Fatalf("expected a warning for bogus.sql, got %+v", warnings) }
+    if err := src.Check(); err == nil || !strings.Contains(err.Error(), "bogus.sql") { t.Fatalf("expected Check to report bogus.sql, got %v", err) }
+
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsSubstr("CREATE TABLE fs1(x int);") || !containsExec("CREATE TABLE fs2(x int);") || containsSubstr("nested") { t.Fatalf("expected both files applied; recs=%v", recStrings()) }
+    if !slices.Equal(hooked, []string{"migrations/001_init_up.sql", "migrations/002_more_up.sql"}) { t.Fatalf("unexpected hook paths %v", hooked) }
+    loaded, _ := src.LoadMigrations()
+    if err := loaded[0].resolveAnnotations(); err != nil || loaded[0].Ticket != "OPS-7" { t.Fatalf("expected annotations read from the FS, got %q, %v", loaded[0].Ticket, err) }
+}
+
+func TestMigrator_StatusReportsAppliedAndPending(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    dir := t.TempDir()
+    mustWrite(t, filepath.Join(dir, "001_init_up.sql"), "CREATE TABLE t1(x int);")
+    src := &staticSource{migs: []Migration{{Version: "002", Name: "inline", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_002")}}}}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{NewDirMigrationSource(dir), src})
+    versions, entries := "SELECT version FROM hist", "SELECT version, name"
+    rowsMu.Lock()
+    if rowsForQueryPrefix == nil { rowsForQueryPrefix = map[string][][]driver.Value{} }
+    if colsForQueryPrefix == nil { colsForQueryPrefix = map[string][]string{} }
+    rowsForQueryPrefix[versions] = [][]driver.Value{{"000"}, {"001"}}
+    rowsForQueryPrefix[entries] = [][]driver.Value{
+        {"000", "legacy", "app", "2024-01-01 10:00:00", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+        {"001", "init", "app", "2024-01-02 10:00:00", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+    }
+    colsForQueryPrefix[entries] = []string{"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); delete(rowsForQueryPrefix, versions); delete(rowsForQueryPrefix, entries); delete(colsForQueryPrefix, entries); rowsMu.Unlock() }()
+    statuses, err := m.Status(context.Background())
+    if err != nil { t.Fatalf("Status: %v", err) }
+    if len(statuses) != 3 { t.Fatalf("expected 3 statuses, got %+v", statuses) }
+    if s := statuses[0]; s.Version != "001" || !s.Applied || s.AppliedAt.Format(time.DateTime) != "2024-01-02 10:00:00" || s.Source != filepath.Join(dir, "001_init_up.sql") { t.Fatalf("unexpected applied status %+v", s) }
+    if s := statuses[1]; s.Version != "002" || s.Applied || !s.AppliedAt.IsZero() || s.Source != "inline" { t.Fatalf("unexpected pending status %+v", s) }
+    if s := statuses[2]; s.Version != "000" || s.Name != "legacy" || !s.Applied || s.Source != "" { t.Fatalf("unexpected orphan status %+v", s) }
+}
+
+func TestMigrator_AdvisoryLockers(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    setRows := func(prefix string, v driver.Value){
+        rowsMu.Lock(); defer rowsMu.Unlock()
+        rowsForQueryPrefix = map[string][][]driver.Value{prefix: {{v}}}
+    }
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{}})
+
+    resetRecs()
+    setRows("SELECT pg_try_advisory_lock($1)", true)
+    if err := m.WithLocker(PostgresAdvisoryLocker{}).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if unlocks := recArgs("SELECT pg_advisory_unlock($1)"); len(unlocks) != 1 || unlocks[0][0] != advisoryLockID("hist_lock") { t.Fatalf("expected the advisory lock released; recs=%v", recStrings()) }
+    if containsSubstr("hist_lock (") { t.Fatalf("expected no lock table; recs=%v", recStrings()) }
+
+    setRows("SELECT pg_try_advisory_lock($1)", false)
+    err := m.WithLocker(PostgresAdvisoryLocker{}).WithLock(25*time.Millisecond).WithLockPollInterval(10*time.Millisecond).MigrateUp(context.Background(), "")
+    var held *LockHeldError
+    if !errors.As(err, &held) || held.Holder.String() != "another runner" { t.Fatalf("expected LockHeldError, got %v", err) }
+
+    resetRecs()
+    setRows("SELECT COALESCE(GET_LOCK(?, 0), 0) = 1", int64(1))
+    if err := m.WithLocker(MySQLLocker{}).MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if unlocks := recArgs("SELECT RELEASE_LOCK(?)"); len(unlocks) != 1 || unlocks[0][0] != "hist_lock" { t.Fatalf("expected the named lock released; recs=%v", recStrings()) }
+    if name := mysqlLockName(strings.Repeat("x", 80)); len(name) != 64 { t.Fatalf("expected a 64 character lock name, got %q", name) }
+}
+
+func TestMigrator_DryRunWritesPlannedSQL(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v string) Migration {
+        return Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP" + v), NewHookMigrationStep()}, DownSteps: []MigrationStep{NewSQLMigrationStep("DOWN" + v + ";")}}
+    }
+    hist := &fakeHistory{applied: map[string]bool{"001": true, "002": true}}
+    var out bytes.Buffer
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig("001"), mig("002"), mig("003")}}}).WithLock(time.Second).WithDryRun(true).WithDryRunOutput(&out)
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    want := "-- up 003: m003\nUP003;\n-- step 2 (hook) is not SQL\n-- record 003 in hist\n\n"
+    if out.String() != want { t.Fatalf("unexpected up plan %q", out.String()) }
+    out.Reset()
+    if err := m.MigrateDown(context.Background(), "002"); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    if out.String() != "-- down 002: m002\nDOWN002;\n-- remove 002 from hist\n\n" { t.Fatalf("unexpected down plan %q", out.String()) }
+    if len(recStrings()) != 0 || hist.ensured || len(hist.recorded) != 0 || len(hist.removed) != 0 { t.Fatalf("expected no writes; recs=%v", recStrings()) }
+}
+
+func TestMigrator_TxPerMigrationCommitsEarlierMigrations(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := func(v, isolation string) Migration {
+        m := Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP_" + v)}}
+        if isolation != "" { m.Annotations = map[string]string{AnnotationIsolation: isolation} }
+        return m
+    }
+    hist := &fakeHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig("001", ""), mig("002", "serializable"), mig("003", "")}}}).WithTxPerMigration(true)
+    resetRecs(); recMu.Lock(); txCommits, txRollbacks, txOptions = 0, 0, nil; recMu.Unlock()
+    rowsMu.Lock(); failExecPrefixes = []string{"UP_003"}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); failExecPrefixes = nil; rowsMu.Unlock() }()
+    if err := m.MigrateUp(context.Background(), ""); err == nil { t.Fatalf("expected migration 003 to fail") }
+    recMu.Lock(); c, r, opts := txCommits, txRollbacks, txOptions; recMu.Unlock()
+    if c != 2 || r != 1 { t.Fatalf("expected 2 commits and 1 rollback; got c=%d r=%d", c, r) }
+    if len(opts) != 3 || sql.IsolationLevel(opts[0].Isolation) != sql.LevelDefault || sql.IsolationLevel(opts[1].Isolation) != sql.LevelSerializable { t.Fatalf("unexpected transaction options %+v", opts) }
+    if len(hist.recorded) != 2 || hist.recorded[1].Version != "002" { t.Fatalf("expected 001 and 002 recorded, got %+v", hist.recorded) }
+}
+
+type captureLogger struct{ mu sync.Mutex; lines []string }
+
+func (c *captureLogger) Printf(format string, v ...any){ c.mu.Lock(); defer c.mu.Unlock(); c.lines = append(c.lines, fmt.Sprintf(format, v...)) }
+
+func TestMigrator_WithLoggerRedirectsOutput(t *testing.T){
+    var std bytes.Buffer
+    log.SetOutput(&std); defer log.SetOutput(os.Stderr)
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "UP1", "DOWN1").WithLogger(DiscardLogger)
+    logger := &captureLogger{}
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{src}).WithLogger(logger)
+    step := ForSchemas(NewSQLMigrationStep("X"), []string{"s1"})
+    m = m.WithSources([]MigrationSource{src, &staticSource{migs: []Migration{{Version: "002", Name: "b", UpSteps: []MigrationStep{step}}}}})
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if std.Len() != 0 { t.Fatalf("expected nothing on the standard logger, got %q", std.String()) }
+    starts, schema := 0, false
+    for _, line := range logger.lines {
+        if line == "Starting MigrateUp" { starts++ }
+        if line == "Executed up step for schema s1" { schema = true }
+    }
+    if starts != 1 || !schema { t.Fatalf("unexpected log lines %q", logger.lines) }
+    if err := m.WithLogger(DiscardLogger).MigrateUp(context.Background(), ""); err != nil || std.Len() != 0 { t.Fatalf("expected a silent run, got %v, %q", err, std.String()) }
+}
+
+func TestMigrator_StatementSplitting(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    script := "CREATE TABLE a (x TEXT);\nINSERT INTO a VALUES ('a;b'); -- done;\n"
+    run := func(m *Migrator, step MigrationStep) error {
+        resetRecs()
+        return m.WithSources([]MigrationSource{&staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{step}}}}}).MigrateUp(context.Background(), "")
+    }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app")
+    if err := run(m, NewSQLMigrationStep(script)); err != nil || !containsExec(script) { t.Fatalf("expected one call without splitting: %v; recs=%v", err, recStrings()) }
+    split := m.WithStatementSplitting(true)
+    if err := run(split, NewSQLMigrationStep(script)); err != nil || !containsExec("CREATE TABLE a (x TEXT)") || !containsExec("INSERT INTO a VALUES ('a;b')") || containsExec(script) { t.Fatalf("expected two statements: %v; recs=%v", err, recStrings()) }
+    if err := run(split, NewSQLMigrationStep(script).WithSplit(false)); err != nil || !containsExec(script) { t.Fatalf("expected the step to opt out: %v; recs=%v", err, recStrings()) }
+    rowsMu.Lock(); failExecPrefixes = []string{"INSERT INTO a"}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); failExecPrefixes = nil; rowsMu.Unlock() }()
+    var stepErr *MigrationError
+    if err := run(split, NewSQLMigrationStep(script)); !errors.As(err, &stepErr) || stepErr.Statement != 2 || stepErr.Line != 2 || stepErr.SQL != "INSERT INTO a VALUES ('a;b')" { t.Fatalf("expected statement 2 on line 2 to fail, got %#v", stepErr) }
+}
+
+func TestMigrator_MigrateToDetectsDirection(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} {
+        migs = append(migs, Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP" + v)}, DownSteps: []MigrationStep{NewSQLMigrationStep("DOWN" + v)}})
+    }
+    versions := func(migs []Migration) (vs []string) { for _, mig := range migs { vs = append(vs, mig.Version) }; return vs }
+    cases := []struct{ applied []string; target string; recorded, removed []string }{
+        {nil, "002", []string{"001", "002"}, nil},
+        {[]string{"001", "002", "003"}, "001", nil, []string{"003", "002"}},
+        {[]string{"001", "003"}, "002", []string{"002"}, []string{"003"}},
+        {[]string{"001", "002"}, "002", nil, nil},
+    }
+    for _, tc := range cases {
+        hist := &fakeHistory{applied: map[string]bool{}}
+        for _, v := range tc.applied { hist.applied[v] = true }
+        m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+        if err := m.MigrateTo(context.Background(), tc.target); err != nil { t.Fatalf("MigrateTo %s: %v", tc.target, err) }
+        if !slices.Equal(versions(hist.recorded), tc.recorded) || !slices.Equal(versions(hist.removed), tc.removed) { t.Fatalf("applied %v to %s: recorded %v, removed %v", tc.applied, tc.target, versions(hist.recorded), versions(hist.removed)) }
+    }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    if err := m.MigrateTo(context.Background(), ""); err == nil { t.Fatalf("expected an error for an empty version") }
+}
+
+func TestMigrator_VersionComparators(t *testing.T){
+    sorted := func(compare VersionComparator, vs ...string) []string { vs = slices.Clone(vs); slices.SortFunc(vs, compare); return vs }
+    if got := sorted(CompareNumeric, "10", "9", "20240101120000000000", "0011"); !slices.Equal(got, []string{"9", "10", "0011", "20240101120000000000"}) { t.Fatalf("numeric order %v", got) }
+    for _, perm := range [][]string{{"1a", "100", "9"}, {"9", "1a", "100"}, {"100", "9", "1a"}} {
+        if got := sorted(CompareNumeric, perm...); !slices.Equal(got, []string{"9", "100", "1a"}) { t.Fatalf("mixed numeric order of %v: %v", perm, got) }
+    }
+    if got := sorted(CompareLexicographic, "10", "9", "100"); !slices.Equal(got, []string{"10", "100", "9"}) { t.Fatalf("lexicographic order %v", got) }
+    if got := sorted(CompareSemver, "1.10.0", "v1.2", "1.2.3", "1.2.3-rc.1", "1.2.3-beta", "1.2.3-rc.10", "x"); !slices.Equal(got, []string{"v1.2", "1.2.3-beta", "1.2.3-rc.1", "1.2.3-rc.10", "1.2.3", "1.10.0", "x"}) { t.Fatalf("semver order %v", got) }
+    if got := sorted(CompareTimestamp, "20240101000001", "2024-01-01", "20231231235959"); !slices.Equal(got, []string{"20231231235959", "2024-01-01", "20240101000001"}) { t.Fatalf("timestamp order %v", got) }
+
+    dir := t.TempDir()
+    for _, v := range []string{"1.10.0", "1.2.0", "1.9.1"} { if err := os.WriteFile(filepath.Join(dir, v+"_m_up.sql"), []byte("UP_"+v), 0o644); err != nil { t.Fatal(err) } }
+    src := NewDirMigrationSource(dir).WithVersionComparator(CompareSemver)
+    migs, err := src.LoadMigrations()
+    if err != nil || len(migs) != 3 || migs[0].Version != "1.2.0" || migs[2].Version != "1.10.0" { t.Fatalf("unexpected source order %v: %v", migs, err) }
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    hist := &fakeHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{src}).WithVersionComparator(CompareSemver)
+    if err := m.MigrateUp(context.Background(), "1.9.1"); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if len(hist.recorded) != 2 || hist.recorded[0].Version != "1.2.0" || hist.recorded[1].Version != "1.9.1" { t.Fatalf("expected 1.2.0 and 1.9.1 applied, got %+v", hist.recorded) }
+    if err := m.MigrateRange(context.Background(), "1.10.0", "1.2.0"); err == nil { t.Fatalf("expected a reversed semver range to fail") }
+}
+
+func TestFileMigrationSource_MigrateMarkers(t *testing.T){
+    f := filepath.Join(t.TempDir(), "004_markers.sql")
+    content := "-- legacy header\n-- +migrate Up\n-- lock_timeout: 5s\nCREATE TABLE a(id int);\n\n-- +migrate Down\nDROP TABLE a;\n-- +migrate Up\n-- +migrate StatementBegin\nCREATE FUNCTION f() AS $$ BEGIN; END $$;\n-- +migrate StatementEnd\n-- +migrate Down notransaction\nDROP FUNCTION f;\n"
+    if err := os.WriteFile(f, []byte(content), 0o644); err != nil { t.Fatal(err) }
+    migs, err := NewFileMigrationSource(f).WithLogger(DiscardLogger).LoadMigrations()
+    if err != nil || len(migs) != 1 { t.Fatalf("LoadMigrations: %v %v", migs, err) }
+    sqlOf := func(steps []MigrationStep) (out []string) { for _, s := range steps { out = append(out, s.(*SQLMigrationStep).SQL) }; return out }
+    up, down := migs[0].UpSteps, migs[0].DownSteps
+    if got := sqlOf(up); len(got) != 2 || got[0] != "-- lock_timeout: 5s\nCREATE TABLE a(id int);" || !strings.HasPrefix(got[1], "-- +migrate StatementBegin\nCREATE FUNCTION") { t.Fatalf("unexpected up steps %q", got) }
+    if got := sqlOf(down); !slices.Equal(got, []string{"DROP TABLE a;", "DROP FUNCTION f;"}) { t.Fatalf("unexpected down steps %q", got) }
+    if up[0].(*SQLMigrationStep).NoSplit || !up[1].(*SQLMigrationStep).NoSplit { t.Fatalf("expected only the statement block to be unsplittable") }
+    if migs[0].Annotations["lock_timeout"] != "5s" { t.Fatalf("expected annotations after the marker, got %v", migs[0].Annotations) }
+
+    if err := os.WriteFile(f, []byte("CREATE TABLE b(id int);\n-- DOWN\nDROP TABLE b;\n"), 0o644); err != nil { t.Fatal(err) }
+    migs, err = NewFileMigrationSource(f).WithLogger(DiscardLogger).LoadMigrations()
+    if err != nil || !slices.Equal(sqlOf(migs[0].UpSteps), []string{"CREATE TABLE b(id int);"}) || !slices.Equal(sqlOf(migs[0].DownSteps), []string{"DROP TABLE b;"}) { t.Fatalf("unexpected legacy file steps %v: %v", migs, err) }
+}
+
+func TestMigrator_BaselineRecordsWithoutExecuting(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003"} { migs = append(migs, Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP" + v)}}) }
+    hist := &fakeHistory{applied: map[string]bool{"001": true}}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    resetRecs()
+    if err := m.Baseline(context.Background(), "002"); err != nil { t.Fatalf("Baseline: %v", err) }
+    if !hist.ensured || len(hist.recorded) != 1 || hist.recorded[0].Version != "002" { t.Fatalf("expected only 002 recorded, got %+v", hist.recorded) }
+    if containsSubstr("UP") { t.Fatalf("expected no migration executed, got %v", recStrings()) }
+    if err := m.Baseline(context.Background(), "x y"); err == nil { t.Fatalf("expected an invalid version to fail") }
+}
+
+func TestCreateMigration(t *testing.T){
+    dir := filepath.Join(t.TempDir(), "migrations")
+    paths, err := CreateMigration(dir, "  Add users!", nil)
+    if err != nil || len(paths) != 2 || filepath.Base(paths[0]) != "001_add_users_up.sql" || filepath.Base(paths[1]) != "001_add_users_down.sql" { t.Fatalf("unexpected files %v: %v", paths, err) }
+    paths, err = CreateMigration(dir, "add orders", SequentialVersion(5))
+    if err != nil || filepath.Base(paths[0]) != "00002_add_orders_up.sql" { t.Fatalf("unexpected files %v: %v", paths, err) }
+    fixed := func([]string) (string, error) { return "00002", nil }
+    if _, err := CreateMigration(dir, "add orders", fixed); err == nil { t.Fatalf("expected existing files not to be overwritten") }
+    if _, err := CreateMigration(dir, "--", nil); err == nil { t.Fatalf("expected an empty name to fail") }
+    migs, err := NewDirMigrationSource(dir).WithLogger(DiscardLogger).LoadMigrations()
+    if err != nil || len(migs) != 2 { t.Fatalf("expected the created migrations to load, got %v: %v", migs, err) }
+}
+
+func TestMigrator_CancellationStopsBetweenSteps(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    for _, perMigration := range []bool{false, true} {
+        ctx, cancel := context.WithCancel(context.Background())
+        cancelling := NewHookMigrationStep().WithUpHook(func(context.Context, Executor) error { cancel(); return nil })
+        migs := []Migration{
+            {Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_A1"), cancelling, NewSQLMigrationStep("UP_A2")}},
+            {Version: "002", Name: "b", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_B")}},
+        }
+        hist := &fakeHistory{}
+        m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithTransactional(true).WithTxPerMigration(perMigration)
+        resetRecs(); recMu.Lock(); txCommits, txRollbacks = 0, 0; recMu.Unlock()
+        err := m.MigrateUp(ctx, "")
+        if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "before up step 3 of migration 001") { t.Fatalf("expected a wrapped cancellation before step 3, got %v", err) }
+        recMu.Lock(); c, r := txCommits, txRollbacks; recMu.Unlock()
+        if containsExec("UP_A2") || containsExec("UP_B") || len(hist.recorded) != 0 || c != 0 || r != 1 { t.Fatalf("expected the run rolled back after step 2; recs=%v commits=%d rollbacks=%d", recStrings(), c, r) }
+        cancel()
+    }
+    ctx, cancel := context.WithCancel(context.Background()); cancel()
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{{Version: "001", Name: "a", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_A1")}}}}})
+    if _, err := m.applyMigrations(ctx, newMigrationRun(db), m.Sources[0].(*staticSource).migs, appliedSet{}, ""); !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "before migration 001") { t.Fatalf("expected cancellation before the migration, got %v", err) }
+}
+
+func TestMigrator_RunLevelHooks(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002"} { migs = append(migs, Migration{Version: v, Name: "m" + v, UpSteps: []MigrationStep{NewSQLMigrationStep("UP" + v)}, DownSteps: []MigrationStep{NewSQLMigrationStep("DOWN" + v)}}) }
+    var calls []string
+    all := func(stage string) RunHookFn { return func(ctx context.Context, exec Executor, dir string) error { calls = append(calls, stage+" "+dir); return nil } }
+    each := func(stage string) MigrationHookFn { return func(ctx context.Context, exec Executor, mig Migration, dir string) error { calls = append(calls, stage+" "+dir+" "+mig.Version); _, err := exec.ExecContext(ctx, "REFRESH "+mig.Version); return err } }
+    hist := &fakeHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithTransactional(true).
+        WithBeforeAll(all("beforeAll")).WithAfterAll(all("afterAll")).WithBeforeEach(each("before")).WithAfterEach(each("after"))
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    want := []string{"beforeAll up", "before up 001", "after up 001", "before up 002", "after up 002", "afterAll up"}
+    if !slices.Equal(calls, want) { t.Fatalf("unexpected hook calls %v", calls) }
+    if recs := recStrings(); !slices.Equal(slices.DeleteFunc(recs, func(r string) bool { return !strings.HasPrefix(r, "UP") && !strings.HasPrefix(r, "REFRESH") }), []string{"REFRESH 001", "UP001", "REFRESH 001", "REFRESH 002", "UP002", "REFRESH 002"}) { t.Fatalf("unexpected statements %v", recs) }
+
+    calls = nil
+    hist.applied = map[string]bool{"001": true, "002": true}
+    if err := m.MigrateDown(context.Background(), "002"); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    if !slices.Equal(calls, []string{"beforeAll down", "before down 002", "after down 002", "afterAll down"}) { t.Fatalf("unexpected down hook calls %v", calls) }
+
+    calls = nil
+    if err := m.MigrateUp(context.Background(), ""); err != nil || len(calls) != 0 { t.Fatalf("expected no hooks without pending migrations, got %v: %v", calls, err) }
+    hist.applied = nil
+    failing := m.WithAfterEach(func(context.Context, Executor, Migration, string) error { return errors.New("refresh failed") })
+    if err := failing.MigrateUp(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "after-each hook 1 of migration 001: refresh failed") { t.Fatalf("expected the hook failure to fail the run, got %v", err) }
+}
+
+func TestMigrator_RepairReconcilesHistory(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var migs []Migration
+    for _, v := range []string{"001", "002", "003", "004"} {
+        mig := *NewMigration(v, "m" + v).WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP")})
+        mig.Checksum = "sum" + v
+        migs = append(migs, mig)
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    rowsMu.Lock()
+    colsForQueryPrefix = map[string][]string{"SELECT version, name": {"version", "name", "migration_name", "applied_at", "description", "applied_by", "applied_user", "applied_host", "ticket", "duration_ms", "attempts", "search_path", "checksum"}}
+    rowsForQueryPrefix = map[string][][]driver.Value{"SELECT version, name": {
+        {"001", "m001", "app", "2024-01-02 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "sum001"},
+        {"002", "m002", "app", "2024-01-03 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, nil},
+        {"004", "m004", "app", "2024-01-04 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "old004"},
+        {"009", "gone", "app", "2024-01-05 03:04:05", nil, nil, nil, nil, nil, nil, nil, nil, "sum009"},
+    }}
+    rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix, colsForQueryPrefix = nil, nil; rowsMu.Unlock() }()
+
+    resetRecs()
+    report, err := m.Repair(context.Background())
+    if err != nil { t.Fatalf("Repair: %v", err) }
+    if len(report.Orphans) != 1 || report.Orphans[0].Migration.Version != "009" || report.OrphansRemoved { t.Fatalf("unexpected orphans %+v", report) }
+    if len(report.ChecksumsFilled) != 1 || report.ChecksumsFilled[0].Migration.Checksum != "sum002" || report.ChecksumsFilled[0].AppliedAt.Day() != 3 { t.Fatalf("unexpected filled checksums %+v", report.ChecksumsFilled) }
+    if len(report.Drift) != 1 || report.Drift[0].Version != "004" || len(report.OutOfOrder) != 1 || report.OutOfOrder[0].Version != "003" { t.Fatalf("unexpected discrepancies %+v", report) }
+    if deletes := recArgs("DELETE FROM hist"); len(deletes) != 1 || deletes[0][0] != "002" { t.Fatalf("expected only the 002 record rewritten, got %v", deletes) }
+    if inserts := recArgs("INSERT INTO hist"); len(inserts) != 1 || inserts[0][0] != "002" || inserts[0][12] != "sum002" { t.Fatalf("expected 002 re-recorded with its checksum, got %v", inserts) }
+
+    resetRecs()
+    report, err = m.WithRepairOrphans(true).Repair(context.Background())
+    if err != nil || !report.OrphansRemoved { t.Fatalf("expected orphans removed: %+v %v", report, err) }
+    if deletes := recArgs("DELETE FROM hist"); len(deletes) != 2 || deletes[1][0] != "009" { t.Fatalf("expected the orphan record deleted, got %v", deletes) }
+}
+
+func TestGoMigrationSource_RunsRegisteredFunctions(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var calls []string
+    fn := func(name string) GoMigrationFn {
+        return func(ctx context.Context, tx *sql.Tx) error {
+            if tx == nil { t.Fatalf("%s called without a transaction", name) }
+            calls = append(calls, name)
+            _, err := tx.ExecContext(ctx, "GO_" + name)
+            return err
+        }
+    }
+    src := NewGoMigrationSource().Register("002", "backfill", fn("up2"), fn("down2")).Register("001", "seed", fn("up1"), nil)
+    migs, err := src.WithMigrationName("data").LoadMigrations()
+    if err != nil || len(migs) != 2 || migs[0].Version != "001" || migs[0].MigrationName != "data" || len(migs[0].DownSteps) != 0 { t.Fatalf("unexpected migrations %+v %v", migs, err) }
+
+    hist := &fakeHistory{}
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{src}).WithTransactional(true)
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if strings.Join(calls, ",") != "up1,up2" || !containsExec("GO_up2") || len(hist.recorded) != 2 { t.Fatalf("unexpected run calls=%v recorded=%+v", calls, hist.recorded) }
+    if err := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{src}).MigrateUp(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "requires a transactional run") { t.Fatalf("expected non-transactional run to fail, got %v", err) }
+
+    _, err = NewGoMigrationSource().Register("001", "a", fn("a"), nil).Register("001", "b", fn("b"), nil).Register("002", "c", nil, nil).LoadMigrations()
+    if err == nil || !strings.Contains(err.Error(), "duplicate version") || !strings.Contains(err.Error(), "no up function") { t.Fatalf("expected registration errors, got %v", err) }
+}
+
+func TestMigrator_SchemaRunsKeepPerSchemaHistory(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    migs := []Migration{*NewMigration("001", "init").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_001")})}
+    hist := &fakeHistory{appliedByName: map[string]map[string]bool{"app@tenant_a": {"001": true}}}
+    m := NewMigrator(db, "public.hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithTransactional(true).WithSchemas([]string{"tenant_a", "Tenant B"})
+    resetRecs()
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if len(result.Migrations) != 1 || result.Migrations[0].MigrationName != "app@Tenant B" { t.Fatalf("expected only Tenant B migrated, got %+v", result.Migrations) }
+    if len(hist.recordedNames) != 1 || hist.recordedNames[0] != "app@Tenant B" { t.Fatalf("unexpected history names %v", hist.recordedNames) }
+    if !containsExec(`SET LOCAL search_path TO "Tenant B"`) || !containsExec("SET LOCAL search_path TO tenant_a") { t.Fatalf("unexpected session setup %v", recStrings()) }
+
+    var seen []string
+    err = m.ForEachSchema(context.Background(), func(ctx context.Context, schema string, sm *Migrator) error { seen = append(seen, schema + "=" + sm.MigrationName); return nil })
+    if err != nil || strings.Join(seen, ",") != "tenant_a=app@tenant_a,Tenant B=app@Tenant B" { t.Fatalf("unexpected iteration %v %v", seen, err) }
+    if err := m.WithSchemas([]string{"a", "a"}).MigrateUp(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "duplicate schema") { t.Fatalf("expected duplicate schema error, got %v", err) }
+    m.HistoryTable = "hist"
+    if err := m.MigrateUp(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "schema-qualified") { t.Fatalf("expected unqualified history table error, got %v", err) }
+}
+
+type opaqueStep struct{}
+
+func (opaqueStep) ExecuteUp(ctx context.Context, exec Executor) error { return nil }
+func (opaqueStep) ExecuteDown(ctx context.Context, exec Executor) error { return nil }
+
+func TestAnalyzeDestructive_WrappedAndGeneratedSteps(t *testing.T){
+    table := PartitionedTable{Table: "events", Interval: PartitionMonthly, Dialect: DialectPostgres}
+    for _, tc := range []struct{ step MigrationStep; reason string }{
+        {ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.events"), []string{"a", "b"}), "drops table"},
+        {NewTemplateMigrationStep("TRUNCATE {{.table}}", ""), "truncates a table"},
+        {table.DetachPartition("events_old", time.Now()), ""},
+        {&PartitionStep{UpSQL: "ALTER TABLE events DROP PARTITION p2020"}, "drops partition p2020"},
+        {opaqueStep{}, "cannot be analyzed"},
+    } {
+        findings, err := AnalyzeDestructive(Migration{Version: "001", UpSteps: []MigrationStep{tc.step}})
+        if err != nil { t.Fatalf("%T: %v", tc.step, err) }
+        if tc.reason == "" { if len(findings) != 0 { t.Fatalf("%T: unexpected findings %+v", tc.step, findings) }; continue }
+        if len(findings) == 0 || !strings.Contains(findings[0].Reason, tc.reason) { t.Fatalf("%T: expected %q, got %+v", tc.step, tc.reason, findings) }
+    }
+    findings, _ := AnalyzeDestructive(Migration{Version: "001", UpSteps: []MigrationStep{ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.events"), []string{"a", "b"})}})
+    if len(findings) != 2 || findings[1].Statement != "DROP TABLE b.events" { t.Fatalf("expected a finding per schema, got %+v", findings) }
+    if findings, _ := AnalyzeDestructive(Migration{UpSteps: []MigrationStep{NewHookMigrationStep()}}); len(findings) != 0 { t.Fatalf("hook steps are not analyzed, got %+v", findings) }
+
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := &staticSource{migs: []Migration{{Version: "001", Name: "drop", UpSteps: []MigrationStep{ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.events"), []string{"a"})}}}}
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{src})
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); !errors.Is(err, ErrDestructiveMigration) || containsSubstr("DROP TABLE a.events") { t.Fatalf("expected ForSchemas DROP to be blocked, got %v", err) }
+    if err := m.WithAllowDestructive(true).MigrateUp(context.Background(), ""); err != nil || !containsExec("DROP TABLE a.events") { t.Fatalf("expected allowed DROP to run: %v", err) }
+}
+
+func TestMigrator_SQLValidationRendersWrappedSteps(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    var parsed []string
+    parser := SQLParserFunc(func(sql string) error {
+        parsed = append(parsed, sql)
+        if strings.Contains(sql, "SELEC ") { return errors.New("syntax error at SELEC") }
+        return nil
+    })
+    steps := []MigrationStep{
+        ForSchemas(NewSQLMigrationStep("CREATE TABLE {{schema}}.t (x int)"), []string{"a", "b"}),
+        NewTemplateMigrationStep("DELETE FROM t WHERE id <= {{.max_id}} AND pw = '{{ secret \"pw\" }}'", ""),
+        &PartitionStep{UpSQL: "ALTER TABLE e DETACH PARTITION e_p1"},
+    }
+    m := NewMigrator(db, "hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{*NewMigration("001", "a").WithUpSteps(steps)}}}).WithSQLValidation(parser)
+    resetRecs()
+    if err := m.validateSQL([]Migration{*NewMigration("001", "a").WithUpSteps(steps)}); err != nil { t.Fatalf("validateSQL: %v", err) }
+    want := []string{"CREATE TABLE a.t (x int)", "CREATE TABLE b.t (x int)", "DELETE FROM t WHERE id <= max_id AND pw = '[secret]'", "ALTER TABLE e DETACH PARTITION e_p1"}
+    if !reflect.DeepEqual(parsed, want) { t.Fatalf("unexpected parsed SQL %q", parsed) }
+    bad := []Migration{*NewMigration("001", "a").WithUpSteps([]MigrationStep{ForSchemas(NewSQLMigrationStep("SELEC * FROM {{schema}}.t"), []string{"a"})})}
+    if err := m.WithSources([]MigrationSource{&staticSource{migs: bad}}).MigrateUp(context.Background(), ""); !errors.Is(err, ErrInvalidSQL) || !strings.Contains(err.Error(), "up step 1 (schema)") { t.Fatalf("expected the ForSchemas step to be rejected, got %v", err) }
+}
+
+func TestClassifyMigration_WrappedAndGeneratedSteps(t *testing.T){
+    for _, tc := range []struct{ step MigrationStep; class MigrationClass }{
+        {ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.events"), []string{"a"}), ClassDestructive},
+        {NewTemplateMigrationStep("DELETE FROM t WHERE id <= {{.max_id}}", ""), ClassDataModifying},
+        {&PartitionStep{UpSQL: "ALTER TABLE events DROP PARTITION p2020"}, ClassDestructive},
+        {ForSchemas(NewSQLMigrationStep("CREATE TABLE {{schema}}.t (x int)"), []string{"a"}), ClassSchemaOnly},
+        {NewHookMigrationStep(), ClassDataModifying},
+    } {
+        check, err := ClassifyMigration(Migration{Version: "001", UpSteps: []MigrationStep{tc.step}})
+        if err != nil || check.Class != tc.class { t.Fatalf("%T: expected %v, got %v (%v)", tc.step, tc.class, check.Class, err) }
+    }
+    if _, err := ClassifyMigration(Migration{Version: "001", UpSteps: []MigrationStep{opaqueStep{}}}); err == nil || !strings.Contains(err.Error(), "cannot be classified") { t.Fatalf("expected opaque step to fail classification, got %v", err) }
+}
+
+func TestRequiredPrivileges_WrappedAndGeneratedSteps(t *testing.T){
+    steps := []MigrationStep{
+        ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.events"), []string{"a", "b"}),
+        NewTemplateMigrationStep("DELETE FROM t WHERE id <= {{.max_id}}", ""),
+        &PartitionStep{UpSQL: "ALTER TABLE events DETACH PARTITION p2020"},
+        NewHookMigrationStep(),
+    }
+    required, err := requiredPrivileges([]Migration{{Version: "001", UpSteps: steps}}, "hist")
+    if err != nil { t.Fatalf("requiredPrivileges: %v", err) }
+    want := []Privilege{{Name: "ALTER"}, {Name: "DELETE"}, {Name: "DROP"}, {Name: "INSERT", Table: "hist"}}
+    if !reflect.DeepEqual(required, want) { t.Fatalf("got required %v, want %v", required, want) }
+}
+
+func TestMigrator_DryRunRendersWrappedSteps(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    mig := Migration{Version: "001", Name: "wrapped", UpSteps: []MigrationStep{
+        ForSchemas(NewSQLMigrationStep("CREATE TABLE {{schema}}.t (x int)"), []string{"a", "b"}),
+        NewTemplateMigrationStep("DELETE FROM t WHERE id <= {{.max_id}} AND pw = '{{ secret \"pw\" }}'", ""),
+        &PartitionStep{UpSQL: "ALTER TABLE e DETACH PARTITION e_p1"},
+    }, DownSteps: []MigrationStep{ForSchemas(NewSQLMigrationStep("DROP TABLE {{schema}}.t"), []string{"a", "b"})}}
+    hist := &fakeHistory{}
+    var out bytes.Buffer
+    m := NewMigrator(db, "hist", hist, "app").WithSources([]MigrationSource{&staticSource{migs: []Migration{mig}}}).WithDryRun(true).WithDryRunOutput(&out)
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    want := "-- up 001: wrapped\nCREATE TABLE a.t (x int);\nCREATE TABLE b.t (x int);\nDELETE FROM t WHERE id <= max_id AND pw = '[secret]';\nALTER TABLE e DETACH PARTITION e_p1;\n-- record 001 in hist\n\n"
+    if out.String() != want { t.Fatalf("unexpected up plan %q", out.String()) }
+    out.Reset(); hist.applied = map[string]bool{"001": true}
+    if err := m.MigrateDown(context.Background(), ""); err != nil { t.Fatalf("MigrateDown: %v", err) }
+    if out.String() != "-- down 001: wrapped\nDROP TABLE b.t;\nDROP TABLE a.t;\n-- remove 001 from hist\n\n" { t.Fatalf("unexpected down plan %q", out.String()) }
+    if len(recStrings()) != 0 { t.Fatalf("expected no writes; recs=%v", recStrings()) }
+}
+
+func TestMigrator_SchemaRunsRefuseLegacyHistoryKey(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    const keyQuery = "SELECT a.attname FROM pg_index"
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{keyQuery: {{"version"}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    migs := []Migration{*NewMigration("001", "init").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_001")})}
+    m := NewMigrator(db, "public.hist", &PostgresHistoryManager{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithSchemas([]string{"tenant_a", "tenant_b"})
+    resetRecs()
+    err := m.MigrateUp(context.Background(), "")
+    if err == nil || !strings.Contains(err.Error(), "legacy primary key (version)") { t.Fatalf("expected legacy key error, got %v", err) }
+    if containsExec("UP_001") || containsSubstr("CREATE TABLE") || containsSubstr("search_path") { t.Fatalf("nothing must run with a legacy key; recs=%v", recStrings()) }
+
+    rowsMu.Lock(); rowsForQueryPrefix[keyQuery] = [][]driver.Value{{"migration_name"}, {"version"}}; rowsMu.Unlock()
+    var seen []string
+    if err := m.ForEachSchema(context.Background(), func(ctx context.Context, schema string, sm *Migrator) error { seen = append(seen, schema); return nil }); err != nil || len(seen) != 2 { t.Fatalf("expected current key to pass, got %v %v", seen, err) }
+}
+
+func TestMigrator_SchemaRunsNotifyOnce(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    migs := []Migration{*NewMigration("001", "init").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_001")})}
+    var notes []Notification
+    var runEvents []EventType
+    m := NewMigrator(db, "public.hist", &fakeHistory{}, "app").WithSources([]MigrationSource{&staticSource{migs: migs}}).WithSchemas([]string{"a", "b"}).
+        WithNotifiers(NotifierFunc(func(ctx context.Context, n Notification) error { notes = append(notes, n); return nil })).
+        WithEventSink(EventSinkFunc(func(e Event){ if e.Type == EventRunStarted || e.Type == EventRunCompleted || e.Type == EventRunFailed { runEvents = append(runEvents, e.Type) } }))
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if len(notes) != 1 || len(notes[0].Migrations) != 2 || notes[0].Migrations[1].MigrationName != "app@b" { t.Fatalf("expected one notification for both schemas, got %+v", notes) }
+    if !reflect.DeepEqual(runEvents, []EventType{EventRunStarted, EventRunCompleted}) { t.Fatalf("expected one run, got events %v", runEvents) }
+}
+
+func TestMigrator_RefusesLegacyHistoryKey(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    const keyQuery = "SELECT name FROM pragma_table_info"
+    rowsMu.Lock(); rowsForQueryPrefix = map[string][][]driver.Value{keyQuery: {{"version"}}}; rowsMu.Unlock()
+    defer func(){ rowsMu.Lock(); rowsForQueryPrefix = nil; rowsMu.Unlock() }()
+    migs := []Migration{*NewMigration("001", "init").WithUpSteps([]MigrationStep{NewSQLMigrationStep("UP_001")}).WithDownSteps([]MigrationStep{NewSQLMigrationStep("DOWN_001")})}
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    resetRecs()
+    for name, run := range map[string]func() error{
+        "up": func() error { return m.MigrateUp(context.Background(), "") },
+        "down": func() error { return m.MigrateDown(context.Background(), "") },
+        "baseline": func() error { return m.Baseline(context.Background(), "001") },
+    } {
+        if err := run(); err == nil || !strings.Contains(err.Error(), "legacy primary key (version)") { t.Fatalf("%s: expected legacy key error, got %v", name, err) }
+    }
+    if containsExec("UP_001") || containsExec("DOWN_001") || containsSubstr("INSERT INTO hist") || containsSubstr("DELETE FROM hist") { t.Fatalf("nothing must be written with a legacy key; recs=%v", recStrings()) }
+
+    rowsMu.Lock(); rowsForQueryPrefix[keyQuery] = [][]driver.Value{{"migration_name"}, {"version"}}; rowsMu.Unlock()
+    if err := m.MigrateUp(context.Background(), ""); err != nil || !containsExec("UP_001") { t.Fatalf("expected the current key to pass: %v", err) }
+}
+
+func TestMigrator_QuotesDerivedTableNames(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    src := NewVarMigrationSource("001", "a", "CREATE TABLE a (id INT)", "DELETE FROM a")
+    cases := []struct{ hm HistoryManager; want []string }{
+        {NewSQLiteHistoryManager(), []string{`CREATE TABLE IF NOT EXISTS ops."Schema Migrations_lock"`, `INSERT INTO ops."Schema Migrations_lock"`, `DELETE FROM ops."Schema Migrations_lock"`, `CREATE TABLE IF NOT EXISTS ops."Schema Migrations_audit"`, `INSERT INTO ops."Schema Migrations_audit"`, `CREATE TABLE IF NOT EXISTS ops."Schema Migrations_steps"`}},
+        {NewMySQLHistoryManager(), []string{"CREATE TABLE IF NOT EXISTS ops.`Schema Migrations_lock`", "INSERT INTO ops.`Schema Migrations_audit`", "CREATE TABLE IF NOT EXISTS ops.`Schema Migrations_steps`"}},
+    }
+    for _, c := range cases {
+        resetRecs()
+        m := NewMigrator(db, `ops."Schema Migrations"`, c.hm, "app").WithSources([]MigrationSource{src}).WithLock(time.Second).WithAudit(AuditChecksum).WithStepHistory(true).WithReadOnlyCheck(false)
+        if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("%T: MigrateUp: %v", c.hm, err) }
+        for _, want := range c.want {
+            if !containsSubstr(want) { t.Fatalf("%T: expected %q; recs=%v", c.hm, want, recStrings()) }
+        }
+        if containsSubstr("Migrations\"_") || containsSubstr("Migrations`_") { t.Fatalf("%T: suffix appended after the quote; recs=%v", c.hm, recStrings()) }
+    }
+    resetRecs()
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithAudit(AuditChecksum).WithAuditTable("ops.audit log")
+    if err := m.MigrateUp(context.Background(), ""); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    if !containsSubstr(`INSERT INTO ops."audit log"`) { t.Fatalf("expected quoted audit table; recs=%v", recStrings()) }
+}
+
+func TestMigrator_DeterministicCrossSourceOrdering(t *testing.T){
+    step := []MigrationStep{NewSQLMigrationStep("X")}
+    a := &staticSource{migs: []Migration{{Version: "002", Name: "a2", UpSteps: step}, {Version: "001", Name: "a1", UpSteps: step}}}
+    b := &staticSource{migs: []Migration{{Version: "002", Name: "b2", UpSteps: step}, {Version: "1", Name: "b1", UpSteps: step}}}
+    order := func(sources ...MigrationSource) []string {
+        all, err := NewMigrator(nil, "hist", nil, "app").WithSources(sources).LoadAllMigrations()
+        if err != nil { t.Fatalf("load: %v", err) }
+        var names []string
+        for _, mig := range all { names = append(names, mig.Name) }
+        return names
+    }
+    if got := order(a, b); !reflect.DeepEqual(got, []string{"a1", "b1", "a2", "b2"}) { t.Fatalf("unexpected order %v", got) }
+    if got := order(a, NewPrioritizedSource(b, -1)); !reflect.DeepEqual(got, []string{"a1", "b1", "b2", "a2"}) { t.Fatalf("unexpected prioritized order %v", got) }
+}
+
+func TestMigrator_NowFuncControlsAppliedAt(t *testing.T){
+    resetRecs()
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    fixed := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("X", 3600))
+    clock := func() time.Time { return fixed }
+    src := NewVarMigrationSource("001", "a", "UP", "DOWN")
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{src}).WithNowFunc(clock)
+    result, err := m.MigrateUpResult(context.Background(), "")
+    if err != nil { t.Fatalf("MigrateUpResult: %v", err) }
+    inserts := recArgs("INSERT INTO hist")
+    if len(inserts) != 1 || inserts[0][3] != fixed.UTC() || !result.StartedAt.Equal(fixed) { t.Fatalf("expected fixed applied_at, got %v", inserts) }
+
+    resetRecs()
+    if err := NewSQLiteHistoryManager().WithNowFunc(clock).RecordMigration(context.Background(), db, "hist", *NewMigration("002", "b"), "app"); err != nil { t.Fatalf("record: %v", err) }
+    if inserts := recArgs("INSERT INTO hist"); len(inserts) != 1 || inserts[0][3] != fixed.UTC() { t.Fatalf("expected manager clock, got %v", inserts) }
+}
+
+func TestNextVersion_InfersFormat(t *testing.T){
+    migs := func(versions ...string) []Migration {
+        var out []Migration
+        for _, v := range versions { out = append(out, Migration{Version: v}) }
+        return out
+    }
+    for _, c := range []struct{ existing []Migration; want string }{
+        {nil, "001"},
+        {migs("001", "002", "009"), "010"},
+        {migs("0001", "17"), "0018"},
+    } {
+        if got, err := NextVersion(c.existing); err != nil || got != c.want { t.Fatalf("NextVersion(%v) = %q, %v; want %q", c.existing, got, err, c.want) }
+    }
+    got, err := NextVersion(migs("20240101120000", "20990101000000"))
+    if err != nil || got != "20990101000001" { t.Fatalf("expected timestamp after newest, got %q, %v", got, err) }
+
+    fixed := func() time.Time { return time.UnixMilli(1700000000000) }
+    gen := ULIDVersion(fixed, strings.NewReader(strings.Repeat("\x00", 10)))
+    ulid, err := gen(nil)
+    if err != nil || ulid != "01HF7YAT00" + strings.Repeat("0", 16) { t.Fatalf("unexpected ULID %q, %v", ulid, err) }
+    next, err := ULIDVersion(fixed, strings.NewReader(strings.Repeat("\x00", 10)))([]string{ulid})
+    if err != nil || next <= ulid || !strings.HasSuffix(next, "01") { t.Fatalf("expected incremented ULID, got %q, %v", next, err) }
+
+    uuid, err := UUIDVersion(fixed, strings.NewReader(strings.Repeat("\xff", 10)))(nil)
+    if err != nil || uuid != "018bcfe5-6800-7fff-bfff-ffffffffffff" { t.Fatalf("unexpected UUID %q, %v", uuid, err) }
+    next, err = UUIDVersion(fixed, strings.NewReader(strings.Repeat("\x00", 10)))([]string{strings.ToUpper(uuid)})
+    if err != nil || next != "018bcfe5-6801-7000-8000-000000000000" { t.Fatalf("expected incremented UUID, got %q, %v", next, err) }
+    if got, err := NextVersion(migs(uuid)); err != nil || !isUUIDVersion(got) || got[14] != '7' || got <= uuid { t.Fatalf("expected UUIDv7 after %s, got %q, %v", uuid, got, err) }
+}
+
+func TestMigrator_ULIDAndUUIDVersionsEndToEnd(t *testing.T){
+    db, _ := sql.Open("testdrv", ""); defer db.Close()
+    ulids := []string{"01HF7YAT00AAAAAAAAAAAAAAAA", "01hf7yat01aaaaaaaaaaaaaaaa", "01HF7YAT02AAAAAAAAAAAAAAAA"}
+    var migs []Migration
+    for _, v := range []string{ulids[2], ulids[0], ulids[1]} {
+        migs = append(migs, Migration{Version: v, Name: "m", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_" + strings.ToUpper(v[8:10]))}, DownSteps: []MigrationStep{NewSQLMigrationStep("DOWN")}})
+    }
+    m := NewMigrator(db, "hist", NewSQLiteHistoryManager(), "app").WithSources([]MigrationSource{&staticSource{migs: migs}})
+    if err := m.Check(context.Background()); err != nil { t.Fatalf("Check: %v", err) }
+    resetRecs()
+    if err := m.MigrateUp(context.Background(), strings.ToLower(ulids[1])); err != nil { t.Fatalf("MigrateUp: %v", err) }
+    var order []string
+    for _, r := range recStrings() { if strings.HasPrefix(r, "UP_") { order = append(order, r) } }
+    if !slices.Equal(order, []string{"UP_00", "UP_01"}) { t.Fatalf("expected the first two ULIDs in order, got %v", order) }
+    if inserts := recArgs("INSERT INTO hist"); len(inserts) != 2 || inserts[0][0] != ulids[0] || inserts[1][0] != ulids[1] { t.Fatalf("expected versions stored as written, got %v", inserts) }
+
+    resetRecs()
+    uuid := Migration{Version: "018bcfe5-6800-7000-8000-000000000000", Name: "u", UpSteps: []MigrationStep{NewSQLMigrationStep("UP_UUID")}}
+    if _, err := m.WithSources([]MigrationSource{&staticSource{migs: []Migration{uuid}}}).MigrateRangeResult(context.Background(), "018BCFE5-6800-7000-8000-000000000000", ""); err != nil || !containsExec("UP_UUID") { t.Fatalf("expected UUID range applied: %v", err) }
+    if err := (&Migrator{}).checkRange("01HF7YAT02AAAAAAAAAAAAAAAA", "01HF7YAT01AAAAAAAAAAAAAAAA"); err == nil { t.Fatalf("expected reversed ULID range to fail") }
+    if err := (&Migrator{}).checkMigration(Migration{Version: "v1", Name: "x"}, map[string]map[string]bool{}); len(err) == 0 { t.Fatalf("expected non-version format rejected") }
+}
+
+// --- Helpers ---
+
+type staticSource struct{ migs []Migration; loads int }
+func (s *staticSource) LoadMigrations() ([]Migration, error) { s.loads++; return s.migs, nil }
+
+func mustWrite(t *testing.T, p, s string){
+    t.Helper()
+    if err := os.WriteFile(p, []byte(s), 0o600); err != nil { t.Fatalf("write %s: %v", p, err) }
+}
+
+func containsExec(sub string) bool {
+    recMu.Lock(); defer recMu.Unlock()
+    for _, r := range recs { if r.query == sub { return true } }
+    return false
+}
+func countExec(query string) int {
+    recMu.Lock(); defer recMu.Unlock()
+    n := 0
+    for _, r := range recs { if r.query == query { n++ } }
+    return n
+}
+func containsSubstr(sub string) bool {
+    recMu.Lock(); defer recMu.Unlock()
+    for _, r := range recs { if strings.Contains(r.query, sub) { return true } }
+    return false
+}
+func recArgs(prefix string) [][]any {
+    recMu.Lock(); defer recMu.Unlock()
+    var out [][]any
+    for _, r := range recs { if strings.HasPrefix(r.query, prefix) { out = append(out, r.args) } }
+    return out
 }
 func recStrings() []string {
     recMu.Lock(); defer recMu.Unlock()
@@ -0,0 +1,324 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds the requests of the built-in notifiers and secret
+// providers when no HTTP client is set.
+const httpTimeout = 10 * time.Second
+
+// Notification summarizes a finished migration run for notifiers.
+type Notification struct {
+	// Direction is "up" or "down".
+	Direction string `json:"direction"`
+	// Succeeded reports whether the run succeeded.
+	Succeeded bool `json:"succeeded"`
+	// Error is the error of a failed run.
+	Error string `json:"error,omitempty"`
+	// StartedAt is when the run started.
+	StartedAt time.Time `json:"started_at"`
+	// DurationMS is the wall time of the run in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+	// Migrations lists the migrations executed by the run. For a failed
+	// run, these are the migrations executed before the failure.
+	Migrations []NotifiedMigration `json:"migrations"`
+	// Warnings holds the messages of the run's warnings.
+	Warnings []string `json:"warnings,omitempty"`
+	// Host is the hostname of the machine running the migrations.
+	Host string `json:"host,omitempty"`
+	// AppliedBy is the service identity set with WithAppliedBy.
+	AppliedBy string `json:"applied_by,omitempty"`
+	// Result is the full result of the run.
+	Result *Result `json:"-"`
+}
+
+// NotifiedMigration describes a migration executed by a notified run.
+type NotifiedMigration struct {
+	Version       string `json:"version"`
+	Name          string `json:"name"`
+	MigrationName string `json:"migration_name,omitempty"`
+	DurationMS    int64  `json:"duration_ms"`
+}
+
+// Summary returns a short human-readable description of the run, e.g.
+// "migrate up failed on host db-1 after 2 migrations: ...".
+//
+// Returns:
+//   - string: The summary.
+func (n Notification) Summary() string {
+	var b strings.Builder
+	status := "succeeded"
+	if !n.Succeeded {
+		status = "failed"
+	}
+	fmt.Fprintf(&b, "migrate %s %s", n.Direction, status)
+	if n.Host != "" {
+		fmt.Fprintf(&b, " on host %s", n.Host)
+	}
+	versions := make([]string, len(n.Migrations))
+	for i, mig := range n.Migrations {
+		versions[i] = mig.Version
+	}
+	fmt.Fprintf(&b, " after %d migrations", len(n.Migrations))
+	if len(versions) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(versions, ", "))
+	}
+	fmt.Fprintf(&b, " in %dms", n.DurationMS)
+	if n.Error != "" {
+		fmt.Fprintf(&b, ": %s", n.Error)
+	}
+	if len(n.Warnings) > 0 {
+		fmt.Fprintf(&b, "; %d warnings", len(n.Warnings))
+	}
+	return b.String()
+}
+
+// Notifier is notified when a migration run finishes.
+type Notifier interface {
+	// Notify delivers the notification of a finished run.
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierFunc is an adapter to use an ordinary function as a Notifier.
+type NotifierFunc func(ctx context.Context, notification Notification) error
+
+// Notify calls f(ctx, notification).
+func (f NotifierFunc) Notify(
+	ctx context.Context, notification Notification,
+) error {
+	return f(ctx, notification)
+}
+
+// WithNotifiers returns a new Migrator that notifies the given notifiers
+// when a run fails, or succeeds after executing migrations, so that
+// failed production migrations are noticed immediately. Runs with nothing
+// to do are not notified. Notifier failures are reported as warnings and
+// do not change the outcome of the run.
+//
+// Parameters:
+//   - notifiers: The notifiers to notify.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithNotifiers(notifiers ...Notifier) *Migrator {
+	new := *m
+	new.Notifiers = notifiers
+	return &new
+}
+
+// notify notifies the notifiers of a run that ended with err. Notifications
+// are delivered even if ctx was canceled, which may be why the run failed.
+func (m *Migrator) notify(ctx context.Context, result *Result, err error) {
+	if len(m.Notifiers) == 0 || err == nil && len(result.Migrations) == 0 {
+		return
+	}
+	notification := newNotification(result, err, currentIdentity(m.AppliedBy))
+	ctx = context.WithoutCancel(ctx)
+	for idx, notifier := range m.Notifiers {
+		if err := notifier.Notify(ctx, notification); err != nil {
+			m.warn(result, Warning{
+				Code:    WarningNotifyFailed,
+				Message: fmt.Sprintf("notifier %d failed: %v", idx+1, err),
+			})
+			continue
+		}
+		m.logf("Notifier %d notified", idx+1)
+	}
+}
+
+// newNotification returns the notification of a run that ended with err.
+func newNotification(
+	result *Result, err error, identity applierIdentity,
+) Notification {
+	notification := Notification{
+		Direction:  result.Direction,
+		Succeeded:  err == nil,
+		StartedAt:  result.StartedAt,
+		DurationMS: result.Duration.Milliseconds(),
+		Migrations: make([]NotifiedMigration, len(result.Migrations)),
+		Host:       identity.host,
+		AppliedBy:  identity.appliedBy,
+		Result:     result,
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	for i, mig := range result.Migrations {
+		notification.Migrations[i] = NotifiedMigration{
+			Version:       mig.Version,
+			Name:          mig.Name,
+			MigrationName: mig.MigrationName,
+			DurationMS:    mig.Duration.Milliseconds(),
+		}
+	}
+	for _, warning := range result.Warnings {
+		notification.Warnings = append(notification.Warnings, warning.Message)
+	}
+	return notification
+}
+
+// WebhookNotifier posts notifications as JSON to a URL.
+type WebhookNotifier struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+// NewWebhookNotifier returns a new WebhookNotifier.
+//
+// Parameters:
+//   - url: The URL to post notifications to.
+//
+// Returns:
+//   - *WebhookNotifier: A new WebhookNotifier.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url}
+}
+
+// WithHeader returns a new WebhookNotifier that sends the given header,
+// e.g. for authorization.
+//
+// Parameters:
+//   - key: The header name.
+//   - value: The header value.
+//
+// Returns:
+//   - *WebhookNotifier: A new WebhookNotifier.
+func (w *WebhookNotifier) WithHeader(key, value string) *WebhookNotifier {
+	new := *w
+	new.Header = w.Header.Clone()
+	if new.Header == nil {
+		new.Header = http.Header{}
+	}
+	new.Header.Set(key, value)
+	return &new
+}
+
+// WithClient returns a new WebhookNotifier that posts with client.
+//
+// Parameters:
+//   - client: The HTTP client to use.
+//
+// Returns:
+//   - *WebhookNotifier: A new WebhookNotifier.
+func (w *WebhookNotifier) WithClient(client *http.Client) *WebhookNotifier {
+	new := *w
+	new.Client = client
+	return &new
+}
+
+// Notify posts the notification as JSON.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - notification: The notification.
+//
+// Returns:
+//   - error: An error if the request fails or is not answered with 2xx.
+func (w WebhookNotifier) Notify(
+	ctx context.Context, notification Notification,
+) error {
+	return postJSON(ctx, w.Client, w.URL, w.Header, notification)
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// NewSlackNotifier returns a new SlackNotifier.
+//
+// Parameters:
+//   - webhookURL: The URL of the Slack incoming webhook.
+//
+// Returns:
+//   - *SlackNotifier: A new SlackNotifier.
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{WebhookURL: webhookURL}
+}
+
+// WithClient returns a new SlackNotifier that posts with client.
+//
+// Parameters:
+//   - client: The HTTP client to use.
+//
+// Returns:
+//   - *SlackNotifier: A new SlackNotifier.
+func (s *SlackNotifier) WithClient(client *http.Client) *SlackNotifier {
+	new := *s
+	new.Client = client
+	return &new
+}
+
+// Notify posts the summary of the notification as a Slack message. Failed
+// runs are prefixed with a warning emoji.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - notification: The notification.
+//
+// Returns:
+//   - error: An error if the request fails or is not answered with 2xx.
+func (s SlackNotifier) Notify(
+	ctx context.Context, notification Notification,
+) error {
+	text := notification.Summary()
+	if !notification.Succeeded {
+		text = ":rotating_light: " + text
+	}
+	payload := map[string]string{"text": text}
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, payload)
+}
+
+// postJSON posts payload encoded as JSON to url.
+func postJSON(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	header http.Header,
+	payload any,
+) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, url, bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = &http.Client{Timeout: httpTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL is left out since webhook URLs often embed a secret.
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("post notification: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post notification: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// objectTableSuffix is appended to the history table name to derive the
+// name of the table recording the applied code objects.
+const objectTableSuffix = "_objects"
+
+// Code object annotation keys.
+const (
+	// AnnotationDepends lists the code objects an object depends on,
+	// separated by commas.
+	AnnotationDepends = "depends"
+	// AnnotationDrop holds the statement dropping a code object when it
+	// cannot be derived from its definition.
+	AnnotationDrop = "drop"
+)
+
+// CodeObject is a repeatable database object, such as a view, function or
+// trigger, defined by a SQL file of the objects directory.
+type CodeObject struct {
+	// Name is the file name without the extension.
+	Name string
+	// Path is the path of the definition file.
+	Path string
+	// SQL is the definition, executed to create the object.
+	SQL string
+	// DropSQL drops the object.
+	DropSQL string
+	// DependsOn lists the names of the objects the object depends on.
+	DependsOn []string
+	// Checksum is the hex encoded SHA-256 of the definition.
+	Checksum string
+}
+
+// WithObjectsDir returns a new Migrator managing the code objects defined
+// in dir: views, functions, triggers and other objects that are replaced
+// rather than migrated. Every "*.sql" file of dir and its subdirectories
+// defines one object named after the file. After MigrateUp applied all
+// pending migrations, objects whose definition checksum changed are
+// dropped and recreated, together with the objects depending on them, in
+// the order declared by "-- depends: a, b" annotations. Objects whose file
+// was removed are dropped. Checksums are recorded in a table named after
+// the history table suffixed with "_objects".
+//
+// The drop statement is derived from CREATE VIEW, MATERIALIZED VIEW,
+// FUNCTION, PROCEDURE and TRIGGER definitions; set it with a "-- drop:
+// DROP ..." annotation for anything else.
+//
+// Parameters:
+//   - dir: The directory holding the object definitions.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithObjectsDir(dir string) *Migrator {
+	new := *m
+	new.ObjectsDir = dir
+	return &new
+}
+
+// LoadCodeObjects loads the code objects defined in dir, ordered so that
+// every object follows the objects it depends on.
+//
+// Parameters:
+//   - dir: The directory holding the object definitions.
+//   - dialect: The dialect drop statements are derived for.
+//
+// Returns:
+//   - []CodeObject: The objects in dependency order.
+//   - error: An error if reading fails, a drop statement cannot be
+//     derived or the dependencies are unknown or cyclic.
+func LoadCodeObjects(dir string, dialect Dialect) ([]CodeObject, error) {
+	byName := make(map[string]CodeObject)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".sql") {
+			return nil
+		}
+		obj, err := loadCodeObject(path, dialect)
+		if err != nil {
+			return err
+		}
+		if other, ok := byName[obj.Name]; ok {
+			return fmt.Errorf(
+				"code object %s defined by %s and %s", obj.Name, other.Path, path,
+			)
+		}
+		byName[obj.Name] = obj
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortCodeObjects(byName)
+}
+
+// loadCodeObject reads the code object defined by the file at path.
+func loadCodeObject(path string, dialect Dialect) (CodeObject, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return CodeObject{}, err
+	}
+	sum := sha256.Sum256(content)
+	obj := CodeObject{
+		Name:     strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:     path,
+		SQL:      string(content),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+	annotations := parseAnnotationsString(obj.SQL)
+	for _, dep := range strings.Split(annotations[AnnotationDepends], ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			obj.DependsOn = append(obj.DependsOn, dep)
+		}
+	}
+	obj.DropSQL = annotations[AnnotationDrop]
+	if obj.DropSQL == "" {
+		if obj.DropSQL = dropStatement(obj.SQL, dialect); obj.DropSQL == "" {
+			return CodeObject{}, fmt.Errorf(
+				"%s: cannot derive the drop statement, add a %q annotation",
+				path, AnnotationDrop,
+			)
+		}
+	}
+	return obj, nil
+}
+
+// sortCodeObjects orders objects so that every object follows its
+// dependencies, and otherwise by name.
+func sortCodeObjects(byName map[string]CodeObject) ([]CodeObject, error) {
+	names := make([]string, 0, len(byName))
+	for name, obj := range byName {
+		for _, dep := range obj.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf(
+					"code object %s depends on unknown object %s", name, dep,
+				)
+			}
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	sorted := make([]CodeObject, 0, len(names))
+	state := make(map[string]int) // 1 while visiting, 2 when sorted
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf(
+				"code objects depend on each other: %s",
+				strings.Join(append(path, name), " -> "),
+			)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		deps := slices.Sorted(slices.Values(byName[name].DependsOn))
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		sorted = append(sorted, byName[name])
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// dropStatement derives the statement dropping the object created by sql,
+// or returns an empty string if it cannot be derived.
+func dropStatement(sql string, dialect Dialect) string {
+	stmts, err := SplitStatements(sql)
+	if err != nil || len(stmts) == 0 {
+		return ""
+	}
+	tokens := sqlTokens(stmts[0])
+	if len(tokens) < 3 || upper(tokens[0]) != "CREATE" {
+		return ""
+	}
+	tokens = skipWords(tokens[1:], "OR", "REPLACE", "DEFINER", "=")
+	// Skip MySQL's DEFINER = user clause.
+	for len(tokens) > 0 && strings.ContainsAny(tokens[0], "@`'") {
+		tokens = tokens[1:]
+	}
+	tokens = skipWords(tokens, "TEMP", "TEMPORARY", "RECURSIVE", "CONSTRAINT")
+	if len(tokens) < 2 {
+		return ""
+	}
+	kind := upper(tokens[0])
+	rest := tokens[1:]
+	if kind == "MATERIALIZED" && upper(rest[0]) == "VIEW" {
+		kind, rest = "MATERIALIZED VIEW", rest[1:]
+	}
+	if len(rest) == 0 {
+		return ""
+	}
+	name := rest[0]
+	switch kind {
+	case "VIEW", "MATERIALIZED VIEW":
+		return fmt.Sprintf("DROP %s IF EXISTS %s", kind, name)
+	case "FUNCTION", "PROCEDURE":
+		if dialect != DialectPostgres {
+			return fmt.Sprintf("DROP %s IF EXISTS %s", kind, name)
+		}
+		return fmt.Sprintf(
+			"DROP %s IF EXISTS %s(%s)", kind, name, routineArgs(rest[1:]),
+		)
+	case "TRIGGER":
+		if dialect != DialectPostgres {
+			return "DROP TRIGGER IF EXISTS " + name
+		}
+		on := slices.IndexFunc(rest, func(tok string) bool { return upper(tok) == "ON" })
+		if on < 0 || on+1 >= len(rest) {
+			return ""
+		}
+		return fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", name, rest[on+1])
+	}
+	return ""
+}
+
+// routineArgs returns the argument list of a Postgres routine, whose
+// tokens start at the opening parenthesis, without defaults.
+func routineArgs(tokens []string) string {
+	if len(tokens) == 0 || tokens[0] != "(" {
+		return ""
+	}
+	depth, end := 0, len(tokens)
+	for i, tok := range tokens {
+		if tok == "(" {
+			depth++
+		} else if tok == ")" {
+			if depth--; depth == 0 {
+				end = i
+				break
+			}
+		}
+	}
+	var args []string
+	for _, arg := range splitClauses(tokens[1:end]) {
+		cut := slices.IndexFunc(arg, func(tok string) bool {
+			return upper(tok) == "DEFAULT" || tok == "="
+		})
+		if cut >= 0 {
+			arg = arg[:cut]
+		}
+		if len(arg) > 0 {
+			args = append(args, strings.Join(arg, " "))
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+// objectTable returns the name of the table recording code objects.
+func (m *Migrator) objectTable() string {
+	return m.derivedTable(objectTableSuffix)
+}
+
+// ensureObjectTable creates the object table if code objects are managed.
+func (m *Migrator) ensureObjectTable(ctx context.Context) error {
+	if m.ObjectsDir == "" {
+		return nil
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		checksum VARCHAR(64) NOT NULL,
+		drop_sql TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`, m.objectTable())
+	if _, err := m.DB.ExecContext(ctx, query); err != nil {
+		m.logf("Error ensuring object table %s: %v", m.objectTable(), err)
+		return err
+	}
+	return nil
+}
+
+// appliedObject is the record of an applied code object.
+type appliedObject struct {
+	checksum string
+	dropSQL  string
+}
+
+// appliedObjects returns the recorded code objects keyed by name.
+func (m *Migrator) appliedObjects(
+	ctx context.Context,
+) (map[string]appliedObject, error) {
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT name, checksum, drop_sql FROM %s", m.objectTable(),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[string]appliedObject)
+	for rows.Next() {
+		var name string
+		var obj appliedObject
+		if err := rows.Scan(&name, &obj.checksum, &obj.dropSQL); err != nil {
+			return nil, err
+		}
+		applied[name] = obj
+	}
+	return applied, rows.Err()
+}
+
+// applyObjects drops and recreates the changed code objects and their
+// dependents and drops removed objects. It returns the names of the
+// recreated objects.
+func (m *Migrator) applyObjects(
+	ctx context.Context, run *migrationRun,
+) ([]string, error) {
+	if m.ObjectsDir == "" {
+		return nil, nil
+	}
+	objects, err := LoadCodeObjects(
+		m.ObjectsDir, historyDialect(m.HistoryManager),
+	)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedObjects(ctx)
+	if err != nil {
+		m.logf("Error reading object table %s: %v", m.objectTable(), err)
+		return nil, err
+	}
+
+	// Objects follow their dependencies, so dependents of a changed object
+	// are marked when they are reached.
+	changed := make(map[string]bool)
+	var recreate []CodeObject
+	for _, obj := range objects {
+		dirty := applied[obj.Name].checksum != obj.Checksum
+		for _, dep := range obj.DependsOn {
+			dirty = dirty || changed[dep]
+		}
+		if dirty {
+			changed[obj.Name] = true
+			recreate = append(recreate, obj)
+		}
+	}
+	var removed []string
+	for name := range applied {
+		if !slices.ContainsFunc(objects, func(o CodeObject) bool {
+			return o.Name == name
+		}) {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(removed)
+
+	for _, obj := range slices.Backward(recreate) {
+		drop := obj.DropSQL
+		if prev, ok := applied[obj.Name]; ok {
+			drop = prev.dropSQL
+		}
+		if _, err := run.exec.ExecContext(ctx, drop); err != nil {
+			return nil, fmt.Errorf("drop code object %s: %w", obj.Name, err)
+		}
+	}
+	bind := placeholderFunc(m.HistoryManager)
+	deleteQuery := fmt.Sprintf(
+		"DELETE FROM %s WHERE name = %s", m.objectTable(), bind(1),
+	)
+	for _, name := range removed {
+		if _, err := run.exec.ExecContext(ctx, applied[name].dropSQL); err != nil {
+			return nil, fmt.Errorf("drop removed code object %s: %w", name, err)
+		}
+		if _, err := run.exec.ExecContext(ctx, deleteQuery, name); err != nil {
+			return nil, err
+		}
+		m.logf("Dropped removed code object %s", name)
+	}
+	insertQuery := fmt.Sprintf(
+		"INSERT INTO %s (name, checksum, drop_sql, applied_at) VALUES (%s)",
+		m.objectTable(), placeholderList(bind, 1, 4),
+	)
+	names := make([]string, 0, len(recreate))
+	for _, obj := range recreate {
+		if _, err := run.exec.ExecContext(ctx, obj.SQL); err != nil {
+			return nil, fmt.Errorf("create code object %s: %w", obj.Name, err)
+		}
+		if _, err := run.exec.ExecContext(ctx, deleteQuery, obj.Name); err != nil {
+			return nil, err
+		}
+		if _, err := run.exec.ExecContext(
+			ctx, insertQuery, obj.Name, obj.Checksum, obj.DropSQL, nowUTC(run.now),
+		); err != nil {
+			return nil, err
+		}
+		names = append(names, obj.Name)
+	}
+	m.logf(
+		"Code objects: %d recreated, %d dropped, %d unchanged",
+		len(recreate), len(removed), len(objects)-len(recreate),
+	)
+	return names, nil
+}
@@ -0,0 +1,257 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OnlineSchemaTool is an online schema change tool for MySQL.
+type OnlineSchemaTool string
+
+const (
+	// ToolGhost is GitHub's gh-ost.
+	ToolGhost OnlineSchemaTool = "gh-ost"
+	// ToolPtOnlineSchemaChange is Percona's pt-online-schema-change.
+	ToolPtOnlineSchemaChange OnlineSchemaTool = "pt-online-schema-change"
+)
+
+// onlineSchemaStopTimeout is how long a tool may take to clean up after it
+// is interrupted because the context is done before it is killed.
+const onlineSchemaStopTimeout = time.Minute
+
+// OnlineSchemaConn holds the connection details passed to an online schema
+// change tool, which connects to MySQL on its own.
+type OnlineSchemaConn struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+}
+
+// OnlineSchemaChangeStep delegates an ALTER TABLE to gh-ost or
+// pt-online-schema-change, so that large MySQL tables can be altered
+// without downtime from within the normal migration flow. The tool runs as
+// a separate process with its own connection: its changes are not part of
+// the run's transaction and are not rolled back if a later step fails. Its
+// output is streamed to the log, or to Output if set. When the context is
+// done, the tool is interrupted and killed if it does not exit within a
+// minute.
+type OnlineSchemaChangeStep struct {
+	Tool OnlineSchemaTool
+	Conn OnlineSchemaConn
+	// Table is the table to alter.
+	Table string
+	// Alter is the alteration without "ALTER TABLE <table>", e.g. "ADD
+	// COLUMN age INT".
+	Alter string
+	// Path is the path of the tool binary. Empty looks the tool up in PATH.
+	Path string
+	// Args are passed to the tool in addition to the built arguments, e.g.
+	// "--max-load=Threads_running=25".
+	Args   []string
+	Output io.Writer
+}
+
+// NewOnlineSchemaChangeStep returns a new OnlineSchemaChangeStep.
+//
+// Parameters:
+//   - tool: The tool to run.
+//   - conn: The connection details passed to the tool.
+//   - table: The table to alter.
+//   - alter: The alteration, e.g. "ADD COLUMN age INT".
+//
+// Returns:
+//   - *OnlineSchemaChangeStep: A new OnlineSchemaChangeStep.
+func NewOnlineSchemaChangeStep(
+	tool OnlineSchemaTool, conn OnlineSchemaConn, table string, alter string,
+) *OnlineSchemaChangeStep {
+	return &OnlineSchemaChangeStep{
+		Tool:  tool,
+		Conn:  conn,
+		Table: table,
+		Alter: alter,
+	}
+}
+
+// WithPath returns a new OnlineSchemaChangeStep running the tool binary at
+// path.
+//
+// Parameters:
+//   - path: The path of the tool binary.
+//
+// Returns:
+//   - *OnlineSchemaChangeStep: A new OnlineSchemaChangeStep.
+func (s *OnlineSchemaChangeStep) WithPath(path string) *OnlineSchemaChangeStep {
+	new := *s
+	new.Path = path
+	return &new
+}
+
+// WithArgs returns a new OnlineSchemaChangeStep passing args to the tool.
+//
+// Parameters:
+//   - args: The additional arguments.
+//
+// Returns:
+//   - *OnlineSchemaChangeStep: A new OnlineSchemaChangeStep.
+func (s *OnlineSchemaChangeStep) WithArgs(args ...string) *OnlineSchemaChangeStep {
+	new := *s
+	new.Args = args
+	return &new
+}
+
+// WithOutput returns a new OnlineSchemaChangeStep streaming the output of
+// the tool to w instead of the log.
+//
+// Parameters:
+//   - w: The writer to stream to.
+//
+// Returns:
+//   - *OnlineSchemaChangeStep: A new OnlineSchemaChangeStep.
+func (s *OnlineSchemaChangeStep) WithOutput(w io.Writer) *OnlineSchemaChangeStep {
+	new := *s
+	new.Output = w
+	return &new
+}
+
+// Command returns the command line running the tool: the binary followed
+// by its arguments.
+//
+// Returns:
+//   - []string: The command line.
+//   - error: An error if the tool is unknown.
+func (s OnlineSchemaChangeStep) Command() ([]string, error) {
+	bin := s.Path
+	if bin == "" {
+		bin = string(s.Tool)
+	}
+	var args []string
+	switch s.Tool {
+	case ToolGhost:
+		args = []string{
+			"--host=" + s.Conn.Host,
+			"--user=" + s.Conn.User,
+			"--password=" + s.Conn.Password,
+			"--database=" + s.Conn.Database,
+			"--table=" + s.Table,
+			"--alter=" + s.Alter,
+			"--execute",
+		}
+		if s.Conn.Port != 0 {
+			args = append(args, "--port="+strconv.Itoa(s.Conn.Port))
+		}
+	case ToolPtOnlineSchemaChange:
+		dsn := []string{"h=" + s.Conn.Host}
+		if s.Conn.Port != 0 {
+			dsn = append(dsn, "P="+strconv.Itoa(s.Conn.Port))
+		}
+		dsn = append(dsn,
+			"u="+s.Conn.User,
+			"p="+s.Conn.Password,
+			"D="+s.Conn.Database,
+			"t="+s.Table,
+		)
+		args = []string{"--alter", s.Alter, "--execute", strings.Join(dsn, ",")}
+	default:
+		return nil, fmt.Errorf("unknown online schema change tool %q", s.Tool)
+	}
+	return append(append([]string{bin}, args...), s.Args...), nil
+}
+
+// ExecuteUp runs the tool for upward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection, unused as the tool connects itself.
+//
+// Returns:
+//   - error: An error if the tool cannot be started or fails.
+func (s OnlineSchemaChangeStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	return s.run(ctx)
+}
+
+// ExecuteDown runs the tool for downward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection, unused as the tool connects itself.
+//
+// Returns:
+//   - error: An error if the tool cannot be started or fails.
+func (s OnlineSchemaChangeStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	return s.run(ctx)
+}
+
+// run runs the tool and streams its output.
+func (s OnlineSchemaChangeStep) run(ctx context.Context) error {
+	command, err := s.Command()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = onlineSchemaStopTimeout
+	out := s.Output
+	if out == nil {
+		logger := &lineLogger{
+			logger: contextLogger(ctx),
+			prefix: "[" + string(s.Tool) + "] ",
+		}
+		defer logger.Flush()
+		out = logger
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	contextLogger(ctx).Printf(
+		"Running %s on table %s: %s", s.Tool, s.Table, s.Alter,
+	)
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s interrupted: %w", s.Tool, ctxErr)
+		}
+		return fmt.Errorf("%s: %w", s.Tool, err)
+	}
+	return nil
+}
+
+// lineLogger logs the lines written to it with a prefix.
+type lineLogger struct {
+	logger Logger
+	prefix string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// Write logs the complete lines of p and buffers the rest.
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		idx := bytes.IndexByte(l.buf, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		l.logger.Printf("%s%s", l.prefix, bytes.TrimRight(l.buf[:idx], "\r"))
+		l.buf = l.buf[idx+1:]
+	}
+}
+
+// Flush logs the buffered incomplete line, if any.
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.logger.Printf("%s%s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
@@ -0,0 +1,287 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ReplicaLagQueries holds per dialect the default query a ReplicaPacer
+// measures replication lag with. Each query selects the largest lag in
+// seconds. The Postgres query runs on the primary; the MySQL query runs on
+// a replica, so MySQL pacers need replica connections (see WithReplicas).
+var ReplicaLagQueries = map[Dialect]string{
+	DialectPostgres: "SELECT COALESCE(EXTRACT(EPOCH FROM MAX(replay_lag)), 0) " +
+		"FROM pg_stat_replication",
+	DialectMySQL: "SELECT COALESCE(MAX(IF(APPLYING_TRANSACTION = '', 0, " +
+		"TIMESTAMPDIFF(MICROSECOND, " +
+		"APPLYING_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP, NOW(6)))), 0) / 1e6 " +
+		"FROM performance_schema.replication_applier_status_by_worker",
+}
+
+// ErrReplicaLag is returned when replicas do not catch up within the
+// maximum wait of a ReplicaPacer.
+var ErrReplicaLag = errors.New("replica lag")
+
+// ReplicaPacer pauses data migrations between batches until replication
+// lag is back under a limit, so that backfills do not knock read replicas
+// out of sync. Call Wait between batches of a hook step, or use a
+// BatchMigrationStep. Pacing is only effective in non-transactional runs,
+// as replicas receive nothing before the transaction commits.
+type ReplicaPacer struct {
+	// MaxLag is the largest acceptable replication lag.
+	MaxLag time.Duration
+	// Query selects the replication lag in seconds.
+	Query string
+	// Replicas are queried for the lag instead of the migration's
+	// connection. The largest lag counts.
+	Replicas []*sql.DB
+	// PollInterval is the pause between lag checks while waiting.
+	PollInterval time.Duration
+	// MaxWait bounds a single wait. Zero waits until the context is done.
+	MaxWait time.Duration
+}
+
+// NewReplicaPacer returns a new ReplicaPacer measuring lag with the
+// dialect's query from ReplicaLagQueries and checking it every second
+// while waiting.
+//
+// Parameters:
+//   - dialect: The dialect of the database.
+//   - maxLag: The largest acceptable replication lag.
+//
+// Returns:
+//   - *ReplicaPacer: A new ReplicaPacer.
+func NewReplicaPacer(dialect Dialect, maxLag time.Duration) *ReplicaPacer {
+	return &ReplicaPacer{
+		MaxLag:       maxLag,
+		Query:        ReplicaLagQueries[dialect],
+		PollInterval: time.Second,
+	}
+}
+
+// WithQuery returns a new ReplicaPacer measuring lag with query, e.g. one
+// reading a heartbeat table.
+//
+// Parameters:
+//   - query: The query selecting the replication lag in seconds.
+//
+// Returns:
+//   - *ReplicaPacer: A new ReplicaPacer.
+func (p *ReplicaPacer) WithQuery(query string) *ReplicaPacer {
+	new := *p
+	new.Query = query
+	return &new
+}
+
+// WithReplicas returns a new ReplicaPacer querying replicas for the lag.
+//
+// Parameters:
+//   - replicas: The replica connections.
+//
+// Returns:
+//   - *ReplicaPacer: A new ReplicaPacer.
+func (p *ReplicaPacer) WithReplicas(replicas ...*sql.DB) *ReplicaPacer {
+	new := *p
+	new.Replicas = replicas
+	return &new
+}
+
+// WithPollInterval returns a new ReplicaPacer checking the lag every
+// interval while waiting.
+//
+// Parameters:
+//   - interval: The pause between lag checks.
+//
+// Returns:
+//   - *ReplicaPacer: A new ReplicaPacer.
+func (p *ReplicaPacer) WithPollInterval(interval time.Duration) *ReplicaPacer {
+	new := *p
+	new.PollInterval = interval
+	return &new
+}
+
+// WithMaxWait returns a new ReplicaPacer whose waits fail with
+// ErrReplicaLag after maxWait.
+//
+// Parameters:
+//   - maxWait: The longest single wait.
+//
+// Returns:
+//   - *ReplicaPacer: A new ReplicaPacer.
+func (p *ReplicaPacer) WithMaxWait(maxWait time.Duration) *ReplicaPacer {
+	new := *p
+	new.MaxWait = maxWait
+	return &new
+}
+
+// Lag returns the current replication lag, measured on the replicas if
+// set, otherwise through exec, which must implement Queryer.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor of the migration.
+//
+// Returns:
+//   - time.Duration: The largest replication lag.
+//   - error: An error if the lag cannot be measured.
+func (p *ReplicaPacer) Lag(ctx context.Context, exec Executor) (time.Duration, error) {
+	if p.Query == "" {
+		return 0, fmt.Errorf("replica pacer has no lag query")
+	}
+	var queryers []Queryer
+	for _, db := range p.Replicas {
+		queryers = append(queryers, db)
+	}
+	if len(queryers) == 0 {
+		q, ok := exec.(Queryer)
+		if !ok {
+			return 0, fmt.Errorf(
+				"executor %T cannot query replication lag: %w",
+				exec, errors.ErrUnsupported,
+			)
+		}
+		queryers = append(queryers, q)
+	}
+	var lag time.Duration
+	for _, q := range queryers {
+		var seconds sql.NullFloat64
+		if err := q.QueryRowContext(ctx, p.Query).Scan(&seconds); err != nil {
+			return 0, fmt.Errorf("query replication lag: %w", err)
+		}
+		lag = max(lag, time.Duration(seconds.Float64*float64(time.Second)))
+	}
+	return lag, nil
+}
+
+// Wait returns once the replication lag is at most MaxLag, checking it
+// every PollInterval.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The executor of the migration.
+//
+// Returns:
+//   - error: An error if the lag cannot be measured, MaxWait passes or
+//     ctx is done.
+func (p *ReplicaPacer) Wait(ctx context.Context, exec Executor) error {
+	start := time.Now()
+	for {
+		lag, err := p.Lag(ctx, exec)
+		if err != nil {
+			return err
+		}
+		if lag <= p.MaxLag {
+			return nil
+		}
+		waited := time.Since(start)
+		if p.MaxWait > 0 && waited >= p.MaxWait {
+			return fmt.Errorf(
+				"%w: %s still exceeds %s after %s", ErrReplicaLag, lag, p.MaxLag,
+				waited.Round(time.Millisecond),
+			)
+		}
+		contextLogger(ctx).Printf(
+			"Replication lag %s exceeds %s, pausing", lag, p.MaxLag,
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// BatchMigrationStep executes a batch of a data migration repeatedly until
+// a batch affects no rows, e.g. "UPDATE users SET email_lower =
+// lower(email) WHERE id IN (SELECT id FROM users WHERE email_lower IS NULL
+// LIMIT 1000)". If Pacer is set, it waits for replicas to catch up between
+// batches.
+type BatchMigrationStep struct {
+	SQL   string
+	Pacer *ReplicaPacer
+}
+
+// NewBatchMigrationStep returns a new BatchMigrationStep.
+//
+// Parameters:
+//   - sql: The SQL of one batch.
+//
+// Returns:
+//   - *BatchMigrationStep: A new BatchMigrationStep.
+func NewBatchMigrationStep(sql string) *BatchMigrationStep {
+	return &BatchMigrationStep{SQL: sql}
+}
+
+// WithPacer returns a new BatchMigrationStep pacing its batches with pacer.
+//
+// Parameters:
+//   - pacer: The pacer to wait for between batches.
+//
+// Returns:
+//   - *BatchMigrationStep: A new BatchMigrationStep.
+func (b *BatchMigrationStep) WithPacer(pacer *ReplicaPacer) *BatchMigrationStep {
+	new := *b
+	new.Pacer = pacer
+	return &new
+}
+
+// ExecuteUp executes the batches for upward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if a batch or pacing fails.
+func (b BatchMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	return b.execute(ctx, exec)
+}
+
+// ExecuteDown executes the batches for downward migration.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if a batch or pacing fails.
+func (b BatchMigrationStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	return b.execute(ctx, exec)
+}
+
+// execute executes batches until one affects no rows.
+func (b BatchMigrationStep) execute(ctx context.Context, exec Executor) error {
+	query, args, err := bindArgs(ctx, b.SQL, nil)
+	if err != nil {
+		return err
+	}
+	for batch := 1; ; batch++ {
+		if batch > 1 {
+			if err := WaitIfPaused(ctx); err != nil {
+				return fmt.Errorf("after batch %d: %w", batch-1, err)
+			}
+		}
+		res, err := exec.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", batch, err)
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", batch, err)
+		}
+		if rows == 0 {
+			contextLogger(ctx).Printf(
+				"Batch migration step done after %d batches", batch,
+			)
+			return nil
+		}
+		if b.Pacer != nil {
+			if err := b.Pacer.Wait(ctx, exec); err != nil {
+				return fmt.Errorf("after batch %d: %w", batch, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package migrator
+
+import "slices"
+
+// Partition is a logical migration set with its own history namespace,
+// such as the migrations shipped by one module of an application.
+type Partition struct {
+	// MigrationName is the history namespace of the partition's migrations.
+	// Migrations whose source sets a migration name keep it.
+	MigrationName string
+	// Sources are the migration sources of the partition.
+	Sources []MigrationSource
+}
+
+// WithPartitions returns a new Migrator that runs the given partitions
+// after the migrations of its own sources, in one coordinated run with a
+// combined plan and result. Partitions are applied in order, each
+// completely before the next, and rolled back in reverse order, so a module
+// may depend on the schema of the partitions before it.
+//
+// Parameters:
+//   - partitions: The partitions to run.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithPartitions(partitions ...Partition) *Migrator {
+	new := *m
+	new.Partitions = slices.Clone(partitions)
+	return &new
+}
+
+// partitions returns the Migrator's own sources as the first partition,
+// followed by the configured partitions.
+func (m *Migrator) partitions() []Partition {
+	return append(
+		[]Partition{{MigrationName: m.MigrationName, Sources: m.Sources}},
+		m.Partitions...,
+	)
+}
+
+// allSources returns the sources of every partition.
+func (m *Migrator) allSources() []MigrationSource {
+	var sources []MigrationSource
+	for _, p := range m.partitions() {
+		sources = append(sources, p.Sources...)
+	}
+	return sources
+}
+
+// PartitionMigrations returns the migrations of the result recorded under
+// migrationName.
+//
+// Parameters:
+//   - migrationName: The history namespace of the partition.
+//
+// Returns:
+//   - []MigrationResult: The migrations of the partition in run order.
+func (r *Result) PartitionMigrations(migrationName string) []MigrationResult {
+	var migs []MigrationResult
+	for _, mig := range r.Migrations {
+		if mig.MigrationName == migrationName {
+			migs = append(migs, mig)
+		}
+	}
+	return migs
+}
@@ -0,0 +1,12 @@
+module github.com/aatuh/migrator/pgquery
+
+go 1.25.1
+
+require (
+	github.com/aatuh/migrator v0.0.0
+	github.com/pganalyze/pg_query_go/v6 v6.2.2
+)
+
+require google.golang.org/protobuf v1.31.0 // indirect
+
+replace github.com/aatuh/migrator => ../
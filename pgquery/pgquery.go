@@ -0,0 +1,65 @@
+// Package pgquery validates migration SQL with the Postgres parser, through
+// pg_query_go, before a run executes it:
+//
+//	m = m.WithSQLValidation(pgquery.NewParser())
+//
+// It is a separate module because pg_query_go compiles the Postgres parser
+// with cgo.
+package pgquery
+
+import (
+	"errors"
+
+	"github.com/aatuh/migrator"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/pganalyze/pg_query_go/v6/parser"
+)
+
+// Parser is a migrator.SQLParser using the parser of Postgres.
+type Parser struct{}
+
+// NewParser returns a new Parser.
+//
+// Returns:
+//   - Parser: A new Parser.
+func NewParser() Parser {
+	return Parser{}
+}
+
+// Parse returns a *migrator.SyntaxError if sql is not valid Postgres SQL.
+//
+// Parameters:
+//   - sql: The SQL to parse, one or more statements.
+//
+// Returns:
+//   - error: A *migrator.SyntaxError locating the problem, or nil.
+func (Parser) Parse(sql string) error {
+	_, err := pg_query.Parse(sql)
+	if err == nil {
+		return nil
+	}
+	var parseErr *parser.Error
+	if !errors.As(err, &parseErr) {
+		return err
+	}
+	return &migrator.SyntaxError{
+		Message: parseErr.Message,
+		Offset:  byteOffset(sql, parseErr.Cursorpos),
+	}
+}
+
+// byteOffset returns the byte offset of the 1-based character position pos
+// in sql, or -1 if pos is unknown.
+func byteOffset(sql string, pos int) int {
+	if pos <= 0 {
+		return -1
+	}
+	chars := 0
+	for offset := range sql {
+		chars++
+		if chars == pos {
+			return offset
+		}
+	}
+	return len(sql)
+}
@@ -0,0 +1,18 @@
+package pgquery
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/aatuh/migrator"
+)
+
+func TestParser_LocatesSyntaxErrors(t *testing.T){
+    p := NewParser()
+    if err := p.Parse("CREATE TABLE a (id int);\nINSERT INTO a VALUES (1);"); err != nil { t.Fatalf("valid SQL rejected: %v", err) }
+    sql := "CREATE TABLE a (id int);\nSELEC * FROM a;"
+    err := p.Parse(sql)
+    var syntaxErr *migrator.SyntaxError
+    if !errors.As(err, &syntaxErr) || syntaxErr.Offset != 25 || syntaxErr.Message != `syntax error at or near "SELEC"` { t.Fatalf("unexpected error %#v", err) }
+    if got := byteOffset("é SELEC", 3); got != 3 { t.Fatalf("byteOffset = %d", got) }
+}
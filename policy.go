@@ -0,0 +1,209 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MigrationClass classifies the impact of a migration.
+type MigrationClass string
+
+const (
+	// ClassSchemaOnly migrations only create or alter schema objects.
+	ClassSchemaOnly MigrationClass = "schema_only"
+	// ClassDataModifying migrations insert, update or delete rows, or run
+	// hooks that may do so.
+	ClassDataModifying MigrationClass = "data_modifying"
+	// ClassDestructive migrations drop or truncate objects or columns.
+	ClassDestructive MigrationClass = "destructive"
+)
+
+// classRank orders classes by impact.
+var classRank = map[MigrationClass]int{
+	ClassSchemaOnly:    0,
+	ClassDataModifying: 1,
+	ClassDestructive:   2,
+}
+
+// dataKeywords are the statement keywords of data-modifying statements.
+var dataKeywords = []string{
+	"INSERT", "UPDATE", "DELETE", "MERGE", "REPLACE", "UPSERT", "COPY",
+}
+
+// ErrPolicyDenied is returned when a PolicyHook blocks a migration.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+// PolicyCheck describes a pending migration passed to a PolicyHook.
+type PolicyCheck struct {
+	// Migration is the pending migration.
+	Migration Migration
+	// Class is the classification of the migration's up steps.
+	Class MigrationClass
+	// Statements are the statements that determined Class. They are empty
+	// for schema-only migrations and for data-modifying migrations that
+	// are classified by their hook steps.
+	Statements []string
+}
+
+// PolicyHook decides whether a pending migration may be applied. It may
+// block, e.g. while waiting for an approval, and returns an error to stop
+// the run before any migration is applied.
+type PolicyHook func(ctx context.Context, check PolicyCheck) error
+
+// ApprovalFunc reports whether a migration has been approved, e.g. by
+// querying a change management system for a second reviewer.
+type ApprovalFunc func(ctx context.Context, check PolicyCheck) (bool, error)
+
+// WithPolicyHook returns a new Migrator that calls hook with the
+// classification of every pending migration before MigrateUp applies any of
+// them, enabling approval gates such as a two-person rule for dangerous
+// changes.
+//
+// Parameters:
+//   - hook: The policy hook.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithPolicyHook(hook PolicyHook) *Migrator {
+	new := *m
+	new.PolicyHook = hook
+	return &new
+}
+
+// RequireApproval returns a PolicyHook that blocks migrations of the given
+// classes until approve reports them approved, polling it every interval.
+// Migrations of other classes pass. Waiting ends with an error when ctx is
+// done or approve fails.
+//
+// Parameters:
+//   - approve: The approval callback.
+//   - interval: The pause between calls of approve.
+//   - classes: The classes that need approval.
+//
+// Returns:
+//   - PolicyHook: The policy hook.
+func RequireApproval(
+	approve ApprovalFunc, interval time.Duration, classes ...MigrationClass,
+) PolicyHook {
+	return func(ctx context.Context, check PolicyCheck) error {
+		if !slices.Contains(classes, check.Class) {
+			return nil
+		}
+		for {
+			ok, err := approve(ctx, check)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return nil
+			}
+			contextLogger(ctx).Printf(
+				"Waiting for approval of %s migration %s",
+				check.Class, check.Migration.Version,
+			)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w: not approved: %w", ErrPolicyDenied, ctx.Err())
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+// ClassifyMigration classifies the up steps of mig. Statements flagged by
+// AnalyzeDestructive, such as DROP, TRUNCATE or dropping a column, are
+// destructive; statements inserting, updating or deleting rows are
+// data-modifying, as are hook steps, whose effects are unknown. Everything
+// else is schema-only. Steps wrapped by ForSchemas, templates and partition
+// steps are classified by the SQL they run. The migration has the class of
+// its most impactful step.
+//
+// Parameters:
+//   - mig: The migration to classify.
+//
+// Returns:
+//   - PolicyCheck: The classification, with the statements that caused it.
+//   - error: An error if the SQL of a step cannot be read or split, or a
+//     step that is not a hook step has no readable SQL.
+func ClassifyMigration(mig Migration) (PolicyCheck, error) {
+	check := PolicyCheck{Migration: mig, Class: ClassSchemaOnly}
+	for idx, step := range mig.UpSteps {
+		sqls, ok, err := stepSQLs(step, "up")
+		if err != nil {
+			return PolicyCheck{}, err
+		}
+		if !ok {
+			if !isHookStep(step) {
+				return PolicyCheck{}, fmt.Errorf(
+					"migration %s: up step %d: %T cannot be classified",
+					mig.Version, idx+1, step,
+				)
+			}
+			if classRank[check.Class] < classRank[ClassDataModifying] {
+				check.Class = ClassDataModifying
+			}
+			continue
+		}
+		for _, sql := range sqls {
+			stmts, err := SplitStatements(sql)
+			if err != nil {
+				return PolicyCheck{}, fmt.Errorf("migration %s: %w", mig.Version, err)
+			}
+			for _, stmt := range stmts {
+				class := classifyStatement(stmt)
+				switch {
+				case class == ClassSchemaOnly:
+				case classRank[class] > classRank[check.Class]:
+					check.Class = class
+					check.Statements = []string{strings.TrimSpace(stmt)}
+				case class == check.Class:
+					check.Statements = append(
+						check.Statements, strings.TrimSpace(stmt),
+					)
+				}
+			}
+		}
+	}
+	return check, nil
+}
+
+// classifyStatement returns the class of a single statement.
+func classifyStatement(stmt string) MigrationClass {
+	switch {
+	case len(analyzeStatement(stmt)) > 0:
+		return ClassDestructive
+	case slices.Contains(dataKeywords, leadingKeyword(stmt)):
+		return ClassDataModifying
+	}
+	return ClassSchemaOnly
+}
+
+// checkPolicy calls the policy hook with the classification of every
+// pending migration.
+func (m *Migrator) checkPolicy(ctx context.Context, pending []Migration) error {
+	if m.PolicyHook == nil {
+		return nil
+	}
+	ctx = m.withLogger(ctx)
+	for _, mig := range pending {
+		check, err := ClassifyMigration(mig)
+		if err != nil {
+			return err
+		}
+		if err := m.PolicyHook(ctx, check); err != nil {
+			err = fmt.Errorf(
+				"migration %s (%s) blocked by policy: %w", mig.Version, mig.Name, err,
+			)
+			m.logf("Policy check failed: %v", err)
+			return err
+		}
+		m.logf(
+			"Policy check passed for %s migration %s", check.Class, mig.Version,
+		)
+	}
+	return nil
+}
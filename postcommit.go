@@ -0,0 +1,45 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// PostCommitHookFn is called after the migrations of a run have been
+// committed. The result lists the migrations executed by the run.
+type PostCommitHookFn func(ctx context.Context, result *Result) error
+
+// WithPostCommitHooks returns a new Migrator that calls the given hooks
+// after a run that executed migrations has committed, for side effects such
+// as cache invalidation or notifications that must not happen if the
+// migrations are rolled back. Hooks run in order even if one fails. Hook
+// failures are reported as warnings and do not undo the migrations.
+//
+// Parameters:
+//   - hooks: The hooks to call after commit.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithPostCommitHooks(hooks ...PostCommitHookFn) *Migrator {
+	new := *m
+	new.PostCommitHooks = hooks
+	return &new
+}
+
+// runPostCommitHooks calls the post-commit hooks of a successful run and
+// reports their failures as warnings.
+func (m *Migrator) runPostCommitHooks(ctx context.Context, result *Result) {
+	if len(result.Migrations) == 0 {
+		return
+	}
+	for idx, hook := range m.PostCommitHooks {
+		if err := hook(ctx, result); err != nil {
+			m.warn(result, Warning{
+				Code:    WarningPostCommitHookFailed,
+				Message: fmt.Sprintf("post-commit hook %d failed: %v", idx+1, err),
+			})
+			continue
+		}
+		m.logf("Post-commit hook %d completed", idx+1)
+	}
+}
@@ -0,0 +1,191 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Preflight is a check run before any migration of a run. A failing check
+// aborts the run before the database is changed.
+type Preflight interface {
+	// Name identifies the check in errors and log output.
+	Name() string
+	// Check returns an error if the database is not fit to be migrated.
+	Check(ctx context.Context, db *sql.DB) error
+}
+
+// preflightFunc is a Preflight backed by a function.
+type preflightFunc struct {
+	name string
+	fn   func(ctx context.Context, db *sql.DB) error
+}
+
+// Name returns the name of the check.
+func (p preflightFunc) Name() string {
+	return p.name
+}
+
+// Check calls the check function.
+func (p preflightFunc) Check(ctx context.Context, db *sql.DB) error {
+	return p.fn(ctx, db)
+}
+
+// NewPreflight returns a Preflight with the given name calling fn.
+//
+// Parameters:
+//   - name: The name of the check.
+//   - fn: The check function.
+//
+// Returns:
+//   - Preflight: A new Preflight.
+func NewPreflight(
+	name string, fn func(ctx context.Context, db *sql.DB) error,
+) Preflight {
+	return preflightFunc{name: name, fn: fn}
+}
+
+// RequireExtensions returns a Preflight that fails unless the given Postgres
+// extensions are installed in the database.
+//
+// Parameters:
+//   - names: The names of the required extensions.
+//
+// Returns:
+//   - Preflight: A new Preflight.
+func RequireExtensions(names ...string) Preflight {
+	return NewPreflight(
+		"required extensions",
+		func(ctx context.Context, db *sql.DB) error {
+			rows, err := db.QueryContext(ctx, "SELECT extname FROM pg_extension")
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			installed := make(map[string]bool)
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					return err
+				}
+				installed[name] = true
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			var missing []string
+			for _, name := range names {
+				if !installed[name] {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) > 0 {
+				return fmt.Errorf(
+					"extensions not installed: %s", strings.Join(missing, ", "),
+				)
+			}
+			return nil
+		},
+	)
+}
+
+// MaxReplicationLag returns a Preflight that fails if the replay lag of any
+// Postgres replica connected to the database exceeds maxLag.
+//
+// Parameters:
+//   - maxLag: The largest acceptable replication lag.
+//
+// Returns:
+//   - Preflight: A new Preflight.
+func MaxReplicationLag(maxLag time.Duration) Preflight {
+	return NewPreflight(
+		"replication lag",
+		func(ctx context.Context, db *sql.DB) error {
+			var seconds sql.NullFloat64
+			err := db.QueryRowContext(
+				ctx,
+				"SELECT EXTRACT(EPOCH FROM MAX(replay_lag)) "+
+					"FROM pg_stat_replication",
+			).Scan(&seconds)
+			if err != nil {
+				return err
+			}
+			lag := time.Duration(seconds.Float64 * float64(time.Second))
+			if lag > maxLag {
+				return fmt.Errorf(
+					"replication lag %s exceeds %s", lag, maxLag,
+				)
+			}
+			return nil
+		},
+	)
+}
+
+// MinFreeDiskSpace returns a Preflight that fails if the free disk space
+// reported by query is below minBytes. Databases do not expose free disk
+// space in a portable way, so query must select a single number of free
+// bytes, e.g. from an administrative function or extension available on
+// the database host.
+//
+// Parameters:
+//   - query: The query selecting the free disk space in bytes.
+//   - minBytes: The smallest acceptable free disk space in bytes.
+//
+// Returns:
+//   - Preflight: A new Preflight.
+func MinFreeDiskSpace(query string, minBytes int64) Preflight {
+	return NewPreflight(
+		"free disk space",
+		func(ctx context.Context, db *sql.DB) error {
+			var free int64
+			if err := db.QueryRowContext(ctx, query).Scan(&free); err != nil {
+				return err
+			}
+			if free < minBytes {
+				return fmt.Errorf(
+					"%d bytes free, at least %d required", free, minBytes,
+				)
+			}
+			return nil
+		},
+	)
+}
+
+// WithPreflights returns a new Migrator that runs the given checks before
+// any migration of MigrateUp and MigrateDown.
+//
+// Parameters:
+//   - checks: The checks to run.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithPreflights(checks ...Preflight) *Migrator {
+	new := *m
+	new.Preflights = slices.Clone(checks)
+	return &new
+}
+
+// RunPreflights runs every configured check, including those after a
+// failing one, and returns the joined failures.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//
+// Returns:
+//   - error: An error listing the failed checks, or nil.
+func (m *Migrator) RunPreflights(ctx context.Context) error {
+	var errs []error
+	for _, check := range m.Preflights {
+		if err := check.Check(ctx, m.DB); err != nil {
+			m.logf("Preflight check %s failed: %v", check.Name(), err)
+			errs = append(errs, fmt.Errorf("preflight %s: %w", check.Name(), err))
+			continue
+		}
+		m.logf("Preflight check %s passed", check.Name())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultSQLPreviewLength is the default maximum length in characters of the
+// SQL preview included in step errors.
+const DefaultSQLPreviewLength = 200
+
+// Markers enclosing the failing statement in an SQL preview.
+const (
+	previewStartMarker = ">>> "
+	previewEndMarker   = " <<<"
+	previewEllipsis    = "..."
+)
+
+var (
+	// nearTextPattern matches the SQL fragment quoted by SQLite and MySQL
+	// syntax errors, such as `near "SELEC"` or `near 'SELEC * FROM'`.
+	nearTextPattern = regexp.MustCompile(`near ["'](.+?)["']`)
+	// lineNumberPattern matches the line number reported by MySQL errors.
+	lineNumberPattern = regexp.MustCompile(`at line (\d+)`)
+)
+
+// WithSQLPreviewLength returns a new Migrator that includes a preview of at
+// most n characters of the offending SQL in step errors and their log
+// output, and of the executed SQL in migration results. Zero uses
+// DefaultSQLPreviewLength and a negative value disables the preview.
+// Previews are never included when SQL is redacted.
+//
+// Parameters:
+//   - n: The maximum preview length.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSQLPreviewLength(n int) *Migrator {
+	new := *m
+	new.SQLPreviewLength = n
+	return &new
+}
+
+// previewLength returns the maximum SQL preview length, or a negative
+// value if previews are disabled or SQL is redacted.
+func (m *Migrator) previewLength() int {
+	if m.RedactSQL != RedactOff {
+		return -1
+	}
+	if m.SQLPreviewLength == 0 {
+		return DefaultSQLPreviewLength
+	}
+	return m.SQLPreviewLength
+}
+
+// sqlPreview returns the preview of sql for a step that failed with err, or
+// an empty string if previews are disabled or SQL is redacted.
+func (m *Migrator) sqlPreview(sql string, err error) string {
+	n := m.previewLength()
+	if n < 0 || sql == "" {
+		return ""
+	}
+	return buildSQLPreview(sql, err.Error(), n)
+}
+
+// previewRecorder keeps the beginning of the statements executed through
+// it, with whitespace collapsed, for the SQL preview of a migration result.
+// It stops recording once the preview length is exceeded.
+type previewRecorder struct {
+	exec  Executor
+	limit int
+	text  []rune
+}
+
+// ExecContext records the query and executes it on the wrapped executor.
+func (p *previewRecorder) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	if len(p.text) <= p.limit {
+		if len(p.text) > 0 {
+			p.text = append(p.text, []rune("; ")...)
+		}
+		text := strings.Join(strings.Fields(maskSecrets(ctx, query)), " ")
+		p.text = append(p.text, []rune(text)...)
+	}
+	return p.exec.ExecContext(ctx, query, args...)
+}
+
+// preview returns the preview of the recorded statements.
+func (p *previewRecorder) preview() string {
+	if p == nil {
+		return ""
+	}
+	return truncatePreview(p.text, 0, p.limit)
+}
+
+// previewExec returns exec wrapped to record the SQL preview of a
+// migration result, or exec and nil if previews are disabled.
+func (m *Migrator) previewExec(exec Executor) (Executor, *previewRecorder) {
+	n := m.previewLength()
+	if n < 0 {
+		return exec, nil
+	}
+	recorder := &previewRecorder{exec: exec, limit: n}
+	return withQueryer(recorder, exec, nil), recorder
+}
+
+// previewStatement is a statement of a script together with the line it
+// starts on.
+type previewStatement struct {
+	text string
+	line int
+}
+
+// buildSQLPreview returns a single-line preview of script of at most n
+// characters. When the failing statement can be identified from errMsg, it
+// is enclosed in markers and the preview is centered on it.
+func buildSQLPreview(script string, errMsg string, n int) string {
+	stmts, err := previewStatements(script)
+	if err != nil || len(stmts) == 0 {
+		return truncatePreview(
+			[]rune(strings.Join(strings.Fields(script), " ")), 0, n,
+		)
+	}
+
+	failing := failingStatement(stmts, errMsg)
+	var b strings.Builder
+	start := 0
+	for i, stmt := range stmts {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		if i == failing {
+			start = len([]rune(b.String()))
+			b.WriteString(previewStartMarker + stmt.text + previewEndMarker)
+			continue
+		}
+		b.WriteString(stmt.text)
+	}
+	return truncatePreview([]rune(b.String()), start, n)
+}
+
+// previewStatements returns the statements of script with whitespace
+// collapsed.
+func previewStatements(script string) ([]previewStatement, error) {
+	var stmts []previewStatement
+	scanner := NewStatementScanner(strings.NewReader(script))
+	scanner.MaxStatementSize = len(script) + 1
+	for scanner.Scan() {
+		stmts = append(stmts, previewStatement{
+			text: strings.Join(strings.Fields(scanner.Statement()), " "),
+			line: scanner.Line(),
+		})
+	}
+	return stmts, scanner.Err()
+}
+
+// locateStatement returns the 1-based index of the statement of a script
+// of several statements that errMsg refers to and the line it starts on,
+// or zeros if the script has a single statement or the failing one cannot
+// be identified.
+func locateStatement(script string, errMsg string) (int, int) {
+	stmts, err := previewStatements(script)
+	if err != nil || len(stmts) < 2 {
+		return 0, 0
+	}
+	failing := failingStatement(stmts, errMsg)
+	if failing < 0 {
+		return 0, 0
+	}
+	return failing + 1, stmts[failing].line
+}
+
+// failingStatement returns the index of the statement that errMsg refers
+// to, or -1 if it cannot be determined. A single statement is always the
+// failing one.
+func failingStatement(stmts []previewStatement, errMsg string) int {
+	if len(stmts) == 1 {
+		return 0
+	}
+	if match := nearTextPattern.FindStringSubmatch(errMsg); match != nil {
+		near := strings.Join(strings.Fields(match[1]), " ")
+		near = string([]rune(near)[:min(len([]rune(near)), 40)])
+		for i, stmt := range stmts {
+			if strings.Contains(stmt.text, near) {
+				return i
+			}
+		}
+	}
+	if match := lineNumberPattern.FindStringSubmatch(errMsg); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		failing := -1
+		for i, stmt := range stmts {
+			if stmt.line <= line {
+				failing = i
+			}
+		}
+		return failing
+	}
+	return -1
+}
+
+// truncatePreview cuts text to at most n characters around the position
+// focus, marking cut ends with an ellipsis.
+func truncatePreview(text []rune, focus int, n int) string {
+	if len(text) <= n {
+		return string(text)
+	}
+	from := max(0, focus-n/4)
+	to := min(len(text), from+n)
+	from = max(0, to-n)
+	preview := string(text[from:to])
+	if from > 0 {
+		preview = previewEllipsis + preview
+	}
+	if to < len(text) {
+		preview += previewEllipsis
+	}
+	return preview
+}
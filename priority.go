@@ -0,0 +1,145 @@
+package migrator
+
+import (
+	"cmp"
+)
+
+// PrioritySource is implemented by migration sources with a priority. When
+// several sources supply the same version, migrations of sources with a
+// lower priority value are ordered first. Sources without a priority have
+// priority 0.
+type PrioritySource interface {
+	// SourcePriority returns the priority of the source.
+	SourcePriority() int
+}
+
+// PrioritizedSource assigns a priority to a migration source.
+type PrioritizedSource struct {
+	MigrationSource
+	// Priority orders migrations of equal versions across sources. Lower
+	// values are ordered first.
+	Priority int
+}
+
+// NewPrioritizedSource returns a new PrioritizedSource wrapping src.
+//
+// Parameters:
+//   - src: The source to wrap.
+//   - priority: The priority of the source. Lower values are ordered first.
+//
+// Returns:
+//   - *PrioritizedSource: A new PrioritizedSource.
+func NewPrioritizedSource(
+	src MigrationSource, priority int,
+) *PrioritizedSource {
+	return &PrioritizedSource{
+		MigrationSource: src,
+		Priority:        priority,
+	}
+}
+
+// SourcePriority returns the priority of the source.
+//
+// Returns:
+//   - int: The priority.
+func (p *PrioritizedSource) SourcePriority() int {
+	return p.Priority
+}
+
+// LoadMigrationsWithWarnings loads the migrations of the wrapped source and
+// its warnings, if it reports any.
+//
+// Returns:
+//   - []Migration: The loaded migrations.
+//   - []Warning: The warnings found.
+//   - error: An error if loading fails.
+func (p *PrioritizedSource) LoadMigrationsWithWarnings() (
+	[]Migration, []Warning, error,
+) {
+	if reporter, ok := p.MigrationSource.(WarningReporter); ok {
+		return reporter.LoadMigrationsWithWarnings()
+	}
+	migs, err := p.MigrationSource.LoadMigrations()
+	return migs, nil, err
+}
+
+// Check validates the wrapped source if it implements SourceChecker.
+//
+// Returns:
+//   - error: The problems found, or nil.
+func (p *PrioritizedSource) Check() error {
+	if checker, ok := p.MigrationSource.(SourceChecker); ok {
+		return checker.Check()
+	}
+	return nil
+}
+
+// sourcePriority returns the priority of src.
+func sourcePriority(src MigrationSource) int {
+	if p, ok := src.(PrioritySource); ok {
+		return p.SourcePriority()
+	}
+	return 0
+}
+
+// compareVersions compares two versions numerically, whatever their number
+// of digits. Versions that are not all digits follow all numeric versions,
+// so that mixing them, e.g. with ULIDs, still yields a total order: a
+// numeric version compared as a string with one of them and as a number
+// with another could otherwise precede itself. ULIDs and UUIDs are compared
+// regardless of case, which orders ULIDs and UUIDv7s by creation time.
+// Other versions, and equal versions such as "01" and "1", are compared as
+// strings. The empty version, used as "no version", precedes all others.
+func compareVersions(a, b string) int {
+	digitsA, digitsB := isDigits(a), isDigits(b)
+	switch {
+	case a == "" || b == "":
+	case digitsA && digitsB:
+		if c := compareDigits(a, b); c != 0 {
+			return c
+		}
+	case digitsA:
+		return -1
+	case digitsB:
+		return 1
+	default:
+		if c := cmp.Compare(
+			canonicalVersion(a), canonicalVersion(b),
+		); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a, b)
+}
+
+// sourcedMigration is a loaded migration with the position of its source.
+type sourcedMigration struct {
+	mig       Migration
+	partition int
+	priority  int
+	source    int
+}
+
+// compareSourcedMigrations returns the function ordering migrations by
+// partition, version as ordered by compare, source priority, source position
+// and name, which makes the order independent of the order in which sources
+// return their migrations.
+func compareSourcedMigrations(
+	compare VersionComparator,
+) func(a, b sourcedMigration) int {
+	return func(a, b sourcedMigration) int {
+		if c := cmp.Compare(a.partition, b.partition); c != 0 {
+			return c
+		}
+		if c := compare(a.mig.Version, b.mig.Version); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.priority, b.priority); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.source, b.source); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.mig.Name, b.mig.Name)
+	}
+}
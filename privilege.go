@@ -0,0 +1,176 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Privilege is a database privilege needed to run migrations.
+type Privilege struct {
+	// Name is the privilege name, such as "CREATE" or "INSERT".
+	Name string
+	// Table is the table the privilege is needed on. It is empty for
+	// privileges needed on the database as a whole.
+	Table string
+}
+
+// String returns the privilege as shown in error messages.
+func (p Privilege) String() string {
+	if p.Table == "" {
+		return p.Name
+	}
+	return p.Name + " on " + p.Table
+}
+
+// PrivilegeChecker is implemented by history managers that can verify the
+// privileges of the connected role.
+type PrivilegeChecker interface {
+	// MissingPrivileges returns the privileges in required that the
+	// connected role does not have.
+	MissingPrivileges(
+		ctx context.Context, db *sql.DB, required []Privilege,
+	) ([]Privilege, error)
+}
+
+// ErrMissingPrivileges is returned when the connected role lacks privileges
+// needed by the pending migrations.
+var ErrMissingPrivileges = errors.New("missing privileges")
+
+// MissingPrivilegesError lists the missing privileges. It wraps
+// ErrMissingPrivileges.
+type MissingPrivilegesError struct {
+	Missing []Privilege
+}
+
+// Error returns the error message.
+func (e *MissingPrivilegesError) Error() string {
+	names := make([]string, len(e.Missing))
+	for i, p := range e.Missing {
+		names[i] = "missing privilege " + p.String()
+	}
+	return strings.Join(names, "; ")
+}
+
+// Unwrap returns ErrMissingPrivileges.
+func (e *MissingPrivilegesError) Unwrap() error {
+	return ErrMissingPrivileges
+}
+
+// privilegeKeywords are the statement keywords that need a privilege of the
+// same name.
+var privilegeKeywords = []string{
+	"CREATE", "ALTER", "DROP", "INSERT", "UPDATE", "DELETE",
+}
+
+// WithVerifyPrivileges returns a new Migrator that verifies, before applying
+// pending migrations, that the connected role has the privileges they need,
+// so that a run fails with a precise message instead of a driver error
+// halfway through. The needed privileges are derived from the leading
+// keyword of every SQL statement of the pending migrations, plus INSERT on
+// the history table. The HistoryManager must implement PrivilegeChecker.
+//
+// Parameters:
+//   - verify: Whether to verify privileges before migrating up.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithVerifyPrivileges(verify bool) *Migrator {
+	new := *m
+	new.VerifyPrivileges = verify
+	return &new
+}
+
+// verifyPrivileges checks that the connected role has the privileges needed
+// to apply pending.
+func (m *Migrator) verifyPrivileges(
+	ctx context.Context, pending []Migration,
+) error {
+	if !m.VerifyPrivileges || len(pending) == 0 {
+		return nil
+	}
+	checker, ok := m.HistoryManager.(PrivilegeChecker)
+	if !ok {
+		return fmt.Errorf(
+			"history manager %T cannot verify privileges: %w",
+			m.HistoryManager, errors.ErrUnsupported,
+		)
+	}
+	required, err := requiredPrivileges(pending, m.HistoryTable)
+	if err != nil {
+		return err
+	}
+	missing, err := checker.MissingPrivileges(ctx, m.DB, required)
+	if err != nil {
+		m.logf("Error verifying privileges: %v", err)
+		return err
+	}
+	if len(missing) > 0 {
+		err := &MissingPrivilegesError{Missing: missing}
+		m.logf("Privilege verification failed: %v", err)
+		return err
+	}
+	m.logf("Verified %d privileges", len(required))
+	return nil
+}
+
+// requiredPrivileges returns the privileges needed to apply pending and to
+// record them in historyTable. Steps wrapped by ForSchemas, templates and
+// partition steps are checked by the SQL they run.
+func requiredPrivileges(
+	pending []Migration, historyTable string,
+) ([]Privilege, error) {
+	var names []string
+	for _, mig := range pending {
+		for _, step := range mig.UpSteps {
+			sqls, _, err := stepSQLs(step, "up")
+			if err != nil {
+				return nil, err
+			}
+			for _, sql := range sqls {
+				stmts, err := SplitStatements(sql)
+				if err != nil {
+					return nil, fmt.Errorf("migration %s: %w", mig.Version, err)
+				}
+				for _, stmt := range stmts {
+					keyword := leadingKeyword(stmt)
+					if slices.Contains(privilegeKeywords, keyword) &&
+						!slices.Contains(names, keyword) {
+						names = append(names, keyword)
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	required := make([]Privilege, 0, len(names)+1)
+	for _, name := range names {
+		required = append(required, Privilege{Name: name})
+	}
+	return append(required, Privilege{Name: "INSERT", Table: historyTable}), nil
+}
+
+// leadingKeyword returns the upper-cased first word of stmt, skipping
+// leading whitespace and comments.
+func leadingKeyword(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"):
+			_, rest, _ := strings.Cut(stmt, "\n")
+			stmt = rest
+		case strings.HasPrefix(stmt, "/*"):
+			_, rest, _ := strings.Cut(stmt, "*/")
+			stmt = rest
+		default:
+			end := strings.IndexFunc(stmt, func(r rune) bool { return !isIdentRune(r) })
+			if end < 0 {
+				end = len(stmt)
+			}
+			return strings.ToUpper(stmt[:end])
+		}
+	}
+}
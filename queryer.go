@@ -0,0 +1,63 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Queryer is implemented by executors that can read data, such as *sql.DB,
+// *sql.Tx and *sql.Conn. The executors passed to steps implement it when
+// the underlying connection does, so hooks and guard steps can read data
+// inside the same transaction as the DDL:
+//
+//	if q, ok := exec.(migrator.Queryer); ok {
+//		err := q.QueryRowContext(ctx, "SELECT max(id) FROM t").Scan(&maxID)
+//	}
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// queryingExecutor adds the Queryer methods of the executor wrapped by an
+// Executor wrapper to the wrapper.
+type queryingExecutor struct {
+	Executor
+	queryer Queryer
+	// rewrite, if set, is applied to queries like the wrapper applies it
+	// to executed statements.
+	rewrite func(query string) string
+}
+
+// QueryContext runs the query on the wrapped Queryer.
+func (e queryingExecutor) QueryContext(
+	ctx context.Context, query string, args ...any,
+) (*sql.Rows, error) {
+	return e.queryer.QueryContext(ctx, e.rewritten(query), args...)
+}
+
+// QueryRowContext runs the single-row query on the wrapped Queryer.
+func (e queryingExecutor) QueryRowContext(
+	ctx context.Context, query string, args ...any,
+) *sql.Row {
+	return e.queryer.QueryRowContext(ctx, e.rewritten(query), args...)
+}
+
+// rewritten returns query after rewrite, if any.
+func (e queryingExecutor) rewritten(query string) string {
+	if e.rewrite == nil {
+		return query
+	}
+	return e.rewrite(query)
+}
+
+// withQueryer returns wrapper extended with the Queryer methods of inner,
+// the executor it wraps, if inner is a Queryer. Otherwise it returns
+// wrapper unchanged.
+func withQueryer(
+	wrapper Executor, inner Executor, rewrite func(query string) string,
+) Executor {
+	if q, ok := inner.(Queryer); ok {
+		return queryingExecutor{Executor: wrapper, queryer: q, rewrite: rewrite}
+	}
+	return wrapper
+}
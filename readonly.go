@@ -0,0 +1,77 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnlyDatabase is returned by runs connected to a read-only
+// database, such as a replica.
+var ErrReadOnlyDatabase = errors.New("database is read-only")
+
+// WithReadOnlyCheck returns a new Migrator that does or does not check
+// that the database accepts writes before applying or rolling back
+// migrations. The check is enabled by default for Postgres, where it
+// fails runs connected to a replica (pg_is_in_recovery()) or a read-only
+// session, and MySQL, where it fails runs on a server with read_only set.
+// Without it such runs fail with a driver error on their first write.
+//
+// Parameters:
+//   - enabled: Whether to check that the database accepts writes.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithReadOnlyCheck(enabled bool) *Migrator {
+	new := *m
+	new.SkipReadOnlyCheck = !enabled
+	return &new
+}
+
+// checkWritable returns an error wrapping ErrReadOnlyDatabase if the
+// database is a replica or read-only. Databases of other dialects, and
+// databases the check queries fail on, are assumed to be writable.
+func (m *Migrator) checkWritable(ctx context.Context) error {
+	if m.SkipReadOnlyCheck {
+		return nil
+	}
+	dialect := historyDialect(m.HistoryManager)
+	if dialect == DialectUnknown {
+		dialect = driverDialect(m.DB)
+	}
+	var reason string
+	switch dialect {
+	case DialectPostgres:
+		var recovery bool
+		var readOnly string
+		err := m.DB.QueryRowContext(
+			ctx,
+			"SELECT pg_is_in_recovery(), current_setting('transaction_read_only')",
+		).Scan(&recovery, &readOnly)
+		if err != nil {
+			m.logf("Skipping read-only check: %v", err)
+			return nil
+		}
+		switch {
+		case recovery:
+			reason = "connected to a replica: pg_is_in_recovery() is true"
+		case readOnly == "on":
+			reason = "connected to a read-only session: transaction_read_only is on"
+		}
+	case DialectMySQL:
+		var readOnly bool
+		err := m.DB.QueryRowContext(ctx, "SELECT @@global.read_only").Scan(&readOnly)
+		if err != nil {
+			m.logf("Skipping read-only check: %v", err)
+			return nil
+		}
+		if readOnly {
+			reason = "connected to a replica: read_only is set"
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	m.logf("Refusing to migrate: %s", reason)
+	return fmt.Errorf("%s: %w", reason, ErrReadOnlyDatabase)
+}
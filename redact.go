@@ -0,0 +1,103 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+)
+
+// RedactMode selects how SQL text appears in log output and errors.
+type RedactMode int
+
+const (
+	// RedactOff shows SQL verbatim.
+	RedactOff RedactMode = iota
+	// RedactFull replaces SQL with a fixed marker.
+	RedactFull
+	// RedactHash replaces SQL with a short SHA-256 hash, which still allows
+	// correlating a failure with a statement.
+	RedactHash
+)
+
+// redactedMarker replaces SQL in RedactFull mode.
+const redactedMarker = "[redacted]"
+
+// apply returns sql as it may be shown in log output and errors.
+func (r RedactMode) apply(sql string) string {
+	switch r {
+	case RedactFull:
+		return redactedMarker
+	case RedactHash:
+		sum := sha256.Sum256([]byte(sql))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	default:
+		return sql
+	}
+}
+
+// WithRedactSQL returns a new Migrator that redacts or hashes SQL in log
+// output and errors, identifying failures only by migration version, name
+// and step. Use it when migrations contain seeded secrets or personal data.
+// Errors returned by the database are hidden from the message of a
+// StepError as well, since they often quote the failing SQL; they remain
+// available through errors.Unwrap.
+//
+// Parameters:
+//   - mode: How to show SQL.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithRedactSQL(mode RedactMode) *Migrator {
+	new := *m
+	new.RedactSQL = mode
+	return &new
+}
+
+// redactedError hides the message of an error that may quote SQL while
+// keeping it available through errors.Unwrap.
+type redactedError struct {
+	err error
+}
+
+// Error returns a fixed message.
+func (e redactedError) Error() string {
+	return "details redacted"
+}
+
+// Unwrap returns the hidden error.
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+// queryTracker remembers the last query executed through it and sums the
+// rows affected by the executed queries.
+type queryTracker struct {
+	exec Executor
+	last string
+	// rows is the sum of rows affected, or -1 once a query could not
+	// report it.
+	rows int64
+}
+
+// ExecContext records the query and executes it on the wrapped executor.
+func (t *queryTracker) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	t.last = maskSecrets(ctx, query)
+	res, err := t.exec.ExecContext(ctx, query, args...)
+	if err != nil || t.rows < 0 {
+		return res, err
+	}
+	if res == nil {
+		t.rows = -1
+		return res, err
+	}
+	n, rowsErr := res.RowsAffected()
+	if rowsErr != nil {
+		t.rows = -1
+	} else {
+		t.rows += n
+	}
+	return res, err
+}
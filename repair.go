@@ -0,0 +1,212 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// RepairReport describes the discrepancies between the history and the
+// loaded migrations found by Repair, and what it changed.
+type RepairReport struct {
+	// Orphans are the history records of migrations no source defines.
+	Orphans []HistoryEntry
+	// OrphansRemoved reports whether the orphan records were removed, see
+	// WithRepairOrphans.
+	OrphansRemoved bool
+	// ChecksumsFilled are the records whose missing checksums were set to
+	// the checksums of the loaded migrations.
+	ChecksumsFilled []HistoryEntry
+	// Drift lists the applied migrations whose files were edited after they
+	// were applied. Repair does not change their records.
+	Drift []FileDrift
+	// OutOfOrder lists the pending migrations with a version below the
+	// highest applied version of their migration name, e.g. left behind by
+	// a failed non-transactional run.
+	OutOfOrder []Migration
+}
+
+// WithRepairOrphans returns a new Migrator whose Repair removes the history
+// records of migrations that no source defines anymore. By default Repair
+// only reports them.
+//
+// Parameters:
+//   - enabled: Whether Repair removes orphan records.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithRepairOrphans(enabled bool) *Migrator {
+	new := *m
+	new.RepairOrphans = enabled
+	return &new
+}
+
+// Repair reconciles the history with the loaded migrations, e.g. after a
+// failed non-transactional run left them out of sync. It records the
+// checksums of applied migrations whose records have none, removes the
+// records of migrations no source defines if WithRepairOrphans is set, and
+// reports edited and out-of-order migrations without changing them. The
+// changes are written in one transaction under the run lock, if enabled,
+// and Repair fails with a *RunInProgressError while another run of the
+// process uses the same database and history table. The HistoryManager must implement HistoryReader, and EntryHistoryManager
+// for checksums to be filled in.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//
+// Returns:
+//   - *RepairReport: The discrepancies found and the changes made.
+//   - error: An error if the history cannot be read or written.
+func (m *Migrator) Repair(ctx context.Context) (*RepairReport, error) {
+	m.logf("Starting Repair")
+
+	endRun, err := m.beginRun("up")
+	if err != nil {
+		return nil, err
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return nil, err
+	}
+	release, err := m.acquireLock(ctx, "up")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	entries, err := m.HistoryEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := m.LoadAllMigrations()
+	if err != nil {
+		return nil, err
+	}
+	report := m.repairReport(all, entries)
+
+	_, canFill := m.HistoryManager.(EntryHistoryManager)
+	if !canFill && len(report.ChecksumsFilled) > 0 {
+		m.logf(
+			"Warning: history manager %T cannot rewrite records, "+
+				"leaving %d checksums missing",
+			m.HistoryManager, len(report.ChecksumsFilled),
+		)
+		report.ChecksumsFilled = nil
+	}
+	report.OrphansRemoved = m.RepairOrphans && len(report.Orphans) > 0
+	if report.OrphansRemoved || len(report.ChecksumsFilled) > 0 {
+		if err := m.writeRepair(ctx, report); err != nil {
+			return nil, err
+		}
+	}
+
+	m.logf(
+		"Repair complete. %d orphan records (removed: %t), %d checksums "+
+			"filled, %d edited and %d out-of-order migrations",
+		len(report.Orphans), report.OrphansRemoved,
+		len(report.ChecksumsFilled), len(report.Drift), len(report.OutOfOrder),
+	)
+	return report, nil
+}
+
+// repairReport compares the history entries with the loaded migrations all.
+// ChecksumsFilled holds the entries with the checksums to record.
+func (m *Migrator) repairReport(
+	all []Migration, entries []HistoryEntry,
+) *RepairReport {
+	report := &RepairReport{}
+	loaded := make(map[[2]string]Migration, len(all))
+	for _, mig := range all {
+		loaded[[2]string{mig.MigrationName, mig.Version}] = mig
+	}
+	applied := make(map[[2]string]bool, len(entries))
+	highest := make(map[string]string)
+	for _, entry := range entries {
+		key := [2]string{entry.MigrationName, entry.Migration.Version}
+		mig, ok := loaded[key]
+		if !ok {
+			m.logf(
+				"Migration %s (%s) is recorded but no source defines it",
+				entry.Migration.Version, entry.Migration.Name,
+			)
+			report.Orphans = append(report.Orphans, entry)
+			continue
+		}
+		applied[key] = true
+		if last, ok := highest[entry.MigrationName]; !ok ||
+			m.compareVersions(entry.Migration.Version, last) > 0 {
+			highest[entry.MigrationName] = entry.Migration.Version
+		}
+		switch recorded := entry.Migration.Checksum; {
+		case mig.Checksum == "" || recorded == mig.Checksum:
+		case recorded == "":
+			entry.Migration.Checksum = mig.Checksum
+			report.ChecksumsFilled = append(report.ChecksumsFilled, entry)
+		default:
+			m.logf(
+				"Migration %s (%s) was edited after it was applied",
+				mig.Version, mig.Name,
+			)
+			report.Drift = append(report.Drift, FileDrift{
+				Version:         mig.Version,
+				Name:            mig.Name,
+				MigrationName:   mig.MigrationName,
+				AppliedAt:       entry.AppliedAt,
+				AppliedChecksum: recorded,
+				FileChecksum:    mig.Checksum,
+			})
+		}
+	}
+	for _, mig := range all {
+		last, ok := highest[mig.MigrationName]
+		if !ok || applied[[2]string{mig.MigrationName, mig.Version}] ||
+			m.compareVersions(mig.Version, last) >= 0 || m.excluded(mig, nil) {
+			continue
+		}
+		m.logf(
+			"Migration %s (%s) is pending below applied version %s",
+			mig.Version, mig.Name, last,
+		)
+		report.OutOfOrder = append(report.OutOfOrder, mig)
+	}
+	return report
+}
+
+// writeRepair removes the orphan records of report if requested and
+// rewrites the records whose checksums it fills, in one transaction.
+func (m *Migrator) writeRepair(ctx context.Context, report *RepairReport) error {
+	remove := slices.Clone(report.ChecksumsFilled)
+	if report.OrphansRemoved {
+		remove = append(remove, report.Orphans...)
+	}
+	tx := m.WithTransactional(true).WithTxPerMigration(false)
+	_, err := tx.runMigrationsIfTransactional(
+		ctx,
+		func(run *migrationRun) (int, error) {
+			for _, entry := range remove {
+				if err := m.HistoryManager.RemoveMigration(
+					ctx,
+					run.history,
+					m.HistoryTable,
+					entry.Migration,
+					entry.MigrationName,
+				); err != nil {
+					m.logf(
+						"Error removing migration record for %s: %v",
+						entry.Migration.Version, err,
+					)
+					return 0, err
+				}
+			}
+			if len(report.ChecksumsFilled) == 0 {
+				return 0, nil
+			}
+			return 0, m.recordEntries(ctx, run, report.ChecksumsFilled)
+		},
+	)
+	m.InvalidateCache()
+	if err != nil {
+		return fmt.Errorf("repair: %w", err)
+	}
+	return nil
+}
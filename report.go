@@ -0,0 +1,156 @@
+package migrator
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportHTML is the template of Result.RenderHTML.
+var reportHTML = template.Must(template.New("report").Parse(`<h2>Migration report ({{.Direction}})</h2>
+<ul>
+<li>Status: {{.Status}}</li>
+<li>Started: {{.Started}}</li>
+<li>Duration: {{.Duration}}</li>
+<li>Migrations: {{len .Migrations}}</li>
+</ul>
+{{- if .Migrations}}
+<table>
+<tr><th>Version</th><th>Name</th><th>Duration</th><th>Rows</th><th>SQL</th></tr>
+{{- range .Migrations}}
+<tr><td>{{.Version}}</td><td>{{.Name}}</td><td>{{.Duration}}</td><td>{{.Rows}}</td><td><code>{{.SQL}}</code></td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Warnings}}
+<h3>Warnings</h3>
+<ul>
+{{- range .Warnings}}
+<li><code>{{.Code}}</code>: {{.Message}}</li>
+{{- end}}
+</ul>
+{{- end}}
+`))
+
+// report holds the formatted fields of a rendered Result.
+type report struct {
+	Direction  string
+	Status     string
+	Started    string
+	Duration   string
+	Migrations []reportMigration
+	Warnings   []Warning
+}
+
+// reportMigration holds the formatted fields of a rendered migration.
+type reportMigration struct {
+	Version  string
+	Name     string
+	Duration string
+	Rows     string
+	SQL      string
+}
+
+// newReport returns the formatted fields of r.
+func newReport(r *Result) report {
+	rep := report{
+		Direction: r.Direction,
+		Status:    "succeeded",
+		Started:   r.StartedAt.Format(time.RFC3339),
+		Duration:  r.Duration.Round(time.Millisecond).String(),
+		Warnings:  r.Warnings,
+	}
+	if r.Err != nil {
+		rep.Status = "failed: " + r.Err.Error()
+	}
+	for _, mig := range r.Migrations {
+		rows := "unknown"
+		if n := mig.TotalRowsAffected(); n >= 0 {
+			rows = strconv.FormatInt(n, 10)
+		}
+		rep.Migrations = append(rep.Migrations, reportMigration{
+			Version:  mig.Version,
+			Name:     mig.Name,
+			Duration: mig.Duration.Round(time.Millisecond).String(),
+			Rows:     rows,
+			SQL:      mig.SQLPreview,
+		})
+	}
+	return rep
+}
+
+// RenderMarkdown returns a human-readable Markdown report of the run: its
+// status, timing, the executed migrations with their durations, affected
+// rows and SQL previews, and the warnings. CI jobs can attach it to a
+// deployment or post it as a pull request comment.
+//
+// Returns:
+//   - string: The Markdown report.
+func (r *Result) RenderMarkdown() string {
+	rep := newReport(r)
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Migration report (%s)\n\n", rep.Direction)
+	fmt.Fprintf(&b, "- Status: %s\n", markdownText(rep.Status))
+	fmt.Fprintf(&b, "- Started: %s\n", rep.Started)
+	fmt.Fprintf(&b, "- Duration: %s\n", rep.Duration)
+	fmt.Fprintf(&b, "- Migrations: %d\n", len(rep.Migrations))
+	if len(rep.Migrations) > 0 {
+		b.WriteString("\n| Version | Name | Duration | Rows | SQL |\n")
+		b.WriteString("|---|---|---|---|---|\n")
+		for _, mig := range rep.Migrations {
+			fmt.Fprintf(
+				&b,
+				"| %s | %s | %s | %s | %s |\n",
+				markdownText(mig.Version),
+				markdownText(mig.Name),
+				mig.Duration,
+				mig.Rows,
+				markdownCode(mig.SQL),
+			)
+		}
+	}
+	if len(rep.Warnings) > 0 {
+		b.WriteString("\n### Warnings\n\n")
+		for _, warning := range rep.Warnings {
+			fmt.Fprintf(
+				&b,
+				"- %s: %s\n",
+				markdownCode(string(warning.Code)),
+				markdownText(warning.Message),
+			)
+		}
+	}
+	return b.String()
+}
+
+// RenderHTML returns the report of RenderMarkdown as an HTML fragment.
+//
+// Returns:
+//   - string: The HTML report.
+func (r *Result) RenderHTML() string {
+	var b strings.Builder
+	// The template is fixed and its data are strings, so it cannot fail.
+	_ = reportHTML.Execute(&b, newReport(r))
+	return b.String()
+}
+
+// markdownText returns s on a single line with table cell separators
+// escaped.
+func markdownText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// markdownCode returns s as a Markdown code span, or "" if s is empty.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = markdownText(s)
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
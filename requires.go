@@ -0,0 +1,260 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AnnotationRequires holds the minimum server version of a migration, such
+// as "postgres >= 14". Several requirements may be separated by commas and
+// must all hold.
+const AnnotationRequires = "requires"
+
+// ServerVersioner is implemented by history managers that can report the
+// database server and its version.
+type ServerVersioner interface {
+	// ServerVersion returns the lower-case server name, such as "mysql",
+	// and its version, such as "8.0.34".
+	ServerVersion(ctx context.Context, db *sql.DB) (string, string, error)
+}
+
+// UnmetRequirementMode selects what happens to a migration whose "requires"
+// annotation does not hold for the connected server.
+type UnmetRequirementMode int
+
+const (
+	// UnmetRequirementError fails the run.
+	UnmetRequirementError UnmetRequirementMode = iota
+	// UnmetRequirementSkip skips the migration with a warning. It stays
+	// pending and is considered again by later runs.
+	UnmetRequirementSkip
+)
+
+// ErrRequirementNotMet is returned when a migration requires a server the
+// database does not match.
+var ErrRequirementNotMet = errors.New("server requirement not met")
+
+// Requirement is a server version constraint of a migration.
+type Requirement struct {
+	// Server is the lower-case server name, such as "postgres".
+	Server string
+	// Op is one of "=", ">", ">=", "<" and "<=".
+	Op string
+	// Version is the dotted version the server version is compared with.
+	Version string
+}
+
+// String returns the requirement in annotation form.
+func (r Requirement) String() string {
+	return r.Server + " " + r.Op + " " + r.Version
+}
+
+// serverVersion is the server name and version reported by a
+// ServerVersioner.
+type serverVersion struct {
+	name    string
+	version string
+}
+
+// requirementOps lists the supported operators, longest first so that ">="
+// is not parsed as ">".
+var requirementOps = []string{">=", "<=", "==", "=", ">", "<"}
+
+// ParseRequirements parses a "requires" annotation value such as
+// "postgres >= 14" or "mysql >= 8.0.13, mysql < 9".
+//
+// Parameters:
+//   - value: The annotation value.
+//
+// Returns:
+//   - []Requirement: The parsed requirements.
+//   - error: An error if a requirement is malformed.
+func ParseRequirements(value string) ([]Requirement, error) {
+	var reqs []Requirement
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		var req Requirement
+		for _, op := range requirementOps {
+			server, version, ok := strings.Cut(part, op)
+			if !ok {
+				continue
+			}
+			req = Requirement{
+				Server:  strings.ToLower(strings.TrimSpace(server)),
+				Op:      op,
+				Version: strings.TrimSpace(version),
+			}
+			if op == "==" {
+				req.Op = "="
+			}
+			break
+		}
+		if req.Server == "" || req.Version == "" {
+			return nil, fmt.Errorf("invalid requirement %q", part)
+		}
+		if _, err := parseServerVersion(req.Version); err != nil {
+			return nil, fmt.Errorf("invalid requirement %q: %w", part, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// Satisfied reports whether the server and version meet the requirement.
+// A different server never meets it.
+//
+// Parameters:
+//   - server: The lower-case server name.
+//   - version: The server version.
+//
+// Returns:
+//   - bool: Whether the requirement holds.
+//   - error: An error if version cannot be parsed.
+func (r Requirement) Satisfied(server, version string) (bool, error) {
+	if server != r.Server {
+		return false, nil
+	}
+	have, err := parseServerVersion(version)
+	if err != nil {
+		return false, err
+	}
+	want, err := parseServerVersion(r.Version)
+	if err != nil {
+		return false, err
+	}
+	c := compareServerVersions(have, want)
+	switch r.Op {
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	default:
+		return c == 0, nil
+	}
+}
+
+// parseServerVersion parses the leading dotted numbers of a version such as
+// "8.0.34-log" or "14.9 (Debian 14.9-1)".
+func parseServerVersion(version string) ([]int, error) {
+	field, _, _ := strings.Cut(strings.TrimSpace(version), " ")
+	var parts []int
+	for _, part := range strings.Split(field, ".") {
+		end := strings.IndexFunc(part, func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if end >= 0 {
+			part = part[:end]
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			if len(parts) > 0 {
+				break
+			}
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+		if end >= 0 {
+			break
+		}
+	}
+	return parts, nil
+}
+
+// compareServerVersions compares dotted versions, treating missing parts as
+// zero.
+func compareServerVersions(a, b []int) int {
+	for i := range max(len(a), len(b)) {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// WithUnmetRequirements returns a new Migrator handling migrations whose
+// "requires" annotation does not hold for the connected server with mode.
+//
+// Parameters:
+//   - mode: Whether to fail or skip such migrations.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithUnmetRequirements(mode UnmetRequirementMode) *Migrator {
+	new := *m
+	new.UnmetRequirements = mode
+	return &new
+}
+
+// checkRequirements reports whether the "requires" annotation of mig holds
+// for the connected server. The server version is queried once per run.
+func (m *Migrator) checkRequirements(
+	ctx context.Context, run *migrationRun, mig Migration,
+) (bool, error) {
+	value := mig.Annotations[AnnotationRequires]
+	if value == "" {
+		return true, nil
+	}
+	reqs, err := ParseRequirements(value)
+	if err != nil {
+		return false, fmt.Errorf("migration %s: %w", mig.Version, err)
+	}
+	if run.server == nil {
+		versioner, ok := m.HistoryManager.(ServerVersioner)
+		if !ok {
+			return false, fmt.Errorf(
+				"history manager %T cannot report the server version: %w",
+				m.HistoryManager, errors.ErrUnsupported,
+			)
+		}
+		server, version, err := versioner.ServerVersion(ctx, m.DB)
+		if err != nil {
+			m.logf("Error querying server version: %v", err)
+			return false, err
+		}
+		m.logf("Connected to %s %s", server, version)
+		run.server = &serverVersion{name: server, version: version}
+	}
+	for _, req := range reqs {
+		ok, err := req.Satisfied(run.server.name, run.server.version)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			continue
+		}
+		msg := fmt.Sprintf(
+			"migration %s (%s) requires %s, connected to %s %s",
+			mig.Version, mig.Name, req, run.server.name, run.server.version,
+		)
+		if m.UnmetRequirements == UnmetRequirementSkip {
+			if run.result != nil {
+				m.warn(run.result, Warning{
+					Code:    WarningRequirementNotMet,
+					Message: msg + "; skipped",
+					Version: mig.Version,
+				})
+			}
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s", ErrRequirementNotMet, msg)
+	}
+	return true, nil
+}
@@ -0,0 +1,145 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rerun re-executes the up steps of an applied migration, e.g. one applied
+// against wrong assumptions, and replaces its history record with a new
+// one. If withDown is set, the down steps run first, as when rolling the
+// migration back. The migration passes the same checks as in MigrateUp.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - version: The version of the migration to re-run.
+//   - withDown: Whether to run the down steps before the up steps.
+//
+// Returns:
+//   - error: An error if the migration is not applied or a step fails.
+func (m *Migrator) Rerun(ctx context.Context, version string, withDown bool) error {
+	_, err := m.RerunResult(ctx, version, withDown)
+	return err
+}
+
+// RerunResult re-executes an applied migration like Rerun and returns a
+// summary of the run.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - version: The version of the migration to re-run.
+//   - withDown: Whether to run the down steps before the up steps.
+//
+// Returns:
+//   - *Result: The summary of the run. It is returned even if the run
+//     fails.
+//   - error: An error if the migration is not applied or a step fails.
+func (m *Migrator) RerunResult(
+	ctx context.Context, version string, withDown bool,
+) (*Result, error) {
+	m.logf("Starting Rerun of migration %s", version)
+	result := m.startRun("up")
+	endRun, err := m.beginRun("up")
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	defer endRun()
+	if err := m.checkWritable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.RunPreflights(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureHistoryTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureAuditTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	if err := m.ensureStepTable(ctx); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	release, err := m.acquireLock(ctx, "up")
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	defer release()
+
+	all, applied, _, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	var matches []Migration
+	for _, mig := range all {
+		if mig.Version == version && applied.has(mig) {
+			matches = append(matches, mig)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return m.finishRun(ctx, result, fmt.Errorf(
+			"rerun: migration %s is not applied", version,
+		))
+	case 1:
+	default:
+		return m.finishRun(ctx, result, fmt.Errorf(
+			"rerun: version %s is applied under %d migration names",
+			version, len(matches),
+		))
+	}
+	mig := matches[0]
+	if err := mig.resolveAnnotations(); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	for _, check := range []func([]Migration) error{
+		m.validateSQL, m.verifySignatures, m.checkDestructive,
+	} {
+		if err := check([]Migration{mig}); err != nil {
+			return m.finishRun(ctx, result, err)
+		}
+	}
+	if err := m.checkPolicy(ctx, []Migration{mig}); err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	rerunner := m
+	if m.TxPerMigration {
+		// Roll back and reapply the migration in one transaction.
+		rerunner = m.WithTransactional(true).WithTxPerMigration(false)
+	}
+	runner, err := rerunner.withRunIsolation([]Migration{mig})
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+
+	_, err = runner.runMigrationsIfTransactional(
+		ctx,
+		func(run *migrationRun) (int, error) {
+			run.result = result
+			if withDown {
+				if err := m.rollbackAndRemoveMigration(ctx, run, mig); err != nil {
+					return 0, err
+				}
+			} else if err := m.HistoryManager.RemoveMigration(
+				ctx, run.history, m.HistoryTable, mig, mig.MigrationName,
+			); err != nil {
+				m.logf(
+					"Error removing migration record for %s: %v", mig.Version, err,
+				)
+				return 0, historyError(mig, "up", "history record removal", err)
+			}
+			applied.set(mig, false)
+			if err := m.executeAndRecordMigration(ctx, run, mig); err != nil {
+				return 0, err
+			}
+			applied.set(mig, true)
+			return 1, m.recordBatchedMigrations(ctx, run)
+		},
+	)
+	m.updateCache(applied, err)
+	if err != nil {
+		return m.finishRun(ctx, result, err)
+	}
+	m.logf("Rerun of migration %s complete", version)
+	m.runPostCommitHooks(ctx, result)
+	return m.finishRun(ctx, result, nil)
+}
@@ -0,0 +1,262 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result summarizes a migration run.
+type Result struct {
+	// Direction is "up" or "down".
+	Direction string
+	// StartedAt is when the run started.
+	StartedAt time.Time
+	// Duration is the wall time of the run.
+	Duration time.Duration
+	// Migrations lists the migrations executed by the run in order. When
+	// the run fails, it lists the migrations executed before the failure,
+	// which were rolled back if the run was transactional.
+	Migrations []MigrationResult
+	// Objects lists the code objects recreated by the run, in creation
+	// order (see WithObjectsDir).
+	Objects []string
+	// Warnings lists the non-fatal problems found during the run.
+	Warnings []Warning
+	// Err is the error that ended a failed run.
+	Err error
+
+	// started is the monotonic start time used to measure Duration.
+	started time.Time
+}
+
+// MigrationResult describes a migration executed by a run.
+type MigrationResult struct {
+	// Version is the version of the migration.
+	Version string
+	// Name is the name of the migration.
+	Name string
+	// MigrationName is the history namespace of the migration.
+	MigrationName string
+	// Description is the description annotation of the migration.
+	Description string
+	// Ticket is the ticket annotation of the migration.
+	Ticket string
+	// Checksum is the checksum of the migration content, if computed by
+	// its source.
+	Checksum string
+	// Duration is the time it took to execute the migration's steps.
+	Duration time.Duration
+	// RowsAffected holds the number of rows affected by each step, in step
+	// order. A step's count is -1 if the driver could not report it for one
+	// of its statements.
+	RowsAffected []int64
+	// SQLPreview is a single-line preview of the SQL executed by the
+	// steps, truncated to the SQL preview length. It is empty when
+	// previews are disabled or SQL is redacted.
+	SQLPreview string
+}
+
+// TotalRowsAffected returns the sum of the rows affected by the steps, or -1
+// if any step's count is unknown.
+//
+// Returns:
+//   - int64: The total number of rows affected.
+func (r MigrationResult) TotalRowsAffected() int64 {
+	var total int64
+	for _, n := range r.RowsAffected {
+		if n < 0 {
+			return -1
+		}
+		total += n
+	}
+	return total
+}
+
+// newResult returns an empty result of a run in direction starting at
+// startedAt.
+func newResult(direction string, startedAt time.Time) *Result {
+	return &Result{
+		Direction: direction,
+		StartedAt: startedAt,
+		started:   time.Now(),
+	}
+}
+
+// newMigrationResult returns the result entry of mig.
+func newMigrationResult(mig Migration) MigrationResult {
+	return MigrationResult{
+		Version:       mig.Version,
+		Name:          mig.Name,
+		MigrationName: mig.MigrationName,
+		Description:   mig.Description,
+		Ticket:        mig.Ticket,
+		Checksum:      mig.Checksum,
+	}
+}
+
+// WarningCode identifies the kind of a Warning.
+type WarningCode string
+
+const (
+	// WarningUnparseableFile reports a file with an allowed extension whose
+	// name does not match the filename format. The file was skipped.
+	WarningUnparseableFile WarningCode = "unparseable_file"
+	// WarningVersionGap reports a missing version between two sequentially
+	// numbered migrations.
+	WarningVersionGap WarningCode = "version_gap"
+	// WarningMissingDownSteps reports a migration that cannot be rolled
+	// back because it defines no down SQL.
+	WarningMissingDownSteps WarningCode = "missing_down_steps"
+	// WarningPostCommitHookFailed reports a post-commit hook that returned
+	// an error. The committed migrations are not affected.
+	WarningPostCommitHookFailed WarningCode = "post_commit_hook_failed"
+	// WarningNotifyFailed reports a notifier that could not deliver the
+	// notification of a run.
+	WarningNotifyFailed WarningCode = "notify_failed"
+	// WarningRequirementNotMet reports a migration skipped because its
+	// "requires" annotation does not hold for the connected server.
+	WarningRequirementNotMet WarningCode = "requirement_not_met"
+	// WarningExcluded reports a migration left alone because it is
+	// excluded from the run (see WithExclude).
+	WarningExcluded WarningCode = "excluded"
+	// WarningMaintenanceWindow reports pending migrations left for the
+	// next maintenance window (see WithMaintenanceWindow).
+	WarningMaintenanceWindow WarningCode = "maintenance_window"
+)
+
+// Warning is a non-fatal problem found while loading or running
+// migrations.
+type Warning struct {
+	// Code identifies the kind of the warning.
+	Code WarningCode
+	// Message describes the problem.
+	Message string
+	// Version is the migration version concerned, if any.
+	Version string
+	// File is the file concerned, if any.
+	File string
+}
+
+// String returns the warning message.
+func (w Warning) String() string {
+	return w.Message
+}
+
+// WarningReporter is implemented by migration sources that report
+// non-fatal problems found while loading.
+type WarningReporter interface {
+	// LoadMigrationsWithWarnings loads migrations like LoadMigrations and
+	// also returns the warnings found.
+	LoadMigrationsWithWarnings() ([]Migration, []Warning, error)
+}
+
+// startRun returns the result of a run in direction and emits
+// EventRunStarted, unless the run is part of a schema run.
+func (m *Migrator) startRun(direction string) *Result {
+	result := newResult(direction, nowUTC(m.NowFunc))
+	if !m.innerRun {
+		m.emit(Event{Type: EventRunStarted, Direction: direction})
+	}
+	return result
+}
+
+// finishRun completes the result of a run that ended with err, notifies
+// the notifiers and emits EventRunCompleted or EventRunFailed, unless the
+// run is part of a schema run.
+func (m *Migrator) finishRun(
+	ctx context.Context, result *Result, err error,
+) (*Result, error) {
+	result.Duration = time.Since(result.started)
+	result.Err = err
+	if m.innerRun {
+		return result, err
+	}
+	m.notify(ctx, result, err)
+	event := Event{
+		Type:      EventRunCompleted,
+		Direction: result.Direction,
+		Result:    result,
+	}
+	if err != nil {
+		event.Type = EventRunFailed
+		event.Err = err
+	}
+	m.emit(event)
+	return result, err
+}
+
+// warn records a warning in the result, logs it and emits it as an event.
+func (m *Migrator) warn(result *Result, warning Warning) {
+	m.logf("Warning: %s", warning.Message)
+	result.Warnings = append(result.Warnings, warning)
+	m.emit(Event{
+		Type:      EventWarning,
+		Direction: result.Direction,
+		Warning:   &warning,
+	})
+}
+
+// sequentialVersionLimit is the version below which versions are assumed to
+// be sequence numbers rather than timestamps. Gaps are only reported between
+// sequence numbers.
+const sequentialVersionLimit = 1_000_000
+
+// migrationWarnings returns the warnings about the sorted migrations all:
+// gaps between sequentially numbered versions of a migration name and
+// migrations without down SQL.
+func migrationWarnings(all []Migration) []Warning {
+	var warnings []Warning
+	last := make(map[string]int)
+	for _, mig := range all {
+		if v, err := strconv.Atoi(mig.Version); err == nil {
+			prev, seen := last[mig.MigrationName]
+			if seen && v-prev > 1 && v < sequentialVersionLimit {
+				warnings = append(warnings, Warning{
+					Code: WarningVersionGap,
+					Message: fmt.Sprintf(
+						"version gap before migration %s: %d version(s) "+
+							"missing after %d",
+						mig.Version, v-prev-1, prev,
+					),
+					Version: mig.Version,
+				})
+			}
+			last[mig.MigrationName] = v
+		}
+		if !hasDownSQL(mig) {
+			warnings = append(warnings, Warning{
+				Code: WarningMissingDownSteps,
+				Message: fmt.Sprintf(
+					"migration %s (%s) has no down steps and cannot be "+
+						"rolled back",
+					mig.Version, mig.Name,
+				),
+				Version: mig.Version,
+			})
+		}
+	}
+	return warnings
+}
+
+// hasDownSQL reports whether mig has a down step other than an empty SQL
+// step. File steps are assumed to hold SQL so that files are not read.
+func hasDownSQL(mig Migration) bool {
+	for _, step := range mig.DownSteps {
+		var sql string
+		switch s := step.(type) {
+		case *SQLMigrationStep:
+			sql = s.SQL
+		case SQLMigrationStep:
+			sql = s.SQL
+		default:
+			return true
+		}
+		if strings.TrimSpace(sql) != "" {
+			return true
+		}
+	}
+	return false
+}
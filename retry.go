@@ -0,0 +1,84 @@
+package migrator
+
+import (
+	"context"
+	"time"
+)
+
+// stepSavepoint is the name of the savepoint wrapping a retried step.
+const stepSavepoint = "migrator_step"
+
+// WithStepRetry returns a new Migrator that retries failed steps of
+// transactional runs. Every step runs inside a savepoint; when it fails with
+// a retryable error, only the step is rolled back to the savepoint and run
+// again, instead of abandoning the whole migration transaction. Postgres,
+// MySQL (InnoDB) and SQLite support savepoints. Non-transactional runs do
+// not retry steps.
+//
+// Parameters:
+//   - attempts: The maximum number of executions of a step, including the
+//     first one. Values below 2 disable retries.
+//   - delay: The pause before each retry.
+//   - retryable: Reports whether a step error is worth retrying, e.g. a
+//     serialization failure or lock timeout. Nil retries every error.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithStepRetry(
+	attempts int, delay time.Duration, retryable func(error) bool,
+) *Migrator {
+	new := *m
+	new.StepRetryAttempts = attempts
+	new.StepRetryDelay = delay
+	new.StepRetryable = retryable
+	return &new
+}
+
+// stepRetryEnabled reports whether failed steps are retried.
+func (m *Migrator) stepRetryEnabled() bool {
+	return m.migrationsTransactional() && m.StepRetryAttempts > 1
+}
+
+// retryStep calls execute, retrying it within a savepoint on tx when step
+// retries are enabled and the error is retryable. It returns the number of
+// failed executions that were retried.
+func (m *Migrator) retryStep(
+	ctx context.Context,
+	tx Executor,
+	mig Migration,
+	step int,
+	execute func() error,
+) (int, error) {
+	if !m.stepRetryEnabled() {
+		return 0, execute()
+	}
+	for attempt := 1; ; attempt++ {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+stepSavepoint); err != nil {
+			return attempt - 1, err
+		}
+		err := execute()
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+stepSavepoint)
+			return attempt - 1, err
+		}
+		if attempt >= m.StepRetryAttempts ||
+			(m.StepRetryable != nil && !m.StepRetryable(err)) {
+			return attempt - 1, err
+		}
+		if _, rbErr := tx.ExecContext(
+			ctx, "ROLLBACK TO SAVEPOINT "+stepSavepoint,
+		); rbErr != nil {
+			m.logf("Error rolling back to savepoint: %v", rbErr)
+			return attempt - 1, err
+		}
+		m.logf(
+			"Retrying step %d of migration %s after attempt %d failed: %v",
+			step, mig.Version, attempt, err,
+		)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(m.StepRetryDelay):
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// RunHookFn is a run-level hook called with the executor of the run and the
+// direction of the run, "up" or "down".
+type RunHookFn func(ctx context.Context, exec Executor, direction string) error
+
+// MigrationHookFn is a run-level hook called with the executor of the run,
+// the migration being applied or rolled back and the direction, "up" or
+// "down".
+type MigrationHookFn func(
+	ctx context.Context, exec Executor, mig Migration, direction string,
+) error
+
+// WithBeforeAll returns a new Migrator calling hooks before the first
+// migration a run applies or rolls back. Runs without migrations to execute
+// do not call them. Hooks execute in the run's transaction, if any, and a
+// failing hook fails the run.
+//
+// Parameters:
+//   - hooks: The hooks to call, in order.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithBeforeAll(hooks ...RunHookFn) *Migrator {
+	new := *m
+	new.BeforeAll = hooks
+	return &new
+}
+
+// WithAfterAll returns a new Migrator calling hooks after the last migration
+// a run applies or rolls back, before the run commits. Runs without
+// migrations to execute, and failed runs, do not call them. A failing hook
+// fails the run.
+//
+// Parameters:
+//   - hooks: The hooks to call, in order.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAfterAll(hooks ...RunHookFn) *Migrator {
+	new := *m
+	new.AfterAll = hooks
+	return &new
+}
+
+// WithBeforeEach returns a new Migrator calling hooks before every migration
+// it applies or rolls back. Hooks execute in the migration's transaction, if
+// any, and a failing hook fails the migration.
+//
+// Parameters:
+//   - hooks: The hooks to call, in order.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithBeforeEach(hooks ...MigrationHookFn) *Migrator {
+	new := *m
+	new.BeforeEach = hooks
+	return &new
+}
+
+// WithAfterEach returns a new Migrator calling hooks after every migration
+// it applies or rolls back, e.g. to refresh materialized views. Hooks
+// execute in the migration's transaction, if any, before it commits, and a
+// failing hook fails the migration.
+//
+// Parameters:
+//   - hooks: The hooks to call, in order.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithAfterEach(hooks ...MigrationHookFn) *Migrator {
+	new := *m
+	new.AfterEach = hooks
+	return &new
+}
+
+// runHooks calls the run-level hooks named stage with the executor of run.
+func (m *Migrator) runHooks(
+	ctx context.Context,
+	run *migrationRun,
+	stage string,
+	hooks []RunHookFn,
+	direction string,
+) error {
+	for idx, hook := range hooks {
+		if err := hook(ctx, run.exec, direction); err != nil {
+			m.logf("Error in %s hook %d: %v", stage, idx+1, err)
+			return fmt.Errorf("%s hook %d: %w", stage, idx+1, err)
+		}
+	}
+	return nil
+}
+
+// runMigrationHooks calls the migration hooks named stage for mig with the
+// executor of run.
+func (m *Migrator) runMigrationHooks(
+	ctx context.Context,
+	run *migrationRun,
+	stage string,
+	hooks []MigrationHookFn,
+	mig Migration,
+	direction string,
+) error {
+	for idx, hook := range hooks {
+		if err := hook(ctx, run.exec, mig, direction); err != nil {
+			m.logf(
+				"Error in %s hook %d of migration %s: %v",
+				stage, idx+1, mig.Version, err,
+			)
+			return fmt.Errorf(
+				"%s hook %d of migration %s: %w", stage, idx+1, mig.Version, err,
+			)
+		}
+	}
+	return nil
+}
+
+// executeWithHooks calls fn, which applies or rolls back mig, between the
+// before-each and after-each hooks.
+func (m *Migrator) executeWithHooks(
+	ctx context.Context,
+	run *migrationRun,
+	mig Migration,
+	direction string,
+	fn func() error,
+) error {
+	if err := m.runMigrationHooks(
+		ctx, run, "before-each", m.BeforeEach, mig, direction,
+	); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return m.runMigrationHooks(
+		ctx, run, "after-each", m.AfterEach, mig, direction,
+	)
+}
@@ -0,0 +1,184 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SchemaProvider returns the schemas, or MySQL databases, that the
+// migrations of a Migrator are run against, such as the schemas of the
+// tenants of an application.
+type SchemaProvider func(ctx context.Context) ([]string, error)
+
+// schemaSeparator joins a migration name and a schema into the migration
+// name the history of the schema is recorded under, e.g. "app@tenant_a".
+const schemaSeparator = "@"
+
+// WithSchemas returns a new Migrator that runs its migrations against each
+// of the given schemas in turn, see WithSchemaProvider.
+//
+// Parameters:
+//   - schemas: The schema names, in the order they are migrated.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSchemas(schemas []string) *Migrator {
+	schemas = slices.Clone(schemas)
+	return m.WithSchemaProvider(
+		func(context.Context) ([]string, error) { return schemas, nil },
+	)
+}
+
+// WithSchemaProvider returns a new Migrator whose MigrateUp, MigrateDown and
+// MigrateTo run the same migrations against every schema returned by
+// provider, one schema after the other, instead of one runner per tenant.
+// Every schema run sets the search_path to the schema, followed by the
+// Migrator's search_path if any, or selects the schema as the current
+// database with a MySQL history manager. The history of each schema is
+// recorded under its own migration name, the Migrator's migration name
+// followed by "@" and the schema, so the history table must be
+// schema-qualified, e.g. "public.schema_migrations", to be found regardless
+// of the current schema. A history table with the legacy primary key is
+// refused before any schema is migrated, see HistoryKeyReader. A failing
+// schema stops the run; the schemas before it stay migrated. MigrateUp and
+// MigrateDown notify the notifiers and emit the run events once, with the
+// combined result of all schemas; MigrateTo, which may run both, does so
+// per schema and direction. Run hooks such as WithBeforeAll run per schema.
+// Use ForEachSchema for other operations.
+//
+// Parameters:
+//   - provider: The provider of the schema names.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSchemaProvider(provider SchemaProvider) *Migrator {
+	new := *m
+	new.SchemaProvider = provider
+	return &new
+}
+
+// ForEachSchema calls fn for every schema of the SchemaProvider in order,
+// with a Migrator set up to run against the schema, e.g. to check the
+// Status or to Baseline every tenant.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - fn: The function called per schema.
+//
+// Returns:
+//   - error: An error if the schemas cannot be listed or fn fails for a
+//     schema, which stops the iteration.
+func (m *Migrator) ForEachSchema(
+	ctx context.Context,
+	fn func(ctx context.Context, schema string, sm *Migrator) error,
+) error {
+	schemas, err := m.schemas(ctx)
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("before schema %s: %w", schema, err)
+		}
+		m.logf("Running schema %s", schema)
+		if err := fn(ctx, schema, m.forSchema(schema)); err != nil {
+			return fmt.Errorf("schema %s: %w", schema, err)
+		}
+	}
+	return nil
+}
+
+// schemas returns the schemas of the SchemaProvider.
+func (m *Migrator) schemas(ctx context.Context) ([]string, error) {
+	if m.SchemaProvider == nil {
+		return nil, errors.New("no schema provider configured")
+	}
+	parts, err := splitQualifiedName(m.HistoryTable)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 2 {
+		return nil, fmt.Errorf(
+			"schema runs need a schema-qualified history table, got %q",
+			m.HistoryTable,
+		)
+	}
+	if err := m.checkHistoryKey(ctx); err != nil {
+		return nil, err
+	}
+	schemas, err := m.SchemaProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("schema provider: %w", err)
+	}
+	seen := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		if strings.TrimSpace(schema) == "" {
+			return nil, errors.New("schema provider: empty schema name")
+		}
+		if seen[schema] {
+			return nil, fmt.Errorf("schema provider: duplicate schema %s", schema)
+		}
+		seen[schema] = true
+	}
+	return schemas, nil
+}
+
+// forSchema returns a copy of the Migrator that runs against schema and
+// records its history under the migration names of the schema.
+func (m *Migrator) forSchema(schema string) *Migrator {
+	new := *m
+	new.SchemaProvider = nil
+	new.schema = schema
+	new.MigrationName = schemaMigrationName(m.MigrationName, schema)
+	new.Partitions = slices.Clone(m.Partitions)
+	for i := range new.Partitions {
+		new.Partitions[i].MigrationName = schemaMigrationName(
+			m.Partitions[i].MigrationName, schema,
+		)
+	}
+	if historyDialect(m.HistoryManager) == DialectMySQL {
+		new.SessionSetup = append(
+			[]string{"USE " + quoteIdentifier(schema, '`')}, m.SessionSetup...,
+		)
+		return &new
+	}
+	new.SearchPath = quoteIdentifier(schema, '"')
+	if m.SearchPath != "" {
+		new.SearchPath += ", " + m.SearchPath
+	}
+	return &new
+}
+
+// schemaMigrationName returns the migration name the history of
+// migrationName is recorded under in schema.
+func schemaMigrationName(migrationName string, schema string) string {
+	return migrationName + schemaSeparator + schema
+}
+
+// schemaRunResult runs run for every schema of the SchemaProvider and
+// returns the combined result of the runs, which is notified and emitted
+// once for all schemas.
+func (m *Migrator) schemaRunResult(
+	ctx context.Context,
+	direction string,
+	run func(ctx context.Context, sm *Migrator) (*Result, error),
+) (*Result, error) {
+	result := m.startRun(direction)
+	err := m.ForEachSchema(
+		ctx,
+		func(ctx context.Context, _ string, sm *Migrator) error {
+			sm.innerRun = true
+			sub, err := run(ctx, sm)
+			if sub != nil {
+				result.Migrations = append(result.Migrations, sub.Migrations...)
+				result.Objects = append(result.Objects, sub.Objects...)
+				result.Warnings = append(result.Warnings, sub.Warnings...)
+			}
+			return err
+		},
+	)
+	return m.finishRun(ctx, result, err)
+}
@@ -0,0 +1,105 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SchemaPlaceholder is replaced with the schema name in every statement
+// executed by a SchemaMigrationStep.
+const SchemaPlaceholder = "{{schema}}"
+
+// SchemaMigrationStep executes a wrapped step once per schema. Every
+// occurrence of SchemaPlaceholder in the statements executed by the wrapped
+// step is replaced with the current schema name, which keeps identical
+// structures in a fixed set of schemas in sync.
+type SchemaMigrationStep struct {
+	Step    MigrationStep
+	Schemas []string
+}
+
+// ForSchemas returns a new SchemaMigrationStep executing step once for each
+// of the given schemas.
+//
+// Parameters:
+//   - step: The step to execute per schema.
+//   - schemas: The schema names, in the order the step is applied.
+//
+// Returns:
+//   - *SchemaMigrationStep: A new SchemaMigrationStep.
+func ForSchemas(step MigrationStep, schemas []string) *SchemaMigrationStep {
+	return &SchemaMigrationStep{
+		Step:    step,
+		Schemas: schemas,
+	}
+}
+
+// ExecuteUp executes the wrapped step's up direction for every schema in
+// order.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the step fails for any schema.
+func (s SchemaMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	for _, schema := range s.Schemas {
+		if err := s.Step.ExecuteUp(ctx, newSchemaExecutor(exec, schema)); err != nil {
+			return fmt.Errorf("schema %s: %w", schema, err)
+		}
+		contextLogger(ctx).Printf("Executed up step for schema %s", schema)
+	}
+	return nil
+}
+
+// ExecuteDown executes the wrapped step's down direction for every schema in
+// reverse order.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the step fails for any schema.
+func (s SchemaMigrationStep) ExecuteDown(
+	ctx context.Context, exec Executor,
+) error {
+	for _, schema := range slices.Backward(s.Schemas) {
+		err := s.Step.ExecuteDown(ctx, newSchemaExecutor(exec, schema))
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", schema, err)
+		}
+		contextLogger(ctx).Printf("Executed down step for schema %s", schema)
+	}
+	return nil
+}
+
+// schemaExecutor substitutes SchemaPlaceholder in queries with a schema
+// name before executing them.
+type schemaExecutor struct {
+	exec   Executor
+	schema string
+}
+
+// newSchemaExecutor returns an executor substituting schema in the
+// statements and queries run on exec.
+func newSchemaExecutor(exec Executor, schema string) Executor {
+	e := schemaExecutor{exec, schema}
+	return withQueryer(e, exec, e.substitute)
+}
+
+// ExecContext executes the query with the schema substituted.
+func (e schemaExecutor) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	return e.exec.ExecContext(ctx, e.substitute(query), args...)
+}
+
+// substitute replaces SchemaPlaceholder in query with the schema name.
+func (e schemaExecutor) substitute(query string) string {
+	return strings.ReplaceAll(query, SchemaPlaceholder, e.schema)
+}
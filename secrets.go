@@ -0,0 +1,353 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretMask replaces secret values in recorded SQL.
+const secretMask = "[secret]"
+
+// SecretProvider resolves secrets by name, for migrations that create
+// roles or foreign servers and must not hard-code credentials. Template
+// steps read secrets with {{ secret "name" }}.
+type SecretProvider interface {
+	// Secret returns the value of the named secret.
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// SecretProviderFunc is an adapter to use an ordinary function as a
+// SecretProvider.
+type SecretProviderFunc func(ctx context.Context, name string) (string, error)
+
+// Secret calls f(ctx, name).
+func (f SecretProviderFunc) Secret(
+	ctx context.Context, name string,
+) (string, error) {
+	return f(ctx, name)
+}
+
+// WithSecretProvider returns a new Migrator whose template steps resolve
+// {{ secret "name" }} with provider. Resolved values are masked in the SQL
+// recorded for errors, previews and the audit table.
+//
+// Parameters:
+//   - provider: The provider of secrets.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSecretProvider(provider SecretProvider) *Migrator {
+	new := *m
+	new.SecretProvider = provider
+	return &new
+}
+
+// secretsKey is the context key of the secrets of a migration execution.
+type secretsKey struct{}
+
+// stepSecrets resolves the secrets of one migration execution and
+// remembers their values to mask them.
+type stepSecrets struct {
+	provider SecretProvider
+	mu       sync.Mutex
+	values   []string
+}
+
+// withSecrets returns ctx carrying the secrets of a migration execution
+// resolved with provider.
+func withSecrets(ctx context.Context, provider SecretProvider) context.Context {
+	return context.WithValue(ctx, secretsKey{}, &stepSecrets{provider: provider})
+}
+
+// resolveSecret returns the value of the named secret using the provider
+// of ctx and remembers it for masking.
+func resolveSecret(ctx context.Context, name string) (string, error) {
+	s, _ := ctx.Value(secretsKey{}).(*stepSecrets)
+	if s == nil || s.provider == nil {
+		return "", fmt.Errorf("secret %q: no secret provider configured", name)
+	}
+	value, err := s.provider.Secret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", name, err)
+	}
+	if value != "" {
+		s.mu.Lock()
+		s.values = append(s.values, value)
+		s.mu.Unlock()
+	}
+	return value, nil
+}
+
+// maskSecrets returns query with the secret values resolved in ctx
+// replaced by a mask.
+func maskSecrets(ctx context.Context, query string) string {
+	s, _ := ctx.Value(secretsKey{}).(*stepSecrets)
+	if s == nil {
+		return query
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, value := range s.values {
+		query = strings.ReplaceAll(query, value, secretMask)
+	}
+	return query
+}
+
+// splitSecretName splits name at its last separator into the path of a
+// secret and the field within it.
+func splitSecretName(name string, sep string) (string, string) {
+	idx := strings.LastIndex(name, sep)
+	if idx < 0 {
+		return name, ""
+	}
+	return name[:idx], name[idx+len(sep):]
+}
+
+// VaultSecretProvider reads secrets from a HashiCorp Vault KV version 2
+// secrets engine. The last segment of a secret name is the field, the rest
+// the path: "db/reporting_password" reads field "reporting_password" of
+// the secret at "db".
+type VaultSecretProvider struct {
+	Addr   string
+	Token  string
+	Mount  string
+	Client *http.Client
+}
+
+// NewVaultSecretProvider returns a new VaultSecretProvider of the KV
+// engine mounted at "secret".
+//
+// Parameters:
+//   - addr: The address of Vault, e.g. "https://vault:8200".
+//   - token: The Vault token.
+//
+// Returns:
+//   - *VaultSecretProvider: A new VaultSecretProvider.
+func NewVaultSecretProvider(addr, token string) *VaultSecretProvider {
+	return &VaultSecretProvider{Addr: addr, Token: token, Mount: "secret"}
+}
+
+// WithMount returns a new VaultSecretProvider reading from the KV engine
+// mounted at mount.
+//
+// Parameters:
+//   - mount: The mount path of the KV engine.
+//
+// Returns:
+//   - *VaultSecretProvider: A new VaultSecretProvider.
+func (v *VaultSecretProvider) WithMount(mount string) *VaultSecretProvider {
+	new := *v
+	new.Mount = mount
+	return &new
+}
+
+// Secret reads the named secret.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - name: The secret name, "path/field".
+//
+// Returns:
+//   - string: The value of the field.
+//   - error: An error if the request fails or the field does not exist.
+func (v VaultSecretProvider) Secret(
+	ctx context.Context, name string,
+) (string, error) {
+	secretPath, field := splitSecretName(name, "/")
+	if field == "" || secretPath == name {
+		return "", fmt.Errorf("secret name %q has no field", name)
+	}
+	url := fmt.Sprintf(
+		"%s/v1/%s/data/%s",
+		strings.TrimSuffix(v.Addr, "/"), strings.Trim(v.Mount, "/"), secretPath,
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := doJSONRequest(v.Client, req, &body); err != nil {
+		return "", err
+	}
+	value, ok := body.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// AWSSecretsManagerProvider reads secrets from AWS Secrets Manager. A
+// secret name is a secret ID, optionally followed by "#field" to read a
+// field of a JSON secret: "prod/reporting#password".
+type AWSSecretsManagerProvider struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the regional endpoint, e.g. for VPC endpoints.
+	Endpoint string
+	Client   *http.Client
+	// NowFunc returns the time requests are signed at. Nil uses time.Now.
+	NowFunc func() time.Time
+}
+
+// NewAWSSecretsManagerProvider returns a new AWSSecretsManagerProvider
+// using the credentials of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables.
+//
+// Parameters:
+//   - region: The AWS region, e.g. "eu-west-1".
+//
+// Returns:
+//   - *AWSSecretsManagerProvider: A new AWSSecretsManagerProvider.
+func NewAWSSecretsManagerProvider(region string) *AWSSecretsManagerProvider {
+	return &AWSSecretsManagerProvider{
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Secret reads the named secret with GetSecretValue.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - name: The secret name, "id" or "id#field".
+//
+// Returns:
+//   - string: The secret string, or the field of a JSON secret.
+//   - error: An error if the request fails or the field does not exist.
+func (a AWSSecretsManagerProvider) Secret(
+	ctx context.Context, name string,
+) (string, error) {
+	id, field := splitSecretName(name, "#")
+	payload, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", a.Region)
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, endpoint+"/", bytes.NewReader(payload),
+	)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	a.sign(req, payload, nowUTC(a.NowFunc))
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := doJSONRequest(a.Client, req, &body); err != nil {
+		return "", err
+	}
+	if field == "" {
+		return body.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(body.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", id, err)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// sign adds an AWS Signature Version 4 to req.
+func (a AWSSecretsManagerProvider) sign(
+	req *http.Request, payload []byte, now time.Time,
+) {
+	const service = "secretsmanager"
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if a.SessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", h, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(headers, ";")
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, a.Region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + a.SecretAccessKey)
+	for _, part := range []string{date, a.Region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// doJSONRequest sends req and decodes the JSON response into out.
+func doJSONRequest(client *http.Client, req *http.Request, out any) error {
+	if client == nil {
+		client = &http.Client{Timeout: httpTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
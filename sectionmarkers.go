@@ -0,0 +1,85 @@
+package migrator
+
+import (
+	"strings"
+)
+
+// fileSection is an SQL block of a migration file.
+type fileSection struct {
+	sql string
+	// noSplit reports that the block holds a "-- +migrate StatementBegin"
+	// marker, so it is executed in one call.
+	noSplit bool
+}
+
+// parseFileSections splits the content of a migration file into its up and
+// down blocks. Files with "-- +migrate Up" and "-- +migrate Down" markers,
+// as written for sql-migrate, yield a block for every marked section, and
+// text before the first marker is ignored. Other files are split at the
+// first "-- DOWN" into one up and one down block, ignoring text after a
+// second one.
+func parseFileSections(content string) (up []fileSection, down []fileSection) {
+	var (
+		current *[]fileSection
+		block   strings.Builder
+		noSplit bool
+		marked  bool
+	)
+	flush := func() {
+		if current != nil {
+			if sql := strings.TrimSpace(block.String()); sql != "" {
+				*current = append(
+					*current, fileSection{sql: sql, noSplit: noSplit},
+				)
+			}
+		}
+		block.Reset()
+		noSplit = false
+	}
+	for line := range strings.Lines(content) {
+		switch migrateMarker(line) {
+		case "up":
+			flush()
+			current, marked = &up, true
+			continue
+		case "down":
+			flush()
+			current, marked = &down, true
+			continue
+		case "statementbegin":
+			noSplit = true
+		}
+		block.WriteString(line)
+	}
+	flush()
+
+	if !marked {
+		parts := strings.Split(content, "-- DOWN")
+		up = []fileSection{{sql: strings.TrimSpace(parts[0])}}
+		down = []fileSection{{}}
+		if len(parts) > 1 {
+			down[0].sql = strings.TrimSpace(parts[1])
+		}
+		return up, down
+	}
+	if len(up) == 0 {
+		up = []fileSection{{}}
+	}
+	if len(down) == 0 {
+		down = []fileSection{{}}
+	}
+	return up, down
+}
+
+// migrateMarker returns the lower-cased directive of a "-- +migrate" marker
+// line, such as "up", "down" or "statementbegin", or "" if line is not a
+// marker. Options following the directive, such as "notransaction", are
+// ignored.
+func migrateMarker(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "--" ||
+		!strings.EqualFold(fields[1], "+migrate") {
+		return ""
+	}
+	return strings.ToLower(fields[2])
+}
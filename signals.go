@@ -0,0 +1,92 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes returned by RunWithSignals.
+const (
+	// ExitCodeSuccess reports that all migrations were applied.
+	ExitCodeSuccess = 0
+	// ExitCodeFailure reports a failed run.
+	ExitCodeFailure = 1
+	// ExitCodeStopped reports a run stopped by a signal before all
+	// migrations were applied, leaving a clean state to resume from. It is
+	// EX_TEMPFAIL of sysexits.h.
+	ExitCodeStopped = 75
+)
+
+// RunWithSignals applies migrations like MigrateUp while trapping SIGTERM
+// and SIGINT, so that orchestrators stopping a deployment do not leave a
+// half-applied migration behind. On the first signal, the current
+// migration is finished and recorded and the run ends before the next one.
+// A second signal cancels the run's context, aborting the running
+// statement; transactional runs roll back. The returned exit code is meant
+// for os.Exit:
+//
+//	os.Exit(m.RunWithSignals(ctx, ""))
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//   - target: The migration version at which to stop (empty means apply
+//     all).
+//
+// Returns:
+//   - int: ExitCodeSuccess, ExitCodeStopped or ExitCodeFailure.
+func (m *Migrator) RunWithSignals(ctx context.Context, target string) int {
+	control := m.RunControl
+	if control == nil {
+		control = NewRunControl()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		for received := 0; ; received++ {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if received == 0 {
+					m.logf(
+						"Received %s, stopping after the current migration", sig,
+					)
+					control.Stop()
+					continue
+				}
+				m.logf("Received %s again, aborting the run", sig)
+				cancel()
+				return
+			}
+		}
+	}()
+	_, err := m.WithRunControl(control).MigrateUpResult(ctx, target)
+	return ExitCode(err)
+}
+
+// ExitCode returns the process exit code reporting the outcome of a run
+// that ended with err.
+//
+// Parameters:
+//   - err: The error of the run.
+//
+// Returns:
+//   - int: ExitCodeSuccess if err is nil, ExitCodeStopped if the run was
+//     stopped or canceled through its RunControl, ExitCodeFailure
+//     otherwise.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitCodeSuccess
+	case errors.Is(err, ErrRunStopped), errors.Is(err, ErrRunCanceled):
+		return ExitCodeStopped
+	default:
+		return ExitCodeFailure
+	}
+}
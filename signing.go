@@ -0,0 +1,307 @@
+package migrator
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignatureExt is appended to the path of a SQL file to find its detached
+// signature.
+const SignatureExt = ".sig"
+
+// ErrInvalidSignature is returned when a migration or manifest lacks a
+// valid signature by one of the configured keys.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ParseSignatureKey parses a base64 encoded Ed25519 public key.
+//
+// Parameters:
+//   - s: The encoded key.
+//
+// Returns:
+//   - ed25519.PublicKey: The key.
+//   - error: An error if s is not a base64 encoded Ed25519 public key.
+func ParseSignatureKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decode signature key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf(
+			"signature key has %d bytes, want %d", len(key), ed25519.PublicKeySize,
+		)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Sign returns the detached signature of data: its base64 encoded Ed25519
+// signature followed by a newline, the format of signature files.
+//
+// Parameters:
+//   - key: The private key to sign with.
+//   - data: The data to sign.
+//
+// Returns:
+//   - []byte: The signature.
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return []byte(sig + "\n")
+}
+
+// SignFile writes the detached signature of the file at path to path with
+// SignatureExt appended.
+//
+// Parameters:
+//   - key: The private key to sign with.
+//   - path: The path of the file to sign.
+//
+// Returns:
+//   - error: An error if reading the file or writing the signature fails.
+func SignFile(key ed25519.PrivateKey, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+SignatureExt, Sign(key, data), 0o644)
+}
+
+// WithSignatureKeys returns a new Migrator that refuses to execute a
+// migration unless its SQL is signed by one of keys, for environments that
+// require signed change scripts. Without a signed manifest, every SQL file
+// of a migration needs a detached signature in a file next to it with
+// SignatureExt appended (see SignFile), and migrations with SQL defined in
+// code are rejected. With a signed manifest (see WithSignedManifest), the
+// manifest is verified instead and every migration must match its entry.
+// Steps wrapped by ForSchemas are verified like the wrapped step. Hook
+// steps are part of the program and are not verified; every other step
+// whose SQL is not covered by a signature, such as a template or partition
+// step, is rejected. The verified file content is what is executed, so a
+// file replaced after the verification is never run.
+//
+// Parameters:
+//   - keys: The trusted Ed25519 public keys.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSignatureKeys(keys ...ed25519.PublicKey) *Migrator {
+	new := *m
+	new.SignatureKeys = keys
+	return &new
+}
+
+// WithSignedManifest returns a new Migrator that verifies migrations
+// against manifest, the JSON encoding of a Manifest, instead of per-file
+// signatures. The signature, created with Sign, must be valid for one of
+// the keys set with WithSignatureKeys.
+//
+// Parameters:
+//   - manifest: The JSON encoded manifest.
+//   - signature: The detached signature of manifest.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSignedManifest(manifest, signature []byte) *Migrator {
+	new := *m
+	new.SignedManifest = manifest
+	new.ManifestSignature = signature
+	return &new
+}
+
+// verifySignatures checks the signatures of migs before they are executed.
+func (m *Migrator) verifySignatures(migs []Migration) error {
+	if len(m.SignatureKeys) == 0 || len(migs) == 0 {
+		return nil
+	}
+	entries, err := m.signedManifestEntries()
+	if err != nil {
+		return err
+	}
+	for _, mig := range migs {
+		if _, err := m.verifyMigration(mig, entries); err != nil {
+			return err
+		}
+	}
+	m.logf("Verified signatures of %d migrations", len(migs))
+	return nil
+}
+
+// verifiedMigration returns mig with its SQL files replaced by SQL steps
+// holding the content that was verified, so that a file changed after the
+// verification is not executed. It returns mig unchanged if no signature
+// keys are set.
+func (m *Migrator) verifiedMigration(mig Migration) (Migration, error) {
+	if len(m.SignatureKeys) == 0 {
+		return mig, nil
+	}
+	entries, err := m.signedManifestEntries()
+	if err != nil {
+		return Migration{}, err
+	}
+	return m.verifyMigration(mig, entries)
+}
+
+// verifyMigration verifies mig against the manifest entries, or the
+// signatures of its files if entries is nil, and returns it with its SQL
+// files replaced by the verified content.
+func (m *Migrator) verifyMigration(
+	mig Migration, entries map[string]ManifestEntry,
+) (Migration, error) {
+	verified, err := m.readSignedSteps(mig, entries == nil)
+	if err == nil && entries != nil {
+		err = verifyManifestEntry(verified, entries)
+	}
+	if err != nil {
+		err = fmt.Errorf("migration %s (%s): %w", mig.Version, mig.Name, err)
+		m.logf("Signature verification failed: %v", err)
+		return Migration{}, err
+	}
+	return verified, nil
+}
+
+// signedManifestEntries verifies the signed manifest and returns its
+// entries keyed by migration name and version, or nil if no manifest is
+// set.
+func (m *Migrator) signedManifestEntries() (map[string]ManifestEntry, error) {
+	if m.SignedManifest == nil {
+		return nil, nil
+	}
+	if !m.verifySignature(m.SignedManifest, m.ManifestSignature) {
+		err := fmt.Errorf("manifest: %w", ErrInvalidSignature)
+		m.logf("Signature verification failed: %v", err)
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(m.SignedManifest, &manifest); err != nil {
+		err = fmt.Errorf("decode manifest: %w", err)
+		m.logf("Signature verification failed: %v", err)
+		return nil, err
+	}
+	entries := make(map[string]ManifestEntry, len(manifest.Migrations))
+	for _, entry := range manifest.Migrations {
+		entries[entry.MigrationName+"\x00"+entry.Version] = entry
+	}
+	return entries, nil
+}
+
+// verifyManifestEntry checks that mig, whose SQL files were replaced by
+// the verified content, matches its entry in the manifest. The checksum is
+// always computed from the steps that are executed; a checksum reported by
+// the source is not trusted.
+func verifyManifestEntry(
+	mig Migration, entries map[string]ManifestEntry,
+) error {
+	signed, ok := entries[mig.MigrationName+"\x00"+mig.Version]
+	if !ok {
+		return fmt.Errorf("not in the signed manifest: %w", ErrInvalidSignature)
+	}
+	entry, err := newManifestEntry(mig)
+	if err != nil {
+		return err
+	}
+	if entry.Checksum != signed.Checksum {
+		return fmt.Errorf(
+			"checksum differs from the signed manifest: %w", ErrInvalidSignature,
+		)
+	}
+	return nil
+}
+
+// readSignedSteps returns mig with every SQL file step replaced by a SQL
+// step holding the file content. With perFile, the
+// detached signature of every file is verified and SQL defined in code is
+// rejected; otherwise the manifest covers SQL defined in code. Hook steps
+// are part of the program and are kept. Steps whose SQL neither a file
+// signature nor the manifest covers are rejected.
+func (m *Migrator) readSignedSteps(
+	mig Migration, perFile bool,
+) (Migration, error) {
+	for _, direction := range []string{"up", "down"} {
+		steps := &mig.UpSteps
+		if direction == "down" {
+			steps = &mig.DownSteps
+		}
+		signed := make([]MigrationStep, len(*steps))
+		for idx, step := range *steps {
+			var err error
+			signed[idx], err = m.signedStep(step, perFile)
+			if err != nil {
+				return Migration{}, err
+			}
+		}
+		*steps = signed
+	}
+	return mig, nil
+}
+
+// signedStep returns step with its SQL file replaced by the verified
+// content. Steps wrapped by ForSchemas are verified like the wrapped step.
+func (m *Migrator) signedStep(
+	step MigrationStep, perFile bool,
+) (MigrationStep, error) {
+	switch s := step.(type) {
+	case HookMigrationStep, *HookMigrationStep,
+		TxHookMigrationStep, *TxHookMigrationStep:
+		return step, nil
+	case SchemaMigrationStep:
+		inner, err := m.signedStep(s.Step, perFile)
+		s.Step = inner
+		return s, err
+	case *SchemaMigrationStep:
+		wrapped := *s
+		inner, err := m.signedStep(s.Step, perFile)
+		wrapped.Step = inner
+		return &wrapped, err
+	}
+	f, ok := stepFile(step)
+	if !ok {
+		if _, isSQL := step.(sqlStep); isSQL && !perFile {
+			return step, nil
+		}
+		if perFile {
+			return nil, fmt.Errorf(
+				"SQL defined in code cannot carry a signature: %w",
+				ErrInvalidSignature,
+			)
+		}
+		return nil, fmt.Errorf(
+			"%T steps cannot be verified: %w", step, ErrInvalidSignature,
+		)
+	}
+	data, err := f.readFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if perFile {
+		sig, err := f.readFile(f.Path + SignatureExt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w: %w", f.Path, ErrInvalidSignature, err)
+		}
+		if !m.verifySignature(data, sig) {
+			return nil, fmt.Errorf("%s: %w", f.Path, ErrInvalidSignature)
+		}
+	}
+	return &SQLMigrationStep{SQL: string(data), NoSplit: f.NoSplit}, nil
+}
+
+// verifySignature reports whether sig is a valid signature of data by one
+// of the configured keys.
+func (m *Migrator) verifySignature(data, sig []byte) bool {
+	decoded, err := base64.StdEncoding.DecodeString(
+		string(bytes.TrimSpace(sig)),
+	)
+	if err != nil {
+		return false
+	}
+	for _, key := range m.SignatureKeys {
+		if ed25519.Verify(key, data, decoded) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,392 @@
+package migrator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// DefaultMaxStatementSize is the default maximum size in bytes of a single
+// statement read by a StatementScanner.
+const DefaultMaxStatementSize = 16 << 20
+
+// ErrStatementTooLarge is returned when a statement exceeds the maximum
+// statement size of a StatementScanner.
+var ErrStatementTooLarge = errors.New("statement exceeds maximum size")
+
+// scanState is the lexical state of a StatementScanner.
+type scanState int
+
+const (
+	stateNormal scanState = iota
+	stateSingleQuote
+	stateDoubleQuote
+	stateBacktick
+	stateLineComment
+	stateBlockComment
+	stateDollarQuote
+)
+
+// StatementScanner splits SQL read from an io.Reader into individual
+// statements separated by semicolons. Semicolons inside quoted strings,
+// quoted identifiers, comments and dollar-quoted bodies do not end a
+// statement. The input is tokenized as a stream, so only the statement being
+// scanned is held in memory.
+type StatementScanner struct {
+	// MaxStatementSize is the maximum size in bytes of one statement.
+	MaxStatementSize int
+	// BackslashEscapes treats a backslash inside a single-quoted string as an
+	// escape character, as MySQL does by default.
+	BackslashEscapes bool
+
+	r          *bufio.Reader
+	buf        strings.Builder
+	pending    rune
+	hasPending bool
+	state      scanState
+	depth      int
+	tag        string
+	hasContent bool
+	last       rune
+	before     rune
+	line       int
+	stmtLine   int
+	stmt       string
+	stmtStart  int
+	err        error
+}
+
+// NewStatementScanner returns a new StatementScanner reading from r.
+//
+// Parameters:
+//   - r: The reader to read SQL from.
+//
+// Returns:
+//   - *StatementScanner: A new StatementScanner.
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	return &StatementScanner{
+		MaxStatementSize: DefaultMaxStatementSize,
+		BackslashEscapes: true,
+		r:                bufio.NewReader(r),
+		line:             1,
+	}
+}
+
+// Scan advances the scanner to the next statement, which is then available
+// through the Statement method. It returns false when the input is exhausted
+// or an error occurred.
+//
+// Returns:
+//   - bool: Whether a statement is available.
+func (s *StatementScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.buf.Reset()
+	s.state = stateNormal
+	s.hasContent = false
+	s.stmtLine = 0
+	s.last, s.before = 0, 0
+	for {
+		r, err := s.next()
+		if err == io.EOF {
+			if s.state != stateNormal && s.state != stateLineComment {
+				s.err = fmt.Errorf(
+					"unterminated %s starting in statement at line %d",
+					s.state, s.stmtLine,
+				)
+				return false
+			}
+			return s.emit()
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		if s.state == stateNormal && r == ';' {
+			if s.emit() {
+				return true
+			}
+			continue
+		}
+		if err := s.write(r); err != nil {
+			s.err = err
+			return false
+		}
+		s.advance(r)
+		if s.err != nil {
+			return false
+		}
+	}
+}
+
+// Statement returns the most recent statement found by Scan, without the
+// terminating semicolon and surrounding whitespace.
+//
+// Returns:
+//   - string: The current statement.
+func (s *StatementScanner) Statement() string {
+	return s.stmt
+}
+
+// Line returns the line on which the most recent statement starts.
+//
+// Returns:
+//   - int: The 1-based line number.
+func (s *StatementScanner) Line() int {
+	return s.stmtStart
+}
+
+// Err returns the first error encountered by the scanner.
+//
+// Returns:
+//   - error: The error, or nil if the input was scanned successfully.
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+// SplitStatements splits the given SQL into statements.
+//
+// Parameters:
+//   - sql: The SQL to split.
+//
+// Returns:
+//   - []string: The statements.
+//   - error: An error if the SQL contains an unterminated token.
+func SplitStatements(sql string) ([]string, error) {
+	var stmts []string
+	scanner := NewStatementScanner(strings.NewReader(sql))
+	scanner.MaxStatementSize = len(sql) + 1
+	for scanner.Scan() {
+		stmts = append(stmts, scanner.Statement())
+	}
+	return stmts, scanner.Err()
+}
+
+// String returns a human readable name of the state.
+func (st scanState) String() string {
+	switch st {
+	case stateSingleQuote:
+		return "string literal"
+	case stateDoubleQuote, stateBacktick:
+		return "quoted identifier"
+	case stateBlockComment:
+		return "block comment"
+	case stateDollarQuote:
+		return "dollar-quoted string"
+	default:
+		return "statement"
+	}
+}
+
+// emit stores the buffered statement if it has content. It returns whether a
+// statement was stored.
+func (s *StatementScanner) emit() bool {
+	if !s.hasContent {
+		s.buf.Reset()
+		s.stmtLine = 0
+		return false
+	}
+	s.stmt = strings.TrimSpace(s.buf.String())
+	s.stmtStart = s.stmtLine
+	return true
+}
+
+// next returns the next rune, honoring a pushed back rune.
+func (s *StatementScanner) next() (rune, error) {
+	if s.hasPending {
+		s.hasPending = false
+		return s.pending, nil
+	}
+	r, _, err := s.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r == '\n' {
+		s.line++
+	}
+	return r, nil
+}
+
+// push pushes back a rune to be returned by the next call to next.
+func (s *StatementScanner) push(r rune) {
+	s.pending = r
+	s.hasPending = true
+}
+
+// write appends a rune to the current statement.
+func (s *StatementScanner) write(r rune) error {
+	if s.stmtLine == 0 && !unicode.IsSpace(r) {
+		s.stmtLine = s.line
+	}
+	s.buf.WriteRune(r)
+	s.before, s.last = s.last, r
+	if s.buf.Len() > s.MaxStatementSize {
+		return fmt.Errorf(
+			"%w: statement at line %d is larger than %d bytes",
+			ErrStatementTooLarge, s.stmtLine, s.MaxStatementSize,
+		)
+	}
+	return nil
+}
+
+// advance updates the lexical state after the rune r has been written.
+func (s *StatementScanner) advance(r rune) {
+	switch s.state {
+	case stateNormal:
+		s.advanceNormal(r)
+	case stateSingleQuote:
+		s.advanceQuote(r, '\'', s.BackslashEscapes)
+	case stateDoubleQuote:
+		s.advanceQuote(r, '"', false)
+	case stateBacktick:
+		s.advanceQuote(r, '`', false)
+	case stateLineComment:
+		if r == '\n' {
+			s.state = stateNormal
+		}
+	case stateBlockComment:
+		s.advanceBlockComment(r)
+	case stateDollarQuote:
+		if r == '$' {
+			s.matchDollarClose()
+		}
+	}
+}
+
+// advanceNormal handles a rune outside of quotes and comments.
+func (s *StatementScanner) advanceNormal(r rune) {
+	switch r {
+	case '\'':
+		s.hasContent = true
+		s.state = stateSingleQuote
+	case '"':
+		s.hasContent = true
+		s.state = stateDoubleQuote
+	case '`':
+		s.hasContent = true
+		s.state = stateBacktick
+	case '-':
+		if s.peekAndWrite('-') {
+			s.state = stateLineComment
+			return
+		}
+		s.hasContent = true
+	case '/':
+		if s.peekAndWrite('*') {
+			s.state = stateBlockComment
+			s.depth = 1
+			return
+		}
+		s.hasContent = true
+	case '$':
+		s.hasContent = true
+		if !isIdentRune(s.before) {
+			s.matchDollarOpen()
+		}
+	default:
+		if !unicode.IsSpace(r) {
+			s.hasContent = true
+		}
+	}
+}
+
+// advanceQuote handles a rune inside a quoted string or identifier. A
+// doubled quote character is an escaped quote.
+func (s *StatementScanner) advanceQuote(r rune, quote rune, backslash bool) {
+	switch {
+	case backslash && r == '\\':
+		if next, err := s.next(); err == nil {
+			s.err = s.write(next)
+		}
+	case r == quote:
+		if !s.peekAndWrite(quote) {
+			s.state = stateNormal
+		}
+	}
+}
+
+// advanceBlockComment handles a rune inside a possibly nested block comment.
+func (s *StatementScanner) advanceBlockComment(r rune) {
+	switch r {
+	case '*':
+		if s.peekAndWrite('/') {
+			s.depth--
+			if s.depth == 0 {
+				s.state = stateNormal
+			}
+		}
+	case '/':
+		if s.peekAndWrite('*') {
+			s.depth++
+		}
+	}
+}
+
+// matchDollarOpen reads a dollar-quote tag after a '$'. If the tag is valid
+// the scanner enters the dollar-quoted state. Positional parameters such as
+// $1 are not tags.
+func (s *StatementScanner) matchDollarOpen() {
+	var tag strings.Builder
+	for {
+		r, err := s.next()
+		if err != nil {
+			return
+		}
+		if r == '$' {
+			s.err = s.write(r)
+			s.tag = tag.String()
+			s.state = stateDollarQuote
+			return
+		}
+		if !isIdentRune(r) || (tag.Len() == 0 && unicode.IsDigit(r)) {
+			s.push(r)
+			return
+		}
+		tag.WriteRune(r)
+		if s.err = s.write(r); s.err != nil {
+			return
+		}
+	}
+}
+
+// matchDollarClose checks whether the '$' just written closes the current
+// dollar-quoted body.
+func (s *StatementScanner) matchDollarClose() {
+	for _, want := range s.tag + "$" {
+		r, err := s.next()
+		if err != nil {
+			return
+		}
+		if r != want {
+			s.push(r)
+			return
+		}
+		if s.err = s.write(r); s.err != nil {
+			return
+		}
+	}
+	s.state = stateNormal
+}
+
+// isIdentRune reports whether r may appear in an unquoted identifier.
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// peekAndWrite consumes and writes the next rune if it equals want.
+func (s *StatementScanner) peekAndWrite(want rune) bool {
+	r, err := s.next()
+	if err != nil {
+		return false
+	}
+	if r != want {
+		s.push(r)
+		return false
+	}
+	s.err = s.write(r)
+	return true
+}
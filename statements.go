@@ -0,0 +1,124 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// splitKey is the context key reporting that SQL steps execute their
+// statements one by one.
+type splitKey struct{}
+
+// statementError is the error of a statement of an SQL step executed
+// statement by statement.
+type statementError struct {
+	// index is the 1-based index of the statement within the step.
+	index int
+	// line is the line of the step's SQL the statement starts on.
+	line int
+	err  error
+}
+
+// Error returns the message of the statement's error.
+func (e *statementError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the statement's error.
+func (e *statementError) Unwrap() error {
+	return e.err
+}
+
+// WithStatementSplitting returns a new Migrator whose SQL steps execute the
+// statements of their SQL one ExecContext call at a time, for drivers that
+// reject several statements in one call. Statements are split at
+// semicolons outside string literals, quoted identifiers, comments and
+// dollar-quoted bodies. Steps with positional Args are executed whole.
+// Splitting is off by default because trigger and procedure bodies written
+// with BEGIN ... END contain semicolons; disable it for such steps with
+// their WithSplit(false), or for all steps of a source with the source's
+// WithSplit(false).
+//
+// Parameters:
+//   - enabled: Whether SQL steps are split into statements.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithStatementSplitting(enabled bool) *Migrator {
+	new := *m
+	new.StatementSplitting = enabled
+	return &new
+}
+
+// withSplitting returns ctx reporting whether SQL steps are split into
+// statements.
+func withSplitting(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, splitKey{}, enabled)
+}
+
+// splittingEnabled reports whether ctx requests SQL steps to be split into
+// statements.
+func splittingEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(splitKey{}).(bool)
+	return enabled
+}
+
+// execSQL executes the SQL of a step with args and the bind variables of
+// the migration bound, statement by statement if splitting is enabled for
+// ctx, noSplit is not set and args are not positional. A failing statement
+// is reported as a *statementError.
+func execSQL(
+	ctx context.Context, exec Executor, script string, args []any, noSplit bool,
+) error {
+	if noSplit || hasPositionalArgs(args) || !splittingEnabled(ctx) {
+		return execBound(ctx, exec, script, args)
+	}
+	scanner := NewStatementScanner(strings.NewReader(script))
+	scanner.MaxStatementSize = len(script) + 1
+	type statement struct {
+		text string
+		line int
+	}
+	var stmts []statement
+	for scanner.Scan() {
+		stmts = append(stmts, statement{scanner.Statement(), scanner.Line()})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("split statements: %w", err)
+	}
+	if len(stmts) < 2 {
+		return execBound(ctx, exec, script, args)
+	}
+	for i, stmt := range stmts {
+		if err := execBound(ctx, exec, stmt.text, args); err != nil {
+			return &statementError{index: i + 1, line: stmt.line, err: err}
+		}
+	}
+	return nil
+}
+
+// execBound executes query on exec with args and the bind variables of the
+// migration bound.
+func execBound(
+	ctx context.Context, exec Executor, query string, args []any,
+) error {
+	query, bound, err := bindArgs(ctx, query, args)
+	if err != nil {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, query, bound...)
+	return err
+}
+
+// hasPositionalArgs reports whether args holds an argument that is not a
+// sql.NamedArg.
+func hasPositionalArgs(args []any) bool {
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); !ok {
+			return true
+		}
+	}
+	return false
+}
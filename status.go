@@ -0,0 +1,103 @@
+package migrator
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// MigrationStatus describes whether a migration is applied.
+type MigrationStatus struct {
+	Version       string
+	Name          string
+	MigrationName string
+	// Applied reports whether the migration is recorded in the history.
+	Applied bool
+	// AppliedAt is when the migration was applied, zero if it is pending
+	// or the HistoryManager does not implement HistoryReader.
+	AppliedAt time.Time
+	// Source is the path of the migration's up SQL file, "inline" for
+	// migrations whose SQL is defined in code, or empty for applied
+	// migrations that no source defines anymore.
+	Source string
+}
+
+// Status reports every migration of the Migrator's sources in execution
+// order, applied or pending, followed by the applied migrations no source
+// defines anymore. It does not modify the database.
+//
+// Parameters:
+//   - ctx: Context to use for database operations.
+//
+// Returns:
+//   - []MigrationStatus: The status of every migration.
+//   - error: An error if the migrations or the history cannot be loaded.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	all, applied, _, err := m.getAllAndAppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[[2]string]HistoryEntry)
+	if reader, ok := m.HistoryManager.(HistoryReader); ok {
+		for _, name := range m.historyNames(all) {
+			entries, err := reader.AppliedEntries(
+				ctx, m.DB, m.HistoryTable, name,
+			)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				recorded[[2]string{name, entry.Migration.Version}] = entry
+			}
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(all))
+	known := make(map[[2]string]bool, len(all))
+	pending := 0
+	for _, mig := range all {
+		key := [2]string{mig.MigrationName, mig.Version}
+		known[key] = true
+		status := MigrationStatus{
+			Version:       mig.Version,
+			Name:          mig.Name,
+			MigrationName: mig.MigrationName,
+			Applied:       applied.has(mig),
+			Source:        migrationSource(mig),
+		}
+		if status.Applied {
+			status.AppliedAt = recorded[key].AppliedAt
+		} else {
+			pending++
+		}
+		statuses = append(statuses, status)
+	}
+	var orphans []MigrationStatus
+	for name, versions := range applied {
+		for version := range versions {
+			key := [2]string{name, version}
+			if known[key] {
+				continue
+			}
+			orphans = append(orphans, MigrationStatus{
+				Version:       version,
+				Name:          recorded[key].Migration.Name,
+				MigrationName: name,
+				Applied:       true,
+				AppliedAt:     recorded[key].AppliedAt,
+			})
+		}
+	}
+	slices.SortFunc(orphans, func(a, b MigrationStatus) int {
+		if c := m.compareVersions(a.Version, b.Version); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.MigrationName, b.MigrationName)
+	})
+	m.logf(
+		"Status: %d applied, %d pending, %d applied without a source",
+		len(all)-pending, pending, len(orphans),
+	)
+	return append(statuses, orphans...), nil
+}
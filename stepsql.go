@@ -0,0 +1,192 @@
+package migrator
+
+import (
+	"slices"
+	"strings"
+	"text/template/parse"
+)
+
+// stepSQLs returns the SQL that step executes in direction, read without
+// executing it, so that pending migrations can be analyzed. Steps wrapped
+// by ForSchemas yield their SQL once per schema with SchemaPlaceholder
+// replaced, in execution order. Templates are rendered with every step
+// value shown by its name and secrets masked. Partition, anonymize and
+// backfill steps yield the statements they build. It reports false for
+// steps whose SQL cannot be known before they run, such as hook steps.
+func stepSQLs(step MigrationStep, direction string) ([]string, bool, error) {
+	up := direction == "up"
+	switch s := step.(type) {
+	case SchemaMigrationStep:
+		return schemaStepSQLs(s, direction)
+	case *SchemaMigrationStep:
+		return schemaStepSQLs(*s, direction)
+	case TemplateMigrationStep:
+		return templateStepSQLs(s, up)
+	case *TemplateMigrationStep:
+		return templateStepSQLs(*s, up)
+	case PartitionStep:
+		return []string{directionSQL(s.UpSQL, s.DownSQL, up)}, true, nil
+	case *PartitionStep:
+		return []string{directionSQL(s.UpSQL, s.DownSQL, up)}, true, nil
+	case EnsurePartitionsStep:
+		return ensurePartitionsSQLs(s, up), true, nil
+	case *EnsurePartitionsStep:
+		return ensurePartitionsSQLs(*s, up), true, nil
+	case AnonymizeStep:
+		return anonymizeSQLs(s, up)
+	case *AnonymizeStep:
+		return anonymizeSQLs(*s, up)
+	case BackfillStep:
+		return []string{directionSQL(s.SQL, "", up)}, true, nil
+	case *BackfillStep:
+		return []string{directionSQL(s.SQL, "", up)}, true, nil
+	case sqlStep:
+		sql, err := s.stepSQL()
+		if err != nil {
+			return nil, true, err
+		}
+		return []string{sql}, true, nil
+	}
+	return nil, false, nil
+}
+
+// isHookStep reports whether step runs Go code instead of SQL, such as a
+// HookMigrationStep, also when wrapped by ForSchemas.
+func isHookStep(step MigrationStep) bool {
+	switch s := step.(type) {
+	case HookMigrationStep, *HookMigrationStep,
+		TxHookMigrationStep, *TxHookMigrationStep:
+		return true
+	case SchemaMigrationStep:
+		return isHookStep(s.Step)
+	case *SchemaMigrationStep:
+		return isHookStep(s.Step)
+	}
+	return false
+}
+
+// directionSQL returns upSQL or downSQL.
+func directionSQL(upSQL string, downSQL string, up bool) string {
+	if up {
+		return upSQL
+	}
+	return downSQL
+}
+
+// schemaStepSQLs returns the SQL of the wrapped step for every schema.
+func schemaStepSQLs(
+	s SchemaMigrationStep, direction string,
+) ([]string, bool, error) {
+	inner, ok, err := stepSQLs(s.Step, direction)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	schemas := slices.Clone(s.Schemas)
+	if direction == "down" {
+		slices.Reverse(schemas)
+	}
+	var sqls []string
+	for _, schema := range schemas {
+		for _, sql := range inner {
+			sqls = append(
+				sqls, strings.ReplaceAll(sql, SchemaPlaceholder, schema),
+			)
+		}
+	}
+	return sqls, true, nil
+}
+
+// templateStepSQLs renders the template of the step with every step value
+// replaced by its name, e.g. "max_id" for {{.max_id}}, and secrets masked.
+// Templates that cannot be rendered so, e.g. because they range over a
+// value, cannot be analyzed.
+func templateStepSQLs(t TemplateMigrationStep, up bool) ([]string, bool, error) {
+	tmpl, err := parseTemplate(
+		directionSQL(t.UpSQL, t.DownSQL, up),
+		func(string) (string, error) { return secretMask, nil },
+	)
+	if err != nil {
+		return nil, true, err
+	}
+	data := make(map[string]any)
+	if tmpl.Tree != nil {
+		templateFields(tmpl.Tree.Root, data)
+	}
+	var sql strings.Builder
+	if err := tmpl.Execute(&sql, data); err != nil {
+		return nil, false, nil
+	}
+	return []string{sql.String()}, true, nil
+}
+
+// templateFields sets data[name] to name for every value referenced as
+// {{.name}} by the template node.
+func templateFields(node parse.Node, data map[string]any) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, data)
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, data)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFields(cmd, data)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, data)
+		}
+	case *parse.ChainNode:
+		templateFields(n.Node, data)
+	case *parse.FieldNode:
+		data[n.Ident[0]] = n.Ident[0]
+	case *parse.IfNode:
+		templateBranchFields(&n.BranchNode, data)
+	case *parse.RangeNode:
+		templateBranchFields(&n.BranchNode, data)
+	case *parse.WithNode:
+		templateBranchFields(&n.BranchNode, data)
+	}
+}
+
+// templateBranchFields collects the fields of an if, range or with node.
+func templateBranchFields(n *parse.BranchNode, data map[string]any) {
+	templateFields(n.Pipe, data)
+	templateFields(n.List, data)
+	templateFields(n.ElseList, data)
+}
+
+// ensurePartitionsSQLs returns the statements creating every partition the
+// step covers, including existing ones, or none for the down direction.
+func ensurePartitionsSQLs(e EnsurePartitionsStep, up bool) []string {
+	if !up {
+		return nil
+	}
+	var sqls []string
+	at := e.Table.periodStart(nowUTC(e.NowFunc))
+	for range e.Ahead + 1 {
+		sqls = append(sqls, e.Table.createSQL(at))
+		at = e.Table.nextPeriod(at)
+	}
+	return sqls
+}
+
+// anonymizeSQLs returns the UPDATE statement of the step, or none for the
+// down direction.
+func anonymizeSQLs(s AnonymizeStep, up bool) ([]string, bool, error) {
+	if !up {
+		return nil, true, nil
+	}
+	sql, err := s.SQL()
+	if err != nil {
+		return nil, true, err
+	}
+	return []string{sql}, true, nil
+}
@@ -0,0 +1,70 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// preparer is implemented by executors that can prepare statements, such as
+// *sql.DB and *sql.Tx.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// stmtCache is an Executor that prepares each distinct query once and reuses
+// the prepared statement for subsequent executions. It is used for history
+// writes so that a run applying many migrations parses the history statements
+// only once per run and transaction.
+type stmtCache struct {
+	exec        Executor
+	stmts       map[string]*sql.Stmt
+	unsupported bool
+}
+
+// newStmtCache returns a stmtCache preparing statements on exec.
+func newStmtCache(exec Executor) *stmtCache {
+	return &stmtCache{
+		exec:  exec,
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+// ExecContext executes the query using a cached prepared statement. If the
+// underlying executor cannot prepare statements, the query is executed
+// directly.
+func (c *stmtCache) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	stmt, ok := c.stmts[query]
+	if !ok {
+		p, canPrepare := c.exec.(preparer)
+		if !canPrepare || c.unsupported {
+			return c.exec.ExecContext(ctx, query, args...)
+		}
+		prepared, err := p.PrepareContext(ctx, query)
+		if err != nil {
+			contextLogger(ctx).Printf(
+				"Preparing history statement failed, executing directly: %v",
+				err,
+			)
+			c.unsupported = true
+			return c.exec.ExecContext(ctx, query, args...)
+		}
+		c.stmts[query] = prepared
+		stmt = prepared
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Close closes all prepared statements.
+func (c *stmtCache) Close() error {
+	var errs []error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,369 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// PartitionInterval is the time range covered by one table partition.
+type PartitionInterval string
+
+const (
+	// PartitionDaily partitions cover a day.
+	PartitionDaily PartitionInterval = "day"
+	// PartitionMonthly partitions cover a month.
+	PartitionMonthly PartitionInterval = "month"
+	// PartitionYearly partitions cover a year.
+	PartitionYearly PartitionInterval = "year"
+)
+
+// PartitionedTable describes a table partitioned by time ranges, for
+// building partition management steps. Periods start at midnight UTC.
+//
+// Postgres tables use declarative partitioning (PARTITION BY RANGE) and
+// their partitions are tables named after the table and the period, e.g.
+// "events_p202601". MySQL tables must be partitioned by RANGE COLUMNS on a
+// DATE or DATETIME column without a MAXVALUE partition; their partitions
+// are named after the period, e.g. "p202601".
+type PartitionedTable struct {
+	Dialect  Dialect
+	Table    string
+	Interval PartitionInterval
+}
+
+// NewPartitionedTable returns a new PartitionedTable.
+//
+// Parameters:
+//   - dialect: DialectPostgres or DialectMySQL.
+//   - table: The partitioned table.
+//   - interval: The period covered by one partition.
+//
+// Returns:
+//   - PartitionedTable: The table.
+func NewPartitionedTable(
+	dialect Dialect, table string, interval PartitionInterval,
+) PartitionedTable {
+	return PartitionedTable{Dialect: dialect, Table: table, Interval: interval}
+}
+
+// PartitionName returns the name of the partition covering at.
+//
+// Parameters:
+//   - at: A time within the period of the partition.
+//
+// Returns:
+//   - string: The partition name.
+func (t PartitionedTable) PartitionName(at time.Time) string {
+	layout := "2006"
+	switch t.Interval {
+	case PartitionDaily:
+		layout = "20060102"
+	case PartitionMonthly:
+		layout = "200601"
+	}
+	suffix := "p" + t.periodStart(at).Format(layout)
+	if t.Dialect == DialectMySQL {
+		return suffix
+	}
+	return t.Table + "_" + suffix
+}
+
+// periodStart returns the start of the period containing at.
+func (t PartitionedTable) periodStart(at time.Time) time.Time {
+	at = at.UTC()
+	switch t.Interval {
+	case PartitionDaily:
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	case PartitionMonthly:
+		return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(at.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// nextPeriod returns the start of the period after the one starting at
+// start.
+func (t PartitionedTable) nextPeriod(start time.Time) time.Time {
+	switch t.Interval {
+	case PartitionDaily:
+		return start.AddDate(0, 0, 1)
+	case PartitionMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(1, 0, 0)
+	}
+}
+
+// bounds returns the FOR VALUES clause of the Postgres partition covering
+// at.
+func (t PartitionedTable) bounds(at time.Time) string {
+	from := t.periodStart(at)
+	return fmt.Sprintf(
+		"FOR VALUES FROM ('%s') TO ('%s')",
+		from.Format(time.DateOnly), t.nextPeriod(from).Format(time.DateOnly),
+	)
+}
+
+// createSQL returns the statement creating the partition covering at.
+func (t PartitionedTable) createSQL(at time.Time) string {
+	if t.Dialect == DialectMySQL {
+		end := t.nextPeriod(t.periodStart(at))
+		return fmt.Sprintf(
+			"ALTER TABLE %s ADD PARTITION (PARTITION %s VALUES LESS THAN ('%s'))",
+			t.Table, t.PartitionName(at), end.Format(time.DateOnly),
+		)
+	}
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s %s",
+		t.PartitionName(at), t.Table, t.bounds(at),
+	)
+}
+
+// dropSQL returns the statement dropping the partition covering at.
+func (t PartitionedTable) dropSQL(at time.Time) string {
+	if t.Dialect == DialectMySQL {
+		return fmt.Sprintf(
+			"ALTER TABLE %s DROP PARTITION %s", t.Table, t.PartitionName(at),
+		)
+	}
+	return "DROP TABLE IF EXISTS " + t.PartitionName(at)
+}
+
+// attachSQL returns the statement making the standalone table source the
+// partition covering at. MySQL exchanges the partition, which must be
+// empty, with the table.
+func (t PartitionedTable) attachSQL(source string, at time.Time) string {
+	if t.Dialect == DialectMySQL {
+		return t.exchangeSQL(source, at)
+	}
+	return fmt.Sprintf(
+		"ALTER TABLE %s ATTACH PARTITION %s %s", t.Table, source, t.bounds(at),
+	)
+}
+
+// detachSQL returns the statement turning the partition covering at into
+// the standalone table target. MySQL exchanges the partition with target,
+// which must be an empty table of the same structure.
+func (t PartitionedTable) detachSQL(target string, at time.Time) string {
+	if t.Dialect == DialectMySQL {
+		return t.exchangeSQL(target, at)
+	}
+	return fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", t.Table, target)
+}
+
+// exchangeSQL returns the MySQL statement exchanging the partition covering
+// at with table.
+func (t PartitionedTable) exchangeSQL(table string, at time.Time) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s EXCHANGE PARTITION %s WITH TABLE %s",
+		t.Table, t.PartitionName(at), table,
+	)
+}
+
+// CreatePartition returns a step creating the partition covering at. Its
+// down direction drops the partition and the rows in it.
+//
+// Parameters:
+//   - at: A time within the period of the partition.
+//
+// Returns:
+//   - *PartitionStep: The step.
+func (t PartitionedTable) CreatePartition(at time.Time) *PartitionStep {
+	return &PartitionStep{UpSQL: t.createSQL(at), DownSQL: t.dropSQL(at)}
+}
+
+// AttachPartition returns a step attaching the table source as the
+// partition covering at; its down direction detaches it again. On Postgres
+// source is the partition's table name, e.g. PartitionName(at) for a
+// previously detached partition. On MySQL the empty partition is exchanged
+// with source.
+//
+// Parameters:
+//   - source: The table to attach.
+//   - at: A time within the period of the partition.
+//
+// Returns:
+//   - *PartitionStep: The step.
+func (t PartitionedTable) AttachPartition(
+	source string, at time.Time,
+) *PartitionStep {
+	return &PartitionStep{
+		UpSQL:   t.attachSQL(source, at),
+		DownSQL: t.detachSQL(source, at),
+	}
+}
+
+// DetachPartition returns a step detaching the partition covering at into
+// the standalone table target, e.g. for archiving; its down direction
+// attaches it again. On Postgres target is the partition's table name, e.g.
+// PartitionName(at). On MySQL the partition is exchanged with target, which
+// must be an empty table of the same structure.
+//
+// Parameters:
+//   - target: The standalone table receiving the partition.
+//   - at: A time within the period of the partition.
+//
+// Returns:
+//   - *PartitionStep: The step.
+func (t PartitionedTable) DetachPartition(
+	target string, at time.Time,
+) *PartitionStep {
+	return &PartitionStep{
+		UpSQL:   t.detachSQL(target, at),
+		DownSQL: t.attachSQL(target, at),
+	}
+}
+
+// EnsurePartitions returns a step creating the missing partitions from the
+// current period through ahead periods into the future.
+//
+// Parameters:
+//   - ahead: The number of future periods to create partitions for.
+//
+// Returns:
+//   - *EnsurePartitionsStep: The step.
+func (t PartitionedTable) EnsurePartitions(ahead int) *EnsurePartitionsStep {
+	return &EnsurePartitionsStep{Table: t, Ahead: ahead}
+}
+
+// PartitionStep executes a partition management statement built by a
+// PartitionedTable, with a statement reverting it for downward migration.
+type PartitionStep struct {
+	UpSQL   string
+	DownSQL string
+}
+
+// ExecuteUp executes the partition statement.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the statement fails.
+func (p PartitionStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	_, err := exec.ExecContext(ctx, p.UpSQL)
+	return err
+}
+
+// ExecuteDown executes the statement reverting the partition statement.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if the statement fails.
+func (p PartitionStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	_, err := exec.ExecContext(ctx, p.DownSQL)
+	return err
+}
+
+// EnsurePartitionsStep creates the missing partitions of a PartitionedTable
+// from the current period through Ahead periods into the future. It is
+// repeatable: as a migration step it runs once, and scheduled jobs keep
+// partitions ahead by calling ExecuteUp with the database directly:
+//
+//	err := table.EnsurePartitions(3).ExecuteUp(ctx, db)
+//
+// Its down direction does nothing, as the partitions may hold data.
+type EnsurePartitionsStep struct {
+	Table PartitionedTable
+	Ahead int
+	// NowFunc returns the current time. Nil uses time.Now.
+	NowFunc func() time.Time
+}
+
+// WithNowFunc returns a new EnsurePartitionsStep taking the current time
+// from now.
+//
+// Parameters:
+//   - now: The function returning the current time.
+//
+// Returns:
+//   - *EnsurePartitionsStep: A new EnsurePartitionsStep.
+func (e *EnsurePartitionsStep) WithNowFunc(
+	now func() time.Time,
+) *EnsurePartitionsStep {
+	new := *e
+	new.NowFunc = now
+	return &new
+}
+
+// ExecuteUp creates the missing partitions.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection. It must implement Queryer for MySQL
+//     tables.
+//
+// Returns:
+//   - error: An error if listing or creating partitions fails.
+func (e EnsurePartitionsStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	var existing []string
+	if e.Table.Dialect == DialectMySQL {
+		var err error
+		if existing, err = e.mysqlPartitions(ctx, exec); err != nil {
+			return err
+		}
+	}
+	created := 0
+	at := e.Table.periodStart(nowUTC(e.NowFunc))
+	for range e.Ahead + 1 {
+		if !slices.Contains(existing, e.Table.PartitionName(at)) {
+			if _, err := exec.ExecContext(ctx, e.Table.createSQL(at)); err != nil {
+				return err
+			}
+			created++
+		}
+		at = e.Table.nextPeriod(at)
+	}
+	contextLogger(ctx).Printf(
+		"Created %d missing partitions of %s", created, e.Table.Table,
+	)
+	return nil
+}
+
+// ExecuteDown does nothing.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: Always nil.
+func (e EnsurePartitionsStep) ExecuteDown(ctx context.Context, exec Executor) error {
+	return nil
+}
+
+// mysqlPartitions returns the partition names of the MySQL table.
+func (e EnsurePartitionsStep) mysqlPartitions(
+	ctx context.Context, exec Executor,
+) ([]string, error) {
+	q, ok := exec.(Queryer)
+	if !ok {
+		return nil, fmt.Errorf("executor %T cannot list partitions", exec)
+	}
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT PARTITION_NAME FROM information_schema.PARTITIONS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? "+
+			"AND PARTITION_NAME IS NOT NULL",
+		e.Table.Table,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions of %s: %w", e.Table.Table, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,145 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// TimelineEvent is a change of the schema version reconstructed from the
+// history and audit tables.
+type TimelineEvent struct {
+	// Time is when the migration was applied or rolled back.
+	Time          time.Time
+	MigrationName string
+	Version       string
+	// Direction is "up" or "down".
+	Direction string
+	// SchemaVersion is the highest applied version after the event, empty
+	// if no migration was applied.
+	SchemaVersion string
+}
+
+// HistoryTimeline reconstructs the sequence of applied and rolled back
+// migrations, oldest first, with the schema version after every change,
+// for incident analysis. With auditing enabled (see WithAudit), the audit
+// table provides both directions, including migrations rolled back since;
+// history entries of migrations applied before auditing was enabled are
+// added as applied at their history time. Without auditing, only the
+// currently applied migrations are known. The HistoryManager must
+// implement HistoryReader.
+//
+// Parameters:
+//   - ctx: Context to use.
+//
+// Returns:
+//   - []TimelineEvent: The events in time order.
+//   - error: An error if the history or audit table cannot be read.
+func (m *Migrator) HistoryTimeline(ctx context.Context) ([]TimelineEvent, error) {
+	entries, err := m.HistoryEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events, err := m.auditEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	audited := make(map[[2]string]bool, len(events))
+	for _, event := range events {
+		audited[[2]string{event.MigrationName, event.Version}] = true
+	}
+	for _, entry := range entries {
+		key := [2]string{entry.MigrationName, entry.Migration.Version}
+		if audited[key] {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			Time:          entry.AppliedAt,
+			MigrationName: entry.MigrationName,
+			Version:       entry.Migration.Version,
+			Direction:     "up",
+		})
+	}
+	slices.SortStableFunc(events, func(a, b TimelineEvent) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return m.compareVersions(a.Version, b.Version)
+	})
+
+	applied := make(map[[2]string]bool)
+	for i := range events {
+		key := [2]string{events[i].MigrationName, events[i].Version}
+		if events[i].Direction == "down" {
+			delete(applied, key)
+		} else {
+			applied[key] = true
+		}
+		for key := range applied {
+			if m.compareVersions(key[1], events[i].SchemaVersion) > 0 {
+				events[i].SchemaVersion = key[1]
+			}
+		}
+	}
+	m.logf("Reconstructed a timeline of %d schema changes", len(events))
+	return events, nil
+}
+
+// VersionAt returns the schema version, the highest applied version, at
+// time t according to HistoryTimeline.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - t: The point in time.
+//
+// Returns:
+//   - string: The schema version at t, empty if no migration was applied.
+//   - error: An error if the history or audit table cannot be read.
+func (m *Migrator) VersionAt(ctx context.Context, t time.Time) (string, error) {
+	events, err := m.HistoryTimeline(ctx)
+	if err != nil {
+		return "", err
+	}
+	version := ""
+	for _, event := range events {
+		if event.Time.After(t) {
+			break
+		}
+		version = event.SchemaVersion
+	}
+	return version, nil
+}
+
+// auditEvents returns the migrations recorded in the audit table, or nil if
+// auditing is disabled.
+func (m *Migrator) auditEvents(ctx context.Context) ([]TimelineEvent, error) {
+	if m.Audit == AuditOff {
+		return nil, nil
+	}
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT migration_name, version, direction, executed_at FROM %s",
+		m.auditTable(),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("read audit table %s: %w", m.auditTable(), err)
+	}
+	defer rows.Close()
+	var events []TimelineEvent
+	for rows.Next() {
+		var (
+			event      TimelineEvent
+			executedAt any
+		)
+		if err := rows.Scan(
+			&event.MigrationName, &event.Version, &event.Direction, &executedAt,
+		); err != nil {
+			return nil, fmt.Errorf("read audit table %s: %w", m.auditTable(), err)
+		}
+		if event.Time, err = parseHistoryTime(executedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,88 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// TxHookFn is a hook function that receives the transaction of the run, for
+// driver features that need the concrete *sql.Tx, such as pq.CopyIn.
+type TxHookFn func(ctx context.Context, tx *sql.Tx) error
+
+// txKey is the context key of the transaction of a run.
+type txKey struct{}
+
+// TxFromContext returns the transaction of the transactional run executing
+// the current migration.
+//
+// Parameters:
+//   - ctx: The context passed to a step.
+//
+// Returns:
+//   - *sql.Tx: The transaction of the run.
+//   - bool: Whether the run is transactional.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// TxHookMigrationStep executes hook functions with the transaction of the
+// run. Statements executed directly on the transaction bypass the Migrator's
+// bookkeeping, so they are not audited and not counted in rows affected. It
+// fails in non-transactional runs.
+type TxHookMigrationStep struct {
+	UpHook   TxHookFn
+	DownHook TxHookFn
+}
+
+// NewTxHookMigrationStep returns a new TxHookMigrationStep with the given
+// hooks.
+//
+// Parameters:
+//   - upHook: The up hook to use.
+//   - downHook: The down hook to use.
+//
+// Returns:
+//   - *TxHookMigrationStep: A new migration step.
+func NewTxHookMigrationStep(upHook, downHook TxHookFn) *TxHookMigrationStep {
+	return &TxHookMigrationStep{UpHook: upHook, DownHook: downHook}
+}
+
+// ExecuteUp executes the up hook with the run's transaction.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection. Unused.
+//
+// Returns:
+//   - error: An error if the hook fails or the run is not transactional.
+func (h TxHookMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	return runTxHook(ctx, h.UpHook, "up")
+}
+
+// ExecuteDown executes the down hook with the run's transaction.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection. Unused.
+//
+// Returns:
+//   - error: An error if the hook fails or the run is not transactional.
+func (h TxHookMigrationStep) ExecuteDown(
+	ctx context.Context, exec Executor,
+) error {
+	return runTxHook(ctx, h.DownHook, "down")
+}
+
+// runTxHook calls hook with the transaction of ctx.
+func runTxHook(ctx context.Context, hook TxHookFn, direction string) error {
+	if hook == nil {
+		return fmt.Errorf("%s hook not defined", direction)
+	}
+	tx, ok := TxFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%s tx hook requires a transactional run", direction)
+	}
+	return hook(ctx, tx)
+}
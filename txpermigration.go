@@ -0,0 +1,123 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// WithTxPerMigration returns a new Migrator running every migration in its
+// own transaction, committed together with its history record, instead of
+// the whole run in one transaction. A failing migration is rolled back
+// while the migrations applied before it stay committed and recorded. The
+// mode takes precedence over WithTransactional; WithTxOptions applies to
+// every migration's transaction, and migrations annotated with
+// "-- isolation: <level>" use that level for their own transaction only.
+// A rerun applies both directions of the migration in one transaction.
+//
+// Parameters:
+//   - enabled: Whether every migration runs in its own transaction.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithTxPerMigration(enabled bool) *Migrator {
+	new := *m
+	new.TxPerMigration = enabled
+	return &new
+}
+
+// runTransactional reports whether the whole run executes in one
+// transaction.
+func (m *Migrator) runTransactional() bool {
+	return m.Transactional && !m.TxPerMigration
+}
+
+// migrationsTransactional reports whether every migration executes in a
+// transaction, either the run's or its own.
+func (m *Migrator) migrationsTransactional() bool {
+	return m.Transactional || m.TxPerMigration
+}
+
+// inMigrationTx calls fn, which applies or rolls back mig, in a transaction
+// of its own when TxPerMigration is set. The run executes on the
+// transaction while fn runs; it is committed if fn succeeds and rolled back
+// otherwise, if database/sql has not rolled it back already because ctx was
+// cancelled.
+func (m *Migrator) inMigrationTx(
+	ctx context.Context, run *migrationRun, mig Migration, fn func() error,
+) error {
+	if !m.TxPerMigration {
+		return fn()
+	}
+	opts, err := m.migrationTxOptions(mig)
+	if err != nil {
+		return err
+	}
+	var tx *sql.Tx
+	if run.conn != nil {
+		tx, err = run.conn.BeginTx(ctx, opts)
+	} else {
+		tx, err = m.DB.BeginTx(ctx, opts)
+	}
+	if err != nil {
+		m.logf(
+			"Error beginning transaction of migration %s: %v", mig.Version, err,
+		)
+		return err
+	}
+	exec, history := run.exec, run.history
+	run.exec, run.history = tx, newStmtCache(tx)
+	err = fn()
+	if closeErr := run.history.Close(); closeErr != nil {
+		m.logf("Error closing history statements: %v", closeErr)
+	}
+	run.exec, run.history = exec, history
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil &&
+			!errors.Is(rbErr, sql.ErrTxDone) {
+			m.logf(
+				"Error rolling back transaction of migration %s: %v",
+				mig.Version, rbErr,
+			)
+			return fmt.Errorf(
+				"migration %s: %w, also error rolling back transaction: %v",
+				mig.Version, err, rbErr,
+			)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		m.logf("Error committing migration %s: %v", mig.Version, err)
+		return err
+	}
+	return nil
+}
+
+// migrationTxOptions returns the options of the transaction of mig: the
+// Migrator's TxOptions with the isolation level raised to the one
+// annotated on mig, if higher.
+func (m *Migrator) migrationTxOptions(mig Migration) (*sql.TxOptions, error) {
+	opts, err := m.txOptions()
+	if err != nil {
+		return nil, err
+	}
+	if err := mig.resolveAnnotations(); err != nil {
+		return nil, err
+	}
+	name, ok := mig.Annotations[AnnotationIsolation]
+	if !ok {
+		return opts, nil
+	}
+	level, err := ParseIsolationLevel(name)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"migration %s (%s): %w", mig.Version, mig.Name, err,
+		)
+	}
+	if opts == nil {
+		opts = &sql.TxOptions{}
+	}
+	opts.Isolation = max(opts.Isolation, level)
+	return opts, nil
+}
@@ -0,0 +1,130 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSQL is wrapped by the errors of migrations whose SQL is
+// rejected by the SQLParser of the Migrator.
+var ErrInvalidSQL = errors.New("invalid SQL")
+
+// SQLParser syntax-checks SQL without executing it. Parsers for a specific
+// server, such as the Postgres parser of the pgquery module, catch typos
+// before any migration of a run executes.
+type SQLParser interface {
+	// Parse returns an error if sql, a script of one or more statements,
+	// is not valid. Errors locating the problem should be *SyntaxError.
+	Parse(sql string) error
+}
+
+// SQLParserFunc adapts a function to SQLParser.
+type SQLParserFunc func(sql string) error
+
+// Parse calls f.
+func (f SQLParserFunc) Parse(sql string) error {
+	return f(sql)
+}
+
+// SyntaxError is returned by SQLParsers that can locate the problem.
+type SyntaxError struct {
+	// Message describes the problem.
+	Message string
+	// Offset is the 0-based byte offset of the problem in the parsed SQL,
+	// or -1 if it is unknown.
+	Offset int
+}
+
+// Error returns the message.
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+// WithSQLValidation returns a new Migrator that parses the SQL of every
+// step of the pending migrations with parser before any migration of a
+// run executes, and fails the run listing every rejected step. Steps
+// wrapped by ForSchemas are validated for every schema, templates with
+// their step values rendered as their names, and partition steps by the
+// statements they build. Steps without readable SQL, such as hooks, are not
+// validated. The SQL is parsed as written, so named bind arguments such as
+// ":id" must be accepted by the parser. A nil parser disables validation.
+//
+// Parameters:
+//   - parser: The parser to validate SQL with.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithSQLValidation(parser SQLParser) *Migrator {
+	new := *m
+	new.SQLParser = parser
+	return &new
+}
+
+// validateSQL parses the SQL of the up steps of pending and returns the
+// rejected steps as joined *MigrationErrors wrapping ErrInvalidSQL.
+func (m *Migrator) validateSQL(pending []Migration) error {
+	if m.SQLParser == nil {
+		return nil
+	}
+	var errs []error
+	for _, mig := range pending {
+		for idx, step := range mig.UpSteps {
+			sqls, _, err := stepSQLs(step, "up")
+			if err != nil {
+				return err
+			}
+			for _, sql := range sqls {
+				parseErr := m.SQLParser.Parse(sql)
+				if parseErr == nil {
+					continue
+				}
+				statement, line := locateSyntaxError(sql, parseErr)
+				err = &MigrationError{
+					Version:   mig.Version,
+					Name:      mig.Name,
+					Direction: "up",
+					Step:      idx + 1,
+					Label:     stepLabel(step),
+					Statement: statement,
+					Line:      line,
+					SQL:       m.redactedSQL(sql),
+					Preview:   m.sqlPreview(sql, parseErr),
+					Redacted:  m.RedactSQL != RedactOff,
+					Err:       fmt.Errorf("%w: %w", ErrInvalidSQL, parseErr),
+				}
+				m.logf("Invalid SQL: %v", err)
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	m.logf("Validated the SQL of %d pending migrations", len(pending))
+	return nil
+}
+
+// locateSyntaxError returns the 1-based index of the statement of sql
+// that err refers to and the line of the error, or the statement the error
+// message points to, or zeros if neither is known.
+func locateSyntaxError(sql string, err error) (int, int) {
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) ||
+		syntaxErr.Offset < 0 || syntaxErr.Offset > len(sql) {
+		return locateStatement(sql, err.Error())
+	}
+	line := strings.Count(sql[:syntaxErr.Offset], "\n") + 1
+	stmts, scanErr := previewStatements(sql)
+	if scanErr != nil || len(stmts) < 2 {
+		return 0, line
+	}
+	statement := 1
+	for i, stmt := range stmts {
+		if stmt.line <= line {
+			statement = i + 1
+		}
+	}
+	return statement, line
+}
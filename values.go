@@ -0,0 +1,207 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// StepValues is a key/value bag shared by the steps of one migration
+// execution, so that an early step can compute a value, such as a maximum
+// ID or a partition name, that later steps consume. Hook steps reach it
+// through StepValuesFromContext; TemplateMigrationStep renders it into SQL.
+type StepValues struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// Set stores value under key.
+//
+// Parameters:
+//   - key: The key.
+//   - value: The value.
+func (v *StepValues) Set(key string, value any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]any)
+	}
+	v.values[key] = value
+}
+
+// Get returns the value stored under key.
+//
+// Parameters:
+//   - key: The key.
+//
+// Returns:
+//   - any: The value.
+//   - bool: Whether a value is stored under key.
+func (v *StepValues) Get(key string) (any, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.values[key]
+	return value, ok
+}
+
+// Map returns a copy of the stored values.
+//
+// Returns:
+//   - map[string]any: The values by key.
+func (v *StepValues) Map() map[string]any {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return maps.Clone(v.values)
+}
+
+// stepValuesKey is the context key of the StepValues of a migration.
+type stepValuesKey struct{}
+
+// StepValuesFromContext returns the values of the migration being executed,
+// or nil if ctx does not belong to a migration execution.
+//
+// Parameters:
+//   - ctx: The context passed to a step.
+//
+// Returns:
+//   - *StepValues: The values of the migration.
+func StepValuesFromContext(ctx context.Context) *StepValues {
+	v, _ := ctx.Value(stepValuesKey{}).(*StepValues)
+	return v
+}
+
+// withStepValues returns ctx carrying a new, empty StepValues.
+func withStepValues(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stepValuesKey{}, &StepValues{})
+}
+
+// WithHookContext returns a new Migrator that passes the context of every
+// migration execution through fn before its steps run. Hook steps can then
+// reach application services such as configuration, clients or loggers
+// stored in the context instead of package-level globals.
+//
+// Parameters:
+//   - fn: Returns the context the steps run with, derived from the given
+//     one.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithHookContext(
+	fn func(ctx context.Context) context.Context,
+) *Migrator {
+	new := *m
+	new.HookContext = fn
+	return &new
+}
+
+// stepContext returns the context the steps of one migration execution run
+// with: ctx with new StepValues, the bind variable format of the history
+// manager, the secret provider, the database and the run's transaction, if
+// tx is one, passed through HookContext if set.
+func (m *Migrator) stepContext(ctx context.Context, tx Executor) context.Context {
+	ctx = withStepValues(ctx)
+	ctx = m.withLogger(ctx)
+	ctx = withSplitting(ctx, m.StatementSplitting)
+	ctx = withPlaceholder(ctx, placeholderFunc(m.HistoryManager))
+	ctx = withSecrets(ctx, m.SecretProvider)
+	ctx = context.WithValue(ctx, dbKey{}, m.DB)
+	ctx = context.WithValue(ctx, backfillTableKey{}, m.derivedTable(backfillTableSuffix))
+	ctx = context.WithValue(ctx, runControlKey{}, m.RunControl)
+	if tx, ok := tx.(*sql.Tx); ok {
+		ctx = context.WithValue(ctx, txKey{}, tx)
+	}
+	if m.HookContext != nil {
+		ctx = m.HookContext(ctx)
+	}
+	return ctx
+}
+
+// TemplateMigrationStep executes SQL rendered with text/template from the
+// StepValues of the migration, e.g. "DELETE FROM t WHERE id <= {{.max_id}}".
+// Referencing a value that no earlier step has set is an error. The
+// function {{ secret "name" }} renders a secret of the Migrator's
+// SecretProvider.
+type TemplateMigrationStep struct {
+	UpSQL   string
+	DownSQL string
+}
+
+// NewTemplateMigrationStep returns a new TemplateMigrationStep.
+//
+// Parameters:
+//   - upSQL: The up SQL template.
+//   - downSQL: The down SQL template.
+//
+// Returns:
+//   - *TemplateMigrationStep: A new migration step.
+func NewTemplateMigrationStep(upSQL, downSQL string) *TemplateMigrationStep {
+	return &TemplateMigrationStep{UpSQL: upSQL, DownSQL: downSQL}
+}
+
+// ExecuteUp renders and executes the up SQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if rendering or execution fails.
+func (t TemplateMigrationStep) ExecuteUp(ctx context.Context, exec Executor) error {
+	return executeTemplate(ctx, exec, t.UpSQL)
+}
+
+// ExecuteDown renders and executes the down SQL.
+//
+// Parameters:
+//   - ctx: Context to use.
+//   - exec: The database connection.
+//
+// Returns:
+//   - error: An error if rendering or execution fails.
+func (t TemplateMigrationStep) ExecuteDown(
+	ctx context.Context, exec Executor,
+) error {
+	return executeTemplate(ctx, exec, t.DownSQL)
+}
+
+// executeTemplate renders text with the step values of ctx and executes it.
+func executeTemplate(ctx context.Context, exec Executor, text string) error {
+	tmpl, err := parseTemplate(text, func(name string) (string, error) {
+		return resolveSecret(ctx, name)
+	})
+	if err != nil {
+		return err
+	}
+	var data map[string]any
+	if values := StepValuesFromContext(ctx); values != nil {
+		data = values.Map()
+	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	var sql strings.Builder
+	if err := tmpl.Execute(&sql, data); err != nil {
+		return fmt.Errorf("render template: %w", err)
+	}
+	_, err = exec.ExecContext(ctx, sql.String())
+	return err
+}
+
+// parseTemplate parses the SQL template text, rendering {{ secret "name" }}
+// with secret.
+func parseTemplate(
+	text string, secret func(name string) (string, error),
+) (*template.Template, error) {
+	tmpl, err := template.New("step").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"secret": secret}).
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	return tmpl, nil
+}
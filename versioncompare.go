@@ -0,0 +1,255 @@
+package migrator
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// VersionComparator orders migration versions. It returns a negative number
+// if a precedes b, a positive number if a follows b and zero if they are
+// equal. It must define a total order.
+type VersionComparator func(a, b string) int
+
+// CompareNumeric orders versions numerically, whatever their number of
+// digits, and is the default VersionComparator. Versions that are not all
+// digits follow the numeric ones. ULIDs and UUIDs are compared regardless
+// of case, which orders ULIDs and UUIDv7s by creation time. Other versions
+// are compared as strings.
+//
+// Parameters:
+//   - a: The first version.
+//   - b: The second version.
+//
+// Returns:
+//   - int: The order of a relative to b.
+func CompareNumeric(a, b string) int {
+	return compareVersions(a, b)
+}
+
+// CompareLexicographic orders versions byte by byte, e.g. for versions that
+// are dates of a fixed width or zero-padded names.
+//
+// Parameters:
+//   - a: The first version.
+//   - b: The second version.
+//
+// Returns:
+//   - int: The order of a relative to b.
+func CompareLexicographic(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+// CompareSemver orders semantic versions such as "1.2.3", "v1.10.0" or
+// "2.0.0-rc.1" by precedence: numeric components numerically, a pre-release
+// before its release, and build metadata ignored. Missing minor and patch
+// components count as zero. Versions that are not semantic versions follow
+// all semantic versions and are compared as strings.
+//
+// Parameters:
+//   - a: The first version.
+//   - b: The second version.
+//
+// Returns:
+//   - int: The order of a relative to b.
+func CompareSemver(a, b string) int {
+	sa, okA := parseSemver(a)
+	sb, okB := parseSemver(b)
+	switch {
+	case okA && okB:
+		if c := sa.compare(sb); c != 0 {
+			return c
+		}
+	case okA:
+		return -1
+	case okB:
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
+
+// CompareTimestamp orders versions that are calendar timestamps, such as
+// "20240101120000" or "2024-01-01T12:00:00", by their digits. Timestamps of
+// different precision are compared as if the shorter one were padded with
+// zeros, so "20240101" precedes "20240101000001". Versions without digits
+// follow all timestamps. Use CompareNumeric for Unix timestamps.
+//
+// Parameters:
+//   - a: The first version.
+//   - b: The second version.
+//
+// Returns:
+//   - int: The order of a relative to b.
+func CompareTimestamp(a, b string) int {
+	da, db := keepDigits(a), keepDigits(b)
+	switch {
+	case da != "" && db != "":
+		width := max(len(da), len(db))
+		da += strings.Repeat("0", width-len(da))
+		db += strings.Repeat("0", width-len(db))
+		if c := cmp.Compare(da, db); c != 0 {
+			return c
+		}
+	case da != "":
+		return -1
+	case db != "":
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
+
+// WithVersionComparator returns a new Migrator ordering versions with
+// compare, for versions that are not numbers, ULIDs or UUIDs. The order
+// determines the run order, target versions and ranges. Versions of any
+// format are accepted once a comparator is set. Sources order their own
+// migrations with their WithVersionComparator option.
+//
+// Parameters:
+//   - compare: The comparator to use, nil for CompareNumeric.
+//
+// Returns:
+//   - *Migrator: A new Migrator instance.
+func (m *Migrator) WithVersionComparator(compare VersionComparator) *Migrator {
+	new := *m
+	new.VersionComparator = compare
+	return &new
+}
+
+// compareVersions compares two versions with the Migrator's comparator.
+func (m *Migrator) compareVersions(a, b string) int {
+	if m.VersionComparator == nil {
+		return compareVersions(a, b)
+	}
+	return m.VersionComparator(a, b)
+}
+
+// validVersion reports whether version has a format the Migrator can order:
+// a number, ULID or UUID for the default comparator, any version without
+// white space otherwise.
+func (m *Migrator) validVersion(version string) bool {
+	if m.VersionComparator == nil {
+		return isVersionFormat(version)
+	}
+	return version != "" && strings.IndexFunc(version, unicode.IsSpace) < 0
+}
+
+// versionFormatError returns the error reporting that version has an invalid
+// format.
+func (m *Migrator) versionFormatError(what string, version string) error {
+	if m.VersionComparator == nil {
+		return fmt.Errorf(
+			"invalid %s %q: not a number, ULID or UUID", what, version,
+		)
+	}
+	return fmt.Errorf("invalid %s %q", what, version)
+}
+
+// semver is a parsed semantic version.
+type semver struct {
+	core       [3]string
+	prerelease []string
+}
+
+// parseSemver parses a semantic version with an optional "v" prefix and up
+// to three core components.
+func parseSemver(version string) (semver, bool) {
+	var s semver
+	if version != "" && (version[0] == 'v' || version[0] == 'V') {
+		version = version[1:]
+	}
+	version, _, _ = strings.Cut(version, "+")
+	version, pre, hasPre := strings.Cut(version, "-")
+	parts := strings.Split(version, ".")
+	if len(parts) > len(s.core) {
+		return semver{}, false
+	}
+	for i := range s.core {
+		s.core[i] = "0"
+		if i < len(parts) {
+			if !isDigits(parts[i]) {
+				return semver{}, false
+			}
+			s.core[i] = parts[i]
+		}
+	}
+	if hasPre {
+		s.prerelease = strings.Split(pre, ".")
+		for _, id := range s.prerelease {
+			if id == "" {
+				return semver{}, false
+			}
+		}
+	}
+	return s, true
+}
+
+// compare orders s and o by semantic version precedence.
+func (s semver) compare(o semver) int {
+	for i := range s.core {
+		if c := compareDigits(s.core[i], o.core[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case s.prerelease == nil && o.prerelease == nil:
+		return 0
+	case s.prerelease == nil:
+		return 1
+	case o.prerelease == nil:
+		return -1
+	}
+	for i := 0; i < min(len(s.prerelease), len(o.prerelease)); i++ {
+		a, b := s.prerelease[i], o.prerelease[i]
+		numA, numB := isDigits(a), isDigits(b)
+		var c int
+		switch {
+		case numA && numB:
+			c = compareDigits(a, b)
+		case numA:
+			c = -1
+		case numB:
+			c = 1
+		default:
+			c = cmp.Compare(a, b)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(s.prerelease), len(o.prerelease))
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareDigits compares two strings of ASCII digits numerically without
+// converting them to integers, so that no number overflows.
+func compareDigits(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a, b)
+}
+
+// keepDigits returns the ASCII digits of s.
+func keepDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
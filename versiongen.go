@@ -0,0 +1,341 @@
+package migrator
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSequentialWidth is the zero-padded width of sequential versions
+// generated when no wider version exists.
+const DefaultSequentialWidth = 3
+
+// timestampVersionLayout is the layout of timestamp versions.
+const timestampVersionLayout = "20060102150405"
+
+// crockfordAlphabet is the Crockford base32 alphabet used by ULIDs.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLength is the length of an encoded ULID.
+const ulidLength = 26
+
+// VersionGenerator returns the version of a new migration given the
+// versions of the existing migrations. The returned version sorts after
+// every existing version of the same format.
+type VersionGenerator func(existing []string) (string, error)
+
+// SequentialVersion returns a generator of sequential numeric versions
+// zero-padded to at least width digits, or to the width of the longest
+// existing version if that is wider.
+//
+// Parameters:
+//   - width: The minimum number of digits.
+//
+// Returns:
+//   - VersionGenerator: The generator.
+func SequentialVersion(width int) VersionGenerator {
+	return func(existing []string) (string, error) {
+		last := 0
+		for _, version := range existing {
+			v, err := strconv.Atoi(version)
+			if err != nil {
+				continue
+			}
+			last = max(last, v)
+			width = max(width, len(version))
+		}
+		return fmt.Sprintf("%0*d", width, last+1), nil
+	}
+}
+
+// TimestampVersion returns a generator of UTC timestamp versions in the
+// format YYYYMMDDHHMMSS. If the current time does not sort after the newest
+// existing timestamp, the newest timestamp plus one second is used.
+//
+// Parameters:
+//   - now: The clock to use. Nil uses time.Now.
+//
+// Returns:
+//   - VersionGenerator: The generator.
+func TimestampVersion(now func() time.Time) VersionGenerator {
+	return func(existing []string) (string, error) {
+		next := nowUTC(now).Truncate(time.Second)
+		for _, version := range existing {
+			if !isTimestampVersion(version) {
+				continue
+			}
+			t, err := time.Parse(timestampVersionLayout, version)
+			if err != nil {
+				continue
+			}
+			if !next.After(t) {
+				next = t.Add(time.Second)
+			}
+		}
+		return next.Format(timestampVersionLayout), nil
+	}
+}
+
+// ULIDVersion returns a generator of ULID versions: 26 character Crockford
+// base32 identifiers made of a millisecond timestamp and random bits. They
+// avoid collisions between branches while still sorting by creation time.
+// If the generated ULID does not sort after the newest existing ULID, the
+// newest ULID incremented by one is used.
+//
+// Parameters:
+//   - now: The clock to use. Nil uses time.Now.
+//   - entropy: The source of random bits. Nil uses crypto/rand.
+//
+// Returns:
+//   - VersionGenerator: The generator.
+func ULIDVersion(now func() time.Time, entropy io.Reader) VersionGenerator {
+	return func(existing []string) (string, error) {
+		if entropy == nil {
+			entropy = rand.Reader
+		}
+		var id [16]byte
+		ms := uint64(nowUTC(now).UnixMilli())
+		binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+		binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+		if _, err := io.ReadFull(entropy, id[6:]); err != nil {
+			return "", fmt.Errorf("read ULID entropy: %w", err)
+		}
+		next := encodeULID(id)
+		for _, version := range existing {
+			version = strings.ToUpper(version)
+			if isULIDVersion(version) && next <= version {
+				var ok bool
+				if next, ok = incrementULID(version); !ok {
+					return "", fmt.Errorf("ULID %s cannot be incremented", version)
+				}
+			}
+		}
+		return next, nil
+	}
+}
+
+// UUIDVersion returns a generator of UUIDv7 versions: lower case RFC 9562
+// UUIDs made of a millisecond timestamp and random bits, for teams that
+// standardize on UUIDs. Like ULIDs they avoid collisions between branches
+// and sort by creation time. If the generated UUID does not sort after the
+// newest existing UUIDv7, the newest one incremented by one is used.
+//
+// Parameters:
+//   - now: The clock to use. Nil uses time.Now.
+//   - entropy: The source of random bits. Nil uses crypto/rand.
+//
+// Returns:
+//   - VersionGenerator: The generator.
+func UUIDVersion(now func() time.Time, entropy io.Reader) VersionGenerator {
+	return func(existing []string) (string, error) {
+		if entropy == nil {
+			entropy = rand.Reader
+		}
+		var id [16]byte
+		ms := uint64(nowUTC(now).UnixMilli())
+		binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+		binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+		if _, err := io.ReadFull(entropy, id[6:]); err != nil {
+			return "", fmt.Errorf("read UUID entropy: %w", err)
+		}
+		id[6] = 0x70 | id[6]&0x0f
+		id[8] = 0x80 | id[8]&0x3f
+		next := encodeUUID(id)
+		for _, version := range existing {
+			version = strings.ToLower(version)
+			if isUUIDVersion(version) && version[14] == '7' && next <= version {
+				var ok bool
+				if next, ok = incrementUUID(version); !ok {
+					return "", fmt.Errorf("UUID %s cannot be incremented", version)
+				}
+			}
+		}
+		return next, nil
+	}
+}
+
+// NextVersion returns the version of a new migration following existing.
+// The format is inferred from the existing versions: timestamps, ULIDs and
+// UUIDs continue as such, anything else continues as sequential numbers
+// with the existing zero-padded width, starting at 001.
+//
+// Parameters:
+//   - existing: The existing migrations.
+//
+// Returns:
+//   - string: The next version.
+//   - error: An error if the version cannot be generated.
+func NextVersion(existing []Migration) (string, error) {
+	versions := make([]string, len(existing))
+	for i, mig := range existing {
+		versions[i] = mig.Version
+	}
+	return DetectVersionGenerator(versions)(versions)
+}
+
+// DetectVersionGenerator returns the generator matching the format of the
+// given versions. Timestamp, ULID and UUID generators are returned only if
+// every version has that format.
+//
+// Parameters:
+//   - versions: The existing versions.
+//
+// Returns:
+//   - VersionGenerator: The matching generator.
+func DetectVersionGenerator(versions []string) VersionGenerator {
+	if len(versions) == 0 {
+		return SequentialVersion(DefaultSequentialWidth)
+	}
+	timestamps, ulids, uuids := true, true, true
+	for _, version := range versions {
+		timestamps = timestamps && isTimestampVersion(version)
+		ulids = ulids && isULIDVersion(strings.ToUpper(version))
+		uuids = uuids && isUUIDVersion(strings.ToLower(version))
+	}
+	switch {
+	case timestamps:
+		return TimestampVersion(nil)
+	case ulids:
+		return ULIDVersion(nil, nil)
+	case uuids:
+		return UUIDVersion(nil, nil)
+	default:
+		return SequentialVersion(DefaultSequentialWidth)
+	}
+}
+
+// isTimestampVersion reports whether version is a YYYYMMDDHHMMSS timestamp.
+func isTimestampVersion(version string) bool {
+	if len(version) != len(timestampVersionLayout) {
+		return false
+	}
+	_, err := time.Parse(timestampVersionLayout, version)
+	return err == nil
+}
+
+// isULIDVersion reports whether version is an upper case ULID.
+func isULIDVersion(version string) bool {
+	if len(version) != ulidLength || version[0] > '7' {
+		return false
+	}
+	for _, r := range version {
+		if !strings.ContainsRune(crockfordAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUIDVersion reports whether version is a lower case hyphenated UUID.
+func isUUIDVersion(version string) bool {
+	if len(version) != 36 {
+		return false
+	}
+	for i, r := range version {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isVersionFormat reports whether version is numeric, a ULID or a UUID, in
+// any case.
+func isVersionFormat(version string) bool {
+	if isDigits(version) {
+		return true
+	}
+	return isULIDVersion(strings.ToUpper(version)) ||
+		isUUIDVersion(strings.ToLower(version))
+}
+
+// canonicalVersion returns ULIDs in upper case and UUIDs in lower case, so
+// that they compare regardless of the case they are written in, and other
+// versions unchanged.
+func canonicalVersion(version string) string {
+	if upper := strings.ToUpper(version); isULIDVersion(upper) {
+		return upper
+	}
+	if lower := strings.ToLower(version); isUUIDVersion(lower) {
+		return lower
+	}
+	return version
+}
+
+// encodeUUID formats 128 bits as a lower case hyphenated UUID.
+func encodeUUID(id [16]byte) string {
+	h := hex.EncodeToString(id[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// incrementUUID returns the UUIDv7 following uuid, keeping its version and
+// variant bits, or false on overflow.
+func incrementUUID(uuid string) (string, bool) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	if err != nil || len(raw) != 16 {
+		return "", false
+	}
+	var id [16]byte
+	copy(id[:], raw)
+	// The counter is the 48 bit timestamp, the 12 bits of rand_a and the 62
+	// bits of rand_b, skipping the version and variant bits.
+	const randBMask = 1<<62 - 1
+	ms := uint64(binary.BigEndian.Uint16(id[0:2]))<<32 |
+		uint64(binary.BigEndian.Uint32(id[2:6]))
+	randA := binary.BigEndian.Uint16(id[6:8]) & 0x0fff
+	randB := binary.BigEndian.Uint64(id[8:16]) & randBMask
+	if randB++; randB > randBMask {
+		randB = 0
+		if randA++; randA > 0x0fff {
+			randA = 0
+			if ms++; ms >= 1<<48 {
+				return "", false
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	binary.BigEndian.PutUint16(id[6:8], 0x7000|randA)
+	binary.BigEndian.PutUint64(id[8:16], 1<<63|randB)
+	return encodeUUID(id), true
+}
+
+// encodeULID encodes 128 bits as 26 Crockford base32 characters. The first
+// character holds the top 3 bits.
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+	var out [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// incrementULID returns the ULID following ulid, or false on overflow.
+func incrementULID(ulid string) (string, bool) {
+	out := []byte(ulid)
+	for i := len(out) - 1; i >= 0; i-- {
+		idx := strings.IndexByte(crockfordAlphabet, out[i])
+		if idx < len(crockfordAlphabet)-1 {
+			out[i] = crockfordAlphabet[idx+1]
+			return string(out), !(i == 0 && out[0] > '7')
+		}
+		out[i] = crockfordAlphabet[0]
+	}
+	return "", false
+}